@@ -0,0 +1,107 @@
+package common
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CronExpression 五段式 cron 表达式（分 时 日 月 周），支持 *、数字、a-b 范围、/n 步长与逗号列表，
+// 周取值 0-7，0 和 7 均表示周日
+type CronExpression struct {
+	minutes  [60]bool
+	hours    [24]bool
+	days     [32]bool
+	months   [13]bool
+	weekdays [7]bool
+	// 日与周都不是任意值时，按 cron 惯例满足其一即可；覆盖全部取值的字段（如 */1、1-31）视为任意值
+	dayAny     bool
+	weekdayAny bool
+}
+
+func parseCronField(field string, lower int, upper int, set func(int)) error {
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepPart)
+			if err != nil || n <= 0 {
+				return fmt.Errorf("invalid step %q", part)
+			}
+			step = n
+		}
+		start, end := lower, upper
+		if rangePart != "*" {
+			startStr, endStr, isRange := strings.Cut(rangePart, "-")
+			var err error
+			if start, err = strconv.Atoi(startStr); err != nil {
+				return fmt.Errorf("invalid value %q", part)
+			}
+			end = start
+			if isRange {
+				if end, err = strconv.Atoi(endStr); err != nil {
+					return fmt.Errorf("invalid value %q", part)
+				}
+			} else if hasStep {
+				end = upper
+			}
+		}
+		if start < lower || end > upper || start > end {
+			return fmt.Errorf("value %q out of range %d-%d", part, lower, upper)
+		}
+		for i := start; i <= end; i += step {
+			set(i)
+		}
+	}
+	return nil
+}
+
+func allCronValues(values []bool) bool {
+	for _, v := range values {
+		if !v {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseCronExpression 解析五段式 cron 表达式
+func ParseCronExpression(expr string) (*CronExpression, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression %q must have 5 fields", expr)
+	}
+	cron := &CronExpression{}
+	if err := parseCronField(fields[0], 0, 59, func(i int) { cron.minutes[i] = true }); err != nil {
+		return nil, err
+	}
+	if err := parseCronField(fields[1], 0, 23, func(i int) { cron.hours[i] = true }); err != nil {
+		return nil, err
+	}
+	if err := parseCronField(fields[2], 1, 31, func(i int) { cron.days[i] = true }); err != nil {
+		return nil, err
+	}
+	if err := parseCronField(fields[3], 1, 12, func(i int) { cron.months[i] = true }); err != nil {
+		return nil, err
+	}
+	if err := parseCronField(fields[4], 0, 7, func(i int) { cron.weekdays[i%7] = true }); err != nil {
+		return nil, err
+	}
+	cron.dayAny = allCronValues(cron.days[1:])
+	cron.weekdayAny = allCronValues(cron.weekdays[:])
+	return cron, nil
+}
+
+// Match 判断时间所在的那一分钟是否匹配表达式
+func (cron *CronExpression) Match(t time.Time) bool {
+	if !cron.minutes[t.Minute()] || !cron.hours[t.Hour()] || !cron.months[t.Month()] {
+		return false
+	}
+	dayMatch := cron.days[t.Day()]
+	weekdayMatch := cron.weekdays[t.Weekday()]
+	if cron.dayAny || cron.weekdayAny {
+		return dayMatch && weekdayMatch
+	}
+	return dayMatch || weekdayMatch
+}
@@ -0,0 +1,72 @@
+package common
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronExpressionMatch(t *testing.T) {
+	tests := []struct {
+		name string
+		expr string
+		time string
+		want bool
+	}{
+		{name: "any", expr: "* * * * *", time: "2026-10-18 03:04", want: true},
+		{name: "exact minute", expr: "30 9 * * *", time: "2026-10-18 09:30", want: true},
+		{name: "other minute", expr: "30 9 * * *", time: "2026-10-18 09:31", want: false},
+		{name: "hour range", expr: "0 9-17 * * *", time: "2026-10-18 17:00", want: true},
+		{name: "outside hour range", expr: "0 9-17 * * *", time: "2026-10-18 18:00", want: false},
+		{name: "step", expr: "*/15 * * * *", time: "2026-10-18 10:45", want: true},
+		{name: "step miss", expr: "*/15 * * * *", time: "2026-10-18 10:50", want: false},
+		{name: "start with step", expr: "5/20 * * * *", time: "2026-10-18 10:45", want: true},
+		{name: "list", expr: "0 8,20 * * *", time: "2026-10-18 20:00", want: true},
+		{name: "sunday as 7", expr: "0 0 * * 7", time: "2026-10-18 00:00", want: true},
+		{name: "sunday as 0", expr: "0 0 * * 0", time: "2026-10-18 00:00", want: true},
+		{name: "weekday range", expr: "0 0 * * 1-5", time: "2026-10-18 00:00", want: false},
+		{name: "month", expr: "0 0 1 10 *", time: "2026-10-01 00:00", want: true},
+		{name: "day or weekday matches day", expr: "0 0 1 * 1", time: "2026-10-01 00:00", want: true},
+		{name: "day or weekday matches weekday", expr: "0 0 1 * 1", time: "2026-10-19 00:00", want: true},
+		{name: "day or weekday matches neither", expr: "0 0 1 * 1", time: "2026-10-18 00:00", want: false},
+		{name: "full day range is any", expr: "0 0 1-31 * 1", time: "2026-10-18 00:00", want: false},
+		{name: "full day step is any", expr: "0 0 */1 * 1", time: "2026-10-19 00:00", want: true},
+		{name: "full weekday range is any", expr: "0 0 1 * 0-6", time: "2026-10-18 00:00", want: false},
+		{name: "full weekday step is any", expr: "0 0 1 * */1", time: "2026-10-01 00:00", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cron, err := ParseCronExpression(tt.expr)
+			if err != nil {
+				t.Fatalf("ParseCronExpression(%q) returned error: %v", tt.expr, err)
+			}
+			at, err := time.Parse("2006-01-02 15:04", tt.time)
+			if err != nil {
+				t.Fatalf("time.Parse(%q) returned error: %v", tt.time, err)
+			}
+			if got := cron.Match(at); got != tt.want {
+				t.Fatalf("%q Match(%s) = %v, want %v", tt.expr, tt.time, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseCronExpressionInvalid(t *testing.T) {
+	tests := []string{
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"a * * * *",
+	}
+	for _, expr := range tests {
+		t.Run(expr, func(t *testing.T) {
+			if _, err := ParseCronExpression(expr); err == nil {
+				t.Fatalf("ParseCronExpression(%q) expected error", expr)
+			}
+		})
+	}
+}
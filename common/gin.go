@@ -138,6 +138,15 @@ func GetContextKeyType[T any](c *gin.Context, key constant.ContextKey) (T, bool)
 	return t, false
 }
 
+// GetBatchRequestId 获取批处理内部请求所属的批处理 id，不是批处理请求时返回空
+func GetBatchRequestId(c *gin.Context) string {
+	if c == nil || c.Request == nil {
+		return ""
+	}
+	batchId, _ := c.Request.Context().Value(constant.ContextKeyBatchId).(string)
+	return batchId
+}
+
 func ApiError(c *gin.Context, err error) {
 	c.JSON(http.StatusOK, gin.H{
 		"success": false,
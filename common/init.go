@@ -135,6 +135,16 @@ func initConstantEnv() {
 	constant.ErrorLogEnabled = GetEnvOrDefaultBool("ERROR_LOG_ENABLED", false)
 	// 任务轮询时查询的最大数量
 	constant.TaskQueryLimit = GetEnvOrDefault("TASK_QUERY_LIMIT", 1000)
+	// Files API 存储：local 保存到 FILE_STORAGE_PATH，s3 保存到 S3 兼容存储
+	constant.FileStorageType = GetEnvOrDefaultString("FILE_STORAGE_TYPE", "local")
+	constant.FileStoragePath = GetEnvOrDefaultString("FILE_STORAGE_PATH", "files")
+	constant.S3Endpoint = GetEnvOrDefaultString("S3_ENDPOINT", "")
+	constant.S3Region = GetEnvOrDefaultString("S3_REGION", "us-east-1")
+	constant.S3Bucket = GetEnvOrDefaultString("S3_BUCKET", "")
+	constant.S3AccessKeyId = GetEnvOrDefaultString("S3_ACCESS_KEY_ID", "")
+	constant.S3SecretAccessKey = GetEnvOrDefaultString("S3_SECRET_ACCESS_KEY", "")
+	constant.S3ForcePathStyle = GetEnvOrDefaultBool("S3_FORCE_PATH_STYLE", false)
+	constant.S3Prefix = GetEnvOrDefaultString("S3_PREFIX", "files/")
 
 	soraPatchStr := GetEnvOrDefaultString("TASK_PRICE_PATCH", "")
 	if soraPatchStr != "" {
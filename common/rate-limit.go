@@ -68,3 +68,14 @@ func (l *InMemoryRateLimiter) Request(key string, maxRequestNum int, duration in
 	}
 	return true
 }
+
+// Allowed 判断 key 是否还能再请求一次，不记录本次请求
+func (l *InMemoryRateLimiter) Allowed(key string, maxRequestNum int, duration int64) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	queue, ok := l.store[key]
+	if !ok || len(*queue) < maxRequestNum {
+		return true
+	}
+	return len(*queue) == 0 || time.Now().Unix()-(*queue)[0] >= duration
+}
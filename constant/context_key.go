@@ -19,6 +19,7 @@ const (
 	ContextKeyTokenModelLimitEnabled ContextKey = "token_model_limit_enabled"
 	ContextKeyTokenModelLimit        ContextKey = "token_model_limit"
 	ContextKeyTokenCrossGroupRetry   ContextKey = "token_cross_group_retry"
+	ContextKeyTokenBudget            ContextKey = "token_budget"
 
 	/* channel related keys */
 	ContextKeyChannelId                ContextKey = "channel_id"
@@ -36,7 +37,12 @@ const (
 	ContextKeyChannelStatusCodeMapping ContextKey = "status_code_mapping"
 	ContextKeyChannelIsMultiKey        ContextKey = "channel_is_multi_key"
 	ContextKeyChannelMultiKeyIndex     ContextKey = "channel_multi_key_index"
+	ContextKeyChannelTrackKeyUsage     ContextKey = "channel_track_key_usage"
 	ContextKeyChannelKey               ContextKey = "channel_key"
+	// 选择渠道时占用的并发名额，请求上游时接管
+	ContextKeyChannelConcurrencyReservation ContextKey = "channel_concurrency_reservation"
+	// 转发请求时占用的熔断半开探测名额，记录请求结果时归还
+	ContextKeyChannelBreakerProbe ContextKey = "channel_breaker_probe"
 
 	ContextKeyAutoGroup           ContextKey = "auto_group"
 	ContextKeyAutoGroupIndex      ContextKey = "auto_group_index"
@@ -51,8 +57,30 @@ const (
 	ContextKeyUserGroup   ContextKey = "user_group"
 	ContextKeyUsingGroup  ContextKey = "group"
 	ContextKeyUserName    ContextKey = "username"
+	ContextKeyUserBudget  ContextKey = "user_budget"
+
+	ContextKeyUserCreditLimit ContextKey = "user_credit_limit"
 
 	ContextKeyLocalCountTokens ContextKey = "local_count_tokens"
 
 	ContextKeySystemPromptOverride ContextKey = "system_prompt_override"
+
+	/* hedge request related keys */
+	ContextKeyHedgeState   ContextKey = "hedge_state"
+	ContextKeyHedgeAttempt ContextKey = "hedge_attempt"
+
+	/* sticky session related keys */
+	ContextKeyStickySessionKey ContextKey = "sticky_session_key"
+	ContextKeyStickyBinding    ContextKey = "sticky_binding"
+	// 微调模型、previous_response_id 与向量库要求固定使用的渠道，仍需通过令牌模型限制与分组渠道检查
+	ContextKeyPinnedChannel ContextKey = "pinned_channel"
+
+	/* gemini live related keys */
+	// Gemini Live 的客户端连接与首条 setup 消息，在选择渠道前读取
+	ContextKeyGeminiLiveConn  ContextKey = "gemini_live_conn"
+	ContextKeyGeminiLiveSetup ContextKey = "gemini_live_setup"
+
+	/* batch related keys */
+	// 批处理内部请求的批处理 id，保存在 http.Request 的 context 中，外部请求无法设置
+	ContextKeyBatchId ContextKey = "batch_id"
 )
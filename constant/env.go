@@ -18,5 +18,16 @@ var GenerateDefaultToken bool
 var ErrorLogEnabled bool
 var TaskQueryLimit int
 
+// Files API 的存储配置
+var FileStorageType string
+var FileStoragePath string
+var S3Endpoint string
+var S3Region string
+var S3Bucket string
+var S3AccessKeyId string
+var S3SecretAccessKey string
+var S3ForcePathStyle bool
+var S3Prefix string
+
 // temporary variable for sora patch, will be removed in future
 var TaskPricePatches []string
@@ -5,4 +5,7 @@ type MultiKeyMode string
 const (
 	MultiKeyModeRandom  MultiKeyMode = "random"  // 随机
 	MultiKeyModePolling MultiKeyMode = "polling" // 轮询
+
+	MultiKeyModeLeastRecentlyUsed     MultiKeyMode = "least_recently_used"      // 最久未使用
+	MultiKeyModeLeastTokensThisMinute MultiKeyMode = "least_tokens_this_minute" // 本分钟 token 用量最少
 )
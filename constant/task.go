@@ -5,6 +5,8 @@ type TaskPlatform string
 const (
 	TaskPlatformSuno       TaskPlatform = "suno"
 	TaskPlatformMidjourney              = "mj"
+	TaskPlatformFineTuning TaskPlatform = "fine_tuning"
+	TaskPlatformResponses  TaskPlatform = "responses"
 )
 
 const (
@@ -16,6 +18,8 @@ const (
 	TaskActionFirstTailGenerate = "firstTailGenerate"
 	TaskActionReferenceGenerate = "referenceGenerate"
 	TaskActionRemix             = "remixGenerate"
+	TaskActionFineTune          = "fineTune"
+	TaskActionResponse          = "response"
 )
 
 var SunoModel2Action = map[string]string{
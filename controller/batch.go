@@ -0,0 +1,114 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/ctrlc-ctrlv-limited/cvai/common"
+	"github.com/ctrlc-ctrlv-limited/cvai/dto"
+	"github.com/ctrlc-ctrlv-limited/cvai/logger"
+	"github.com/ctrlc-ctrlv-limited/cvai/model"
+	"github.com/ctrlc-ctrlv-limited/cvai/service"
+	"github.com/ctrlc-ctrlv-limited/cvai/setting/operation_setting"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Batch API 依赖 Files API 保存输入与输出文件
+func isBatchEnabled() bool {
+	return operation_setting.GetBatchSetting().Enabled && operation_setting.GetFileSetting().Enabled
+}
+
+// getRelayBatch 获取请求路径中的批处理，不存在或功能未启用时直接返回错误
+func getRelayBatch(c *gin.Context) (*model.Batch, bool) {
+	if !isBatchEnabled() {
+		RelayNotImplemented(c)
+		return nil, false
+	}
+	batch, err := model.GetUserBatchByBatchId(c.GetInt("id"), c.Param("id"))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			fileApiError(c, http.StatusNotFound, fmt.Sprintf("No such Batch object: %s", c.Param("id")), "batch_not_found")
+		} else {
+			fileApiError(c, http.StatusInternalServerError, err.Error(), "get_batch_failed")
+		}
+		return nil, false
+	}
+	return batch, true
+}
+
+// RelayCreateBatch POST /v1/batches
+func RelayCreateBatch(c *gin.Context) {
+	if !isBatchEnabled() {
+		RelayNotImplemented(c)
+		return
+	}
+	var req dto.CreateBatchRequest
+	if err := common.UnmarshalBodyReusable(c, &req); err != nil {
+		fileApiError(c, http.StatusBadRequest, "invalid request body: "+err.Error(), "invalid_request")
+		return
+	}
+	batch, err := service.CreateBatch(c, &req)
+	if err != nil {
+		logger.LogError(c, "create batch failed: "+err.Error())
+		fileApiError(c, http.StatusBadRequest, err.Error(), "create_batch_failed")
+		return
+	}
+	c.JSON(http.StatusOK, service.ToOpenAIBatch(batch))
+}
+
+// RelayListBatches GET /v1/batches
+func RelayListBatches(c *gin.Context) {
+	if !isBatchEnabled() {
+		RelayNotImplemented(c)
+		return
+	}
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+	batches, hasMore, err := model.GetUserBatches(c.GetInt("id"), c.Query("after"), limit)
+	if err != nil {
+		fileApiError(c, http.StatusBadRequest, err.Error(), "list_batches_failed")
+		return
+	}
+	list := dto.OpenAIBatchList{
+		Object:  "list",
+		Data:    make([]dto.OpenAIBatch, 0, len(batches)),
+		HasMore: hasMore,
+	}
+	for _, batch := range batches {
+		list.Data = append(list.Data, service.ToOpenAIBatch(batch))
+	}
+	if len(batches) > 0 {
+		list.FirstId = common.GetPointer(batches[0].BatchId)
+		list.LastId = common.GetPointer(batches[len(batches)-1].BatchId)
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+// RelayGetBatch GET /v1/batches/:id
+func RelayGetBatch(c *gin.Context) {
+	batch, ok := getRelayBatch(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, service.ToOpenAIBatch(batch))
+}
+
+// RelayCancelBatch POST /v1/batches/:id/cancel
+func RelayCancelBatch(c *gin.Context) {
+	batch, ok := getRelayBatch(c)
+	if !ok {
+		return
+	}
+	batch, err := service.CancelBatch(c, batch)
+	if err != nil {
+		fileApiError(c, http.StatusConflict, err.Error(), "cancel_batch_failed")
+		return
+	}
+	c.JSON(http.StatusOK, service.ToOpenAIBatch(batch))
+}
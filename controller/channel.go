@@ -14,6 +14,7 @@ import (
 	"github.com/ctrlc-ctrlv-limited/cvai/relay/channel/gemini"
 	"github.com/ctrlc-ctrlv-limited/cvai/relay/channel/ollama"
 	"github.com/ctrlc-ctrlv-limited/cvai/service"
+	"github.com/ctrlc-ctrlv-limited/cvai/setting/operation_setting"
 
 	"github.com/gin-gonic/gin"
 )
@@ -65,6 +66,17 @@ func clearChannelInfo(channel *model.Channel) {
 	}
 }
 
+// fillChannelScores 填充自适应渠道选择的实时统计
+func fillChannelScores(channels []*model.Channel) {
+	if !operation_setting.IsAdaptiveSelectUsed() {
+		return
+	}
+	scores := model.GetChannelScoresByChannel()
+	for _, channel := range channels {
+		channel.Scores = scores[channel.Id]
+	}
+}
+
 func GetAllChannels(c *gin.Context) {
 	pageInfo := common.GetPageQuery(c)
 	channelData := make([]*model.Channel, 0)
@@ -142,6 +154,7 @@ func GetAllChannels(c *gin.Context) {
 	for _, datum := range channelData {
 		clearChannelInfo(datum)
 	}
+	fillChannelScores(channelData)
 
 	countQuery := model.DB.Model(&model.Channel{})
 	if statusFilter == common.ChannelStatusEnabled {
@@ -480,6 +493,7 @@ func SearchChannels(c *gin.Context) {
 	for _, datum := range pagedData {
 		clearChannelInfo(datum)
 	}
+	fillChannelScores(pagedData)
 
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
@@ -1291,11 +1305,13 @@ func CopyChannel(c *gin.Context) {
 // MultiKeyManageRequest represents the request for multi-key management operations
 type MultiKeyManageRequest struct {
 	ChannelId int    `json:"channel_id"`
-	Action    string `json:"action"`              // "disable_key", "enable_key", "delete_key", "delete_disabled_keys", "get_key_status"
-	KeyIndex  *int   `json:"key_index,omitempty"` // for disable_key, enable_key, and delete_key actions
+	Action    string `json:"action"`              // "disable_key", "enable_key", "delete_key", "delete_disabled_keys", "get_key_status", "set_key_limit"
+	KeyIndex  *int   `json:"key_index,omitempty"` // for disable_key, enable_key, delete_key and set_key_limit actions
 	Page      int    `json:"page,omitempty"`      // for get_key_status pagination
 	PageSize  int    `json:"page_size,omitempty"` // for get_key_status pagination
 	Status    *int   `json:"status,omitempty"`    // for get_key_status filtering: 1=enabled, 2=manual_disabled, 3=auto_disabled, nil=all
+	RPMLimit  *int   `json:"rpm_limit,omitempty"` // for set_key_limit, 0 means no limit
+	TPMLimit  *int   `json:"tpm_limit,omitempty"` // for set_key_limit, 0 means no limit
 }
 
 // MultiKeyStatusResponse represents the response for key status query
@@ -1317,6 +1333,29 @@ type KeyStatus struct {
 	DisabledTime int64  `json:"disabled_time,omitempty"`
 	Reason       string `json:"reason,omitempty"`
 	KeyPreview   string `json:"key_preview"` // first 10 chars of key for identification
+	// 上游返回的限流额度与冷却时间
+	RateLimit *model.KeyRateLimit `json:"rate_limit,omitempty"`
+	// 每分钟请求数、token 数上限与当前一分钟的用量
+	RPMLimit int             `json:"rpm_limit,omitempty"`
+	TPMLimit int             `json:"tpm_limit,omitempty"`
+	Usage    *model.KeyUsage `json:"usage,omitempty"`
+}
+
+// GetChannelBreakers 获取当前处于熔断或半开状态的渠道
+func GetChannelBreakers(c *gin.Context) {
+	common.ApiSuccess(c, model.GetChannelBreakers())
+}
+
+// ResetChannelBreakers 手动重置渠道熔断状态，不传 channel_id 时重置全部
+func ResetChannelBreakers(c *gin.Context) {
+	channelId, _ := strconv.Atoi(c.Query("channel_id"))
+	model.ResetChannelBreakers(channelId)
+	common.ApiSuccess(c, nil)
+}
+
+// GetRequestQueues 获取当前节点各个分组、模型的排队深度与等待时间
+func GetRequestQueues(c *gin.Context) {
+	common.ApiSuccess(c, service.GetRequestQueueStats())
 }
 
 // ManageMultiKeys handles multi-key management operations
@@ -1366,6 +1405,12 @@ func ManageMultiKeys(c *gin.Context) {
 		// Statistics for all keys (unchanged by filtering)
 		var enabledCount, manualDisabledCount, autoDisabledCount int
 
+		rateLimits := model.GetChannelKeyRateLimits(channel.Id)
+		var usages map[int]model.KeyUsage
+		if channel.ShouldTrackKeyUsage() {
+			usages = model.GetChannelKeyUsages(channel.Id)
+		}
+
 		// Build all key status data first
 		var allKeyStatusList []KeyStatus
 		for i, key := range keys {
@@ -1404,13 +1449,22 @@ func ManageMultiKeys(c *gin.Context) {
 				keyPreview = key[:10] + "..."
 			}
 
-			allKeyStatusList = append(allKeyStatusList, KeyStatus{
+			keyStatus := KeyStatus{
 				Index:        i,
 				Status:       status,
 				DisabledTime: disabledTime,
 				Reason:       reason,
 				KeyPreview:   keyPreview,
-			})
+			}
+			if rateLimit, ok := rateLimits[i]; ok {
+				keyStatus.RateLimit = &rateLimit
+			}
+			keyStatus.RPMLimit = channel.ChannelInfo.MultiKeyRPMLimit[i]
+			keyStatus.TPMLimit = channel.ChannelInfo.MultiKeyTPMLimit[i]
+			if usage, ok := usages[i]; ok {
+				keyStatus.Usage = &usage
+			}
+			allKeyStatusList = append(allKeyStatusList, keyStatus)
 		}
 
 		// Apply status filter if specified
@@ -1643,6 +1697,8 @@ func ManageMultiKeys(c *gin.Context) {
 		var newStatusList = make(map[int]int)
 		var newDisabledTime = make(map[int]int64)
 		var newDisabledReason = make(map[int]string)
+		var newRPMLimit = make(map[int]int)
+		var newTPMLimit = make(map[int]int)
 
 		newIndex := 0
 		for i, key := range keys {
@@ -1669,6 +1725,7 @@ func ManageMultiKeys(c *gin.Context) {
 					newDisabledReason[newIndex] = r
 				}
 			}
+			reindexKeyLimits(&channel.ChannelInfo, newRPMLimit, newTPMLimit, i, newIndex)
 			newIndex++
 		}
 
@@ -1686,6 +1743,8 @@ func ManageMultiKeys(c *gin.Context) {
 		channel.ChannelInfo.MultiKeyStatusList = newStatusList
 		channel.ChannelInfo.MultiKeyDisabledTime = newDisabledTime
 		channel.ChannelInfo.MultiKeyDisabledReason = newDisabledReason
+		channel.ChannelInfo.MultiKeyRPMLimit = newRPMLimit
+		channel.ChannelInfo.MultiKeyTPMLimit = newTPMLimit
 
 		err = channel.Update()
 		if err != nil {
@@ -1707,6 +1766,8 @@ func ManageMultiKeys(c *gin.Context) {
 		var newStatusList = make(map[int]int)
 		var newDisabledTime = make(map[int]int64)
 		var newDisabledReason = make(map[int]string)
+		var newRPMLimit = make(map[int]int)
+		var newTPMLimit = make(map[int]int)
 
 		newIndex := 0
 		for i, key := range keys {
@@ -1736,6 +1797,7 @@ func ManageMultiKeys(c *gin.Context) {
 						}
 					}
 				}
+				reindexKeyLimits(&channel.ChannelInfo, newRPMLimit, newTPMLimit, i, newIndex)
 				newIndex++
 			}
 		}
@@ -1754,6 +1816,8 @@ func ManageMultiKeys(c *gin.Context) {
 		channel.ChannelInfo.MultiKeyStatusList = newStatusList
 		channel.ChannelInfo.MultiKeyDisabledTime = newDisabledTime
 		channel.ChannelInfo.MultiKeyDisabledReason = newDisabledReason
+		channel.ChannelInfo.MultiKeyRPMLimit = newRPMLimit
+		channel.ChannelInfo.MultiKeyTPMLimit = newTPMLimit
 
 		err = channel.Update()
 		if err != nil {
@@ -1769,6 +1833,66 @@ func ManageMultiKeys(c *gin.Context) {
 		})
 		return
 
+	case "set_key_limit":
+		if request.RPMLimit == nil && request.TPMLimit == nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "未指定 RPM 或 TPM 上限",
+			})
+			return
+		}
+		// 未指定密钥索引时设置所有密钥
+		indexes := make([]int, 0, channel.ChannelInfo.MultiKeySize)
+		if request.KeyIndex != nil {
+			if *request.KeyIndex < 0 || *request.KeyIndex >= channel.ChannelInfo.MultiKeySize {
+				c.JSON(http.StatusOK, gin.H{
+					"success": false,
+					"message": "密钥索引超出范围",
+				})
+				return
+			}
+			indexes = append(indexes, *request.KeyIndex)
+		} else {
+			for i := 0; i < channel.ChannelInfo.MultiKeySize; i++ {
+				indexes = append(indexes, i)
+			}
+		}
+		if channel.ChannelInfo.MultiKeyRPMLimit == nil {
+			channel.ChannelInfo.MultiKeyRPMLimit = make(map[int]int)
+		}
+		if channel.ChannelInfo.MultiKeyTPMLimit == nil {
+			channel.ChannelInfo.MultiKeyTPMLimit = make(map[int]int)
+		}
+		for _, i := range indexes {
+			if request.RPMLimit != nil {
+				if *request.RPMLimit > 0 {
+					channel.ChannelInfo.MultiKeyRPMLimit[i] = *request.RPMLimit
+				} else {
+					delete(channel.ChannelInfo.MultiKeyRPMLimit, i)
+				}
+			}
+			if request.TPMLimit != nil {
+				if *request.TPMLimit > 0 {
+					channel.ChannelInfo.MultiKeyTPMLimit[i] = *request.TPMLimit
+				} else {
+					delete(channel.ChannelInfo.MultiKeyTPMLimit, i)
+				}
+			}
+		}
+
+		err = channel.Update()
+		if err != nil {
+			common.ApiError(c, err)
+			return
+		}
+
+		model.InitChannelCache()
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"message": "密钥限额已更新",
+		})
+		return
+
 	default:
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
@@ -2036,3 +2160,13 @@ func OllamaVersion(c *gin.Context) {
 		},
 	})
 }
+
+// reindexKeyLimits 删除密钥后将原索引的 RPM/TPM 上限移动到新索引
+func reindexKeyLimits(info *model.ChannelInfo, newRPMLimit map[int]int, newTPMLimit map[int]int, oldIndex int, newIndex int) {
+	if limit, exists := info.MultiKeyRPMLimit[oldIndex]; exists {
+		newRPMLimit[newIndex] = limit
+	}
+	if limit, exists := info.MultiKeyTPMLimit[oldIndex]; exists {
+		newTPMLimit[newIndex] = limit
+	}
+}
@@ -0,0 +1,94 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/ctrlc-ctrlv-limited/cvai/common"
+	"github.com/ctrlc-ctrlv-limited/cvai/dto"
+	"github.com/ctrlc-ctrlv-limited/cvai/logger"
+	"github.com/ctrlc-ctrlv-limited/cvai/relay"
+	relaycommon "github.com/ctrlc-ctrlv-limited/cvai/relay/common"
+	"github.com/ctrlc-ctrlv-limited/cvai/relay/helper"
+	"github.com/ctrlc-ctrlv-limited/cvai/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// getCountTokensRequest 按客户端格式解析 count_tokens 请求
+func getCountTokensRequest(c *gin.Context, relayFormat types.RelayFormat) (dto.Request, error) {
+	switch relayFormat {
+	case types.RelayFormatClaude:
+		return helper.GetAndValidateClaudeRequest(c)
+	case types.RelayFormatOpenAIResponses:
+		return helper.GetAndValidateResponsesRequest(c)
+	case types.RelayFormatGemini:
+		request := &dto.GeminiCountTokensRequest{}
+		if err := common.UnmarshalBodyReusable(c, request); err != nil {
+			return nil, err
+		}
+		chatRequest := request.ToChatRequest()
+		if len(chatRequest.Contents) == 0 {
+			return nil, errors.New("contents is required")
+		}
+		return chatRequest, nil
+	default:
+		return nil, errors.New("unsupported relay format")
+	}
+}
+
+// RelayCountTokens POST /v1/messages/count_tokens、/v1/responses/input_tokens、/v1beta/models/{model}:countTokens
+// 计算请求的输入 token 数量，不计费
+func RelayCountTokens(c *gin.Context, relayFormat types.RelayFormat) {
+	var newAPIError *types.CVAIError
+	defer func() {
+		if newAPIError != nil {
+			logger.LogError(c, "count tokens error: "+newAPIError.Error())
+			newAPIError.SetMessage(common.MessageWithRequestId(newAPIError.Error(), c.GetString(common.RequestIdKey)))
+			if relayFormat == types.RelayFormatClaude {
+				c.JSON(newAPIError.StatusCode, gin.H{
+					"type":  "error",
+					"error": newAPIError.ToClaudeError(),
+				})
+			} else {
+				c.JSON(newAPIError.StatusCode, gin.H{
+					"error": newAPIError.ToOpenAIError(),
+				})
+			}
+		}
+	}()
+
+	request, err := getCountTokensRequest(c, relayFormat)
+	if err != nil {
+		if common.IsRequestBodyTooLargeError(err) || errors.Is(err, common.ErrRequestBodyTooLarge) {
+			newAPIError = types.NewErrorWithStatusCode(err, types.ErrorCodeReadRequestBodyFailed, http.StatusRequestEntityTooLarge, types.ErrOptionWithSkipRetry())
+		} else {
+			newAPIError = types.NewError(err, types.ErrorCodeInvalidRequest)
+		}
+		return
+	}
+
+	info, err := relaycommon.GenRelayInfo(c, relayFormat, request, nil)
+	if err != nil {
+		newAPIError = types.NewError(err, types.ErrorCodeGenRelayInfoFailed)
+		return
+	}
+
+	tokens, err := relay.CountTokensHelper(c, info, request)
+	if err != nil {
+		newAPIError = types.NewError(err, types.ErrorCodeCountTokenFailed)
+		return
+	}
+
+	switch relayFormat {
+	case types.RelayFormatClaude:
+		c.JSON(http.StatusOK, dto.ClaudeCountTokensResponse{InputTokens: tokens})
+	case types.RelayFormatOpenAIResponses:
+		c.JSON(http.StatusOK, dto.OpenAIResponsesInputTokensResponse{
+			Object:      "response.input_tokens",
+			InputTokens: tokens,
+		})
+	default:
+		c.JSON(http.StatusOK, dto.GeminiCountTokensResponse{TotalTokens: tokens})
+	}
+}
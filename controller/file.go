@@ -0,0 +1,200 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/ctrlc-ctrlv-limited/cvai/common"
+	"github.com/ctrlc-ctrlv-limited/cvai/dto"
+	"github.com/ctrlc-ctrlv-limited/cvai/logger"
+	"github.com/ctrlc-ctrlv-limited/cvai/model"
+	"github.com/ctrlc-ctrlv-limited/cvai/service"
+	"github.com/ctrlc-ctrlv-limited/cvai/setting/operation_setting"
+	"github.com/ctrlc-ctrlv-limited/cvai/types"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func fileApiError(c *gin.Context, statusCode int, message string, code string) {
+	c.JSON(statusCode, gin.H{
+		"error": types.OpenAIError{
+			Message: message,
+			Type:    "invalid_request_error",
+			Code:    code,
+		},
+	})
+}
+
+// getRelayFile 获取请求路径中的文件，不存在或功能未启用时直接返回错误
+func getRelayFile(c *gin.Context) (*model.File, bool) {
+	if !operation_setting.GetFileSetting().Enabled {
+		RelayNotImplemented(c)
+		return nil, false
+	}
+	file, err := model.GetUserFileByFileId(c.GetInt("id"), c.Param("id"))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			fileApiError(c, http.StatusNotFound, fmt.Sprintf("No such File object: %s", c.Param("id")), "file_not_found")
+		} else {
+			fileApiError(c, http.StatusInternalServerError, err.Error(), "get_file_failed")
+		}
+		return nil, false
+	}
+	return file, true
+}
+
+// RelayListFiles GET /v1/files
+func RelayListFiles(c *gin.Context) {
+	if !operation_setting.GetFileSetting().Enabled {
+		RelayNotImplemented(c)
+		return
+	}
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "10000"))
+	if limit <= 0 || limit > 10000 {
+		limit = 10000
+	}
+	files, hasMore, err := model.GetUserFiles(c.GetInt("id"), c.Query("purpose"), c.Query("after"), limit, c.Query("order") == "asc")
+	if err != nil {
+		fileApiError(c, http.StatusBadRequest, err.Error(), "list_files_failed")
+		return
+	}
+	list := dto.OpenAIFileList{
+		Object:  "list",
+		Data:    make([]dto.OpenAIFile, 0, len(files)),
+		HasMore: hasMore,
+	}
+	for _, file := range files {
+		list.Data = append(list.Data, service.ToOpenAIFile(file))
+	}
+	if len(files) > 0 {
+		list.FirstId = common.GetPointer(files[0].FileId)
+		list.LastId = common.GetPointer(files[len(files)-1].FileId)
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+// RelayUploadFile POST /v1/files
+func RelayUploadFile(c *gin.Context) {
+	if !operation_setting.GetFileSetting().Enabled {
+		RelayNotImplemented(c)
+		return
+	}
+	header, err := c.FormFile("file")
+	if err != nil {
+		fileApiError(c, http.StatusBadRequest, "file is required: "+err.Error(), "invalid_file")
+		return
+	}
+	purpose := c.PostForm("purpose")
+	if purpose == "" {
+		fileApiError(c, http.StatusBadRequest, "purpose is required", "invalid_purpose")
+		return
+	}
+	var expiresAfter int64
+	if seconds := c.PostForm("expires_after[seconds]"); seconds != "" {
+		expiresAfter, err = strconv.ParseInt(seconds, 10, 64)
+		if err != nil || expiresAfter <= 0 {
+			fileApiError(c, http.StatusBadRequest, "invalid expires_after[seconds]", "invalid_expires_after")
+			return
+		}
+	}
+	file, err := service.UploadFile(c, header, purpose, expiresAfter)
+	if err != nil {
+		logger.LogError(c, "upload file failed: "+err.Error())
+		fileApiError(c, http.StatusBadRequest, err.Error(), "upload_file_failed")
+		return
+	}
+	c.JSON(http.StatusOK, service.ToOpenAIFile(file))
+}
+
+// RelayGetFile GET /v1/files/:id
+func RelayGetFile(c *gin.Context) {
+	file, ok := getRelayFile(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, service.ToOpenAIFile(file))
+}
+
+// RelayDeleteFile DELETE /v1/files/:id
+func RelayDeleteFile(c *gin.Context) {
+	file, ok := getRelayFile(c)
+	if !ok {
+		return
+	}
+	if err := service.DeleteFile(c.Request.Context(), file); err != nil {
+		fileApiError(c, http.StatusInternalServerError, err.Error(), "delete_file_failed")
+		return
+	}
+	c.JSON(http.StatusOK, dto.OpenAIFileDeleted{
+		Id:      file.FileId,
+		Object:  "file",
+		Deleted: true,
+	})
+}
+
+// RelayGetFileContent GET /v1/files/:id/content
+func RelayGetFileContent(c *gin.Context) {
+	file, ok := getRelayFile(c)
+	if !ok {
+		return
+	}
+	content, err := service.OpenFileContent(c.Request.Context(), file)
+	if err != nil {
+		fileApiError(c, http.StatusInternalServerError, err.Error(), "read_file_failed")
+		return
+	}
+	defer content.Close()
+	contentType := file.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Length", strconv.FormatInt(file.Bytes, 10))
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", file.Filename))
+	c.Status(http.StatusOK)
+	if _, err = io.Copy(c.Writer, content); err != nil {
+		logger.LogError(c, "write file content failed: "+err.Error())
+	}
+}
+
+// GetAllFiles 管理员查看所有用户的文件
+func GetAllFiles(c *gin.Context) {
+	pageInfo := common.GetPageQuery(c)
+	userId, _ := strconv.Atoi(c.Query("user_id"))
+	files, total, err := model.GetAllFiles(userId, c.Query("purpose"), pageInfo.GetStartIdx(), pageInfo.GetPageSize())
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	pageInfo.SetTotal(int(total))
+	pageInfo.SetItems(files)
+	common.ApiSuccess(c, pageInfo)
+}
+
+// DeleteFileByAdmin 管理员删除文件
+func DeleteFileByAdmin(c *gin.Context) {
+	file, err := model.GetFileByFileId(c.Param("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err = service.DeleteFile(c.Request.Context(), file); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, nil)
+}
+
+// CleanupExpiredFiles 管理员手动清理已过期的文件
+func CleanupExpiredFiles(c *gin.Context) {
+	deleted, err := service.CleanupExpiredFiles()
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, gin.H{"deleted": deleted})
+}
@@ -0,0 +1,119 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/ctrlc-ctrlv-limited/cvai/constant"
+	"github.com/ctrlc-ctrlv-limited/cvai/logger"
+	"github.com/ctrlc-ctrlv-limited/cvai/model"
+	"github.com/ctrlc-ctrlv-limited/cvai/service"
+	"github.com/ctrlc-ctrlv-limited/cvai/setting/operation_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+type fineTuningJobList struct {
+	Object  string            `json:"object"`
+	Data    []json.RawMessage `json:"data"`
+	HasMore bool              `json:"has_more"`
+}
+
+// getRelayFineTuningJob 获取请求路径中的微调任务，不存在或功能未启用时直接返回错误
+func getRelayFineTuningJob(c *gin.Context) (*model.Task, bool) {
+	if !operation_setting.GetFineTuningSetting().Enabled {
+		RelayNotImplemented(c)
+		return nil, false
+	}
+	task, exist, err := model.GetByTaskId(c.GetInt("id"), c.Param("id"))
+	if err != nil {
+		fileApiError(c, http.StatusInternalServerError, err.Error(), "get_fine_tuning_job_failed")
+		return nil, false
+	}
+	if !exist || task.Platform != constant.TaskPlatformFineTuning {
+		fileApiError(c, http.StatusNotFound, fmt.Sprintf("No such fine-tuning job: %s", c.Param("id")), "fine_tuning_job_not_found")
+		return nil, false
+	}
+	return task, true
+}
+
+// RelayCreateFineTuningJob POST /v1/fine_tuning/jobs
+func RelayCreateFineTuningJob(c *gin.Context) {
+	if !operation_setting.GetFineTuningSetting().Enabled {
+		RelayNotImplemented(c)
+		return
+	}
+	task, err := service.CreateFineTuningJob(c)
+	if err != nil {
+		logger.LogError(c, "create fine-tuning job failed: "+err.Error())
+		fileApiError(c, http.StatusBadRequest, err.Error(), "create_fine_tuning_job_failed")
+		return
+	}
+	c.Data(http.StatusOK, "application/json", service.ToFineTuningJob(task))
+}
+
+// RelayListFineTuningJobs GET /v1/fine_tuning/jobs
+func RelayListFineTuningJobs(c *gin.Context) {
+	if !operation_setting.GetFineTuningSetting().Enabled {
+		RelayNotImplemented(c)
+		return
+	}
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+	tasks, hasMore, err := model.GetUserPlatformTasks(c.GetInt("id"), constant.TaskPlatformFineTuning, c.Query("after"), limit)
+	if err != nil {
+		fileApiError(c, http.StatusBadRequest, err.Error(), "list_fine_tuning_jobs_failed")
+		return
+	}
+	list := fineTuningJobList{
+		Object:  "list",
+		Data:    make([]json.RawMessage, 0, len(tasks)),
+		HasMore: hasMore,
+	}
+	for _, task := range tasks {
+		list.Data = append(list.Data, service.ToFineTuningJob(task))
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+// RelayGetFineTuningJob GET /v1/fine_tuning/jobs/:id
+func RelayGetFineTuningJob(c *gin.Context) {
+	task, ok := getRelayFineTuningJob(c)
+	if !ok {
+		return
+	}
+	c.Data(http.StatusOK, "application/json", service.ToFineTuningJob(task))
+}
+
+// RelayCancelFineTuningJob POST /v1/fine_tuning/jobs/:id/cancel
+func RelayCancelFineTuningJob(c *gin.Context) {
+	task, ok := getRelayFineTuningJob(c)
+	if !ok {
+		return
+	}
+	if err := service.CancelFineTuningJob(c.Request.Context(), task); err != nil {
+		fileApiError(c, http.StatusConflict, err.Error(), "cancel_fine_tuning_job_failed")
+		return
+	}
+	c.Data(http.StatusOK, "application/json", service.ToFineTuningJob(task))
+}
+
+// RelayGetFineTuningJobResource GET /v1/fine_tuning/jobs/:id/events、/v1/fine_tuning/jobs/:id/checkpoints
+func RelayGetFineTuningJobResource(resource string) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		task, ok := getRelayFineTuningJob(c)
+		if !ok {
+			return
+		}
+		data, err := service.GetFineTuningJobResource(c.Request.Context(), task, resource, c.Request.URL.Query())
+		if err != nil {
+			fileApiError(c, http.StatusBadGateway, err.Error(), "get_fine_tuning_job_"+resource+"_failed")
+			return
+		}
+		c.Data(http.StatusOK, "application/json", data)
+	}
+}
@@ -168,6 +168,24 @@ func UpdateOption(c *gin.Context) {
 			})
 			return
 		}
+	case "StoragePrice":
+		err = ratio_setting.UpdateStoragePriceByJSONString(option.Value.(string))
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "存储价格设置失败: " + err.Error(),
+			})
+			return
+		}
+	case "ModelPricingRules":
+		err = ratio_setting.UpdateModelPricingRulesByJSONString(option.Value.(string))
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "分档计费规则设置失败: " + err.Error(),
+			})
+			return
+		}
 	case "ModelRequestRateLimitGroup":
 		err = setting.CheckModelRequestRateLimitGroup(option.Value.(string))
 		if err != nil {
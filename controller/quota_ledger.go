@@ -0,0 +1,121 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/ctrlc-ctrlv-limited/cvai/common"
+	"github.com/ctrlc-ctrlv-limited/cvai/model"
+	"github.com/ctrlc-ctrlv-limited/cvai/service"
+	"github.com/ctrlc-ctrlv-limited/cvai/setting/operation_setting"
+
+	"github.com/bytedance/gopkg/util/gopool"
+	"github.com/gin-gonic/gin"
+)
+
+type quotaStatementEntry struct {
+	*model.QuotaLedgerEntry
+	// 该笔分录之后的账户余额
+	Balance int `json:"balance"`
+}
+
+// GetUserQuotaStatement 获取用户账户的额度流水对账单，包含期初余额、收支合计与每笔分录后的余额
+func GetUserQuotaStatement(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	user, err := model.GetUserById(id, false)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	myRole := c.GetInt("role")
+	if myRole <= user.Role && myRole != common.RoleRootUser {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无权获取同级或更高等级用户的信息",
+		})
+		return
+	}
+	pageInfo := common.GetPageQuery(c)
+	startTimestamp, _ := strconv.ParseInt(c.Query("start_timestamp"), 10, 64)
+	endTimestamp, _ := strconv.ParseInt(c.Query("end_timestamp"), 10, 64)
+
+	openingBalance := 0
+	if startTimestamp > 0 {
+		openingBalance, err = model.GetQuotaLedgerBalanceBefore(model.QuotaLedgerAccountUser, id, 0, startTimestamp)
+		if err != nil {
+			common.ApiError(c, err)
+			return
+		}
+	}
+	credit, debit, err := model.SumQuotaLedgerEntries(model.QuotaLedgerAccountUser, id, startTimestamp, endTimestamp)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	entries, total, err := model.GetQuotaLedgerEntries(model.QuotaLedgerAccountUser, id, startTimestamp, endTimestamp, pageInfo.GetStartIdx(), pageInfo.GetPageSize())
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	items := make([]quotaStatementEntry, 0, len(entries))
+	if len(entries) > 0 {
+		balance, err := model.GetQuotaLedgerBalanceBefore(model.QuotaLedgerAccountUser, id, entries[0].Id, 0)
+		if err != nil {
+			common.ApiError(c, err)
+			return
+		}
+		for _, entry := range entries {
+			balance += entry.Amount
+			items = append(items, quotaStatementEntry{QuotaLedgerEntry: entry, Balance: balance})
+		}
+	}
+	pageInfo.SetTotal(int(total))
+	pageInfo.SetItems(items)
+	common.ApiSuccess(c, gin.H{
+		"user_id":         id,
+		"quota":           user.Quota,
+		"opening_balance": openingBalance,
+		"closing_balance": openingBalance + credit - debit,
+		"total_credit":    credit,
+		"total_debit":     debit,
+		"entries":         pageInfo,
+	})
+}
+
+// GetQuotaLedgerDrifts 获取最近一次对账发现的余额差异
+func GetQuotaLedgerDrifts(c *gin.Context) {
+	pageInfo := common.GetPageQuery(c)
+	drifts, total, err := model.GetQuotaLedgerDrifts(pageInfo.GetStartIdx(), pageInfo.GetPageSize())
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	pageInfo.SetTotal(int(total))
+	pageInfo.SetItems(drifts)
+	common.ApiSuccess(c, pageInfo)
+}
+
+// ReconcileQuotaLedger 立即在后台执行一次对账
+func ReconcileQuotaLedger(c *gin.Context) {
+	if !operation_setting.GetQuotaLedgerSetting().Enabled {
+		common.ApiErrorMsg(c, "额度流水未启用")
+		return
+	}
+	if service.IsQuotaLedgerReconciling() {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "对账任务正在执行",
+		})
+		return
+	}
+	gopool.Go(func() {
+		if _, err := service.ReconcileQuotaLedger(); err != nil {
+			common.SysError("failed to reconcile quota ledger: " + err.Error())
+		}
+	})
+	common.ApiSuccess(c, nil)
+}
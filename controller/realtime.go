@@ -0,0 +1,77 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/ctrlc-ctrlv-limited/cvai/logger"
+	"github.com/ctrlc-ctrlv-limited/cvai/relay"
+	"github.com/ctrlc-ctrlv-limited/cvai/service"
+
+	"github.com/bytedance/gopkg/util/gopool"
+	"github.com/gin-gonic/gin"
+)
+
+// RelayRealtimeCall 使用网关签发的临时密钥建立 WebRTC 通话：将 SDP offer 转发到创建会话的渠道，
+// 并通过 sideband WebSocket 监听通话中的用量
+func RelayRealtimeCall(c *gin.Context) {
+	key := strings.TrimSpace(strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer "))
+	// 先取出临时密钥再请求上游，避免并发请求使用同一个临时密钥建立多个通话
+	secret, ok := service.ClaimRealtimeClientSecret(key)
+	if !ok {
+		fileApiError(c, http.StatusUnauthorized, "Invalid or expired ephemeral key.", "invalid_api_key")
+		return
+	}
+
+	path := c.Request.URL.Path
+	query := c.Request.URL.Query()
+	if query.Get("model") != "" {
+		query.Set("model", secret.UpstreamModelName)
+	}
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+	resp, err := service.CreateRealtimeCall(c.Request.Context(), secret, path, c.GetHeader("Content-Type"), c.Request.Body)
+	if err != nil {
+		service.RestoreRealtimeClientSecret(key, secret)
+		fileApiError(c, http.StatusBadGateway, err.Error(), "create_realtime_call_failed")
+		return
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		service.RestoreRealtimeClientSecret(key, secret)
+		fileApiError(c, http.StatusBadGateway, err.Error(), "create_realtime_call_failed")
+		return
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		service.RestoreRealtimeClientSecret(key, secret)
+		c.Data(resp.StatusCode, resp.Header.Get("Content-Type"), body)
+		return
+	}
+
+	// 无法获取 call_id 时无法计量通话用量，不返回 SDP answer
+	location := resp.Header.Get("Location")
+	callId := location[strings.LastIndex(location, "/")+1:]
+	if callId == "" {
+		fileApiError(c, http.StatusBadGateway, "no call id in upstream response", "create_realtime_call_failed")
+		return
+	}
+	conn, err := service.DialRealtimeCall(secret, callId)
+	if err != nil {
+		logger.LogError(c, fmt.Sprintf("failed to connect sideband of realtime call %s: %s", callId, err.Error()))
+		_ = service.HangupRealtimeCall(c.Request.Context(), secret, callId)
+		service.RestoreRealtimeClientSecret(key, secret)
+		fileApiError(c, http.StatusBadGateway, "failed to monitor realtime call", "create_realtime_call_failed")
+		return
+	}
+	gopool.Go(func() {
+		relay.MonitorRealtimeCall(context.Background(), secret, callId, conn)
+	})
+
+	c.Header("Location", location)
+	c.Data(resp.StatusCode, resp.Header.Get("Content-Type"), body)
+}
@@ -8,6 +8,7 @@ import (
 	"log"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/ctrlc-ctrlv-limited/cvai/common"
 	"github.com/ctrlc-ctrlv-limited/cvai/constant"
@@ -32,7 +33,7 @@ import (
 func relayHandler(c *gin.Context, info *relaycommon.RelayInfo) *types.CVAIError {
 	var err *types.CVAIError
 	switch info.RelayMode {
-	case relayconstant.RelayModeImagesGenerations, relayconstant.RelayModeImagesEdits:
+	case relayconstant.RelayModeImagesGenerations, relayconstant.RelayModeImagesEdits, relayconstant.RelayModeImagesVariations:
 		err = relay.ImageHelper(c, info)
 	case relayconstant.RelayModeAudioSpeech:
 		fallthrough
@@ -54,7 +55,9 @@ func relayHandler(c *gin.Context, info *relaycommon.RelayInfo) *types.CVAIError
 
 func geminiRelayHandler(c *gin.Context, info *relaycommon.RelayInfo) *types.CVAIError {
 	var err *types.CVAIError
-	if strings.Contains(c.Request.URL.Path, "embed") {
+	if info.RelayMode == relayconstant.RelayModeGeminiLive {
+		err = relay.GeminiLiveHelper(c, info)
+	} else if strings.Contains(c.Request.URL.Path, "embed") {
 		err = relay.GeminiEmbeddingHandler(c, info)
 	} else {
 		err = relay.GeminiHelper(c, info)
@@ -62,6 +65,23 @@ func geminiRelayHandler(c *gin.Context, info *relaycommon.RelayInfo) *types.CVAI
 	return err
 }
 
+func relayByFormat(c *gin.Context, relayFormat types.RelayFormat, info *relaycommon.RelayInfo) *types.CVAIError {
+	switch relayFormat {
+	case types.RelayFormatOpenAIRealtime:
+		return relay.WssHelper(c, info)
+	case types.RelayFormatOpenAIRealtimeSession:
+		return relay.RealtimeSessionHelper(c, info)
+	case types.RelayFormatClaude:
+		return relay.ClaudeHelper(c, info)
+	case types.RelayFormatGemini:
+		return geminiRelayHandler(c, info)
+	case types.RelayFormatModeration:
+		return relay.ModerationHelper(c, info)
+	default:
+		return relayHandler(c, info)
+	}
+}
+
 func Relay(c *gin.Context, relayFormat types.RelayFormat) {
 
 	requestId := c.GetString(common.RequestIdKey)
@@ -81,16 +101,21 @@ func Relay(c *gin.Context, relayFormat types.RelayFormat) {
 			return
 		}
 		defer ws.Close()
+	} else if liveWs, ok := common.GetContextKeyType[*websocket.Conn](c, constant.ContextKeyGeminiLiveConn); ok {
+		// Gemini Live 的连接已在 GeminiLiveSetup 中升级
+		ws = liveWs
 	}
 
 	defer func() {
 		if newAPIError != nil {
 			logger.LogError(c, fmt.Sprintf("relay error: %s", newAPIError.Error()))
 			newAPIError.SetMessage(common.MessageWithRequestId(newAPIError.Error(), requestId))
-			switch relayFormat {
-			case types.RelayFormatOpenAIRealtime:
+			switch {
+			case relayFormat == types.RelayFormatOpenAIRealtime:
 				helper.WssError(c, ws, newAPIError.ToOpenAIError())
-			case types.RelayFormatClaude:
+			case relayFormat == types.RelayFormatGemini && ws != nil:
+				helper.GeminiLiveError(ws, newAPIError.StatusCode, newAPIError.Error())
+			case relayFormat == types.RelayFormatClaude:
 				c.JSON(newAPIError.StatusCode, gin.H{
 					"type":  "error",
 					"error": newAPIError.ToClaudeError(),
@@ -139,6 +164,11 @@ func Relay(c *gin.Context, relayFormat types.RelayFormat) {
 		}
 	}
 
+	newAPIError = moderationPreflight(c, relayInfo, request)
+	if newAPIError != nil {
+		return
+	}
+
 	tokens, err := service.EstimateRequestToken(c, meta, relayInfo)
 	if err != nil {
 		newAPIError = types.NewError(err, types.ErrorCodeCountTokenFailed)
@@ -178,47 +208,74 @@ func Relay(c *gin.Context, relayFormat types.RelayFormat) {
 		Retry:      common.GetPointer(0),
 	}
 
-	for ; retryParam.GetRetry() <= common.RetryTimes; retryParam.IncreaseRetry() {
-		channel, channelErr := getChannel(c, relayInfo, retryParam)
-		if channelErr != nil {
-			logger.LogError(c, channelErr.Error())
-			newAPIError = channelErr
-			break
-		}
+	fallbackModels := getFallbackModels(c, relayFormat, relayInfo.OriginModelName)
+	if len(fallbackModels) > 0 {
+		c.Header(servedModelHeader, relayInfo.OriginModelName)
+	}
+
+	hedgeTried := false
+	for fallbackIndex := 0; ; fallbackIndex++ {
+		for ; retryParam.GetRetry() <= common.RetryTimes; retryParam.IncreaseRetry() {
+			channel, channelErr := getChannel(c, relayInfo, retryParam)
+			if channelErr != nil {
+				logger.LogError(c, channelErr.Error())
+				newAPIError = channelErr
+				break
+			}
+
+			addUsedChannel(c, channel.Id)
+			requestBody, bodyErr := common.GetRequestBody(c)
+			if bodyErr != nil {
+				// Ensure consistent 413 for oversized bodies even when error occurs later (e.g., retry path)
+				if common.IsRequestBodyTooLargeError(bodyErr) || errors.Is(bodyErr, common.ErrRequestBodyTooLarge) {
+					newAPIError = types.NewErrorWithStatusCode(bodyErr, types.ErrorCodeReadRequestBodyFailed, http.StatusRequestEntityTooLarge, types.ErrOptionWithSkipRetry())
+				} else {
+					newAPIError = types.NewErrorWithStatusCode(bodyErr, types.ErrorCodeReadRequestBodyFailed, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+				}
+				break
+			}
+			c.Request.Body = io.NopCloser(bytes.NewBuffer(requestBody))
 
-		addUsedChannel(c, channel.Id)
-		requestBody, bodyErr := common.GetRequestBody(c)
-		if bodyErr != nil {
-			// Ensure consistent 413 for oversized bodies even when error occurs later (e.g., retry path)
-			if common.IsRequestBodyTooLargeError(bodyErr) || errors.Is(bodyErr, common.ErrRequestBodyTooLarge) {
-				newAPIError = types.NewErrorWithStatusCode(bodyErr, types.ErrorCodeReadRequestBodyFailed, http.StatusRequestEntityTooLarge, types.ErrOptionWithSkipRetry())
+			if !hedgeTried && shouldHedge(c, relayFormat, relayInfo) {
+				hedgeTried = true
+				channel, newAPIError = relayWithHedge(c, relayFormat, relayInfo, channel, retryParam)
 			} else {
-				newAPIError = types.NewErrorWithStatusCode(bodyErr, types.ErrorCodeReadRequestBodyFailed, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+				attemptStart := time.Now()
+				newAPIError = func() *types.CVAIError {
+					defer service.AcquireChannelConcurrency(c, channel.Id)()
+					return relayByFormat(c, relayFormat, relayInfo)
+				}()
+				service.RecordChannelScore(relayInfo, channel.Id, attemptStart, newAPIError)
+				service.RecordChannelBreaker(c, channel, relayInfo.OriginModelName, newAPIError)
 			}
-			break
-		}
-		c.Request.Body = io.NopCloser(bytes.NewBuffer(requestBody))
 
-		switch relayFormat {
-		case types.RelayFormatOpenAIRealtime:
-			newAPIError = relay.WssHelper(c, relayInfo)
-		case types.RelayFormatClaude:
-			newAPIError = relay.ClaudeHelper(c, relayInfo)
-		case types.RelayFormatGemini:
-			newAPIError = geminiRelayHandler(c, relayInfo)
-		default:
-			newAPIError = relayHandler(c, relayInfo)
-		}
+			if newAPIError == nil {
+				service.BindStickySession(c)
+				return
+			}
 
-		if newAPIError == nil {
-			return
-		}
+			processChannelError(c, *types.NewChannelError(channel.Id, channel.Type, channel.Name, channel.ChannelInfo.IsMultiKey, common.GetContextKeyString(c, constant.ContextKeyChannelKey), channel.GetAutoBan()), newAPIError)
+
+			if !shouldRetry(c, newAPIError, common.RetryTimes-retryParam.GetRetry()) {
+				break
+			}
 
-		processChannelError(c, *types.NewChannelError(channel.Id, channel.Type, channel.Name, channel.ChannelInfo.IsMultiKey, common.GetContextKeyString(c, constant.ContextKeyChannelKey), channel.GetAutoBan()), newAPIError)
+		}
 
-		if !shouldRetry(c, newAPIError, common.RetryTimes-retryParam.GetRetry()) {
+		// 当前模型的渠道全部失败，按降级链切换到下一个模型
+		if fallbackIndex >= len(fallbackModels) || !shouldFallbackModel(relayInfo, newAPIError) {
+			break
+		}
+		if fallbackErr := switchFallbackModel(c, relayInfo, fallbackModels[fallbackIndex], tokens, meta); fallbackErr != nil {
+			newAPIError = fallbackErr
 			break
 		}
+		retryParam = &service.RetryParam{
+			Ctx:        c,
+			TokenGroup: relayInfo.TokenGroup,
+			ModelName:  relayInfo.OriginModelName,
+			Retry:      common.GetPointer(0),
+		}
 	}
 
 	useChannel := c.GetStringSlice("use_channel")
@@ -260,7 +317,7 @@ func fastTokenCountMetaForPricing(request dto.Request) *types.TokenCountMeta {
 	case *dto.ClaudeRequest:
 		meta.MaxTokens = int(r.MaxTokens)
 	case *dto.ImageRequest:
-		// Pricing for image requests depends on ImagePriceRatio; safe to compute even when CountToken is disabled.
+		// Image requests are priced per image from the request itself; the meta is cheap to build.
 		return r.GetTokenCountMeta()
 	default:
 		// Best-effort: leave CombineText empty to avoid large allocations.
@@ -269,35 +326,78 @@ func fastTokenCountMetaForPricing(request dto.Request) *types.TokenCountMeta {
 }
 
 func getChannel(c *gin.Context, info *relaycommon.RelayInfo, retryParam *service.RetryParam) (*model.Channel, *types.CVAIError) {
+	// 选中的渠道没有可用的 key（例如都达到了 RPM/TPM 上限，或半开探测名额已被并发请求占满）时跳过该渠道重新选择，不消耗重试次数
+	var noKeyErr *types.CVAIError
 	if info.ChannelMeta == nil {
 		autoBan := c.GetBool("auto_ban")
 		autoBanInt := 1
 		if !autoBan {
 			autoBanInt = 0
 		}
-		return &model.Channel{
+		channel := &model.Channel{
 			Id:      c.GetInt("channel_id"),
 			Type:    c.GetInt("channel_type"),
 			Name:    c.GetString("channel_name"),
 			AutoBan: &autoBanInt,
-		}, nil
+		}
+		noKeyErr = acquireChannelBreaker(c, channel, info.OriginModelName)
+		if noKeyErr == nil {
+			return channel, nil
+		}
+		if isChannelPinned(c) {
+			return nil, noKeyErr
+		}
+		service.ReleaseChannelConcurrencyReservation(c)
+		retryParam.ExcludeChannel(channel.Id)
 	}
-	channel, selectGroup, err := service.CacheGetRandomSatisfiedChannel(retryParam)
+	for {
+		channel, selectGroup, err := service.CacheGetRandomSatisfiedChannel(retryParam)
 
-	info.PriceData.GroupRatioInfo = helper.HandleGroupRatio(c, info)
+		info.PriceData.GroupRatioInfo = helper.HandleGroupRatio(c, info)
 
-	if err != nil {
-		return nil, types.NewError(fmt.Errorf("获取分组 %s 下模型 %s 的可用渠道失败（retry）: %s", selectGroup, info.OriginModelName, err.Error()), types.ErrorCodeGetChannelFailed, types.ErrOptionWithSkipRetry())
+		if err != nil {
+			return nil, types.NewError(fmt.Errorf("获取分组 %s 下模型 %s 的可用渠道失败（retry）: %s", selectGroup, info.OriginModelName, err.Error()), types.ErrorCodeGetChannelFailed, types.ErrOptionWithSkipRetry())
+		}
+		if channel == nil {
+			if noKeyErr != nil {
+				return nil, noKeyErr
+			}
+			return nil, types.NewError(fmt.Errorf("分组 %s 下模型 %s 的可用渠道不存在（retry）", selectGroup, info.OriginModelName), types.ErrorCodeGetChannelFailed, types.ErrOptionWithSkipRetry())
+		}
+
+		newAPIError := middleware.SetupContextForSelectedChannel(c, channel, info.OriginModelName)
+		if newAPIError == nil {
+			newAPIError = acquireChannelBreaker(c, channel, info.OriginModelName)
+		}
+		if newAPIError == nil {
+			return channel, nil
+		}
+		if newAPIError.GetErrorCode() != types.ErrorCodeChannelNoAvailableKey || isChannelPinned(c) {
+			return nil, newAPIError
+		}
+		logger.LogDebug(c, "channel #%d has no available key, selecting another channel", channel.Id)
+		service.ReleaseChannelConcurrencyReservation(c)
+		retryParam.ExcludeChannel(channel.Id)
+		noKeyErr = newAPIError
 	}
-	if channel == nil {
-		return nil, types.NewError(fmt.Errorf("分组 %s 下模型 %s 的可用渠道不存在（retry）", selectGroup, info.OriginModelName), types.ErrorCodeGetChannelFailed, types.ErrOptionWithSkipRetry())
+}
+
+// acquireChannelBreaker 转发前为选中的 key 占用半开探测名额，key 已熔断或名额已满时返回 ErrorCodeChannelNoAvailableKey。
+// 只在会记录熔断结果的转发中占用，任务、Midjourney 等接口选择渠道时不占用
+func acquireChannelBreaker(c *gin.Context, channel *model.Channel, modelName string) *types.CVAIError {
+	if service.AcquireChannelBreaker(c, channel, modelName) {
+		return nil
 	}
+	return types.NewError(fmt.Errorf("渠道 #%d 的 key 在模型 %s 上已熔断", channel.Id, modelName), types.ErrorCodeChannelNoAvailableKey)
+}
 
-	newAPIError := middleware.SetupContextForSelectedChannel(c, channel, info.OriginModelName)
-	if newAPIError != nil {
-		return nil, newAPIError
+// isChannelPinned 令牌指定了渠道，或请求引用的微调模型、response、向量库要求固定使用某个渠道时，不切换到其他渠道
+func isChannelPinned(c *gin.Context) bool {
+	if _, ok := common.GetContextKey(c, constant.ContextKeyTokenSpecificChannelId); ok {
+		return true
 	}
-	return channel, nil
+	_, ok := common.GetContextKey(c, constant.ContextKeyPinnedChannel)
+	return ok
 }
 
 func shouldRetry(c *gin.Context, openaiErr *types.CVAIError, retryTimes int) bool {
@@ -313,7 +413,7 @@ func shouldRetry(c *gin.Context, openaiErr *types.CVAIError, retryTimes int) boo
 	if retryTimes <= 0 {
 		return false
 	}
-	if _, ok := c.Get("specific_channel_id"); ok {
+	if isChannelPinned(c) {
 		return false
 	}
 	if openaiErr.StatusCode == http.StatusTooManyRequests {
@@ -525,7 +625,7 @@ func shouldRetryTaskRelay(c *gin.Context, channelId int, taskErr *dto.TaskError,
 	if retryTimes <= 0 {
 		return false
 	}
-	if _, ok := c.Get("specific_channel_id"); ok {
+	if isChannelPinned(c) {
 		return false
 	}
 	if taskErr.StatusCode == http.StatusTooManyRequests {
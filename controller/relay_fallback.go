@@ -0,0 +1,93 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/ctrlc-ctrlv-limited/cvai/common"
+	"github.com/ctrlc-ctrlv-limited/cvai/constant"
+	"github.com/ctrlc-ctrlv-limited/cvai/logger"
+	relaycommon "github.com/ctrlc-ctrlv-limited/cvai/relay/common"
+	"github.com/ctrlc-ctrlv-limited/cvai/relay/helper"
+	"github.com/ctrlc-ctrlv-limited/cvai/service"
+	"github.com/ctrlc-ctrlv-limited/cvai/setting/operation_setting"
+	"github.com/ctrlc-ctrlv-limited/cvai/setting/ratio_setting"
+	"github.com/ctrlc-ctrlv-limited/cvai/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 响应头中返回实际提供服务的模型
+const servedModelHeader = "X-Served-Model"
+
+// getFallbackModels 获取模型的降级链，跳过令牌无权访问的模型，指定渠道和实时接口不降级
+func getFallbackModels(c *gin.Context, relayFormat types.RelayFormat, modelName string) []string {
+	if relayFormat == types.RelayFormatOpenAIRealtime || relayFormat == types.RelayFormatOpenAIRealtimeSession {
+		return nil
+	}
+	if _, ok := common.GetContextKey(c, constant.ContextKeyGeminiLiveConn); ok {
+		return nil
+	}
+	if isChannelPinned(c) {
+		return nil
+	}
+	fallbacks := operation_setting.GetModelFallbacks(modelName)
+	if len(fallbacks) == 0 {
+		return nil
+	}
+	var tokenModelLimit map[string]bool
+	if common.GetContextKeyBool(c, constant.ContextKeyTokenModelLimitEnabled) {
+		tokenModelLimit, _ = common.GetContextKeyType[map[string]bool](c, constant.ContextKeyTokenModelLimit)
+		if tokenModelLimit == nil {
+			return nil
+		}
+	}
+	models := make([]string, 0, len(fallbacks))
+	for _, fallback := range fallbacks {
+		if fallback == "" || fallback == modelName {
+			continue
+		}
+		if tokenModelLimit != nil && !tokenModelLimit[ratio_setting.FormatMatchingModelName(fallback)] {
+			continue
+		}
+		models = append(models, fallback)
+	}
+	return models
+}
+
+// shouldFallbackModel 当前模型的渠道全部失败、被限流或无可用渠道时才降级，已向客户端输出内容时不降级
+func shouldFallbackModel(info *relaycommon.RelayInfo, err *types.CVAIError) bool {
+	if err == nil || info.HasSendResponse() {
+		return false
+	}
+	if err.GetErrorCode() == types.ErrorCodeGetChannelFailed || types.IsChannelError(err) {
+		return true
+	}
+	return err.StatusCode == http.StatusTooManyRequests || err.StatusCode/100 == 5
+}
+
+// switchFallbackModel 切换到备用模型：退还原模型的预扣费，按新模型重新计算价格并预扣费
+func switchFallbackModel(c *gin.Context, info *relaycommon.RelayInfo, modelName string, tokens int, meta *types.TokenCountMeta) *types.CVAIError {
+	logger.LogInfo(c, fmt.Sprintf("模型 %s 无可用渠道，降级到模型 %s", info.OriginModelName, modelName))
+	if info.FinalPreConsumedQuota != 0 {
+		// 返还在异步任务中进行，使用副本避免与后续的预扣费相互影响
+		returnInfo := *info
+		service.ReturnPreConsumedQuota(c, &returnInfo)
+		info.FinalPreConsumedQuota = 0
+	}
+
+	info.OriginModelName = modelName
+	common.SetContextKey(c, constant.ContextKeyOriginalModel, modelName)
+	common.SetContextKey(c, constant.ContextKeyAutoGroupIndex, 0)
+	common.SetContextKey(c, constant.ContextKeyAutoGroupRetryIndex, 0)
+	c.Header(servedModelHeader, modelName)
+
+	priceData, err := helper.ModelPriceHelper(c, info, tokens, meta)
+	if err != nil {
+		return types.NewError(err, types.ErrorCodeModelPriceError, types.ErrOptionWithSkipRetry())
+	}
+	if priceData.FreeModel {
+		return nil
+	}
+	return service.PreConsumeQuota(c, priceData.QuotaToPreConsume, info)
+}
@@ -0,0 +1,259 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/ctrlc-ctrlv-limited/cvai/common"
+	"github.com/ctrlc-ctrlv-limited/cvai/constant"
+	"github.com/ctrlc-ctrlv-limited/cvai/dto"
+	"github.com/ctrlc-ctrlv-limited/cvai/logger"
+	"github.com/ctrlc-ctrlv-limited/cvai/middleware"
+	"github.com/ctrlc-ctrlv-limited/cvai/model"
+	relaycommon "github.com/ctrlc-ctrlv-limited/cvai/relay/common"
+	"github.com/ctrlc-ctrlv-limited/cvai/relay/helper"
+	"github.com/ctrlc-ctrlv-limited/cvai/service"
+	"github.com/ctrlc-ctrlv-limited/cvai/setting/operation_setting"
+	"github.com/ctrlc-ctrlv-limited/cvai/types"
+
+	"github.com/bytedance/gopkg/util/gopool"
+	"github.com/gin-gonic/gin"
+)
+
+// 选择对冲渠道时最多尝试的次数，避免一直选中主请求的渠道
+const hedgeSelectAttempts = 3
+
+type hedgeAttempt struct {
+	ctx     *gin.Context
+	cancel  context.CancelFunc
+	info    *relaycommon.RelayInfo
+	channel *model.Channel
+	start   time.Time
+	err     *types.CVAIError
+	done    bool
+}
+
+type hedgeResult struct {
+	attempt int
+	err     *types.CVAIError
+}
+
+// shouldHedge 判断本次请求是否需要对冲，指定渠道和实时接口不对冲
+func shouldHedge(c *gin.Context, relayFormat types.RelayFormat, info *relaycommon.RelayInfo) bool {
+	if relayFormat == types.RelayFormatOpenAIRealtime || relayFormat == types.RelayFormatOpenAIRealtimeSession || info.ChannelMeta == nil {
+		return false
+	}
+	if _, ok := common.GetContextKey(c, constant.ContextKeyGeminiLiveConn); ok {
+		return false
+	}
+	if isChannelPinned(c) {
+		return false
+	}
+	// background 模式的 response 会在上游各创建一个后台任务
+	if request, ok := info.Request.(*dto.OpenAIResponsesRequest); ok && request.IsBackground() {
+		return false
+	}
+	return operation_setting.ShouldHedgeModel(info.OriginModelName, info.IsStream)
+}
+
+// newHedgeContext 为一次对冲请求复制上下文，写入经过 HedgeWriter 包装，上游请求可被单独取消
+func newHedgeContext(c *gin.Context, state *service.HedgeState, attempt int) (*gin.Context, context.CancelFunc, error) {
+	requestBody, err := common.GetRequestBody(c)
+	if err != nil {
+		return nil, nil, err
+	}
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	cp := c.Copy()
+	cp.Request = c.Request.Clone(ctx)
+	cp.Request.Body = io.NopCloser(bytes.NewBuffer(requestBody))
+	cp.Writer = service.NewHedgeWriter(c.Writer, state, attempt)
+	common.SetContextKey(cp, constant.ContextKeyHedgeState, state)
+	common.SetContextKey(cp, constant.ContextKeyHedgeAttempt, attempt)
+	// 主请求沿用选择渠道时占用的并发名额与探测名额，对冲请求选择渠道时另行占用
+	service.TransferChannelConcurrencyReservation(c, cp)
+	service.TransferChannelBreaker(c, cp)
+	return cp, cancel, nil
+}
+
+// copyHedgeKeys 将胜出一方上下文中的信息同步回原始上下文，供后续日志与重试使用
+func copyHedgeKeys(c *gin.Context, cp *gin.Context) {
+	for key, value := range cp.Keys {
+		if key == string(constant.ContextKeyHedgeState) || key == string(constant.ContextKeyHedgeAttempt) {
+			continue
+		}
+		c.Set(key, value)
+	}
+}
+
+// selectHedgeChannel 为对冲请求选择一个与主请求不同的渠道
+func selectHedgeChannel(cp *gin.Context, info *relaycommon.RelayInfo, primary *model.Channel, retryParam *service.RetryParam) *model.Channel {
+	param := &service.RetryParam{
+		Ctx:        cp,
+		TokenGroup: info.TokenGroup,
+		ModelName:  info.OriginModelName,
+		Retry:      common.GetPointer(retryParam.GetRetry()),
+	}
+	for i := 0; i < hedgeSelectAttempts; i++ {
+		channel, _, err := service.CacheGetRandomSatisfiedChannel(param)
+		if err != nil || channel == nil {
+			return nil
+		}
+		if channel.Id == primary.Id {
+			continue
+		}
+		if setupErr := middleware.SetupContextForSelectedChannel(cp, channel, info.OriginModelName); setupErr != nil {
+			break
+		}
+		if !service.AcquireChannelBreaker(cp, channel, info.OriginModelName) {
+			continue
+		}
+		return channel
+	}
+	service.ReleaseChannelConcurrencyReservation(cp)
+	return nil
+}
+
+// startHedgeBackup 在另一个渠道上发起对冲请求，计费信息沿用主请求的预扣费结果
+func startHedgeBackup(c *gin.Context, relayFormat types.RelayFormat, primary *hedgeAttempt, state *service.HedgeState, retryParam *service.RetryParam, priceData types.PriceData) (*hedgeAttempt, error) {
+	cp, cancel, err := newHedgeContext(c, state, service.HedgeAttemptBackup)
+	if err != nil {
+		return nil, err
+	}
+	channel := selectHedgeChannel(cp, primary.info, primary.channel, retryParam)
+	if channel == nil {
+		cancel()
+		return nil, errors.New("no other channel available")
+	}
+	request, err := helper.GetAndValidateRequest(cp, relayFormat)
+	if err != nil {
+		service.ReleaseChannelConcurrencyReservation(cp)
+		service.ReleaseChannelBreaker(cp)
+		cancel()
+		return nil, err
+	}
+	info, err := relaycommon.GenRelayInfo(cp, relayFormat, request, nil)
+	if err != nil {
+		service.ReleaseChannelConcurrencyReservation(cp)
+		service.ReleaseChannelBreaker(cp)
+		cancel()
+		return nil, err
+	}
+	info.SetEstimatePromptTokens(primary.info.GetEstimatePromptTokens())
+	info.PriceData = priceData
+	info.UserQuota = primary.info.UserQuota
+	info.FinalPreConsumedQuota = primary.info.FinalPreConsumedQuota
+	info.DisablePing = true
+	state.SetChannel(service.HedgeAttemptBackup, channel.Id)
+	return &hedgeAttempt{ctx: cp, cancel: cancel, info: info, channel: channel}, nil
+}
+
+func runHedgeAttempt(relayFormat types.RelayFormat, index int, attempt *hedgeAttempt, results chan<- hedgeResult) {
+	attempt.start = time.Now()
+	gopool.Go(func() {
+		var err *types.CVAIError
+		release := service.AcquireChannelConcurrency(attempt.ctx, attempt.channel.Id)
+		defer func() {
+			release()
+			if r := recover(); r != nil {
+				err = types.NewError(fmt.Errorf("hedge request panic: %v", r), types.ErrorCodeDoRequestFailed)
+			}
+			results <- hedgeResult{attempt: index, err: err}
+		}()
+		err = relayByFormat(attempt.ctx, relayFormat, attempt.info)
+	})
+}
+
+// relayWithHedge 主请求超过设定时间仍未开始输出时，向另一个渠道发起相同的请求，
+// 先向客户端输出的一方胜出，另一方被取消且不计费。
+// 返回最终结果对应的渠道及错误，未胜出的对冲请求的错误在内部处理。
+func relayWithHedge(c *gin.Context, relayFormat types.RelayFormat, info *relaycommon.RelayInfo, channel *model.Channel, retryParam *service.RetryParam) (*model.Channel, *types.CVAIError) {
+	state := service.NewHedgeState()
+	state.SetChannel(service.HedgeAttemptPrimary, channel.Id)
+	state.SetEstimatePromptTokens(info.GetEstimatePromptTokens())
+	// 主请求会修改 PriceData，提前保存一份供对冲请求使用
+	priceData := info.PriceData
+	info.DisablePing = true
+
+	cp, cancel, err := newHedgeContext(c, state, service.HedgeAttemptPrimary)
+	if err != nil {
+		return channel, types.NewErrorWithStatusCode(err, types.ErrorCodeReadRequestBodyFailed, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+	}
+	attempts := [2]*hedgeAttempt{{ctx: cp, cancel: cancel, info: info, channel: channel}}
+	results := make(chan hedgeResult, len(attempts))
+	runHedgeAttempt(relayFormat, service.HedgeAttemptPrimary, attempts[0], results)
+
+	timer := time.NewTimer(time.Duration(operation_setting.GetHedgeSetting().DelayMs) * time.Millisecond)
+	defer timer.Stop()
+	claimed := state.Claimed()
+	pending := 1
+	for pending > 0 {
+		select {
+		case result := <-results:
+			pending--
+			attempts[result.attempt].err = result.err
+			attempts[result.attempt].done = true
+		case <-claimed:
+			claimed = nil
+			// 已有一方开始输出，取消另一方
+			if loser := attempts[1-state.Winner()]; loser != nil {
+				loser.cancel()
+			}
+		case <-timer.C:
+			if attempts[0].done || state.Winner() != -1 {
+				continue
+			}
+			backup, backupErr := startHedgeBackup(c, relayFormat, attempts[0], state, retryParam, priceData)
+			if backupErr != nil {
+				logger.LogWarn(c, fmt.Sprintf("hedge request skipped: %s", backupErr.Error()))
+				continue
+			}
+			logger.LogInfo(c, fmt.Sprintf("channel #%d has no response after %dms, hedging to channel #%d",
+				channel.Id, operation_setting.GetHedgeSetting().DelayMs, backup.channel.Id))
+			attempts[1] = backup
+			pending++
+			runHedgeAttempt(relayFormat, service.HedgeAttemptBackup, backup, results)
+		}
+	}
+	for _, attempt := range attempts {
+		if attempt != nil {
+			attempt.cancel()
+		}
+	}
+
+	winner := state.Winner()
+	for i, attempt := range attempts {
+		if attempt == nil {
+			continue
+		}
+		if winner != -1 && winner != i {
+			// 落败的一方被主动取消，不计入渠道统计，归还占用的探测名额
+			service.ReleaseChannelBreaker(attempt.ctx)
+			continue
+		}
+		service.RecordChannelScore(attempt.info, attempt.channel.Id, attempt.start, attempt.err)
+		service.RecordChannelBreaker(attempt.ctx, attempt.channel, attempt.info.OriginModelName, attempt.err)
+	}
+
+	backup := attempts[1]
+	if backup == nil {
+		copyHedgeKeys(c, attempts[0].ctx)
+		return channel, attempts[0].err
+	}
+	if winner == service.HedgeAttemptBackup || (winner == -1 && backup.err == nil && attempts[0].err != nil) {
+		copyHedgeKeys(c, backup.ctx)
+		addUsedChannel(c, backup.channel.Id)
+		return backup.channel, backup.err
+	}
+	if winner == -1 && backup.err != nil {
+		// 两个请求都失败，对冲渠道的错误在此处理，主请求的错误交给调用方处理
+		processChannelError(backup.ctx, *types.NewChannelError(backup.channel.Id, backup.channel.Type, backup.channel.Name, backup.channel.ChannelInfo.IsMultiKey, common.GetContextKeyString(backup.ctx, constant.ContextKeyChannelKey), backup.channel.GetAutoBan()), backup.err)
+	}
+	copyHedgeKeys(c, attempts[0].ctx)
+	addUsedChannel(c, backup.channel.Id)
+	return channel, attempts[0].err
+}
@@ -0,0 +1,91 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/ctrlc-ctrlv-limited/cvai/common"
+	"github.com/ctrlc-ctrlv-limited/cvai/dto"
+	"github.com/ctrlc-ctrlv-limited/cvai/logger"
+	"github.com/ctrlc-ctrlv-limited/cvai/middleware"
+	"github.com/ctrlc-ctrlv-limited/cvai/relay"
+	relaycommon "github.com/ctrlc-ctrlv-limited/cvai/relay/common"
+	relayconstant "github.com/ctrlc-ctrlv-limited/cvai/relay/constant"
+	"github.com/ctrlc-ctrlv-limited/cvai/service"
+	"github.com/ctrlc-ctrlv-limited/cvai/setting/operation_setting"
+	"github.com/ctrlc-ctrlv-limited/cvai/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// moderationPreflight 聊天请求转发前使用审核模型检查输入，内容被标记时拒绝请求
+func moderationPreflight(c *gin.Context, info *relaycommon.RelayInfo, request dto.Request) *types.CVAIError {
+	if !operation_setting.ShouldModerationPreflight(info.UsingGroup) {
+		return nil
+	}
+	switch request.(type) {
+	case *dto.GeneralOpenAIRequest:
+		if info.RelayMode != relayconstant.RelayModeChatCompletions && info.RelayMode != relayconstant.RelayModeCompletions {
+			return nil
+		}
+	case *dto.ClaudeRequest, *dto.OpenAIResponsesRequest, *dto.GeminiChatRequest:
+	default:
+		return nil
+	}
+	text := request.GetTokenCountMeta().CombineText
+	if strings.TrimSpace(text) == "" {
+		return nil
+	}
+
+	response, newAPIError := doModerationPreflight(c, info, text)
+	if newAPIError != nil {
+		if operation_setting.GetModerationSetting().FailOpen {
+			logger.LogWarn(c, fmt.Sprintf("moderation preflight failed, request allowed: %s", newAPIError.Error()))
+			return nil
+		}
+		return types.NewErrorWithStatusCode(fmt.Errorf("moderation preflight failed: %s", newAPIError.Error()), types.ErrorCodeModerationFailed, http.StatusServiceUnavailable, types.ErrOptionWithSkipRetry())
+	}
+	if !response.IsFlagged() {
+		return nil
+	}
+	categories := response.FlaggedCategories()
+	if !operation_setting.ShouldBlockModeration(categories) {
+		logger.LogInfo(c, fmt.Sprintf("moderation preflight flagged categories not blocked: %s", strings.Join(categories, ", ")))
+		return nil
+	}
+	logger.LogWarn(c, fmt.Sprintf("user input flagged by moderation: %s", strings.Join(categories, ", ")))
+	return types.NewErrorWithStatusCode(fmt.Errorf("input flagged by moderation: %s", strings.Join(categories, ", ")), types.ErrorCodeModerationFlagged, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+}
+
+// doModerationPreflight 在复制的上下文中为审核模型选择渠道并发起审核，不影响原请求的渠道选择，预检调用不计费
+func doModerationPreflight(c *gin.Context, info *relaycommon.RelayInfo, text string) (*dto.ModerationResponse, *types.CVAIError) {
+	modelName := operation_setting.GetModerationSetting().PreflightModel
+	cp := c.Copy()
+	channel, selectGroup, err := service.CacheGetRandomSatisfiedChannel(&service.RetryParam{
+		Ctx:        cp,
+		TokenGroup: info.TokenGroup,
+		ModelName:  modelName,
+		Retry:      common.GetPointer(0),
+	})
+	if err != nil {
+		return nil, types.NewError(fmt.Errorf("获取分组 %s 下模型 %s 的可用渠道失败: %s", selectGroup, modelName, err.Error()), types.ErrorCodeGetChannelFailed)
+	}
+	if channel == nil {
+		return nil, types.NewError(fmt.Errorf("分组 %s 下模型 %s 的可用渠道不存在", selectGroup, modelName), types.ErrorCodeGetChannelFailed)
+	}
+	defer service.ReleaseChannelConcurrencyReservation(cp)
+	if newAPIError := middleware.SetupContextForSelectedChannel(cp, channel, modelName); newAPIError != nil {
+		return nil, newAPIError
+	}
+	if !service.AcquireChannelBreaker(cp, channel, modelName) {
+		return nil, types.NewError(fmt.Errorf("渠道 #%d 的 key 在模型 %s 上已熔断", channel.Id, modelName), types.ErrorCodeChannelNoAvailableKey)
+	}
+	defer service.ReleaseChannelBreaker(cp)
+	defer service.AcquireChannelConcurrency(cp, channel.Id)()
+
+	request := &dto.ModerationRequest{Model: modelName, Input: text}
+	response, _, newAPIError := relay.Moderate(cp, relaycommon.GenRelayInfoModeration(cp, request), request)
+	service.RecordChannelBreaker(cp, channel, modelName, newAPIError)
+	return response, newAPIError
+}
@@ -0,0 +1,163 @@
+package controller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ctrlc-ctrlv-limited/cvai/common"
+	"github.com/ctrlc-ctrlv-limited/cvai/constant"
+	"github.com/ctrlc-ctrlv-limited/cvai/middleware"
+	"github.com/ctrlc-ctrlv-limited/cvai/model"
+	relaycommon "github.com/ctrlc-ctrlv-limited/cvai/relay/common"
+	"github.com/ctrlc-ctrlv-limited/cvai/service"
+	"github.com/ctrlc-ctrlv-limited/cvai/setting/operation_setting"
+	"github.com/ctrlc-ctrlv-limited/cvai/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+func setupRelayTest(t *testing.T) {
+	t.Helper()
+	t.Setenv("SQL_DSN", "")
+	t.Setenv("LOG_SQL_DSN", "")
+	oldDB, oldLogDB, oldPath := model.DB, model.LOG_DB, common.SQLitePath
+	oldRedis, oldBatch, oldMemoryCache, oldMaster := common.RedisEnabled, common.BatchUpdateEnabled, common.MemoryCacheEnabled, common.IsMasterNode
+	oldSelfUse := operation_setting.SelfUseModeEnabled
+	common.SQLitePath = "file:" + t.Name() + "?mode=memory&cache=shared"
+	common.RedisEnabled = false
+	common.BatchUpdateEnabled = false
+	common.MemoryCacheEnabled = false
+	common.IsMasterNode = true
+	// 不配置模型价格
+	operation_setting.SelfUseModeEnabled = true
+	if err := model.InitDB(); err != nil {
+		t.Fatalf("failed to init database: %v", err)
+	}
+	if err := model.InitLogDB(); err != nil {
+		t.Fatalf("failed to init log database: %v", err)
+	}
+	service.InitHttpClient()
+	t.Cleanup(func() {
+		model.DB, model.LOG_DB, common.SQLitePath = oldDB, oldLogDB, oldPath
+		common.RedisEnabled, common.BatchUpdateEnabled, common.MemoryCacheEnabled, common.IsMasterNode = oldRedis, oldBatch, oldMemoryCache, oldMaster
+		operation_setting.SelfUseModeEnabled = oldSelfUse
+	})
+}
+
+// newRelayTestUpstream 模拟 OpenAI 接口，记录每次请求使用的 key
+func newRelayTestUpstream(t *testing.T) (*httptest.Server, func() []string) {
+	t.Helper()
+	var lock sync.Mutex
+	var keys []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		keys = append(keys, strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+		lock.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"id":"chatcmpl-test","object":"chat.completion","created":1,"model":"gpt-4o-mini",` +
+			`"choices":[{"index":0,"message":{"role":"assistant","content":"ok"},"finish_reason":"stop"}],` +
+			`"usage":{"prompt_tokens":1,"completion_tokens":1,"total_tokens":2}}`))
+	}))
+	t.Cleanup(server.Close)
+	return server, func() []string {
+		lock.Lock()
+		defer lock.Unlock()
+		return append([]string(nil), keys...)
+	}
+}
+
+func newRelayTestEngine() *gin.Engine {
+	engine := gin.New()
+	engine.POST("/v1/chat/completions", middleware.TokenAuth(), middleware.Distribute(), func(c *gin.Context) {
+		Relay(c, types.RelayFormatOpenAI)
+	})
+	return engine
+}
+
+// createSaturatingTestChannels 创建两个提供 gpt-4o-mini 的渠道：优先级更高的渠道 A 有两个 key，每个 key 每分钟只允许一次请求，渠道 B 不限制。
+// key 用量按渠道 id 保存在内存中，各测试使用不同的渠道 id
+func createSaturatingTestChannels(t *testing.T, baseURL string, idA int, idB int) {
+	t.Helper()
+	priorityA, priorityB := int64(10), int64(0)
+	channelA := &model.Channel{Id: idA, Type: constant.ChannelTypeOpenAI, Name: "A", Key: "sk-a0\nsk-a1", Status: common.ChannelStatusEnabled,
+		BaseURL: &baseURL, Models: "gpt-4o-mini", Group: "default", Priority: &priorityA}
+	channelA.ChannelInfo.IsMultiKey = true
+	channelA.ChannelInfo.MultiKeySize = 2
+	channelA.ChannelInfo.MultiKeyMode = constant.MultiKeyModePolling
+	channelA.ChannelInfo.MultiKeyRPMLimit = map[int]int{0: 1, 1: 1}
+	channelB := &model.Channel{Id: idB, Type: constant.ChannelTypeOpenAI, Name: "B", Key: "sk-b", Status: common.ChannelStatusEnabled,
+		BaseURL: &baseURL, Models: "gpt-4o-mini", Group: "default", Priority: &priorityB}
+	for _, channel := range []*model.Channel{channelA, channelB} {
+		if err := channel.Insert(); err != nil {
+			t.Fatalf("failed to create channel %s: %v", channel.Name, err)
+		}
+	}
+}
+
+func TestRelaySkipsChannelWithSaturatedKeys(t *testing.T) {
+	setupRelayTest(t)
+	upstream, upstreamKeys := newRelayTestUpstream(t)
+
+	user := &model.User{Username: "relay", Password: "password", AffCode: "relay", Group: "default", Quota: 100000000, Status: common.UserStatusEnabled}
+	if err := model.DB.Create(user).Error; err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	token := &model.Token{UserId: user.Id, Key: "relaytestkey", Name: "relay", Status: common.TokenStatusEnabled, ExpiredTime: -1, UnlimitedQuota: true}
+	if err := model.DB.Create(token).Error; err != nil {
+		t.Fatalf("failed to create token: %v", err)
+	}
+
+	createSaturatingTestChannels(t, upstream.URL, 1, 2)
+
+	engine := newRelayTestEngine()
+	for i := 0; i < 3; i++ {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"gpt-4o-mini","messages":[{"role":"user","content":"hi"}]}`))
+		request.Header.Set("Authorization", "Bearer sk-relaytestkey")
+		request.Header.Set("Content-Type", "application/json")
+		engine.ServeHTTP(recorder, request)
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("request %d: expected status 200, got %d: %s", i, recorder.Code, recorder.Body.String())
+		}
+	}
+
+	keys := upstreamKeys()
+	if len(keys) != 3 {
+		t.Fatalf("expected 3 upstream requests, got %v", keys)
+	}
+	if (keys[0] != "sk-a0" || keys[1] != "sk-a1") && (keys[0] != "sk-a1" || keys[1] != "sk-a0") {
+		t.Fatalf("expected first two requests to use both keys of channel A, got %v", keys)
+	}
+	if keys[2] != "sk-b" {
+		t.Fatalf("expected request to be served by channel B after channel A keys are saturated, got %v", keys)
+	}
+}
+
+func TestGetChannelSkipsChannelWithSaturatedKeys(t *testing.T) {
+	setupRelayTest(t)
+	createSaturatingTestChannels(t, "http://127.0.0.1", 3, 4)
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	common.SetContextKey(c, constant.ContextKeyUserGroup, "default")
+	info := &relaycommon.RelayInfo{OriginModelName: "gpt-4o-mini", UsingGroup: "default", ChannelMeta: &relaycommon.ChannelMeta{}}
+	retryParam := &service.RetryParam{Ctx: c, TokenGroup: "default", ModelName: "gpt-4o-mini", Retry: common.GetPointer(0)}
+
+	var names []string
+	for i := 0; i < 3; i++ {
+		channel, newAPIError := getChannel(c, info, retryParam)
+		if newAPIError != nil {
+			t.Fatalf("attempt %d: getChannel returned error: %v", i, newAPIError)
+		}
+		names = append(names, channel.Name)
+	}
+	if names[0] != "A" || names[1] != "A" || names[2] != "B" {
+		t.Fatalf("expected channel B after channel A keys are saturated, got %v", names)
+	}
+	if retryParam.GetRetry() != 0 {
+		t.Fatalf("expected skipping a channel not to consume retries, got retry %d", retryParam.GetRetry())
+	}
+}
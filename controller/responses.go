@@ -0,0 +1,117 @@
+package controller
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/ctrlc-ctrlv-limited/cvai/constant"
+	"github.com/ctrlc-ctrlv-limited/cvai/logger"
+	"github.com/ctrlc-ctrlv-limited/cvai/model"
+	"github.com/ctrlc-ctrlv-limited/cvai/relay"
+	"github.com/ctrlc-ctrlv-limited/cvai/service"
+	"github.com/ctrlc-ctrlv-limited/cvai/setting/operation_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+// getRelayResponseBinding 获取请求路径中的 response 与渠道的绑定，不存在或功能未启用时直接返回错误
+func getRelayResponseBinding(c *gin.Context) (*model.ResponseBinding, bool) {
+	if !operation_setting.GetResponsesSetting().Enabled {
+		RelayNotImplemented(c)
+		return nil, false
+	}
+	binding, err := model.GetUserResponseBinding(c.GetInt("id"), c.Param("id"))
+	if err != nil {
+		fileApiError(c, http.StatusInternalServerError, err.Error(), "get_response_failed")
+		return nil, false
+	}
+	if binding == nil {
+		fileApiError(c, http.StatusNotFound, fmt.Sprintf("No response found with id '%s'.", c.Param("id")), "response_not_found")
+		return nil, false
+	}
+	return binding, true
+}
+
+// syncBackgroundResponse 用户查询或取消 background 模式的 response 时同步更新对应的异步任务
+func syncBackgroundResponse(c *gin.Context, binding *model.ResponseBinding, response []byte) {
+	task, exist, err := model.GetByTaskId(binding.UserId, binding.ResponseId)
+	if err != nil || !exist || task.Platform != constant.TaskPlatformResponses {
+		return
+	}
+	if err = relay.UpdateBackgroundResponseTask(c.Request.Context(), task, response); err != nil {
+		logger.LogError(c, fmt.Sprintf("failed to update background response %s: %s", binding.ResponseId, err.Error()))
+	}
+}
+
+// relayResponseRequest 将请求转发到创建 response 的渠道与 key，并透传上游的响应
+func relayResponseRequest(c *gin.Context, method string, action string) {
+	binding, ok := getRelayResponseBinding(c)
+	if !ok {
+		return
+	}
+	resp, err := service.ForwardResponseRequest(c.Request.Context(), binding, method, action, c.Request.URL.RawQuery)
+	if err != nil {
+		fileApiError(c, http.StatusBadGateway, err.Error(), "forward_response_request_failed")
+		return
+	}
+	defer resp.Body.Close()
+
+	contentType := resp.Header.Get("Content-Type")
+	if strings.HasPrefix(contentType, "text/event-stream") {
+		// 以流式方式获取 background 模式的 response
+		c.Header("Content-Type", contentType)
+		c.Header("Cache-Control", "no-cache")
+		c.Status(resp.StatusCode)
+		buf := make([]byte, 4096)
+		for {
+			n, err := resp.Body.Read(buf)
+			if n > 0 {
+				if _, writeErr := c.Writer.Write(buf[:n]); writeErr != nil {
+					return
+				}
+				c.Writer.Flush()
+			}
+			if err != nil {
+				return
+			}
+		}
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		fileApiError(c, http.StatusBadGateway, err.Error(), "forward_response_request_failed")
+		return
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		if method == http.MethodDelete {
+			if err = model.DeleteResponseBinding(binding.ResponseId); err != nil {
+				logger.LogError(c, fmt.Sprintf("failed to delete binding of response %s: %s", binding.ResponseId, err.Error()))
+			}
+		} else if binding.Background && action != "input_items" {
+			syncBackgroundResponse(c, binding, body)
+		}
+	}
+	c.Data(resp.StatusCode, contentType, body)
+}
+
+// RelayGetResponse GET /v1/responses/:id
+func RelayGetResponse(c *gin.Context) {
+	relayResponseRequest(c, http.MethodGet, "")
+}
+
+// RelayDeleteResponse DELETE /v1/responses/:id
+func RelayDeleteResponse(c *gin.Context) {
+	relayResponseRequest(c, http.MethodDelete, "")
+}
+
+// RelayCancelResponse POST /v1/responses/:id/cancel
+func RelayCancelResponse(c *gin.Context) {
+	relayResponseRequest(c, http.MethodPost, "cancel")
+}
+
+// RelayListResponseInputItems GET /v1/responses/:id/input_items
+func RelayListResponseInputItems(c *gin.Context) {
+	relayResponseRequest(c, http.MethodGet, "input_items")
+}
@@ -0,0 +1,233 @@
+package controller
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/ctrlc-ctrlv-limited/cvai/common"
+	"github.com/ctrlc-ctrlv-limited/cvai/dto"
+	"github.com/ctrlc-ctrlv-limited/cvai/logger"
+	"github.com/ctrlc-ctrlv-limited/cvai/model"
+	"github.com/ctrlc-ctrlv-limited/cvai/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Excel 需要 BOM 才能正确识别 UTF-8 编码的 CSV
+const csvUTF8BOM = "\xef\xbb\xbf"
+
+func getStatementFormat(c *gin.Context) (string, bool) {
+	format := c.DefaultQuery("format", service.StatementFormatJSON)
+	switch format {
+	case service.StatementFormatJSON, service.StatementFormatCSV, service.StatementFormatPDF:
+		return format, true
+	}
+	common.ApiErrorMsg(c, "不支持的账单格式: "+format)
+	return "", false
+}
+
+func sendStatementFile(c *gin.Context, filename string, contentType string, data []byte) {
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	c.Data(http.StatusOK, contentType, data)
+}
+
+func renderStatement(c *gin.Context, statement *dto.UserStatement, format string) {
+	filename := fmt.Sprintf("statement-%s-%d", statement.Month, statement.UserId)
+	switch format {
+	case service.StatementFormatCSV:
+		var buf bytes.Buffer
+		buf.WriteString(csvUTF8BOM)
+		writer, err := service.NewStatementCSVWriter(&buf)
+		if err == nil {
+			err = writer.Write(statement)
+		}
+		if err == nil {
+			err = writer.Flush()
+		}
+		if err != nil {
+			common.ApiError(c, err)
+			return
+		}
+		sendStatementFile(c, filename+".csv", "text/csv; charset=utf-8", buf.Bytes())
+	case service.StatementFormatPDF:
+		sendStatementFile(c, filename+".pdf", "application/pdf", service.RenderStatementPDF(statement))
+	default:
+		common.ApiSuccess(c, statement)
+	}
+}
+
+// GetSelfStatement 获取当前用户的月度账单，month 为空时为上个月，format 可选 json、csv、pdf
+func GetSelfStatement(c *gin.Context) {
+	format, ok := getStatementFormat(c)
+	if !ok {
+		return
+	}
+	statement, err := service.BuildUserStatement(c.GetInt("id"), c.GetString("username"), c.Query("month"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	renderStatement(c, statement, format)
+}
+
+// GetUserStatement 管理员获取指定用户的月度账单
+func GetUserStatement(c *gin.Context) {
+	format, ok := getStatementFormat(c)
+	if !ok {
+		return
+	}
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	user, err := model.GetUserById(id, false)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	myRole := c.GetInt("role")
+	if myRole <= user.Role && myRole != common.RoleRootUser {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无权获取同级或更高等级用户的信息",
+		})
+		return
+	}
+	statement, err := service.BuildUserStatement(user.Id, user.Username, c.Query("month"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	renderStatement(c, statement, format)
+}
+
+// ExportStatements 批量导出指定月份所有用户的账单，逐个用户生成并写出，CSV 为单个文件，PDF 为每个用户一个文件的 zip 压缩包，JSON 为账单数组。
+// 开始写出后出错时只能中断响应，导出的文件不完整
+func ExportStatements(c *gin.Context) {
+	format, ok := getStatementFormat(c)
+	if !ok {
+		return
+	}
+	month, _, _, err := service.ParseStatementMonth(c.Query("month"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	started := false
+	var write func(statement *dto.UserStatement) error
+	var finish func() error
+	switch format {
+	case service.StatementFormatCSV:
+		var writer *service.StatementCSVWriter
+		start := func() (err error) {
+			started = true
+			c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "statements-"+month+".csv"))
+			c.Header("Content-Type", "text/csv; charset=utf-8")
+			c.Status(http.StatusOK)
+			if _, err = c.Writer.WriteString(csvUTF8BOM); err != nil {
+				return err
+			}
+			writer, err = service.NewStatementCSVWriter(c.Writer)
+			return err
+		}
+		write = func(statement *dto.UserStatement) error {
+			if !started {
+				if err := start(); err != nil {
+					return err
+				}
+			}
+			if err := writer.Write(statement); err != nil {
+				return err
+			}
+			return writer.Flush()
+		}
+		finish = func() error {
+			if !started {
+				if err := start(); err != nil {
+					return err
+				}
+			}
+			return writer.Flush()
+		}
+	case service.StatementFormatPDF:
+		var archive *zip.Writer
+		start := func() {
+			started = true
+			c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "statements-"+month+".zip"))
+			c.Header("Content-Type", "application/zip")
+			c.Status(http.StatusOK)
+			archive = zip.NewWriter(c.Writer)
+		}
+		write = func(statement *dto.UserStatement) error {
+			if !started {
+				start()
+			}
+			file, err := archive.Create(fmt.Sprintf("statement-%s-%d.pdf", month, statement.UserId))
+			if err != nil {
+				return err
+			}
+			if _, err = file.Write(service.RenderStatementPDF(statement)); err != nil {
+				return err
+			}
+			return archive.Flush()
+		}
+		finish = func() error {
+			if !started {
+				start()
+			}
+			return archive.Close()
+		}
+	default:
+		// 与 common.ApiSuccess 的响应格式一致
+		start := func() error {
+			started = true
+			c.Header("Content-Type", "application/json; charset=utf-8")
+			c.Status(http.StatusOK)
+			_, err := c.Writer.WriteString(`{"success":true,"message":"","data":[`)
+			return err
+		}
+		write = func(statement *dto.UserStatement) error {
+			separator := ","
+			if !started {
+				separator = ""
+				if err := start(); err != nil {
+					return err
+				}
+			}
+			data, err := common.Marshal(statement)
+			if err != nil {
+				return err
+			}
+			if _, err = c.Writer.WriteString(separator); err != nil {
+				return err
+			}
+			_, err = c.Writer.Write(data)
+			return err
+		}
+		finish = func() error {
+			if !started {
+				if err := start(); err != nil {
+					return err
+				}
+			}
+			_, err := c.Writer.WriteString("]}")
+			return err
+		}
+	}
+	err = service.EachMonthlyStatement(month, write)
+	if err == nil {
+		err = finish()
+	}
+	if err != nil {
+		if !started {
+			common.ApiError(c, err)
+			return
+		}
+		logger.LogError(c, fmt.Sprintf("failed to export statements of %s: %s", month, err.Error()))
+		c.Abort()
+	}
+}
@@ -0,0 +1,44 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ctrlc-ctrlv-limited/cvai/common"
+	"github.com/ctrlc-ctrlv-limited/cvai/logger"
+	"github.com/ctrlc-ctrlv-limited/cvai/model"
+	"github.com/ctrlc-ctrlv-limited/cvai/service"
+)
+
+// UpdateFineTuningTaskAll 轮询各渠道未完成的微调任务
+func UpdateFineTuningTaskAll(ctx context.Context, taskChannelM map[int][]string, taskM map[string]*model.Task) error {
+	for channelId, taskIds := range taskChannelM {
+		logger.LogInfo(ctx, fmt.Sprintf("Channel #%d pending fine-tuning jobs: %d", channelId, len(taskIds)))
+		if _, err := model.CacheGetChannel(channelId); err != nil {
+			errUpdate := model.TaskBulkUpdate(taskIds, map[string]any{
+				"fail_reason": fmt.Sprintf("Failed to get channel info, channel ID: %d", channelId),
+				"status":      "FAILURE",
+				"progress":    "100%",
+			})
+			if errUpdate != nil {
+				common.SysLog(fmt.Sprintf("UpdateFineTuningTask error: %v", errUpdate))
+			}
+			continue
+		}
+		for _, taskId := range taskIds {
+			task := taskM[taskId]
+			if task == nil {
+				continue
+			}
+			job, err := service.FetchFineTuningJob(ctx, task)
+			if err != nil {
+				logger.LogError(ctx, fmt.Sprintf("Failed to fetch fine-tuning job %s: %s", taskId, err.Error()))
+				continue
+			}
+			if err = service.UpdateFineTuningTask(ctx, task, job); err != nil {
+				logger.LogError(ctx, fmt.Sprintf("Failed to update fine-tuning job %s: %s", taskId, err.Error()))
+			}
+		}
+	}
+	return nil
+}
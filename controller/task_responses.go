@@ -0,0 +1,47 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ctrlc-ctrlv-limited/cvai/common"
+	"github.com/ctrlc-ctrlv-limited/cvai/logger"
+	"github.com/ctrlc-ctrlv-limited/cvai/model"
+	"github.com/ctrlc-ctrlv-limited/cvai/relay"
+	"github.com/ctrlc-ctrlv-limited/cvai/service"
+)
+
+// UpdateResponsesTaskAll 轮询各渠道未完成的 background 模式 response
+func UpdateResponsesTaskAll(ctx context.Context, taskChannelM map[int][]string, taskM map[string]*model.Task) error {
+	for channelId, taskIds := range taskChannelM {
+		logger.LogInfo(ctx, fmt.Sprintf("Channel #%d pending background responses: %d", channelId, len(taskIds)))
+		_, channelErr := model.CacheGetChannel(channelId)
+		for _, taskId := range taskIds {
+			task := taskM[taskId]
+			if task == nil {
+				continue
+			}
+			if channelErr != nil {
+				// 渠道已不存在时无法再获取结果，按失败处理并退还预扣的额度
+				response, _ := common.Marshal(map[string]any{
+					"id":     task.TaskID,
+					"status": "failed",
+					"error":  map[string]any{"message": fmt.Sprintf("Failed to get channel info, channel ID: %d", channelId)},
+				})
+				if err := relay.UpdateBackgroundResponseTask(ctx, task, response); err != nil {
+					logger.LogError(ctx, fmt.Sprintf("Failed to update background response %s: %s", taskId, err.Error()))
+				}
+				continue
+			}
+			response, err := service.FetchBackgroundResponse(ctx, task)
+			if err != nil {
+				logger.LogError(ctx, fmt.Sprintf("Failed to fetch background response %s: %s", taskId, err.Error()))
+				continue
+			}
+			if err = relay.UpdateBackgroundResponseTask(ctx, task, response); err != nil {
+				logger.LogError(ctx, fmt.Sprintf("Failed to update background response %s: %s", taskId, err.Error()))
+			}
+		}
+	}
+	return nil
+}
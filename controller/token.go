@@ -168,6 +168,13 @@ func AddToken(c *gin.Context) {
 			return
 		}
 	}
+	if err := token.GetBudget().Validate(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
 	key, err := common.GenerateKey()
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
@@ -191,6 +198,9 @@ func AddToken(c *gin.Context) {
 		AllowIps:           token.AllowIps,
 		Group:              token.Group,
 		CrossGroupRetry:    token.CrossGroupRetry,
+		BudgetPeriod:       token.BudgetPeriod,
+		BudgetQuota:        token.BudgetQuota,
+		BudgetTimezone:     token.BudgetTimezone,
 	}
 	err = cleanToken.Insert()
 	if err != nil {
@@ -252,6 +262,13 @@ func UpdateToken(c *gin.Context) {
 			return
 		}
 	}
+	if err := token.GetBudget().Validate(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
 	cleanToken, err := model.GetTokenByIds(token.Id, userId)
 	if err != nil {
 		common.ApiError(c, err)
@@ -286,6 +303,9 @@ func UpdateToken(c *gin.Context) {
 		cleanToken.AllowIps = token.AllowIps
 		cleanToken.Group = token.Group
 		cleanToken.CrossGroupRetry = token.CrossGroupRetry
+		cleanToken.BudgetPeriod = token.BudgetPeriod
+		cleanToken.BudgetQuota = token.BudgetQuota
+		cleanToken.BudgetTimezone = token.BudgetTimezone
 	}
 	err = cleanToken.Update()
 	if err != nil {
@@ -191,6 +191,7 @@ func RequestEpay(c *gin.Context) {
 		Money:         payMoney,
 		TradeNo:       tradeNo,
 		PaymentMethod: req.PaymentMethod,
+		Currency:      model.TopUpCurrencyCNY,
 		CreateTime:    time.Now().Unix(),
 		Status:        "pending",
 	}
@@ -279,7 +280,7 @@ func EpayNotify(c *gin.Context) {
 			dAmount := decimal.NewFromInt(int64(topUp.Amount))
 			dQuotaPerUnit := decimal.NewFromFloat(common.QuotaPerUnit)
 			quotaToAdd := int(dAmount.Mul(dQuotaPerUnit).IntPart())
-			err = model.IncreaseUserQuota(topUp.UserId, quotaToAdd, true)
+			err = model.IncreaseUserQuota(topUp.UserId, quotaToAdd, true, model.QuotaLedgerMeta{Reason: model.QuotaLedgerReasonTopUp, ActorId: topUp.UserId, Remark: topUp.TradeNo})
 			if err != nil {
 				log.Printf("易支付回调更新用户失败: %v", topUp)
 				return
@@ -384,7 +385,7 @@ func AdminCompleteTopUp(c *gin.Context) {
 	LockOrder(req.TradeNo)
 	defer UnlockOrder(req.TradeNo)
 
-	if err := model.ManualCompleteTopUp(req.TradeNo); err != nil {
+	if err := model.ManualCompleteTopUp(req.TradeNo, c.GetInt("id")); err != nil {
 		common.ApiError(c, err)
 		return
 	}
@@ -13,6 +13,7 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -110,6 +111,7 @@ func (*CreemAdaptor) RequestPay(c *gin.Context, req *CreemPayRequest) {
 		UserId:     id,
 		Amount:     selectedProduct.Quota, // 充值额度
 		Money:      selectedProduct.Price, // 支付金额
+		Currency:   strings.ToUpper(selectedProduct.Currency),
 		TradeNo:    referenceId,
 		CreateTime: time.Now().Unix(),
 		Status:     common.TopUpStatusPending,
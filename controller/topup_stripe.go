@@ -89,6 +89,7 @@ func (*StripeAdaptor) RequestPay(c *gin.Context, req *StripePayRequest) {
 		Money:         chargedMoney,
 		TradeNo:       referenceId,
 		PaymentMethod: PaymentMethodStripe,
+		Currency:      model.TopUpCurrencyUSD,
 		CreateTime:    time.Now().Unix(),
 		Status:        common.TopUpStatusPending,
 	}
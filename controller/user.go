@@ -15,6 +15,7 @@ import (
 	"github.com/ctrlc-ctrlv-limited/cvai/model"
 	"github.com/ctrlc-ctrlv-limited/cvai/service"
 	"github.com/ctrlc-ctrlv-limited/cvai/setting"
+	"github.com/ctrlc-ctrlv-limited/cvai/setting/operation_setting"
 
 	"github.com/ctrlc-ctrlv-limited/cvai/constant"
 
@@ -597,9 +598,19 @@ func GetUserModels(c *gin.Context) {
 	return
 }
 
+// updateUserRequest 中的指针字段未出现在请求中时保留用户原有的值
+type updateUserRequest struct {
+	model.User
+	BudgetPeriod   *string `json:"budget_period"`
+	BudgetQuota    *int    `json:"budget_quota"`
+	BudgetTimezone *string `json:"budget_timezone"`
+	CreditLimit    *int    `json:"credit_limit"`
+}
+
 func UpdateUser(c *gin.Context) {
-	var updatedUser model.User
-	err := json.NewDecoder(c.Request.Body).Decode(&updatedUser)
+	var req updateUserRequest
+	err := json.NewDecoder(c.Request.Body).Decode(&req)
+	updatedUser := req.User
 	if err != nil || updatedUser.Id == 0 {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
@@ -637,11 +648,41 @@ func UpdateUser(c *gin.Context) {
 		})
 		return
 	}
+	updatedUser.BudgetPeriod = originUser.BudgetPeriod
+	if req.BudgetPeriod != nil {
+		updatedUser.BudgetPeriod = *req.BudgetPeriod
+	}
+	updatedUser.BudgetQuota = originUser.BudgetQuota
+	if req.BudgetQuota != nil {
+		updatedUser.BudgetQuota = *req.BudgetQuota
+	}
+	updatedUser.BudgetTimezone = originUser.BudgetTimezone
+	if req.BudgetTimezone != nil {
+		updatedUser.BudgetTimezone = *req.BudgetTimezone
+	}
+	updatedUser.CreditLimit = originUser.CreditLimit
+	if req.CreditLimit != nil {
+		updatedUser.CreditLimit = *req.CreditLimit
+	}
+	if err := updatedUser.GetBudget().Validate(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if updatedUser.CreditLimit < operation_setting.UserCreditLimitInherit {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "信用额度不能小于 -1（-1 表示使用分组的默认信用额度）",
+		})
+		return
+	}
 	if updatedUser.Password == "$I_LOVE_U" {
 		updatedUser.Password = "" // rollback to what it should be
 	}
 	updatePassword := updatedUser.Password != ""
-	if err := updatedUser.Edit(updatePassword); err != nil {
+	if err := updatedUser.Edit(updatePassword, c.GetInt("id")); err != nil {
 		common.ApiError(c, err)
 		return
 	}
@@ -0,0 +1,69 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ctrlc-ctrlv-limited/cvai/common"
+	"github.com/ctrlc-ctrlv-limited/cvai/dto"
+	"github.com/ctrlc-ctrlv-limited/cvai/model"
+
+	"github.com/gin-gonic/gin"
+)
+
+func updateUserForTest(t *testing.T, body string) {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPut, "/api/user/", strings.NewReader(body))
+	c.Set("id", 1)
+	c.Set("role", common.RoleRootUser)
+	UpdateUser(c)
+	var resp struct {
+		Success bool   `json:"success"`
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &resp); err != nil || !resp.Success {
+		t.Fatalf("update user failed: %s", recorder.Body.String())
+	}
+}
+
+func TestUpdateUserKeepsOmittedFields(t *testing.T) {
+	setupRelayTest(t)
+	user := &model.User{Username: "edit", Password: "password", AffCode: "edit", Group: "default", Status: common.UserStatusEnabled,
+		Role: common.RoleCommonUser, CreditLimit: 500, BudgetPeriod: dto.QuotaBudgetPeriodDaily, BudgetQuota: 1000, BudgetTimezone: "Asia/Shanghai"}
+	if err := model.DB.Create(user).Error; err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+
+	updateUserForTest(t, fmt.Sprintf(`{"id":%d,"username":"edit","display_name":"renamed","group":"default","quota":100}`, user.Id))
+	got, err := model.GetUserById(user.Id, false)
+	if err != nil {
+		t.Fatalf("failed to get user: %v", err)
+	}
+	if got.DisplayName != "renamed" || got.Quota != 100 {
+		t.Fatalf("expected update to be applied, got display name %q quota %d", got.DisplayName, got.Quota)
+	}
+	if got.CreditLimit != 500 {
+		t.Fatalf("expected omitted credit limit to be kept, got %d", got.CreditLimit)
+	}
+	if got.BudgetPeriod != dto.QuotaBudgetPeriodDaily || got.BudgetQuota != 1000 || got.BudgetTimezone != "Asia/Shanghai" {
+		t.Fatalf("expected omitted budget to be kept, got %+v", got.GetBudget())
+	}
+
+	updateUserForTest(t, fmt.Sprintf(`{"id":%d,"username":"edit","group":"default","credit_limit":0,"budget_period":""}`, user.Id))
+	got, err = model.GetUserById(user.Id, false)
+	if err != nil {
+		t.Fatalf("failed to get user: %v", err)
+	}
+	if got.CreditLimit != 0 {
+		t.Fatalf("expected credit limit to be updated to 0, got %d", got.CreditLimit)
+	}
+	if got.BudgetPeriod != "" {
+		t.Fatalf("expected budget period to be cleared, got %q", got.BudgetPeriod)
+	}
+}
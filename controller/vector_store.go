@@ -0,0 +1,130 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ctrlc-ctrlv-limited/cvai/logger"
+	"github.com/ctrlc-ctrlv-limited/cvai/model"
+	"github.com/ctrlc-ctrlv-limited/cvai/service"
+	"github.com/ctrlc-ctrlv-limited/cvai/setting/operation_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+type vectorStoreList struct {
+	Object  string            `json:"object"`
+	Data    []json.RawMessage `json:"data"`
+	FirstId *string           `json:"first_id"`
+	LastId  *string           `json:"last_id"`
+	HasMore bool              `json:"has_more"`
+}
+
+// getRelayVectorStore 获取请求路径中的向量库，不存在或功能未启用时直接返回错误
+func getRelayVectorStore(c *gin.Context) (*model.VectorStore, bool) {
+	if !operation_setting.GetVectorStoreSetting().Enabled {
+		RelayNotImplemented(c)
+		return nil, false
+	}
+	store, err := model.GetUserVectorStore(c.GetInt("id"), c.Param("id"))
+	if err != nil {
+		fileApiError(c, http.StatusInternalServerError, err.Error(), "get_vector_store_failed")
+		return nil, false
+	}
+	if store == nil {
+		fileApiError(c, http.StatusNotFound, fmt.Sprintf("No vector store found with id '%s'.", c.Param("id")), "vector_store_not_found")
+		return nil, false
+	}
+	return store, true
+}
+
+// RelayCreateVectorStore POST /v1/vector_stores
+func RelayCreateVectorStore(c *gin.Context) {
+	if !operation_setting.GetVectorStoreSetting().Enabled {
+		RelayNotImplemented(c)
+		return
+	}
+	store, err := service.CreateVectorStore(c)
+	if err != nil {
+		logger.LogError(c, "create vector store failed: "+err.Error())
+		fileApiError(c, http.StatusBadRequest, err.Error(), "create_vector_store_failed")
+		return
+	}
+	c.Data(http.StatusOK, "application/json", []byte(store.Data))
+}
+
+// RelayListVectorStores GET /v1/vector_stores
+func RelayListVectorStores(c *gin.Context) {
+	if !operation_setting.GetVectorStoreSetting().Enabled {
+		RelayNotImplemented(c)
+		return
+	}
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+	stores, hasMore, err := model.GetUserVectorStores(c.GetInt("id"), c.Query("after"), limit, c.Query("order") == "asc")
+	if err != nil {
+		fileApiError(c, http.StatusBadRequest, err.Error(), "list_vector_stores_failed")
+		return
+	}
+	list := vectorStoreList{
+		Object:  "list",
+		Data:    make([]json.RawMessage, 0, len(stores)),
+		HasMore: hasMore,
+	}
+	for _, store := range stores {
+		list.Data = append(list.Data, json.RawMessage(store.Data))
+	}
+	if len(stores) > 0 {
+		list.FirstId = &stores[0].VectorStoreId
+		list.LastId = &stores[len(stores)-1].VectorStoreId
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+// RelayVectorStoreRequest 向量库的查询、修改、检索以及文件、文件批次请求，固定发送到创建向量库的渠道与 key
+func RelayVectorStoreRequest(c *gin.Context) {
+	store, ok := getRelayVectorStore(c)
+	if !ok {
+		return
+	}
+	var body []byte
+	if c.Request.Method == http.MethodPost {
+		var err error
+		if body, err = io.ReadAll(c.Request.Body); err != nil {
+			fileApiError(c, http.StatusBadRequest, err.Error(), "invalid_request_body")
+			return
+		}
+	}
+	action := strings.TrimPrefix(c.Request.URL.Path, "/v1/vector_stores/"+c.Param("id"))
+	statusCode, respBody, err := service.ForwardVectorStoreRequest(c.Request.Context(), store, c.Request.Method, action, c.Request.URL.Query(), body)
+	if err != nil {
+		fileApiError(c, http.StatusBadGateway, err.Error(), "forward_vector_store_request_failed")
+		return
+	}
+	if action == "" && statusCode >= 200 && statusCode < 300 {
+		if err = service.UpdateVectorStoreData(store, respBody); err != nil {
+			logger.LogError(c, fmt.Sprintf("failed to update vector store %s: %s", store.VectorStoreId, err.Error()))
+		}
+	}
+	c.Data(statusCode, "application/json", respBody)
+}
+
+// RelayDeleteVectorStore DELETE /v1/vector_stores/:id
+func RelayDeleteVectorStore(c *gin.Context) {
+	store, ok := getRelayVectorStore(c)
+	if !ok {
+		return
+	}
+	statusCode, respBody, err := service.DeleteVectorStore(c.Request.Context(), store)
+	if err != nil {
+		fileApiError(c, http.StatusBadGateway, err.Error(), "delete_vector_store_failed")
+		return
+	}
+	c.Data(statusCode, "application/json", respBody)
+}
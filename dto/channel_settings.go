@@ -7,6 +7,8 @@ type ChannelSettings struct {
 	PassThroughBodyEnabled bool   `json:"pass_through_body_enabled,omitempty"`
 	SystemPrompt           string `json:"system_prompt,omitempty"`
 	SystemPromptOverride   bool   `json:"system_prompt_override,omitempty"`
+	// 渠道最大并发请求数，达到后暂不选择该渠道，0 表示不限制
+	MaxConcurrency int `json:"max_concurrency,omitempty"`
 }
 
 type VertexKeyType string
@@ -214,6 +214,33 @@ type ClaudeRequest struct {
 	ServiceTier string `json:"service_tier,omitempty"`
 }
 
+// ClaudeCountTokensRequest /v1/messages/count_tokens 接口的请求体
+type ClaudeCountTokensRequest struct {
+	Model      string          `json:"model"`
+	System     any             `json:"system,omitempty"`
+	Messages   []ClaudeMessage `json:"messages"`
+	Tools      any             `json:"tools,omitempty"`
+	ToolChoice any             `json:"tool_choice,omitempty"`
+	Thinking   *Thinking       `json:"thinking,omitempty"`
+	McpServers json.RawMessage `json:"mcp_servers,omitempty"`
+}
+
+type ClaudeCountTokensResponse struct {
+	InputTokens int `json:"input_tokens"`
+}
+
+func (c *ClaudeRequest) ToCountTokensRequest(model string) *ClaudeCountTokensRequest {
+	return &ClaudeCountTokensRequest{
+		Model:      model,
+		System:     c.System,
+		Messages:   c.Messages,
+		Tools:      c.Tools,
+		ToolChoice: c.ToolChoice,
+		Thinking:   c.Thinking,
+		McpServers: c.McpServers,
+	}
+}
+
 func (c *ClaudeRequest) GetTokenCountMeta() *types.TokenCountMeta {
 	var tokenCountMeta = types.TokenCountMeta{
 		TokenType: types.TokenTypeTokenizer,
@@ -22,6 +22,24 @@ type GeminiChatRequest struct {
 	CachedContent      string                     `json:"cachedContent,omitempty"`
 }
 
+// GeminiCountTokensRequest countTokens 接口的请求体，contents 与 generateContentRequest 二选一
+type GeminiCountTokensRequest struct {
+	Contents               []GeminiChatContent `json:"contents,omitempty"`
+	GenerateContentRequest *GeminiChatRequest  `json:"generateContentRequest,omitempty"`
+}
+
+// ToChatRequest 将 countTokens 请求统一为 generateContent 请求
+func (r *GeminiCountTokensRequest) ToChatRequest() *GeminiChatRequest {
+	if r.GenerateContentRequest != nil {
+		return r.GenerateContentRequest
+	}
+	return &GeminiChatRequest{Contents: r.Contents}
+}
+
+type GeminiCountTokensResponse struct {
+	TotalTokens int `json:"totalTokens"`
+}
+
 // UnmarshalJSON allows GeminiChatRequest to accept both snake_case and camelCase fields.
 func (r *GeminiChatRequest) UnmarshalJSON(data []byte) error {
 	type Alias GeminiChatRequest
@@ -468,7 +486,24 @@ type GeminiImageRequest struct {
 }
 
 type GeminiImageInstance struct {
-	Prompt string `json:"prompt"`
+	Prompt          string                 `json:"prompt"`
+	ReferenceImages []GeminiReferenceImage `json:"referenceImages,omitempty"` // Imagen 编辑时的参考图片与蒙版
+}
+
+type GeminiReferenceImage struct {
+	ReferenceType   string                     `json:"referenceType"`
+	ReferenceId     int                        `json:"referenceId"`
+	ReferenceImage  GeminiReferenceImageData   `json:"referenceImage"`
+	MaskImageConfig *GeminiReferenceMaskConfig `json:"maskImageConfig,omitempty"`
+}
+
+type GeminiReferenceImageData struct {
+	BytesBase64Encoded string `json:"bytesBase64Encoded"`
+}
+
+type GeminiReferenceMaskConfig struct {
+	MaskMode string  `json:"maskMode"`
+	Dilation float64 `json:"dilation,omitempty"`
 }
 
 type GeminiImageParameters struct {
@@ -476,6 +511,7 @@ type GeminiImageParameters struct {
 	AspectRatio      string `json:"aspectRatio,omitempty"`
 	PersonGeneration string `json:"personGeneration,omitempty"`
 	ImageSize        string `json:"imageSize,omitempty"`
+	EditMode         string `json:"editMode,omitempty"`
 }
 
 type GeminiImageResponse struct {
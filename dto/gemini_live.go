@@ -0,0 +1,88 @@
+package dto
+
+import (
+	"strings"
+
+	"github.com/ctrlc-ctrlv-limited/cvai/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GeminiLiveRequest Gemini Live（BidiGenerateContent）会话的首条 setup 消息
+type GeminiLiveRequest struct {
+	Model string `json:"-"`
+	// 客户端发送的原始 setup 消息，连接上游后改写模型并转发
+	Setup []byte `json:"-"`
+}
+
+func (r *GeminiLiveRequest) GetTokenCountMeta() *types.TokenCountMeta {
+	return &types.TokenCountMeta{
+		TokenType: types.TokenTypeTokenizer,
+	}
+}
+
+func (r *GeminiLiveRequest) IsStream(c *gin.Context) bool {
+	return true
+}
+
+func (r *GeminiLiveRequest) SetModelName(modelName string) {
+	if modelName != "" {
+		r.Model = modelName
+	}
+}
+
+// GeminiLiveModelName 去掉 setup.model 中的资源路径前缀，如 models/gemini-live-2.5-flash-preview
+func GeminiLiveModelName(model string) string {
+	return model[strings.LastIndex(model, "/")+1:]
+}
+
+// GeminiLiveServerMessage Live API 服务端消息中计费需要的字段
+type GeminiLiveServerMessage struct {
+	ServerContent *GeminiLiveServerContent `json:"serverContent,omitempty"`
+	UsageMetadata *GeminiLiveUsageMetadata `json:"usageMetadata,omitempty"`
+}
+
+type GeminiLiveServerContent struct {
+	TurnComplete bool `json:"turnComplete"`
+}
+
+// GeminiLiveUsageMetadata 一轮对话的用量，同一轮中后发送的用量包含之前的用量
+type GeminiLiveUsageMetadata struct {
+	PromptTokenCount           int                         `json:"promptTokenCount"`
+	CachedContentTokenCount    int                         `json:"cachedContentTokenCount"`
+	ResponseTokenCount         int                         `json:"responseTokenCount"`
+	ToolUsePromptTokenCount    int                         `json:"toolUsePromptTokenCount"`
+	ThoughtsTokenCount         int                         `json:"thoughtsTokenCount"`
+	TotalTokenCount            int                         `json:"totalTokenCount"`
+	PromptTokensDetails        []GeminiPromptTokensDetails `json:"promptTokensDetails"`
+	ResponseTokensDetails      []GeminiPromptTokensDetails `json:"responseTokensDetails"`
+	ToolUsePromptTokensDetails []GeminiPromptTokensDetails `json:"toolUsePromptTokensDetails"`
+}
+
+// ToRealtimeUsage 转换为 OpenAI Realtime 用量，音频以外的模态（文本、图片、视频）按文本计费
+func (u *GeminiLiveUsageMetadata) ToRealtimeUsage() *RealtimeUsage {
+	usage := &RealtimeUsage{
+		InputTokens:  u.PromptTokenCount + u.ToolUsePromptTokenCount,
+		OutputTokens: u.ResponseTokenCount + u.ThoughtsTokenCount,
+		TotalTokens:  u.TotalTokenCount,
+	}
+	if usage.TotalTokens == 0 {
+		usage.TotalTokens = usage.InputTokens + usage.OutputTokens
+	}
+	for _, details := range [][]GeminiPromptTokensDetails{u.PromptTokensDetails, u.ToolUsePromptTokensDetails} {
+		for _, detail := range details {
+			if detail.Modality == "AUDIO" {
+				usage.InputTokenDetails.AudioTokens += detail.TokenCount
+			}
+		}
+	}
+	for _, detail := range u.ResponseTokensDetails {
+		if detail.Modality == "AUDIO" {
+			usage.OutputTokenDetails.AudioTokens += detail.TokenCount
+		}
+	}
+	usage.InputTokenDetails.CachedTokens = u.CachedContentTokenCount
+	usage.InputTokenDetails.TextTokens = usage.InputTokens - usage.InputTokenDetails.AudioTokens
+	usage.OutputTokenDetails.TextTokens = usage.OutputTokens - usage.OutputTokenDetails.AudioTokens
+	return usage
+}
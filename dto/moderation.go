@@ -0,0 +1,151 @@
+package dto
+
+import (
+	"strings"
+
+	"github.com/ctrlc-ctrlv-limited/cvai/common"
+	"github.com/ctrlc-ctrlv-limited/cvai/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// OpenAI moderation 分类
+const (
+	ModerationCategoryHarassment            = "harassment"
+	ModerationCategoryHarassmentThreatening = "harassment/threatening"
+	ModerationCategoryHate                  = "hate"
+	ModerationCategoryHateThreatening       = "hate/threatening"
+	ModerationCategoryIllicit               = "illicit"
+	ModerationCategoryIllicitViolent        = "illicit/violent"
+	ModerationCategorySelfHarm              = "self-harm"
+	ModerationCategorySelfHarmIntent        = "self-harm/intent"
+	ModerationCategorySelfHarmInstructions  = "self-harm/instructions"
+	ModerationCategorySexual                = "sexual"
+	ModerationCategorySexualMinors          = "sexual/minors"
+	ModerationCategoryViolence              = "violence"
+	ModerationCategoryViolenceGraphic       = "violence/graphic"
+)
+
+var ModerationCategories = []string{
+	ModerationCategoryHarassment,
+	ModerationCategoryHarassmentThreatening,
+	ModerationCategoryHate,
+	ModerationCategoryHateThreatening,
+	ModerationCategoryIllicit,
+	ModerationCategoryIllicitViolent,
+	ModerationCategorySelfHarm,
+	ModerationCategorySelfHarmIntent,
+	ModerationCategorySelfHarmInstructions,
+	ModerationCategorySexual,
+	ModerationCategorySexualMinors,
+	ModerationCategoryViolence,
+	ModerationCategoryViolenceGraphic,
+}
+
+type ModerationRequest struct {
+	Model string `json:"model"`
+	// 字符串、字符串数组或多模态输入数组
+	Input any `json:"input"`
+}
+
+func (r *ModerationRequest) IsStream(c *gin.Context) bool {
+	return false
+}
+
+func (r *ModerationRequest) GetTokenCountMeta() *types.TokenCountMeta {
+	return &types.TokenCountMeta{
+		CombineText: strings.Join(r.GetInputTexts(), "\n"),
+	}
+}
+
+func (r *ModerationRequest) SetModelName(modelName string) {
+	if modelName != "" {
+		r.Model = modelName
+	}
+}
+
+// GetInputTexts 按审核结果的顺序返回每一项输入的文本，多模态输入数组作为一项输入，只保留其中的文本
+func (r *ModerationRequest) GetInputTexts() []string {
+	switch input := r.Input.(type) {
+	case string:
+		return []string{input}
+	case []any:
+		texts := make([]string, 0, len(input))
+		parts := make([]string, 0)
+		for _, item := range input {
+			switch v := item.(type) {
+			case string:
+				texts = append(texts, v)
+			case map[string]any:
+				if common.Interface2String(v["type"]) == "text" {
+					parts = append(parts, common.Interface2String(v["text"]))
+				}
+			}
+		}
+		if len(texts) == 0 && len(input) > 0 {
+			return []string{strings.Join(parts, "\n")}
+		}
+		return texts
+	}
+	return nil
+}
+
+type ModerationResponse struct {
+	Id      string             `json:"id"`
+	Model   string             `json:"model"`
+	Results []ModerationResult `json:"results"`
+}
+
+type ModerationResult struct {
+	Flagged                   bool                `json:"flagged"`
+	Categories                map[string]bool     `json:"categories"`
+	CategoryScores            map[string]float64  `json:"category_scores"`
+	CategoryAppliedInputTypes map[string][]string `json:"category_applied_input_types,omitempty"`
+}
+
+// NewModerationResult 返回所有分类均未命中的审核结果
+func NewModerationResult() ModerationResult {
+	result := ModerationResult{
+		Categories:     make(map[string]bool, len(ModerationCategories)),
+		CategoryScores: make(map[string]float64, len(ModerationCategories)),
+	}
+	for _, category := range ModerationCategories {
+		result.Categories[category] = false
+		result.CategoryScores[category] = 0
+	}
+	return result
+}
+
+// SetCategoryScore 记录分类得分，保留较高的得分，flagged 为 true 时标记该分类与审核结果
+func (r *ModerationResult) SetCategoryScore(category string, score float64, flagged bool) {
+	if score > r.CategoryScores[category] {
+		r.CategoryScores[category] = score
+	}
+	if flagged {
+		r.Categories[category] = true
+		r.Flagged = true
+	}
+}
+
+// FlaggedCategories 返回所有结果中被标记的分类
+func (r *ModerationResponse) FlaggedCategories() []string {
+	categories := make([]string, 0)
+	for _, result := range r.Results {
+		for _, category := range ModerationCategories {
+			if result.Categories[category] && !common.StringsContains(categories, category) {
+				categories = append(categories, category)
+			}
+		}
+	}
+	return categories
+}
+
+// IsFlagged 任一结果被标记时返回 true
+func (r *ModerationResponse) IsFlagged() bool {
+	for _, result := range r.Results {
+		if result.Flagged {
+			return true
+		}
+	}
+	return false
+}
@@ -3,7 +3,6 @@ package dto
 import (
 	"encoding/json"
 	"reflect"
-	"strings"
 
 	"github.com/ctrlc-ctrlv-limited/cvai/common"
 	"github.com/ctrlc-ctrlv-limited/cvai/types"
@@ -32,6 +31,9 @@ type ImageRequest struct {
 	WatermarkEnabled json.RawMessage `json:"watermark_enabled,omitempty"`
 	UserId           json.RawMessage `json:"user_id,omitempty"`
 	Image            json.RawMessage `json:"image,omitempty"`
+	// 图片编辑：多张输入图片与蒙版
+	Images json.RawMessage `json:"images,omitempty"`
+	Mask   json.RawMessage `json:"mask,omitempty"`
 	// 用匿名参数接收额外参数
 	Extra map[string]json.RawMessage `json:"-"`
 }
@@ -124,35 +126,44 @@ func indexComma(s string) int {
 	return -1
 }
 
-func (i *ImageRequest) GetTokenCountMeta() *types.TokenCountMeta {
+// GetDallEPriceRatio dall-e 按尺寸与品质计算的单张价格倍率
+func (i *ImageRequest) GetDallEPriceRatio() float64 {
 	var sizeRatio = 1.0
 	var qualityRatio = 1.0
 
-	if strings.HasPrefix(i.Model, "dall-e") {
-		// Size
-		if i.Size == "256x256" {
-			sizeRatio = 0.4
-		} else if i.Size == "512x512" {
-			sizeRatio = 0.45
-		} else if i.Size == "1024x1024" {
-			sizeRatio = 1
-		} else if i.Size == "1024x1792" || i.Size == "1792x1024" {
-			sizeRatio = 2
-		}
+	// Size
+	if i.Size == "256x256" {
+		sizeRatio = 0.4
+	} else if i.Size == "512x512" {
+		sizeRatio = 0.45
+	} else if i.Size == "1024x1024" {
+		sizeRatio = 1
+	} else if i.Size == "1024x1792" || i.Size == "1792x1024" {
+		sizeRatio = 2
+	}
 
-		if i.Model == "dall-e-3" && i.Quality == "hd" {
-			qualityRatio = 2.0
-			if i.Size == "1024x1792" || i.Size == "1792x1024" {
-				qualityRatio = 1.5
-			}
+	if i.Model == "dall-e-3" && i.Quality == "hd" {
+		qualityRatio = 2.0
+		if i.Size == "1024x1792" || i.Size == "1792x1024" {
+			qualityRatio = 1.5
 		}
 	}
+	return sizeRatio * qualityRatio
+}
+
+// GetImageCount 请求生成的图片数量
+func (i *ImageRequest) GetImageCount() int {
+	if i.N == 0 {
+		return 1
+	}
+	return int(i.N)
+}
 
+func (i *ImageRequest) GetTokenCountMeta() *types.TokenCountMeta {
 	// not support token count for dalle
 	return &types.TokenCountMeta{
-		CombineText:     i.Prompt,
-		MaxTokens:       1584,
-		ImagePriceRatio: sizeRatio * qualityRatio * float64(i.N),
+		CombineText: i.Prompt,
+		MaxTokens:   1584,
 	}
 }
 
@@ -817,6 +817,51 @@ type OpenAIResponsesRequest struct {
 	User                 string          `json:"user,omitempty"`
 	MaxToolCalls         uint            `json:"max_tool_calls,omitempty"`
 	Prompt               json.RawMessage `json:"prompt,omitempty"`
+	Background           json.RawMessage `json:"background,omitempty"`
+}
+
+// IsBackground 是否为 background 模式，上游立即返回，response 在后台生成
+func (r *OpenAIResponsesRequest) IsBackground() bool {
+	return strings.TrimSpace(string(r.Background)) == "true"
+}
+
+// IsStoreDisabled 是否显式设置了 store: false，此时上游不保存 response
+func (r *OpenAIResponsesRequest) IsStoreDisabled() bool {
+	return strings.TrimSpace(string(r.Store)) == "false"
+}
+
+// OpenAIResponsesInputTokensRequest /v1/responses/input_tokens 接口的请求体
+type OpenAIResponsesInputTokensRequest struct {
+	Model              string          `json:"model"`
+	Input              json.RawMessage `json:"input,omitempty"`
+	Instructions       json.RawMessage `json:"instructions,omitempty"`
+	ParallelToolCalls  json.RawMessage `json:"parallel_tool_calls,omitempty"`
+	PreviousResponseID string          `json:"previous_response_id,omitempty"`
+	Reasoning          *Reasoning      `json:"reasoning,omitempty"`
+	Text               json.RawMessage `json:"text,omitempty"`
+	ToolChoice         json.RawMessage `json:"tool_choice,omitempty"`
+	Tools              json.RawMessage `json:"tools,omitempty"`
+	Truncation         string          `json:"truncation,omitempty"`
+}
+
+type OpenAIResponsesInputTokensResponse struct {
+	Object      string `json:"object"`
+	InputTokens int    `json:"input_tokens"`
+}
+
+func (r *OpenAIResponsesRequest) ToInputTokensRequest(model string) *OpenAIResponsesInputTokensRequest {
+	return &OpenAIResponsesInputTokensRequest{
+		Model:              model,
+		Input:              r.Input,
+		Instructions:       r.Instructions,
+		ParallelToolCalls:  r.ParallelToolCalls,
+		PreviousResponseID: r.PreviousResponseID,
+		Reasoning:          r.Reasoning,
+		Text:               r.Text,
+		ToolChoice:         r.ToolChoice,
+		Tools:              r.Tools,
+		Truncation:         r.Truncation,
+	}
 }
 
 func (r *OpenAIResponsesRequest) GetTokenCountMeta() *types.TokenCountMeta {
@@ -305,6 +305,17 @@ func (o *OpenAIResponsesResponse) HasImageGenerationCall() bool {
 	return false
 }
 
+// CountOutputType 统计指定类型的输出项数量，如内置工具的调用次数
+func (o *OpenAIResponsesResponse) CountOutputType(outputType string) int {
+	count := 0
+	for _, output := range o.Output {
+		if output.Type == outputType {
+			count++
+		}
+	}
+	return count
+}
+
 func (o *OpenAIResponsesResponse) GetQuality() string {
 	if len(o.Output) == 0 {
 		return ""
@@ -358,7 +369,8 @@ const (
 )
 
 const (
-	BuildInCallWebSearchCall = "web_search_call"
+	BuildInCallWebSearchCall  = "web_search_call"
+	BuildInCallFileSearchCall = "file_search_call"
 )
 
 const (
@@ -0,0 +1,72 @@
+package dto
+
+import (
+	"fmt"
+	"time"
+)
+
+// 周期预算的重置周期
+const (
+	QuotaBudgetPeriodDaily   = "daily"
+	QuotaBudgetPeriodWeekly  = "weekly"
+	QuotaBudgetPeriodMonthly = "monthly"
+)
+
+// QuotaBudget 令牌或用户的周期预算，每个周期内最多消耗 Quota 额度，在 Timezone 时区的零点重置，周从周一开始
+type QuotaBudget struct {
+	Period   string `json:"period"`
+	Quota    int    `json:"quota"`
+	Timezone string `json:"timezone"`
+}
+
+func (b QuotaBudget) Enabled() bool {
+	return b.Period != ""
+}
+
+// Validate 检查重置周期、额度与时区是否有效，未设置周期时不检查
+func (b QuotaBudget) Validate() error {
+	switch b.Period {
+	case "":
+		return nil
+	case QuotaBudgetPeriodDaily, QuotaBudgetPeriodWeekly, QuotaBudgetPeriodMonthly:
+	default:
+		return fmt.Errorf("无效的预算周期: %s", b.Period)
+	}
+	if b.Quota < 0 {
+		return fmt.Errorf("预算额度不能为负数")
+	}
+	if _, err := time.LoadLocation(b.Timezone); err != nil {
+		return fmt.Errorf("无效的预算时区: %s", b.Timezone)
+	}
+	return nil
+}
+
+func (b QuotaBudget) location() *time.Location {
+	if b.Timezone == "" {
+		return time.Local
+	}
+	location, err := time.LoadLocation(b.Timezone)
+	if err != nil {
+		return time.Local
+	}
+	return location
+}
+
+// Window 返回 now 所在预算周期的开始与结束（下次重置）时间
+func (b QuotaBudget) Window(now time.Time) (start time.Time, end time.Time) {
+	now = now.In(b.location())
+	year, month, day := now.Date()
+	switch b.Period {
+	case QuotaBudgetPeriodWeekly:
+		offset := (int(now.Weekday()) + 6) % 7
+		start = time.Date(year, month, day-offset, 0, 0, 0, 0, now.Location())
+		end = start.AddDate(0, 0, 7)
+	case QuotaBudgetPeriodMonthly:
+		start = time.Date(year, month, 1, 0, 0, 0, 0, now.Location())
+		end = start.AddDate(0, 1, 0)
+	default:
+		start = time.Date(year, month, day, 0, 0, 0, 0, now.Location())
+		end = start.AddDate(0, 0, 1)
+	}
+	return start, end
+}
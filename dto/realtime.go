@@ -1,6 +1,10 @@
 package dto
 
-import "github.com/ctrlc-ctrlv-limited/cvai/types"
+import (
+	"github.com/ctrlc-ctrlv-limited/cvai/types"
+
+	"github.com/gin-gonic/gin"
+)
 
 const (
 	RealtimeEventTypeError              = "error"
@@ -19,6 +23,7 @@ const (
 	RealtimeEventResponseFunctionCallArgumentsDelta = "response.function_call_arguments.delta"
 	RealtimeEventResponseFunctionCallArgumentsDone  = "response.function_call_arguments.done"
 	RealtimeEventConversationItemCreated            = "conversation.item.created"
+	RealtimeEventInputAudioTranscriptionCompleted   = "conversation.item.input_audio_transcription.completed"
 )
 
 type RealtimeEvent struct {
@@ -31,6 +36,8 @@ type RealtimeEvent struct {
 	Response *RealtimeResponse  `json:"response,omitempty"`
 	Delta    string             `json:"delta,omitempty"`
 	Audio    string             `json:"audio,omitempty"`
+	// 转写完成事件中的用量
+	Usage *RealtimeUsage `json:"usage,omitempty"`
 }
 
 type RealtimeResponse struct {
@@ -86,3 +93,30 @@ type RealtimeContent struct {
 	Audio      string `json:"audio,omitempty"` // Base64-encoded audio bytes.
 	Transcript string `json:"transcript,omitempty"`
 }
+
+// RealtimeSessionRequest 创建 WebRTC 会话（/v1/realtime/sessions、/v1/realtime/transcription_sessions）的请求，其余字段原样转发
+type RealtimeSessionRequest struct {
+	Model                   string                   `json:"model,omitempty"`
+	InputAudioTranscription *InputAudioTranscription `json:"input_audio_transcription,omitempty"`
+}
+
+func (r *RealtimeSessionRequest) GetTokenCountMeta() *types.TokenCountMeta {
+	return &types.TokenCountMeta{
+		TokenType: types.TokenTypeTokenizer,
+	}
+}
+
+func (r *RealtimeSessionRequest) IsStream(c *gin.Context) bool {
+	return false
+}
+
+func (r *RealtimeSessionRequest) SetModelName(modelName string) {
+	if modelName == "" {
+		return
+	}
+	if r.InputAudioTranscription != nil && r.Model == "" {
+		r.InputAudioTranscription.Model = modelName
+		return
+	}
+	r.Model = modelName
+}
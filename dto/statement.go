@@ -0,0 +1,62 @@
+package dto
+
+// UserStatement 用户的月度账单，金额按站点的额度展示类型换算，TOKENS 展示类型下金额即额度
+type UserStatement struct {
+	UserId         int     `json:"user_id"`
+	Username       string  `json:"username"`
+	Month          string  `json:"month"`
+	StartTime      int64   `json:"start_time"`
+	EndTime        int64   `json:"end_time"`
+	Currency       string  `json:"currency"`
+	CurrencySymbol string  `json:"currency_symbol"`
+	ExchangeRate   float64 `json:"exchange_rate"`
+	// 消费的数据来源，logs 为消费日志，quota_data 为没有消费日志时使用的数据看板按小时汇总数据
+	UsageSource string `json:"usage_source"`
+
+	Usages      []StatementUsage      `json:"usages"`
+	TopUps      []StatementTopUp      `json:"topups"`
+	Redemptions []StatementRedemption `json:"redemptions"`
+
+	TotalRequests        int                `json:"total_requests"`
+	TotalUsageQuota      int                `json:"total_usage_quota"`
+	TotalUsageAmount     float64            `json:"total_usage_amount"`
+	TotalTopUpMoney      map[string]float64 `json:"total_topup_money"` // 按支付币种汇总的充值支付金额
+	TotalRedemptionQuota int                `json:"total_redemption_quota"`
+	GeneratedAt          int64              `json:"generated_at"`
+}
+
+// StatementUsage 按模型与令牌汇总的消费，来自数据看板时没有令牌与输入输出 token 的区分
+type StatementUsage struct {
+	UserId           int     `json:"-"`
+	Username         string  `json:"-"`
+	ModelName        string  `json:"model_name"`
+	TokenId          int     `json:"token_id"`
+	TokenName        string  `json:"token_name"`
+	Count            int     `json:"count"`
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	TotalTokens      int     `json:"total_tokens"`
+	Quota            int     `json:"quota"`
+	Amount           float64 `json:"amount" gorm:"-"`
+}
+
+// StatementTopUp 已完成的充值订单，Money 为以 Currency 支付的实际金额
+type StatementTopUp struct {
+	UserId        int     `json:"-"`
+	TradeNo       string  `json:"trade_no"`
+	PaymentMethod string  `json:"payment_method"`
+	Currency      string  `json:"currency"`
+	Amount        int64   `json:"amount"`
+	Money         float64 `json:"money"`
+	CompleteTime  int64   `json:"complete_time"`
+}
+
+// StatementRedemption 已使用的兑换码
+type StatementRedemption struct {
+	UserId       int     `json:"-"`
+	Id           int     `json:"id"`
+	Name         string  `json:"name"`
+	Quota        int     `json:"quota"`
+	Amount       float64 `json:"amount" gorm:"-"`
+	RedeemedTime int64   `json:"redeemed_time"`
+}
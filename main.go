@@ -84,11 +84,23 @@ func main() {
 		}()
 
 		go model.SyncChannelCache(common.SyncFrequency)
+		// 按定时规则启停渠道、调整优先级与权重
+		go model.SyncChannelSchedules()
+	}
+
+	if common.RedisEnabled {
+		// 多节点共享自适应渠道选择的统计
+		go model.SyncChannelScores()
+		// 多节点共享渠道并发数
+		go model.SyncChannelInflight()
 	}
 
 	// 热更新配置
 	go model.SyncOptions(common.SyncFrequency)
 
+	// 为已有账户写入额度流水的期初余额
+	service.InitQuotaLedger()
+
 	// 数据看板
 	go model.UpdateQuotaData()
 
@@ -102,6 +114,27 @@ func main() {
 
 	go controller.AutomaticallyTestChannels()
 
+	// 清理过期的 Files API 文件
+	go service.StartFileCleanupTask()
+
+	// 执行 Batch API 的批处理
+	go service.StartBatchTask()
+
+	// 清理过期的 response 绑定
+	go service.StartResponseBindingCleanupTask()
+
+	// 同步向量库的存储量并结算存储费用
+	go service.StartVectorStoreBillingTask()
+
+	// 额度流水对账
+	go service.StartQuotaLedgerReconcileTask()
+
+	// 清理过期的周期预算计数
+	go service.StartQuotaBudgetCleanupTask()
+
+	// 月度账单通知
+	go service.StartStatementNotifyTask()
+
 	if common.IsMasterNode && constant.UpdateTask {
 		gopool.Go(func() {
 			controller.UpdateMidjourneyTaskBulk()
@@ -160,6 +193,7 @@ func main() {
 
 	// 设置路由
 	router.SetRouter(server, buildFS, indexPage)
+	service.SetBatchHandler(server)
 	var port = os.Getenv("PORT")
 	if port == "" {
 		port = strconv.Itoa(*common.Port)
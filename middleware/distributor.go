@@ -1,6 +1,7 @@
 package middleware
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
@@ -15,26 +16,60 @@ import (
 	"github.com/ctrlc-ctrlv-limited/cvai/model"
 	relayconstant "github.com/ctrlc-ctrlv-limited/cvai/relay/constant"
 	"github.com/ctrlc-ctrlv-limited/cvai/service"
+	"github.com/ctrlc-ctrlv-limited/cvai/setting/operation_setting"
 	"github.com/ctrlc-ctrlv-limited/cvai/setting/ratio_setting"
 	"github.com/ctrlc-ctrlv-limited/cvai/types"
 
 	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
 )
 
 type ModelRequest struct {
 	Model string `json:"model"`
 	Group string `json:"group,omitempty"`
+	// Responses API 续写时需要发送到创建上一个 response 的渠道
+	PreviousResponseId string `json:"previous_response_id,omitempty"`
+	// Responses API 的 file_search 工具需要发送到创建向量库的渠道，其他接口的 tools 格式不同，只按原始 JSON 读取
+	Tools json.RawMessage `json:"tools,omitempty"`
+}
+
+// GetVectorStoreIds 获取 file_search 工具引用的向量库
+func (r *ModelRequest) GetVectorStoreIds() []string {
+	var vectorStoreIds []string
+	for _, tool := range gjson.ParseBytes(r.Tools).Array() {
+		if tool.Get("type").String() != dto.BuildInToolFileSearch {
+			continue
+		}
+		for _, vectorStoreId := range tool.Get("vector_store_ids").Array() {
+			vectorStoreIds = append(vectorStoreIds, vectorStoreId.String())
+		}
+	}
+	return vectorStoreIds
 }
 
 func Distribute() func(c *gin.Context) {
 	return func(c *gin.Context) {
+		// 选择渠道时占用、但没有用于请求上游的并发名额与没有记录结果的探测名额在请求结束时释放
+		defer service.ReleaseChannelConcurrencyReservation(c)
+		defer service.ReleaseChannelBreaker(c)
 		var channel *model.Channel
-		channelId, ok := common.GetContextKey(c, constant.ContextKeyTokenSpecificChannelId)
+		// 按分组与模型选择渠道时使用，令牌指定或请求固定的渠道为 nil
+		var retryParam *service.RetryParam
 		modelRequest, shouldSelectChannel, err := getModelRequest(c)
 		if err != nil {
 			abortWithOpenAiMessage(c, http.StatusBadRequest, "Invalid request, "+err.Error())
 			return
 		}
+		if shouldSelectChannel && strings.HasPrefix(modelRequest.Model, "ft:") && !setupFineTunedModelChannel(c, modelRequest.Model) {
+			return
+		}
+		if shouldSelectChannel && modelRequest.PreviousResponseId != "" && !setupPreviousResponseChannel(c, modelRequest.PreviousResponseId) {
+			return
+		}
+		if shouldSelectChannel && len(modelRequest.GetVectorStoreIds()) > 0 && !setupVectorStoreChannel(c, modelRequest.GetVectorStoreIds()) {
+			return
+		}
+		channelId, ok := common.GetContextKey(c, constant.ContextKeyTokenSpecificChannelId)
 		if ok {
 			id, err := strconv.Atoi(channelId.(string))
 			if err != nil {
@@ -97,38 +132,237 @@ func Distribute() func(c *gin.Context) {
 						common.SetContextKey(c, constant.ContextKeyUsingGroup, usingGroup)
 					}
 				}
-				channel, selectGroup, err = service.CacheGetRandomSatisfiedChannel(&service.RetryParam{
-					Ctx:        c,
-					ModelName:  modelRequest.Model,
-					TokenGroup: usingGroup,
-					Retry:      common.GetPointer(0),
-				})
-				if err != nil {
-					showGroup := usingGroup
-					if usingGroup == "auto" {
-						showGroup = fmt.Sprintf("auto(%s)", selectGroup)
+				if pinned, ok := common.GetContextKeyType[*pinnedChannel](c, constant.ContextKeyPinnedChannel); ok {
+					if channel = getPinnedChannel(c, usingGroup, modelRequest.Model, pinned); channel == nil {
+						return
+					}
+				} else {
+					// 会话亲和：优先使用会话已绑定的渠道
+					channel = service.GetStickyChannel(c, usingGroup, modelRequest.Model)
+					if channel != nil && !service.ReserveChannelConcurrency(c, channel) {
+						channel = nil
+					}
+					retryParam = &service.RetryParam{
+						Ctx:        c,
+						ModelName:  modelRequest.Model,
+						TokenGroup: usingGroup,
+						Retry:      common.GetPointer(0),
+					}
+					if channel == nil {
+						channel, selectGroup, err = service.CacheGetRandomSatisfiedChannel(retryParam)
+					}
+					// 渠道全部不可用时排队等待渠道恢复，排队失败时沿用原有逻辑
+					if service.ShouldQueueRequest(usingGroup, modelRequest.Model, channel) {
+						// 已达到模型请求数限制的请求不占用排队名额
+						if message, limited := modelRequestRateLimited(c); limited {
+							abortWithOpenAiMessage(c, http.StatusTooManyRequests, message)
+							return
+						}
+						if queueErr := service.WaitInRequestQueue(c, usingGroup, modelRequest.Model); queueErr == nil {
+							common.SetContextKey(c, constant.ContextKeyAutoGroupIndex, 0)
+							common.SetContextKey(c, constant.ContextKeyAutoGroupRetryIndex, 0)
+							channel, selectGroup, err = service.CacheGetRandomSatisfiedChannel(retryParam)
+						} else if err == nil && channel == nil {
+							abortWithOpenAiMessage(c, http.StatusServiceUnavailable, queueErr.Error(), string(types.ErrorCodeModelNotFound))
+							return
+						}
+					}
+					if err != nil {
+						showGroup := usingGroup
+						if usingGroup == "auto" {
+							showGroup = fmt.Sprintf("auto(%s)", selectGroup)
+						}
+						message := fmt.Sprintf("获取分组 %s 下模型 %s 的可用渠道失败（distributor）: %s", showGroup, modelRequest.Model, err.Error())
+						// 如果错误，但是渠道不为空，说明是数据库一致性问题
+						//if channel != nil {
+						//	common.SysError(fmt.Sprintf("渠道不存在：%d", channel.Id))
+						//	message = "数据库一致性已被破坏，请联系管理员"
+						//}
+						abortWithOpenAiMessage(c, http.StatusServiceUnavailable, message, string(types.ErrorCodeModelNotFound))
+						return
+					}
+					if channel == nil {
+						abortWithOpenAiMessage(c, http.StatusServiceUnavailable, fmt.Sprintf("分组 %s 下模型 %s 无可用渠道（distributor）", usingGroup, modelRequest.Model), string(types.ErrorCodeModelNotFound))
+						return
 					}
-					message := fmt.Sprintf("获取分组 %s 下模型 %s 的可用渠道失败（distributor）: %s", showGroup, modelRequest.Model, err.Error())
-					// 如果错误，但是渠道不为空，说明是数据库一致性问题
-					//if channel != nil {
-					//	common.SysError(fmt.Sprintf("渠道不存在：%d", channel.Id))
-					//	message = "数据库一致性已被破坏，请联系管理员"
-					//}
-					abortWithOpenAiMessage(c, http.StatusServiceUnavailable, message, string(types.ErrorCodeModelNotFound))
-					return
-				}
-				if channel == nil {
-					abortWithOpenAiMessage(c, http.StatusServiceUnavailable, fmt.Sprintf("分组 %s 下模型 %s 无可用渠道（distributor）", usingGroup, modelRequest.Model), string(types.ErrorCodeModelNotFound))
-					return
 				}
 			}
 		}
 		common.SetContextKey(c, constant.ContextKeyRequestStartTime, time.Now())
-		SetupContextForSelectedChannel(c, channel, modelRequest.Model)
+		setupErr := SetupContextForSelectedChannel(c, channel, modelRequest.Model)
+		// 选中的渠道没有可用的 key（例如都达到了 RPM/TPM 上限）时跳过该渠道重新选择，令牌指定或请求固定的渠道不切换
+		for retryParam != nil && setupErr != nil && setupErr.GetErrorCode() == types.ErrorCodeChannelNoAvailableKey {
+			service.ReleaseChannelConcurrencyReservation(c)
+			retryParam.ExcludeChannel(channel.Id)
+			next, _, selectErr := service.CacheGetRandomSatisfiedChannel(retryParam)
+			if selectErr != nil || next == nil {
+				break
+			}
+			channel = next
+			setupErr = SetupContextForSelectedChannel(c, channel, modelRequest.Model)
+		}
+		if setupErr != nil && setupErr.GetErrorCode() == types.ErrorCodeChannelNoAvailableKey {
+			// 渠道的 key 都已熔断、限流冷却中或达到 RPM/TPM 上限
+			abortWithOpenAiMessage(c, http.StatusServiceUnavailable, fmt.Sprintf("模型 %s 的渠道暂无可用的 key", modelRequest.Model), string(types.ErrorCodeChannelNoAvailableKey))
+			return
+		}
 		c.Next()
 	}
 }
 
+// setupFineTunedModelChannel 通过网关微调产生的模型只能由创建它的用户通过创建它的渠道与 key 调用，返回 false 表示请求已被拒绝
+func setupFineTunedModelChannel(c *gin.Context, modelName string) bool {
+	fineTunedModel, err := model.GetFineTunedModel(modelName)
+	if err != nil {
+		abortWithOpenAiMessage(c, http.StatusInternalServerError, "获取微调模型失败: "+err.Error())
+		return false
+	}
+	if fineTunedModel == nil {
+		// 不是通过网关微调的模型，按普通模型选择渠道
+		return true
+	}
+	if fineTunedModel.UserId != c.GetInt("id") {
+		abortWithOpenAiMessage(c, http.StatusForbidden, "无权访问模型 "+modelName)
+		return false
+	}
+	// 产生该模型的微调任务也必须属于当前用户，且与模型绑定的渠道一致
+	task, exist, err := model.GetByTaskId(c.GetInt("id"), fineTunedModel.TaskId)
+	if err != nil {
+		abortWithOpenAiMessage(c, http.StatusInternalServerError, "获取微调任务失败: "+err.Error())
+		return false
+	}
+	if !exist || task.ChannelId != fineTunedModel.ChannelId {
+		abortWithOpenAiMessage(c, http.StatusForbidden, "无权访问模型 "+modelName)
+		return false
+	}
+	// 微调模型不在渠道的模型列表中，按基础模型检查渠道是否在用户分组下可用
+	return pinChannel(c, fineTunedModel.ChannelId, fineTunedModel.KeyIndex, fineTunedModel.BaseModel, "该令牌指定的渠道无法访问模型 "+modelName)
+}
+
+// setupPreviousResponseChannel 携带 previous_response_id 的请求发送到创建上一个 response 的渠道与 key，返回 false 表示请求已被拒绝
+func setupPreviousResponseChannel(c *gin.Context, previousResponseId string) bool {
+	if !operation_setting.GetResponsesSetting().Enabled {
+		return true
+	}
+	binding, err := model.GetUserResponseBinding(c.GetInt("id"), previousResponseId)
+	if err != nil {
+		abortWithOpenAiMessage(c, http.StatusInternalServerError, "获取 response 失败: "+err.Error())
+		return false
+	}
+	if binding == nil {
+		// 不是通过网关创建的 response，按普通请求选择渠道
+		return true
+	}
+	return pinChannel(c, binding.ChannelId, binding.KeyIndex, "", "该令牌指定的渠道无法访问 response "+previousResponseId)
+}
+
+// setupVectorStoreChannel file_search 工具引用的向量库只能由创建它的用户通过创建它的渠道与 key 使用，返回 false 表示请求已被拒绝
+func setupVectorStoreChannel(c *gin.Context, vectorStoreIds []string) bool {
+	if !operation_setting.GetVectorStoreSetting().Enabled {
+		return true
+	}
+	var pinned *model.VectorStore
+	for _, vectorStoreId := range vectorStoreIds {
+		store, err := model.GetVectorStore(vectorStoreId)
+		if err != nil {
+			abortWithOpenAiMessage(c, http.StatusInternalServerError, "获取向量库失败: "+err.Error())
+			return false
+		}
+		if store == nil {
+			// 不是通过网关创建的向量库，按普通请求选择渠道
+			continue
+		}
+		if store.UserId != c.GetInt("id") {
+			abortWithOpenAiMessage(c, http.StatusForbidden, "无权访问向量库 "+vectorStoreId)
+			return false
+		}
+		if store.Status == model.VectorStoreStatusExpired {
+			abortWithOpenAiMessage(c, http.StatusBadRequest, "向量库 "+vectorStoreId+" 已过期")
+			return false
+		}
+		if pinned != nil && (pinned.ChannelId != store.ChannelId || pinned.KeyIndex != store.KeyIndex) {
+			abortWithOpenAiMessage(c, http.StatusBadRequest, fmt.Sprintf("向量库 %s 与 %s 位于不同的渠道，不能在同一个请求中使用", pinned.VectorStoreId, vectorStoreId))
+			return false
+		}
+		pinned = store
+	}
+	if pinned == nil {
+		return true
+	}
+	return pinChannel(c, pinned.ChannelId, pinned.KeyIndex, "", "该令牌指定的渠道无法访问向量库 "+pinned.VectorStoreId)
+}
+
+// pinnedChannel 请求要求固定使用的渠道，abilityModel 为检查渠道是否提供时使用的模型，为空时使用请求的模型
+type pinnedChannel struct {
+	channelId    int
+	keyIndex     int
+	abilityModel string
+}
+
+// pinChannel 固定使用指定的渠道与 key，令牌指定了其他渠道时拒绝请求，返回 false 表示请求已被拒绝。
+// 只决定使用哪个渠道，令牌的模型限制以及渠道是否在请求分组下提供该模型仍在选择渠道时检查
+func pinChannel(c *gin.Context, channelId int, keyIndex int, abilityModel string, forbiddenMessage string) bool {
+	if specificChannelId, ok := common.GetContextKey(c, constant.ContextKeyTokenSpecificChannelId); ok && specificChannelId.(string) != strconv.Itoa(channelId) {
+		abortWithOpenAiMessage(c, http.StatusForbidden, forbiddenMessage)
+		return false
+	}
+	// 微调模型、previous_response_id 与向量库可能同时出现，必须位于同一个渠道与 key
+	if pinned, ok := common.GetContextKeyType[*pinnedChannel](c, constant.ContextKeyPinnedChannel); ok {
+		if pinned.channelId != channelId || pinned.keyIndex != keyIndex {
+			abortWithOpenAiMessage(c, http.StatusBadRequest, "请求引用的微调模型、response 与向量库位于不同的渠道，不能在同一个请求中使用")
+			return false
+		}
+		return true
+	}
+	common.SetContextKey(c, constant.ContextKeyPinnedChannel, &pinnedChannel{
+		channelId:    channelId,
+		keyIndex:     keyIndex,
+		abilityModel: abilityModel,
+	})
+	common.SetContextKey(c, constant.ContextKeyStickyBinding, &service.StickyBinding{
+		ChannelId: channelId,
+		KeyIndex:  keyIndex,
+	})
+	return true
+}
+
+// getPinnedChannel 获取固定使用的渠道，渠道必须在请求的分组（auto 分组时为用户可用的任一自动分组）下提供该模型，
+// 返回 nil 表示请求已被拒绝
+func getPinnedChannel(c *gin.Context, usingGroup string, modelName string, pinned *pinnedChannel) *model.Channel {
+	abilityModel := pinned.abilityModel
+	if abilityModel == "" {
+		abilityModel = modelName
+	}
+	groups := []string{usingGroup}
+	if usingGroup == "auto" {
+		groups = service.GetUserAutoGroup(common.GetContextKeyString(c, constant.ContextKeyUserGroup))
+	}
+	satisfiedGroup := ""
+	for _, group := range groups {
+		if model.IsChannelSatisfied(group, abilityModel, pinned.channelId) {
+			satisfiedGroup = group
+			break
+		}
+	}
+	if satisfiedGroup == "" {
+		abortWithOpenAiMessage(c, http.StatusForbidden, fmt.Sprintf("分组 %s 下模型 %s 无法使用渠道 #%d", usingGroup, modelName, pinned.channelId))
+		return nil
+	}
+	if usingGroup == "auto" {
+		common.SetContextKey(c, constant.ContextKeyAutoGroup, satisfiedGroup)
+	}
+	channel, err := model.CacheGetChannel(pinned.channelId)
+	if err != nil {
+		abortWithOpenAiMessage(c, http.StatusServiceUnavailable, fmt.Sprintf("获取渠道 #%d 失败: %s", pinned.channelId, err.Error()), string(types.ErrorCodeGetChannelFailed))
+		return nil
+	}
+	if channel.Status != common.ChannelStatusEnabled {
+		abortWithOpenAiMessage(c, http.StatusForbidden, "该渠道已被禁用")
+		return nil
+	}
+	return channel
+}
+
 // getModelFromRequest 从请求中读取模型信息
 // 根据 Content-Type 自动处理：
 // - application/json
@@ -227,6 +461,11 @@ func getModelRequest(c *gin.Context) (*ModelRequest, bool, error) {
 		if _, ok := c.Get("relay_mode"); !ok {
 			c.Set("relay_mode", relayMode)
 		}
+	} else if strings.Contains(c.Request.URL.Path, "BidiGenerateContent") {
+		// Gemini Live 的模型在 setup 消息中: {"setup":{"model":"models/gemini-live-2.5-flash-preview"}}
+		setup, _ := common.GetContextKeyType[[]byte](c, constant.ContextKeyGeminiLiveSetup)
+		modelRequest.Model = dto.GeminiLiveModelName(gjson.GetBytes(setup, "setup.model").String())
+		c.Set("relay_mode", relayconstant.RelayModeGeminiLive)
 	} else if strings.HasPrefix(c.Request.URL.Path, "/v1beta/models/") || strings.HasPrefix(c.Request.URL.Path, "/v1/models/") {
 		// Gemini API 路径处理: /v1beta/models/gemini-2.0-flash:generateContent
 		relayMode := relayconstant.RelayModeGemini
@@ -235,16 +474,28 @@ func getModelRequest(c *gin.Context) (*ModelRequest, bool, error) {
 			modelRequest.Model = modelName
 		}
 		c.Set("relay_mode", relayMode)
+	} else if strings.HasPrefix(c.Request.URL.Path, "/v1/vector_stores") {
+		// 向量库没有模型，按配置的模型名称选择渠道
+		modelRequest.Model = operation_setting.GetVectorStoreSetting().ChannelModel
 	} else if !strings.HasPrefix(c.Request.URL.Path, "/v1/audio/transcriptions") && !strings.Contains(c.Request.Header.Get("Content-Type"), "multipart/form-data") {
 		req, err := getModelFromRequest(c)
 		if err != nil {
 			return nil, false, err
 		}
 		modelRequest.Model = req.Model
+		modelRequest.PreviousResponseId = req.PreviousResponseId
+		modelRequest.Tools = req.Tools
 	}
-	if strings.HasPrefix(c.Request.URL.Path, "/v1/realtime") {
+	if c.Request.URL.Path == "/v1/realtime" {
 		//wss://api.openai.com/v1/realtime?model=gpt-4o-realtime-preview-2024-10-01
 		modelRequest.Model = c.Query("model")
+	} else if strings.HasPrefix(c.Request.URL.Path, "/v1/realtime/transcription_sessions") {
+		// 转写会话按转写模型选择渠道
+		body, err := common.GetRequestBody(c)
+		if err != nil {
+			return nil, false, err
+		}
+		modelRequest.Model = common.GetStringIfEmpty(gjson.GetBytes(body, "input_audio_transcription.model").String(), "gpt-4o-transcribe")
 	}
 	if strings.HasPrefix(c.Request.URL.Path, "/v1/moderations") {
 		if modelRequest.Model == "" {
@@ -258,7 +509,7 @@ func getModelRequest(c *gin.Context) (*ModelRequest, bool, error) {
 	}
 	if strings.HasPrefix(c.Request.URL.Path, "/v1/images/generations") {
 		modelRequest.Model = common.GetStringIfEmpty(modelRequest.Model, "dall-e")
-	} else if strings.HasPrefix(c.Request.URL.Path, "/v1/images/edits") {
+	} else if strings.HasPrefix(c.Request.URL.Path, "/v1/images/edits") || strings.HasPrefix(c.Request.URL.Path, "/v1/images/variations") {
 		//modelRequest.Model = common.GetStringIfEmpty(c.PostForm("model"), "gpt-image-1")
 		contentType := c.ContentType()
 		if slices.Contains([]string{gin.MIMEPOSTForm, gin.MIMEMultipartPOSTForm}, contentType) {
@@ -267,6 +518,10 @@ func getModelRequest(c *gin.Context) (*ModelRequest, bool, error) {
 				modelRequest.Model = req.Model
 			}
 		}
+		if strings.HasPrefix(c.Request.URL.Path, "/v1/images/variations") {
+			// OpenAI 仅 dall-e-2 支持生成变体
+			modelRequest.Model = common.GetStringIfEmpty(modelRequest.Model, "dall-e-2")
+		}
 	}
 	if strings.HasPrefix(c.Request.URL.Path, "/v1/audio") {
 		relayMode := relayconstant.RelayModeAudioSpeech
@@ -323,10 +578,19 @@ func SetupContextForSelectedChannel(c *gin.Context, channel *model.Channel, mode
 	common.SetContextKey(c, constant.ContextKeyChannelModelMapping, channel.GetModelMapping())
 	common.SetContextKey(c, constant.ContextKeyChannelStatusCodeMapping, channel.GetStatusCodeMapping())
 
-	key, index, newAPIError := channel.GetNextEnabledKey()
-	if newAPIError != nil {
-		return newAPIError
+	var key string
+	index, ok := service.GetStickyKeyIndex(c, channel.Id)
+	if ok {
+		key, ok = channel.GetKeyByIndex(index, modelName)
+	}
+	if !ok {
+		var newAPIError *types.CVAIError
+		key, index, newAPIError = channel.GetNextAvailableKey(modelName)
+		if newAPIError != nil {
+			return newAPIError
+		}
 	}
+	common.SetContextKey(c, constant.ContextKeyChannelTrackKeyUsage, channel.ShouldTrackKeyUsage())
 	if channel.ChannelInfo.IsMultiKey {
 		common.SetContextKey(c, constant.ContextKeyChannelIsMultiKey, true)
 		common.SetContextKey(c, constant.ContextKeyChannelMultiKeyIndex, index)
@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/ctrlc-ctrlv-limited/cvai/common"
+	"github.com/ctrlc-ctrlv-limited/cvai/constant"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// setup 消息的最长等待时间
+const geminiLiveSetupTimeout = 30 * time.Second
+
+var geminiLiveUpgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool {
+		return true // 允许跨域
+	},
+}
+
+// GeminiLiveSetup Gemini Live 的模型在首条 setup 消息中，需要先升级连接并读取 setup 消息才能选择渠道
+// 连接在请求处理结束后关闭
+func GeminiLiveSetup() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		ws, err := geminiLiveUpgrader.Upgrade(c.Writer, c.Request, nil)
+		if err != nil {
+			c.Abort()
+			return
+		}
+		defer ws.Close()
+
+		_ = ws.SetReadDeadline(time.Now().Add(geminiLiveSetupTimeout))
+		_, message, err := ws.ReadMessage()
+		if err != nil {
+			c.Abort()
+			return
+		}
+		_ = ws.SetReadDeadline(time.Time{})
+
+		common.SetContextKey(c, constant.ContextKeyGeminiLiveConn, ws)
+		common.SetContextKey(c, constant.ContextKeyGeminiLiveSetup, message)
+		c.Next()
+	}
+}
@@ -173,22 +173,7 @@ func ModelRequestRateLimit() func(c *gin.Context) {
 		}
 
 		// 计算限流参数
-		duration := int64(setting.ModelRequestRateLimitDurationMinutes * 60)
-		totalMaxCount := setting.ModelRequestRateLimitCount
-		successMaxCount := setting.ModelRequestRateLimitSuccessCount
-
-		// 获取分组
-		group := common.GetContextKeyString(c, constant.ContextKeyTokenGroup)
-		if group == "" {
-			group = common.GetContextKeyString(c, constant.ContextKeyUserGroup)
-		}
-
-		//获取分组的限流配置
-		groupTotalCount, groupSuccessCount, found := setting.GetGroupRateLimit(group)
-		if found {
-			totalMaxCount = groupTotalCount
-			successMaxCount = groupSuccessCount
-		}
+		duration, totalMaxCount, successMaxCount := modelRequestRateLimitParams(c)
 
 		// 根据存储类型选择并执行限流处理器
 		if common.RedisEnabled {
@@ -198,3 +183,53 @@ func ModelRequestRateLimit() func(c *gin.Context) {
 		}
 	}
 }
+
+// modelRequestRateLimitParams 返回限流时间窗口（秒）与请求所在分组的总请求数、成功请求数限制
+func modelRequestRateLimitParams(c *gin.Context) (int64, int, int) {
+	duration := int64(setting.ModelRequestRateLimitDurationMinutes * 60)
+	totalMaxCount := setting.ModelRequestRateLimitCount
+	successMaxCount := setting.ModelRequestRateLimitSuccessCount
+
+	// 获取分组
+	group := common.GetContextKeyString(c, constant.ContextKeyTokenGroup)
+	if group == "" {
+		group = common.GetContextKeyString(c, constant.ContextKeyUserGroup)
+	}
+
+	//获取分组的限流配置
+	groupTotalCount, groupSuccessCount, found := setting.GetGroupRateLimit(group)
+	if found {
+		totalMaxCount = groupTotalCount
+		successMaxCount = groupSuccessCount
+	}
+	return duration, totalMaxCount, successMaxCount
+}
+
+// modelRequestRateLimited 在不记录请求的情况下检查用户是否已达到成功请求数限制，用于排队前提前拒绝
+func modelRequestRateLimited(c *gin.Context) (string, bool) {
+	if !setting.ModelRequestRateLimitEnabled {
+		return "", false
+	}
+	duration, _, successMaxCount := modelRequestRateLimitParams(c)
+	if successMaxCount <= 0 {
+		return "", false
+	}
+	userId := strconv.Itoa(c.GetInt("id"))
+	allowed := true
+	if common.RedisEnabled {
+		successKey := fmt.Sprintf("rateLimit:%s:%s", ModelRequestRateLimitSuccessCountMark, userId)
+		var err error
+		allowed, err = checkRedisRateLimit(context.Background(), common.RDB, successKey, successMaxCount, duration)
+		if err != nil {
+			// 检查失败时不拦截，由排队后的正常流程处理
+			return "", false
+		}
+	} else {
+		inMemoryRateLimiter.Init(time.Duration(setting.ModelRequestRateLimitDurationMinutes) * time.Minute)
+		allowed = inMemoryRateLimiter.Allowed(ModelRequestRateLimitSuccessCountMark+userId, successMaxCount, duration)
+	}
+	if allowed {
+		return "", false
+	}
+	return fmt.Sprintf("您已达到请求数限制：%d分钟内最多请求%d次", setting.ModelRequestRateLimitDurationMinutes, successMaxCount), true
+}
@@ -4,8 +4,11 @@ import (
 	"fmt"
 
 	"github.com/ctrlc-ctrlv-limited/cvai/common"
+	"github.com/ctrlc-ctrlv-limited/cvai/constant"
 	"github.com/ctrlc-ctrlv-limited/cvai/logger"
+	"github.com/ctrlc-ctrlv-limited/cvai/relay/helper"
 	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
 )
 
 func abortWithOpenAiMessage(c *gin.Context, statusCode int, message string, code ...string) {
@@ -14,6 +17,13 @@ func abortWithOpenAiMessage(c *gin.Context, statusCode int, message string, code
 		codeStr = code[0]
 	}
 	userId := c.GetInt("id")
+	if ws, ok := common.GetContextKeyType[*websocket.Conn](c, constant.ContextKeyGeminiLiveConn); ok {
+		// 连接已升级为 WebSocket
+		helper.GeminiLiveError(ws, statusCode, common.MessageWithRequestId(message, c.GetString(common.RequestIdKey)))
+		c.Abort()
+		logger.LogError(c.Request.Context(), fmt.Sprintf("user %d | %s", userId, message))
+		return
+	}
 	c.JSON(statusCode, gin.H{
 		"error": gin.H{
 			"message": common.MessageWithRequestId(message, c.GetString(common.RequestIdKey)),
@@ -7,6 +7,7 @@ import (
 	"sync"
 
 	"github.com/ctrlc-ctrlv-limited/cvai/common"
+	"github.com/ctrlc-ctrlv-limited/cvai/setting/operation_setting"
 
 	"github.com/samber/lo"
 	"gorm.io/gorm"
@@ -58,10 +59,10 @@ func GetAllEnableAbilities() []Ability {
 	return abilities
 }
 
-func getPriority(group string, model string, retry int) (int, error) {
+func getPriority(group string, model string, retry int, excludedChannelIds []int) (int, error) {
 
 	var priorities []int
-	err := DB.Model(&Ability{}).
+	err := excludeChannels(DB.Model(&Ability{}), excludedChannelIds).
 		Select("DISTINCT(priority)").
 		Where(commonGroupCol+" = ? and model = ? and enabled = ?", group, model, true).
 		Order("priority DESC").              // 按优先级降序排序
@@ -88,11 +89,19 @@ func getPriority(group string, model string, retry int) (int, error) {
 	return priorityToUse, nil
 }
 
-func getChannelQuery(group string, model string, retry int) (*gorm.DB, error) {
-	maxPrioritySubQuery := DB.Model(&Ability{}).Select("MAX(priority)").Where(commonGroupCol+" = ? and model = ? and enabled = ?", group, model, true)
+// excludeChannels 排除本次请求已跳过的渠道
+func excludeChannels(query *gorm.DB, excludedChannelIds []int) *gorm.DB {
+	if len(excludedChannelIds) == 0 {
+		return query
+	}
+	return query.Where("channel_id not in (?)", excludedChannelIds)
+}
+
+func getChannelQuery(group string, model string, retry int, excludedChannelIds []int) (*gorm.DB, error) {
+	maxPrioritySubQuery := excludeChannels(DB.Model(&Ability{}), excludedChannelIds).Select("MAX(priority)").Where(commonGroupCol+" = ? and model = ? and enabled = ?", group, model, true)
 	channelQuery := DB.Where(commonGroupCol+" = ? and model = ? and enabled = ? and priority = (?)", group, model, true, maxPrioritySubQuery)
 	if retry != 0 {
-		priority, err := getPriority(group, model, retry)
+		priority, err := getPriority(group, model, retry, excludedChannelIds)
 		if err != nil {
 			return nil, err
 		} else {
@@ -100,14 +109,14 @@ func getChannelQuery(group string, model string, retry int) (*gorm.DB, error) {
 		}
 	}
 
-	return channelQuery, nil
+	return excludeChannels(channelQuery, excludedChannelIds), nil
 }
 
-func GetChannel(group string, model string, retry int) (*Channel, error) {
+func GetChannel(group string, model string, retry int, excludedChannelIds []int) (*Channel, error) {
 	var abilities []Ability
 
 	var err error = nil
-	channelQuery, err := getChannelQuery(group, model, retry)
+	channelQuery, err := getChannelQuery(group, model, retry, excludedChannelIds)
 	if err != nil {
 		return nil, err
 	}
@@ -119,6 +128,32 @@ func GetChannel(group string, model string, retry int) (*Channel, error) {
 	if err != nil {
 		return nil, err
 	}
+	adaptive := operation_setting.IsAdaptiveSelectEnabled(group)
+	if len(abilities) > 0 && (adaptive || shouldFilterChannels()) {
+		channelIds := lo.Map(abilities, func(ability Ability, _ int) int { return ability.ChannelId })
+		var candidates []*Channel
+		err = DB.Where("id in (?)", channelIds).Find(&candidates).Error
+		if err != nil {
+			return nil, err
+		}
+		// 并发已满的渠道始终跳过
+		candidates = lo.Filter(candidates, func(channel *Channel, _ int) bool {
+			return !IsChannelSaturated(channel)
+		})
+		if len(candidates) == 0 {
+			return nil, nil
+		}
+		allowed := lo.Filter(candidates, func(channel *Channel, _ int) bool {
+			return IsChannelSelectable(channel, model)
+		})
+		if len(allowed) > 0 {
+			candidates = allowed
+		}
+		if adaptive {
+			return pickAdaptiveChannel(candidates, model), nil
+		}
+		return pickWeightedChannel(candidates), nil
+	}
 	channel := Channel{}
 	if len(abilities) > 0 {
 		// Randomly choose one
@@ -0,0 +1,198 @@
+package model
+
+import (
+	"errors"
+
+	"github.com/ctrlc-ctrlv-limited/cvai/common"
+
+	"gorm.io/gorm"
+)
+
+const (
+	BatchStatusValidating = "validating"
+	BatchStatusFailed     = "failed"
+	BatchStatusInProgress = "in_progress"
+	BatchStatusFinalizing = "finalizing"
+	BatchStatusCompleted  = "completed"
+	BatchStatusExpired    = "expired"
+	BatchStatusCancelling = "cancelling"
+	BatchStatusCancelled  = "cancelled"
+)
+
+// Batch 通过 Batch API 创建的批处理，由网关逐行执行或转发到上游的原生 Batch API
+type Batch struct {
+	Id               int    `json:"-"`
+	BatchId          string `json:"id" gorm:"type:varchar(64);uniqueIndex"`
+	UserId           int    `json:"user_id" gorm:"index"`
+	TokenId          int    `json:"token_id" gorm:"index"`
+	Group            string `json:"group" gorm:"type:varchar(64)"`
+	Endpoint         string `json:"endpoint" gorm:"type:varchar(64)"`
+	Model            string `json:"model" gorm:"type:varchar(255)"`
+	InputFileId      string `json:"input_file_id" gorm:"type:varchar(64)"`
+	OutputFileId     string `json:"output_file_id" gorm:"type:varchar(64)"`
+	ErrorFileId      string `json:"error_file_id" gorm:"type:varchar(64)"`
+	CompletionWindow string `json:"completion_window" gorm:"type:varchar(16)"`
+	Status           string `json:"status" gorm:"type:varchar(32);index"`
+	// 失败原因，OpenAI 格式的 errors 对象（JSON）
+	Errors   string `json:"errors" gorm:"type:text"`
+	Metadata string `json:"metadata" gorm:"type:text"`
+
+	RequestTotal     int `json:"request_total"`
+	RequestCompleted int `json:"request_completed"`
+	RequestFailed    int `json:"request_failed"`
+	// 转发到上游原生 Batch API 时按用量扣除的额度，网关执行时每行请求各自计费
+	Quota int `json:"quota" gorm:"default:0"`
+	// 转发到上游原生 Batch API 时按输入文件估算并预扣的额度，结束后按实际用量多退少补
+	PreConsumedQuota int `json:"-" gorm:"default:0"`
+	// 创建批处理的客户端 IP，网关逐行执行时作为请求来源，令牌的 IP 白名单同样生效
+	Ip string `json:"-" gorm:"type:varchar(64)"`
+
+	// 转发到上游原生 Batch API 时使用的渠道、key 与上游的批处理 id
+	ChannelId       int    `json:"channel_id"`
+	KeyIndex        int    `json:"-"`
+	UpstreamBatchId string `json:"upstream_batch_id" gorm:"type:varchar(128)"`
+
+	CreatedAt    int64 `json:"created_at" gorm:"bigint;index"`
+	InProgressAt int64 `json:"in_progress_at" gorm:"bigint"`
+	FinalizingAt int64 `json:"finalizing_at" gorm:"bigint"`
+	CompletedAt  int64 `json:"completed_at" gorm:"bigint"`
+	FailedAt     int64 `json:"failed_at" gorm:"bigint"`
+	ExpiredAt    int64 `json:"expired_at" gorm:"bigint"`
+	CancellingAt int64 `json:"cancelling_at" gorm:"bigint"`
+	CancelledAt  int64 `json:"cancelled_at" gorm:"bigint"`
+	ExpiresAt    int64 `json:"expires_at" gorm:"bigint"`
+}
+
+// BatchResult 网关执行批处理时每一行请求的结果，批处理结束后汇总到输出文件并删除
+type BatchResult struct {
+	Id        int    `json:"id"`
+	BatchId   string `json:"batch_id" gorm:"type:varchar(64);index:idx_batch_result,unique"`
+	LineIndex int    `json:"line_index" gorm:"index:idx_batch_result,unique"`
+	Success   bool   `json:"success"`
+	Content   string `json:"content" gorm:"type:text"`
+}
+
+func (batch *Batch) Insert() error {
+	batch.CreatedAt = common.GetTimestamp()
+	return DB.Create(batch).Error
+}
+
+func (batch *Batch) Update() error {
+	return DB.Save(batch).Error
+}
+
+// IsNative 是否转发到上游的原生 Batch API
+func (batch *Batch) IsNative() bool {
+	return batch.UpstreamBatchId != ""
+}
+
+// IsFinished 批处理是否已结束
+func (batch *Batch) IsFinished() bool {
+	switch batch.Status {
+	case BatchStatusCompleted, BatchStatusFailed, BatchStatusExpired, BatchStatusCancelled:
+		return true
+	}
+	return false
+}
+
+func GetBatchByBatchId(batchId string) (*Batch, error) {
+	if batchId == "" {
+		return nil, errors.New("batch id 为空")
+	}
+	batch := &Batch{}
+	err := DB.Where("batch_id = ?", batchId).First(batch).Error
+	return batch, err
+}
+
+func GetUserBatchByBatchId(userId int, batchId string) (*Batch, error) {
+	batch, err := GetBatchByBatchId(batchId)
+	if err != nil {
+		return nil, err
+	}
+	if batch.UserId != userId {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return batch, nil
+}
+
+// GetUserBatches 按 OpenAI 的游标分页方式获取用户的批处理，after 为上一页最后一个批处理的 id
+func GetUserBatches(userId int, after string, limit int) (batches []*Batch, hasMore bool, err error) {
+	query := DB.Where("user_id = ?", userId)
+	if after != "" {
+		afterBatch, err := GetUserBatchByBatchId(userId, after)
+		if err != nil {
+			return nil, false, err
+		}
+		query = query.Where("id < ?", afterBatch.Id)
+	}
+	err = query.Order("id desc").Limit(limit + 1).Find(&batches).Error
+	if err != nil {
+		return nil, false, err
+	}
+	if len(batches) > limit {
+		return batches[:limit], true, nil
+	}
+	return batches, false, nil
+}
+
+// GetUnfinishedBatches 获取尚未结束的批处理
+func GetUnfinishedBatches() (batches []*Batch, err error) {
+	err = DB.Where("status in ?", []string{BatchStatusValidating, BatchStatusInProgress, BatchStatusFinalizing, BatchStatusCancelling}).
+		Order("id asc").Find(&batches).Error
+	return batches, err
+}
+
+// GetBatchStatus 获取批处理当前的状态，用于执行过程中检查是否已被取消
+func GetBatchStatus(id int) (string, error) {
+	var status string
+	err := DB.Model(&Batch{}).Where("id = ?", id).Select("status").Scan(&status).Error
+	return status, err
+}
+
+// StartBatch 将等待执行的批处理标记为执行中，返回是否成功
+func StartBatch(id int) (bool, error) {
+	result := DB.Model(&Batch{}).Where("id = ? and status = ?", id, BatchStatusValidating).
+		Updates(map[string]interface{}{
+			"status":         BatchStatusInProgress,
+			"in_progress_at": common.GetTimestamp(),
+		})
+	return result.RowsAffected > 0, result.Error
+}
+
+// CancelBatch 将进行中的批处理标记为取消中，返回是否成功
+func CancelBatch(id int) (bool, error) {
+	result := DB.Model(&Batch{}).Where("id = ? and status in ?", id, []string{BatchStatusValidating, BatchStatusInProgress}).
+		Updates(map[string]interface{}{
+			"status":        BatchStatusCancelling,
+			"cancelling_at": common.GetTimestamp(),
+		})
+	return result.RowsAffected > 0, result.Error
+}
+
+func InsertBatchResult(result *BatchResult) error {
+	return DB.Create(result).Error
+}
+
+// GetBatchResultStates 获取已执行完成的行号与是否成功，不包含结果内容
+func GetBatchResultStates(batchId string) (results []*BatchResult, err error) {
+	err = DB.Select("line_index", "success").Where("batch_id = ?", batchId).Find(&results).Error
+	return results, err
+}
+
+// UpdateBatchRequestCounts 更新执行中的批处理的进度
+func UpdateBatchRequestCounts(id int, completed int, failed int) error {
+	return DB.Model(&Batch{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"request_completed": completed,
+		"request_failed":    failed,
+	}).Error
+}
+
+// GetBatchResults 按行号顺序分页获取执行结果
+func GetBatchResults(batchId string, afterLine int, limit int) (results []*BatchResult, err error) {
+	err = DB.Where("batch_id = ? and line_index > ?", batchId, afterLine).Order("line_index asc").Limit(limit).Find(&results).Error
+	return results, err
+}
+
+func DeleteBatchResults(batchId string) error {
+	return DB.Where("batch_id = ?", batchId).Delete(&BatchResult{}).Error
+}
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"math/rand"
+	"sort"
 	"strings"
 	"sync"
 
@@ -55,6 +56,13 @@ type Channel struct {
 
 	// cache info
 	Keys []string `json:"-" gorm:"-"`
+
+	// 自适应渠道选择的实时统计，model -> score，仅在渠道列表中返回
+	Scores map[string]ChannelScore `json:"scores,omitempty" gorm:"-"`
+
+	// 定时规则生效期间的优先级与权重，仅存在于渠道缓存中
+	ScheduledPriority *int64 `json:"-" gorm:"-"`
+	ScheduledWeight   *uint  `json:"-" gorm:"-"`
 }
 
 type ChannelInfo struct {
@@ -65,6 +73,8 @@ type ChannelInfo struct {
 	MultiKeyDisabledTime   map[int]int64         `json:"multi_key_disabled_time,omitempty"`   // key禁用时间列表，key index -> time
 	MultiKeyPollingIndex   int                   `json:"multi_key_polling_index"`             // 多Key模式下轮询的key索引
 	MultiKeyMode           constant.MultiKeyMode `json:"multi_key_mode"`
+	MultiKeyRPMLimit       map[int]int           `json:"multi_key_rpm_limit,omitempty"` // key每分钟请求数上限，key index -> limit
+	MultiKeyTPMLimit       map[int]int           `json:"multi_key_tpm_limit,omitempty"` // key每分钟token数上限，key index -> limit
 }
 
 // Value implements driver.Valuer interface
@@ -103,6 +113,12 @@ func (channel *Channel) GetKeys() []string {
 }
 
 func (channel *Channel) GetNextEnabledKey() (string, int, *types.CVAIError) {
+	return channel.GetNextAvailableKey("")
+}
+
+// GetNextAvailableKey 与 GetNextEnabledKey 相同，但会跳过在该模型上已熔断、限流冷却中或达到 RPM/TPM 上限的 key，
+// 所有已启用的 key 都不可用时返回 ErrorCodeChannelNoAvailableKey，半开状态的探测名额由转发请求并记录结果的调用方占用
+func (channel *Channel) GetNextAvailableKey(modelName string) (string, int, *types.CVAIError) {
 	// If not in multi-key mode, return the original key string directly.
 	if !channel.ChannelInfo.IsMultiKey {
 		return channel.Key, 0, nil
@@ -145,48 +161,78 @@ func (channel *Channel) GetNextEnabledKey() (string, int, *types.CVAIError) {
 		return "", 0, types.NewError(errors.New("no enabled keys"), types.ErrorCodeChannelNoAvailableKey)
 	}
 
+	candidates := lo.Filter(enabledIdx, func(idx int, _ int) bool {
+		return !IsKeyCoolingDown(channel.Id, idx) && (modelName == "" || IsKeyBreakerAllowed(channel.Id, modelName, idx))
+	})
+
+	// 按选择方式排列候选 key，依次尝试，达到 RPM/TPM 上限的 key 在记录请求时原子跳过
+	polling := false
 	switch channel.ChannelInfo.MultiKeyMode {
 	case constant.MultiKeyModeRandom:
-		// Randomly pick one enabled key
-		selectedIdx := enabledIdx[rand.Intn(len(enabledIdx))]
-		return keys[selectedIdx], selectedIdx, nil
+		rand.Shuffle(len(candidates), func(i, j int) {
+			candidates[i], candidates[j] = candidates[j], candidates[i]
+		})
+	case constant.MultiKeyModeLeastRecentlyUsed, constant.MultiKeyModeLeastTokensThisMinute:
+		sortKeysByUsage(channel.ChannelInfo.MultiKeyMode, candidates, getSelectionKeyUsages(channel.Id))
 	case constant.MultiKeyModePolling:
 		// Use channel-specific lock to ensure thread-safe polling
-
 		channelInfo, err := CacheGetChannelInfo(channel.Id)
 		if err != nil {
 			return "", 0, types.NewError(err, types.ErrorCodeGetChannelFailed, types.ErrOptionWithSkipRetry())
 		}
-		//println("before polling index:", channel.ChannelInfo.MultiKeyPollingIndex)
+		polling = true
 		defer func() {
 			if common.DebugEnabled {
 				println(fmt.Sprintf("channel %d polling index: %d", channel.Id, channel.ChannelInfo.MultiKeyPollingIndex))
 			}
 			if !common.MemoryCacheEnabled {
 				_ = channel.SaveChannelInfo()
-			} else {
-				// CacheUpdateChannel(channel)
 			}
 		}()
-		// Start from the saved polling index and look for the next enabled key
+		// Start from the saved polling index and look for the next available key
 		start := channelInfo.MultiKeyPollingIndex
 		if start < 0 || start >= len(keys) {
 			start = 0
 		}
-		for i := 0; i < len(keys); i++ {
-			idx := (start + i) % len(keys)
-			if getStatus(idx) == common.ChannelStatusEnabled {
-				// update polling index for next call (point to the next position)
-				channel.ChannelInfo.MultiKeyPollingIndex = (idx + 1) % len(keys)
-				return keys[idx], idx, nil
-			}
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return (candidates[i]-start+len(keys))%len(keys) < (candidates[j]-start+len(keys))%len(keys)
+		})
+	}
+
+	trackUsage := channel.ShouldTrackKeyUsage()
+	for _, idx := range candidates {
+		// 记录 key 被选中，用于按用量选择以及 RPM/TPM 上限
+		if trackUsage && !channel.tryUseKey(idx) {
+			continue
+		}
+		if polling {
+			// update polling index for next call (point to the next position)
+			channel.ChannelInfo.MultiKeyPollingIndex = (idx + 1) % len(keys)
 		}
-		// Fallback – should not happen, but return first enabled key
-		return keys[enabledIdx[0]], enabledIdx[0], nil
-	default:
-		// Unknown mode, default to first enabled key (or original key string)
-		return keys[enabledIdx[0]], enabledIdx[0], nil
+		return keys[idx], idx, nil
 	}
+	return "", 0, types.NewError(errors.New("no available keys"), types.ErrorCodeChannelNoAvailableKey)
+}
+
+// GetKeyByIndex 获取多 key 渠道中指定序号的 key，key 已禁用、在该模型上已熔断、限流冷却中或达到 RPM/TPM 上限时返回 false
+func (channel *Channel) GetKeyByIndex(index int, modelName string) (string, bool) {
+	if !channel.ChannelInfo.IsMultiKey {
+		return channel.Key, true
+	}
+	keys := channel.GetKeys()
+	if index < 0 || index >= len(keys) {
+		return "", false
+	}
+	if status, ok := channel.ChannelInfo.MultiKeyStatusList[index]; ok && status != common.ChannelStatusEnabled {
+		return "", false
+	}
+	if IsKeyCoolingDown(channel.Id, index) || !IsKeyBreakerAllowed(channel.Id, modelName, index) {
+		return "", false
+	}
+	if channel.ShouldTrackKeyUsage() && !channel.tryUseKey(index) {
+		return "", false
+	}
+	return keys[index], true
 }
 
 func (channel *Channel) SaveChannelInfo() error {
@@ -407,6 +453,9 @@ func BatchDeleteChannels(ids []int) error {
 }
 
 func (channel *Channel) GetPriority() int64 {
+	if channel.ScheduledPriority != nil {
+		return *channel.ScheduledPriority
+	}
 	if channel.Priority == nil {
 		return 0
 	}
@@ -414,6 +463,9 @@ func (channel *Channel) GetPriority() int64 {
 }
 
 func (channel *Channel) GetWeight() int {
+	if channel.ScheduledWeight != nil {
+		return int(*channel.ScheduledWeight)
+	}
 	if channel.Weight == nil {
 		return 0
 	}
@@ -0,0 +1,243 @@
+package model
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ctrlc-ctrlv-limited/cvai/setting/operation_setting"
+)
+
+const (
+	BreakerStateClosed   = "closed"
+	BreakerStateOpen     = "open"
+	BreakerStateHalfOpen = "half_open"
+)
+
+// ChannelBreaker 渠道 + 模型 + key 序号 的熔断状态，只保存在内存中，不修改渠道的数据库状态
+type ChannelBreaker struct {
+	ChannelId   int    `json:"channel_id"`
+	Model       string `json:"model"`
+	KeyIndex    int    `json:"key_index"`
+	State       string `json:"state"`
+	Failures    int    `json:"failures"`
+	WindowStart int64  `json:"window_start"`
+	OpenedAt    int64  `json:"opened_at"`
+	// 半开状态下正在进行和已成功的探测请求数
+	ProbesInFlight int   `json:"probes_in_flight"`
+	ProbeSuccesses int   `json:"probe_successes"`
+	HalfOpenAt     int64 `json:"half_open_at"`
+}
+
+var channelBreakers = make(map[string]*ChannelBreaker)
+var channelBreakersLock sync.Mutex
+
+func channelBreakerKey(channelId int, modelName string, keyIndex int) string {
+	return fmt.Sprintf("%d:%s:%d", channelId, modelName, keyIndex)
+}
+
+// refresh 根据时间推进状态：熔断到期后进入半开，半开探测超时后重置探测计数
+func (b *ChannelBreaker) refresh(now int64, setting *operation_setting.CircuitBreakerSetting) {
+	switch b.State {
+	case BreakerStateOpen:
+		if now-b.OpenedAt >= int64(setting.OpenSeconds) {
+			b.State = BreakerStateHalfOpen
+			b.HalfOpenAt = now
+			b.ProbesInFlight = 0
+			b.ProbeSuccesses = 0
+		}
+	case BreakerStateHalfOpen:
+		// 探测请求没有回报结果（例如客户端断开），避免一直占用探测名额
+		if b.ProbesInFlight > 0 && now-b.HalfOpenAt >= int64(setting.OpenSeconds) {
+			b.HalfOpenAt = now
+			b.ProbesInFlight = 0
+		}
+	}
+}
+
+func (b *ChannelBreaker) allow(setting *operation_setting.CircuitBreakerSetting) bool {
+	switch b.State {
+	case BreakerStateOpen:
+		return false
+	case BreakerStateHalfOpen:
+		return b.ProbesInFlight < max(setting.HalfOpenProbes, 1)
+	}
+	return true
+}
+
+// IsChannelBreakerAllowed 判断渠道在某个模型上是否至少有一个 key 未被熔断
+func IsChannelBreakerAllowed(channel *Channel, modelName string) bool {
+	if !operation_setting.IsCircuitBreakerEnabled() || channel == nil {
+		return true
+	}
+	keySize := 1
+	if channel.ChannelInfo.IsMultiKey {
+		keySize = max(channel.ChannelInfo.MultiKeySize, len(channel.GetKeys()))
+	}
+	setting := operation_setting.GetCircuitBreakerSetting()
+	now := time.Now().Unix()
+
+	channelBreakersLock.Lock()
+	defer channelBreakersLock.Unlock()
+	for i := 0; i < keySize; i++ {
+		breaker, ok := channelBreakers[channelBreakerKey(channel.Id, modelName, i)]
+		if !ok {
+			return true
+		}
+		breaker.refresh(now, setting)
+		if breaker.allow(setting) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsKeyBreakerAllowed 判断渠道的某个 key 在某个模型上是否未被熔断
+func IsKeyBreakerAllowed(channelId int, modelName string, keyIndex int) bool {
+	if !operation_setting.IsCircuitBreakerEnabled() {
+		return true
+	}
+	setting := operation_setting.GetCircuitBreakerSetting()
+	channelBreakersLock.Lock()
+	defer channelBreakersLock.Unlock()
+	breaker, ok := channelBreakers[channelBreakerKey(channelId, modelName, keyIndex)]
+	if !ok {
+		return true
+	}
+	breaker.refresh(time.Now().Unix(), setting)
+	return breaker.allow(setting)
+}
+
+// AcquireKeyBreaker 向选中的 key 发送请求前调用，判断 key 在该模型上未被熔断，
+// 半开状态下在同一次加锁中占用一个探测名额，probe 表示是否占用了名额，探测名额已满时 allowed 为 false。
+// 只有会通过 RecordChannelBreaker 记录结果的请求才占用名额
+func AcquireKeyBreaker(channelId int, modelName string, keyIndex int) (allowed bool, probe bool) {
+	if !operation_setting.IsCircuitBreakerEnabled() {
+		return true, false
+	}
+	setting := operation_setting.GetCircuitBreakerSetting()
+	channelBreakersLock.Lock()
+	defer channelBreakersLock.Unlock()
+	breaker, ok := channelBreakers[channelBreakerKey(channelId, modelName, keyIndex)]
+	if !ok {
+		return true, false
+	}
+	breaker.refresh(time.Now().Unix(), setting)
+	if !breaker.allow(setting) {
+		return false, false
+	}
+	if breaker.State == BreakerStateHalfOpen {
+		breaker.ProbesInFlight++
+		return true, true
+	}
+	return true, false
+}
+
+// ReleaseKeyBreaker 占用探测名额后请求最终没有记录结果（例如未发送到上游）时归还名额
+func ReleaseKeyBreaker(channelId int, modelName string, keyIndex int) {
+	if !operation_setting.IsCircuitBreakerEnabled() {
+		return
+	}
+	channelBreakersLock.Lock()
+	defer channelBreakersLock.Unlock()
+	breaker, ok := channelBreakers[channelBreakerKey(channelId, modelName, keyIndex)]
+	if ok && breaker.State == BreakerStateHalfOpen && breaker.ProbesInFlight > 0 {
+		breaker.ProbesInFlight--
+	}
+}
+
+// RecordChannelBreaker 记录请求结果，返回熔断器当前状态
+func RecordChannelBreaker(channelId int, modelName string, keyIndex int, success bool) string {
+	if !operation_setting.IsCircuitBreakerEnabled() {
+		return BreakerStateClosed
+	}
+	setting := operation_setting.GetCircuitBreakerSetting()
+	now := time.Now().Unix()
+	key := channelBreakerKey(channelId, modelName, keyIndex)
+
+	channelBreakersLock.Lock()
+	defer channelBreakersLock.Unlock()
+	breaker, ok := channelBreakers[key]
+	if !ok {
+		if success {
+			return BreakerStateClosed
+		}
+		breaker = &ChannelBreaker{
+			ChannelId:   channelId,
+			Model:       modelName,
+			KeyIndex:    keyIndex,
+			State:       BreakerStateClosed,
+			WindowStart: now,
+		}
+		channelBreakers[key] = breaker
+	}
+	breaker.refresh(now, setting)
+
+	switch breaker.State {
+	case BreakerStateHalfOpen:
+		if breaker.ProbesInFlight > 0 {
+			breaker.ProbesInFlight--
+		}
+		if !success {
+			breaker.State = BreakerStateOpen
+			breaker.OpenedAt = now
+			breaker.ProbeSuccesses = 0
+			return breaker.State
+		}
+		breaker.ProbeSuccesses++
+		if breaker.ProbeSuccesses >= max(setting.HalfOpenProbes, 1) {
+			// 探测全部成功，恢复
+			delete(channelBreakers, key)
+			return BreakerStateClosed
+		}
+	case BreakerStateClosed:
+		if success {
+			if now-breaker.WindowStart >= int64(setting.WindowSeconds) {
+				delete(channelBreakers, key)
+			}
+			return breaker.State
+		}
+		if now-breaker.WindowStart >= int64(setting.WindowSeconds) {
+			breaker.WindowStart = now
+			breaker.Failures = 0
+		}
+		breaker.Failures++
+		if breaker.Failures >= max(setting.FailureThreshold, 1) {
+			breaker.State = BreakerStateOpen
+			breaker.OpenedAt = now
+		}
+	}
+	return breaker.State
+}
+
+// GetChannelBreakers 获取所有非关闭状态的熔断器
+func GetChannelBreakers() []ChannelBreaker {
+	setting := operation_setting.GetCircuitBreakerSetting()
+	now := time.Now().Unix()
+	channelBreakersLock.Lock()
+	defer channelBreakersLock.Unlock()
+	breakers := make([]ChannelBreaker, 0, len(channelBreakers))
+	for key, breaker := range channelBreakers {
+		breaker.refresh(now, setting)
+		if breaker.State == BreakerStateClosed {
+			// 窗口已过期的失败计数没有意义，顺便清理
+			if now-breaker.WindowStart >= int64(setting.WindowSeconds) {
+				delete(channelBreakers, key)
+			}
+			continue
+		}
+		breakers = append(breakers, *breaker)
+	}
+	return breakers
+}
+
+// ResetChannelBreakers 手动重置某个渠道的所有熔断器，channelId 为 0 时重置全部
+func ResetChannelBreakers(channelId int) {
+	channelBreakersLock.Lock()
+	defer channelBreakersLock.Unlock()
+	for key, breaker := range channelBreakers {
+		if channelId == 0 || breaker.ChannelId == channelId {
+			delete(channelBreakers, key)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package model
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ctrlc-ctrlv-limited/cvai/constant"
+	"github.com/ctrlc-ctrlv-limited/cvai/setting/operation_setting"
+)
+
+func setupChannelBreakerTest(t *testing.T, halfOpenProbes int) {
+	t.Helper()
+	setting := operation_setting.GetCircuitBreakerSetting()
+	old := *setting
+	*setting = operation_setting.CircuitBreakerSetting{
+		Enabled:          true,
+		FailureThreshold: 1,
+		WindowSeconds:    60,
+		OpenSeconds:      30,
+		HalfOpenProbes:   halfOpenProbes,
+	}
+	ResetChannelBreakers(0)
+	t.Cleanup(func() {
+		*setting = old
+		ResetChannelBreakers(0)
+	})
+}
+
+// openHalfOpenBreaker 熔断 key 并将熔断时间提前到已过期，下次检查时进入半开状态
+func openHalfOpenBreaker(t *testing.T, channelId int, modelName string, keyIndex int) {
+	t.Helper()
+	if state := RecordChannelBreaker(channelId, modelName, keyIndex, false); state != BreakerStateOpen {
+		t.Fatalf("expected breaker to open, got %s", state)
+	}
+	channelBreakersLock.Lock()
+	channelBreakers[channelBreakerKey(channelId, modelName, keyIndex)].OpenedAt -= 30
+	channelBreakersLock.Unlock()
+}
+
+func TestAcquireKeyBreakerHalfOpenProbes(t *testing.T) {
+	setupChannelBreakerTest(t, 2)
+	RecordChannelBreaker(1, "test-model", 0, false)
+	if allowed, _ := AcquireKeyBreaker(1, "test-model", 0); allowed {
+		t.Fatalf("expected open breaker to reject")
+	}
+	openHalfOpenBreaker(t, 2, "test-model", 0)
+
+	var acquired atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if allowed, probe := AcquireKeyBreaker(2, "test-model", 0); allowed && probe {
+				acquired.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if acquired.Load() != 2 {
+		t.Fatalf("expected 2 concurrent probes, got %d", acquired.Load())
+	}
+	if IsKeyBreakerAllowed(2, "test-model", 0) {
+		t.Fatalf("expected breaker with all probes in flight to reject")
+	}
+
+	ReleaseKeyBreaker(2, "test-model", 0)
+	if allowed, probe := AcquireKeyBreaker(2, "test-model", 0); !allowed || !probe {
+		t.Fatalf("expected released probe to be acquirable")
+	}
+	RecordChannelBreaker(2, "test-model", 0, true)
+	if state := RecordChannelBreaker(2, "test-model", 0, true); state != BreakerStateClosed {
+		t.Fatalf("expected breaker to close after probes succeed, got %s", state)
+	}
+	if allowed, probe := AcquireKeyBreaker(2, "test-model", 0); !allowed || probe {
+		t.Fatalf("expected closed breaker to allow without probe, got allowed=%v probe=%v", allowed, probe)
+	}
+}
+
+func TestKeySelectionDoesNotReserveProbe(t *testing.T) {
+	setupChannelBreakerTest(t, 1)
+
+	single := &Channel{Id: 10, Key: "sk-single"}
+	openHalfOpenBreaker(t, single.Id, "test-model", 0)
+	for i := 0; i < 3; i++ {
+		if _, _, err := single.GetNextAvailableKey("test-model"); err != nil {
+			t.Fatalf("GetNextAvailableKey returned error: %v", err)
+		}
+	}
+	if !IsKeyBreakerAllowed(single.Id, "test-model", 0) {
+		t.Fatalf("expected key selection not to take the probe")
+	}
+
+	multi := &Channel{Id: 11, Key: "sk-0\nsk-1"}
+	multi.ChannelInfo.IsMultiKey = true
+	multi.ChannelInfo.MultiKeySize = 2
+	multi.ChannelInfo.MultiKeyMode = constant.MultiKeyModeRandom
+	openHalfOpenBreaker(t, multi.Id, "test-model", 0)
+	for i := 0; i < 3; i++ {
+		if _, ok := multi.GetKeyByIndex(0, "test-model"); !ok {
+			t.Fatalf("expected sticky request to get half-open key")
+		}
+	}
+	if allowed, probe := AcquireKeyBreaker(multi.Id, "test-model", 0); !allowed || !probe {
+		t.Fatalf("expected probe to be acquirable after key selection")
+	}
+	if _, ok := multi.GetKeyByIndex(0, "test-model"); ok {
+		t.Fatalf("expected sticky key to be rejected while probe in flight")
+	}
+	for i := 0; i < 20; i++ {
+		_, index, err := multi.GetNextAvailableKey("test-model")
+		if err != nil {
+			t.Fatalf("GetNextAvailableKey returned error: %v", err)
+		}
+		if index != 1 {
+			t.Fatalf("expected key 1 while probe in flight on key 0, got %d", index)
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"math/rand"
+	"slices"
 	"sort"
 	"strings"
 	"sync"
@@ -11,6 +12,7 @@ import (
 
 	"github.com/ctrlc-ctrlv-limited/cvai/common"
 	"github.com/ctrlc-ctrlv-limited/cvai/constant"
+	"github.com/ctrlc-ctrlv-limited/cvai/setting/operation_setting"
 	"github.com/ctrlc-ctrlv-limited/cvai/setting/ratio_setting"
 )
 
@@ -38,10 +40,15 @@ func InitChannelCache() {
 	for group := range groups {
 		newGroup2model2channels[group] = make(map[string][]int)
 	}
+	// 应用当前生效的定时规则
+	pausedChannels, scheduleSignature := applyChannelSchedules(channels, time.Now())
 	for _, channel := range channels {
 		if channel.Status != common.ChannelStatusEnabled {
 			continue // skip disabled channels
 		}
+		if pausedChannels[channel.Id] {
+			continue // 定时规则暂停使用的渠道
+		}
 		groups := strings.Split(channel.Group, ",")
 		for _, group := range groups {
 			models := strings.Split(channel.Models, ",")
@@ -66,6 +73,7 @@ func InitChannelCache() {
 
 	channelSyncLock.Lock()
 	group2model2channels = newGroup2model2channels
+	channelScheduleSignature = scheduleSignature
 	//channelsIDM = newChannelId2channel
 	for i, channel := range newChannelId2channel {
 		if channel.ChannelInfo.IsMultiKey {
@@ -93,10 +101,11 @@ func SyncChannelCache(frequency int) {
 	}
 }
 
-func GetRandomSatisfiedChannel(group string, model string, retry int) (*Channel, error) {
+// GetRandomSatisfiedChannel 按优先级与权重选择一个渠道，excludedChannelIds 为本次请求已跳过的渠道，不参与选择与优先级计算
+func GetRandomSatisfiedChannel(group string, model string, retry int, excludedChannelIds []int) (*Channel, error) {
 	// if memory cache is disabled, get channel directly from database
 	if !common.MemoryCacheEnabled {
-		return GetChannel(group, model, retry)
+		return GetChannel(group, model, retry, excludedChannelIds)
 	}
 
 	channelSyncLock.RLock()
@@ -111,10 +120,23 @@ func GetRandomSatisfiedChannel(group string, model string, retry int) (*Channel,
 		channels = group2model2channels[group][normalizedModel]
 	}
 
+	if len(excludedChannelIds) > 0 {
+		channels = slices.DeleteFunc(slices.Clone(channels), func(channelId int) bool {
+			return slices.Contains(excludedChannelIds, channelId)
+		})
+	}
+
 	if len(channels) == 0 {
 		return nil, nil
 	}
 
+	if shouldFilterChannels() {
+		channels = filterUnavailableChannels(channels, model)
+		if len(channels) == 0 {
+			return nil, nil
+		}
+	}
+
 	if len(channels) == 1 {
 		if channel, ok := channelsIDM[channels[0]]; ok {
 			return channel, nil
@@ -142,12 +164,10 @@ func GetRandomSatisfiedChannel(group string, model string, retry int) (*Channel,
 	targetPriority := int64(sortedUniquePriorities[retry])
 
 	// get the priority for the given retry number
-	var sumWeight = 0
 	var targetChannels []*Channel
 	for _, channelId := range channels {
 		if channel, ok := channelsIDM[channelId]; ok {
 			if channel.GetPriority() == targetPriority {
-				sumWeight += channel.GetWeight()
 				targetChannels = append(targetChannels, channel)
 			}
 		} else {
@@ -159,6 +179,106 @@ func GetRandomSatisfiedChannel(group string, model string, retry int) (*Channel,
 		return nil, errors.New(fmt.Sprintf("no channel found, group: %s, model: %s, priority: %d", group, model, targetPriority))
 	}
 
+	var channel *Channel
+	if operation_setting.IsAdaptiveSelectEnabled(group) {
+		channel = pickAdaptiveChannel(targetChannels, model)
+	} else {
+		channel = pickWeightedChannel(targetChannels)
+	}
+	if channel == nil {
+		// return null if no channel is not found
+		return nil, errors.New("channel not found")
+	}
+	return channel, nil
+}
+
+// IsChannelSatisfied 判断渠道当前是否仍在该分组下提供该模型
+func IsChannelSatisfied(group string, model string, channelId int) bool {
+	if !common.MemoryCacheEnabled {
+		var count int64
+		err := DB.Model(&Ability{}).Where(commonGroupCol+" = ? and model = ? and channel_id = ? and enabled = ?", group, model, channelId, true).Count(&count).Error
+		return err == nil && count > 0
+	}
+
+	channelSyncLock.RLock()
+	defer channelSyncLock.RUnlock()
+
+	channels := group2model2channels[group][model]
+	if len(channels) == 0 {
+		channels = group2model2channels[group][ratio_setting.FormatMatchingModelName(model)]
+	}
+	return slices.Contains(channels, channelId)
+}
+
+// HasSelectableChannel 判断分组下该模型是否至少有一个未熔断、未处于限流冷却的启用渠道
+func HasSelectableChannel(group string, model string) bool {
+	if !common.MemoryCacheEnabled {
+		var channelIds []int
+		err := DB.Model(&Ability{}).Where(commonGroupCol+" = ? and model = ? and enabled = ?", group, model, true).Pluck("channel_id", &channelIds).Error
+		if err != nil || len(channelIds) == 0 {
+			return false
+		}
+		var channels []*Channel
+		if err = DB.Where("id in (?)", channelIds).Find(&channels).Error; err != nil {
+			return false
+		}
+		return slices.ContainsFunc(channels, func(channel *Channel) bool {
+			return IsChannelSelectable(channel, model)
+		})
+	}
+
+	channelSyncLock.RLock()
+	defer channelSyncLock.RUnlock()
+
+	channels := group2model2channels[group][model]
+	if len(channels) == 0 {
+		channels = group2model2channels[group][ratio_setting.FormatMatchingModelName(model)]
+	}
+	for _, channelId := range channels {
+		if channel, ok := channelsIDM[channelId]; ok && IsChannelSelectable(channel, model) {
+			return true
+		}
+	}
+	return false
+}
+
+// shouldFilterChannels 是否存在熔断、限流冷却或并发已满的渠道，需要在选择前过滤
+func shouldFilterChannels() bool {
+	return operation_setting.IsCircuitBreakerEnabled() || hasKeyCooldowns() || hasChannelInflight()
+}
+
+// filterUnavailableChannels 过滤掉在该模型上已熔断、限流冷却中或并发已满的渠道；
+// 熔断和限流冷却的渠道全部不可用时保留，交由重试逻辑处理，并发已满的渠道始终跳过
+func filterUnavailableChannels(channels []int, model string) []int {
+	allowed := make([]int, 0, len(channels))
+	unsaturated := make([]int, 0, len(channels))
+	for _, channelId := range channels {
+		channel, ok := channelsIDM[channelId]
+		if ok && IsChannelSaturated(channel) {
+			continue
+		}
+		unsaturated = append(unsaturated, channelId)
+		if ok && !IsChannelSelectable(channel, model) {
+			continue
+		}
+		allowed = append(allowed, channelId)
+	}
+	if len(allowed) == 0 {
+		return unsaturated
+	}
+	return allowed
+}
+
+// pickWeightedChannel 按渠道权重随机选择一个渠道
+func pickWeightedChannel(targetChannels []*Channel) *Channel {
+	if len(targetChannels) == 0 {
+		return nil
+	}
+	sumWeight := 0
+	for _, channel := range targetChannels {
+		sumWeight += channel.GetWeight()
+	}
+
 	// smoothing factor and adjustment
 	smoothingFactor := 1
 	smoothingAdjustment := 0
@@ -183,11 +303,10 @@ func GetRandomSatisfiedChannel(group string, model string, retry int) (*Channel,
 	for _, channel := range targetChannels {
 		randomWeight -= channel.GetWeight()*smoothingFactor + smoothingAdjustment
 		if randomWeight < 0 {
-			return channel, nil
+			return channel
 		}
 	}
-	// return null if no channel is not found
-	return nil, errors.New("channel not found")
+	return nil
 }
 
 func CacheGetChannel(id int) (*Channel, error) {
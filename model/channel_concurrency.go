@@ -0,0 +1,237 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ctrlc-ctrlv-limited/cvai/common"
+
+	"github.com/bytedance/gopkg/util/gopool"
+	"github.com/go-redis/redis/v8"
+)
+
+// Redis 中的计数与节点心跳使用相同的 hash tag，Redis Cluster 下位于同一个 slot，可以在同一个脚本中访问
+const (
+	// 各节点的心跳，field 为节点标识，value 为心跳过期的时间戳
+	channelInflightNodesKey = "{channel_inflight}:nodes"
+	// 各节点正在处理的请求数，field 为 "节点标识:渠道 id"
+	channelInflightCountsKey = "{channel_inflight}:counts"
+	// 节点心跳的有效期（秒），过期的节点不再计入并发数
+	channelInflightNodeTTL = 30
+	// 从 Redis 同步其他节点并发数的间隔
+	channelInflightSyncInterval = time.Second
+)
+
+// 当前节点的标识，每次启动重新生成，避免沿用上次运行遗留的计数
+var channelInflightNodeId = common.GetRandomString(16)
+
+// 渠道 id -> 当前节点正在处理的请求数，仅统计设置了最大并发数的渠道
+var channelInflight = make(map[int]int64)
+
+// 渠道 id -> 其他节点正在处理的请求数，定期从 Redis 同步
+var remoteChannelInflight = make(map[int]int64)
+var channelInflightLock sync.RWMutex
+
+func channelInflightField(nodeId string, channelId int) string {
+	return nodeId + ":" + strconv.Itoa(channelId)
+}
+
+// 在整个集群范围内检查并占用一个并发名额：汇总存活节点的计数，未达到最大并发数时增加当前节点的计数。
+// 返回 {是否占用成功, 占用前集群中正在处理的请求数}
+var channelInflightReserveScript = redis.NewScript(`
+local nodesKey = KEYS[1]
+local countsKey = KEYS[2]
+local nodeId = ARGV[1]
+local channelId = ARGV[2]
+local maxConcurrency = tonumber(ARGV[3])
+local now = tonumber(ARGV[4])
+redis.call('HSET', nodesKey, nodeId, now + tonumber(ARGV[5]))
+local total = 0
+local nodes = redis.call('HGETALL', nodesKey)
+for i = 1, #nodes, 2 do
+	if nodes[i] == nodeId or tonumber(nodes[i + 1]) >= now then
+		local count = tonumber(redis.call('HGET', countsKey, nodes[i] .. ':' .. channelId) or '0')
+		if count > 0 then
+			total = total + count
+		end
+	end
+end
+if total >= maxConcurrency then
+	return {0, total}
+end
+redis.call('HINCRBY', countsKey, nodeId .. ':' .. channelId, 1)
+return {1, total}
+`)
+
+// TryAcquireChannelConcurrency 渠道未达到最大并发数时原子地占用一个并发名额，返回 false 表示并发已满。
+// 多节点部署下通过 Redis 脚本在整个集群范围内检查并占用，Redis 出错时退回按本地汇总的计数检查
+func TryAcquireChannelConcurrency(channelId int, maxConcurrency int) bool {
+	if common.RedisEnabled {
+		acquired, inflight, err := reserveChannelInflight(channelId, maxConcurrency)
+		if err == nil {
+			channelInflightLock.Lock()
+			defer channelInflightLock.Unlock()
+			if !acquired {
+				// 以集群中的实际计数更新其他节点的计数，选择渠道时立即跳过该渠道
+				remoteChannelInflight[channelId] = max(inflight-channelInflight[channelId], 0)
+				return false
+			}
+			channelInflight[channelId]++
+			return true
+		}
+		common.SysError(fmt.Sprintf("failed to reserve channel concurrency: channel_id=%d, error=%v", channelId, err))
+	}
+	channelInflightLock.Lock()
+	if channelInflight[channelId]+remoteChannelInflight[channelId] >= int64(maxConcurrency) {
+		channelInflightLock.Unlock()
+		return false
+	}
+	channelInflight[channelId]++
+	channelInflightLock.Unlock()
+	publishChannelInflight(channelId, 1)
+	return true
+}
+
+func reserveChannelInflight(channelId int, maxConcurrency int) (bool, int64, error) {
+	result, err := channelInflightReserveScript.Run(context.Background(), common.RDB, []string{channelInflightNodesKey, channelInflightCountsKey},
+		channelInflightNodeId, channelId, maxConcurrency, time.Now().Unix(), channelInflightNodeTTL).Int64Slice()
+	if err != nil {
+		return false, 0, err
+	}
+	if len(result) != 2 {
+		return false, 0, fmt.Errorf("unexpected reserve result: %v", result)
+	}
+	return result[0] == 1, result[1], nil
+}
+
+// AcquireChannelConcurrency 记录渠道开始处理一个请求，不检查最大并发数，用于指定渠道的请求
+func AcquireChannelConcurrency(channelId int) {
+	channelInflightLock.Lock()
+	channelInflight[channelId]++
+	channelInflightLock.Unlock()
+	publishChannelInflight(channelId, 1)
+}
+
+// ReleaseChannelConcurrency 记录渠道处理完一个请求，流式与 WebSocket 请求在连接关闭后释放
+func ReleaseChannelConcurrency(channelId int) {
+	channelInflightLock.Lock()
+	if channelInflight[channelId] <= 1 {
+		delete(channelInflight, channelId)
+	} else {
+		channelInflight[channelId]--
+	}
+	channelInflightLock.Unlock()
+	publishChannelInflight(channelId, -1)
+}
+
+func publishChannelInflight(channelId int, delta int64) {
+	if !common.RedisEnabled {
+		return
+	}
+	gopool.Go(func() {
+		ctx := context.Background()
+		if err := common.RDB.HIncrBy(ctx, channelInflightCountsKey, channelInflightField(channelInflightNodeId, channelId), delta).Err(); err != nil {
+			common.SysError(fmt.Sprintf("failed to publish channel inflight: channel_id=%d, error=%v", channelId, err))
+		}
+	})
+}
+
+// GetChannelInflight 获取渠道在整个集群中正在处理的请求数
+func GetChannelInflight(channelId int) int64 {
+	channelInflightLock.RLock()
+	defer channelInflightLock.RUnlock()
+	return channelInflight[channelId] + remoteChannelInflight[channelId]
+}
+
+// hasChannelInflight 是否有设置了最大并发数的渠道正在处理请求，用于在没有时跳过逐个渠道的检查
+func hasChannelInflight() bool {
+	channelInflightLock.RLock()
+	defer channelInflightLock.RUnlock()
+	return len(channelInflight) > 0 || len(remoteChannelInflight) > 0
+}
+
+// IsChannelSaturated 判断渠道正在处理的请求数是否已达到最大并发数
+func IsChannelSaturated(channel *Channel) bool {
+	if channel == nil {
+		return false
+	}
+	inflight := GetChannelInflight(channel.Id)
+	if inflight == 0 {
+		return false
+	}
+	maxConcurrency := channel.GetSetting().MaxConcurrency
+	return maxConcurrency > 0 && inflight >= int64(maxConcurrency)
+}
+
+// SyncChannelInflight 多节点部署下定期上报当前节点心跳，并从 Redis 汇总其他存活节点正在处理的请求数
+func SyncChannelInflight() {
+	ctx := context.Background()
+	for {
+		if err := common.RDB.HSet(ctx, channelInflightNodesKey, channelInflightNodeId, time.Now().Unix()+channelInflightNodeTTL).Err(); err != nil {
+			common.SysError("failed to register channel inflight node: " + err.Error())
+		}
+		if err := loadRemoteChannelInflight(ctx); err != nil {
+			common.SysError("failed to sync channel inflight: " + err.Error())
+		}
+		time.Sleep(channelInflightSyncInterval)
+	}
+}
+
+func loadRemoteChannelInflight(ctx context.Context) error {
+	nodes, err := common.RDB.HGetAll(ctx, channelInflightNodesKey).Result()
+	if err != nil {
+		return err
+	}
+	counts, err := common.RDB.HGetAll(ctx, channelInflightCountsKey).Result()
+	if err != nil {
+		return err
+	}
+	now := time.Now().Unix()
+	var deadNodes []string
+	for nodeId, expiresAt := range nodes {
+		if nodeId == channelInflightNodeId {
+			continue
+		}
+		if expires, _ := strconv.ParseInt(expiresAt, 10, 64); expires < now {
+			deadNodes = append(deadNodes, nodeId)
+		}
+	}
+	remote := make(map[int]int64)
+	var staleFields []string
+	for field, value := range counts {
+		nodeId, channelField, ok := strings.Cut(field, ":")
+		if !ok || nodeId == channelInflightNodeId {
+			continue
+		}
+		if slices.Contains(deadNodes, nodeId) {
+			staleFields = append(staleFields, field)
+			continue
+		}
+		if _, registered := nodes[nodeId]; !registered {
+			continue
+		}
+		channelId, err := strconv.Atoi(channelField)
+		if err != nil {
+			continue
+		}
+		if count, _ := strconv.ParseInt(value, 10, 64); count > 0 {
+			remote[channelId] += count
+		}
+	}
+	if len(deadNodes) > 0 {
+		// 节点已退出或失联，清理其遗留的计数
+		common.RDB.HDel(ctx, channelInflightNodesKey, deadNodes...)
+		if len(staleFields) > 0 {
+			common.RDB.HDel(ctx, channelInflightCountsKey, staleFields...)
+		}
+	}
+	channelInflightLock.Lock()
+	remoteChannelInflight = remote
+	channelInflightLock.Unlock()
+	return nil
+}
@@ -0,0 +1,286 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ctrlc-ctrlv-limited/cvai/common"
+	"github.com/ctrlc-ctrlv-limited/cvai/constant"
+
+	"github.com/bytedance/gopkg/util/gopool"
+	"github.com/go-redis/redis/v8"
+)
+
+// KeyUsage 多 key 渠道中某个 key 当前一分钟内的用量
+type KeyUsage struct {
+	Requests   int64 `json:"requests"`
+	Tokens     int64 `json:"tokens"`
+	LastUsedAt int64 `json:"last_used_at"` // 毫秒
+}
+
+type keyUsageCounter struct {
+	minute int64
+	KeyUsage
+}
+
+// 渠道 id -> key 序号 -> 用量，启用 Redis 时以 Redis 为准，多个节点共享
+var keyUsages = make(map[int]map[int]*keyUsageCounter)
+var keyUsagesLock sync.Mutex
+
+func currentUsageMinute() int64 {
+	return time.Now().Unix() / 60
+}
+
+func keyUsageRedisKey(channelId int, minute int64) string {
+	return fmt.Sprintf("key_usage:%d:%d", channelId, minute)
+}
+
+func keyLastUsedRedisKey(channelId int) string {
+	return fmt.Sprintf("key_last_used:%d", channelId)
+}
+
+// ShouldTrackKeyUsage 渠道是否需要统计每个 key 的用量：按用量选择 key 或设置了 key 的 RPM/TPM 上限
+func (channel *Channel) ShouldTrackKeyUsage() bool {
+	info := channel.ChannelInfo
+	if !info.IsMultiKey {
+		return false
+	}
+	return info.MultiKeyMode == constant.MultiKeyModeLeastRecentlyUsed ||
+		info.MultiKeyMode == constant.MultiKeyModeLeastTokensThisMinute ||
+		len(info.MultiKeyRPMLimit) > 0 || len(info.MultiKeyTPMLimit) > 0
+}
+
+// keyLimits 返回 key 的 RPM/TPM 上限，0 表示不限制
+func (channel *Channel) keyLimits(index int) (int64, int64) {
+	return int64(max(channel.ChannelInfo.MultiKeyRPMLimit[index], 0)), int64(max(channel.ChannelInfo.MultiKeyTPMLimit[index], 0))
+}
+
+func isKeyUsageOverLimit(usage KeyUsage, rpmLimit int64, tpmLimit int64) bool {
+	return (rpmLimit > 0 && usage.Requests >= rpmLimit) || (tpmLimit > 0 && usage.Tokens >= tpmLimit)
+}
+
+func updateLocalKeyUsage(channelId int, keyIndex int, update func(usage *KeyUsage) bool) bool {
+	minute := currentUsageMinute()
+	keyUsagesLock.Lock()
+	defer keyUsagesLock.Unlock()
+	usages, ok := keyUsages[channelId]
+	if !ok {
+		usages = make(map[int]*keyUsageCounter)
+		keyUsages[channelId] = usages
+	}
+	counter, ok := usages[keyIndex]
+	if !ok {
+		counter = &keyUsageCounter{}
+		usages[keyIndex] = counter
+	}
+	if counter.minute != minute {
+		counter.minute = minute
+		counter.Requests = 0
+		counter.Tokens = 0
+	}
+	return update(&counter.KeyUsage)
+}
+
+// keyRequestReserveScript 在 key 未达到 RPM/TPM 上限时记录一次请求，检查与计数在一次调用中完成，多个节点同时选择同一个 key 时不会超过上限。
+// KEYS[1] 为当前分钟的用量，KEYS[2] 为最近使用时间；ARGV 依次为 key 序号、RPM 上限、TPM 上限、当前毫秒时间与用量的过期秒数
+var keyRequestReserveScript = redis.NewScript(`
+local field = ARGV[1]
+local rpm = tonumber(ARGV[2])
+local tpm = tonumber(ARGV[3])
+if rpm > 0 and tonumber(redis.call('HGET', KEYS[1], 'r:' .. field) or '0') >= rpm then
+	return 0
+end
+if tpm > 0 and tonumber(redis.call('HGET', KEYS[1], 't:' .. field) or '0') >= tpm then
+	return 0
+end
+redis.call('HINCRBY', KEYS[1], 'r:' .. field, 1)
+redis.call('EXPIRE', KEYS[1], tonumber(ARGV[5]))
+redis.call('HSET', KEYS[2], field, ARGV[4])
+return 1
+`)
+
+// tryUseKey 在 key 未达到 RPM/TPM 上限时记录 key 被选中发起一次请求，返回 false 表示已达到上限。
+// 启用 Redis 时由一个 Lua 脚本完成检查与计数，多个节点共享上限，Redis 不可用时按当前节点的用量判断
+func (channel *Channel) tryUseKey(index int) bool {
+	rpmLimit, tpmLimit := channel.keyLimits(index)
+	now := time.Now().UnixMilli()
+	if common.RedisEnabled {
+		reserved, err := keyRequestReserveScript.Run(context.Background(), common.RDB,
+			[]string{keyUsageRedisKey(channel.Id, currentUsageMinute()), keyLastUsedRedisKey(channel.Id)},
+			index, rpmLimit, tpmLimit, now, 120).Int()
+		if err == nil {
+			if reserved == 1 {
+				updateLocalKeyUsage(channel.Id, index, func(usage *KeyUsage) bool {
+					usage.Requests++
+					usage.LastUsedAt = now
+					return true
+				})
+				recordCachedKeyRequest(channel.Id, index, now)
+			}
+			return reserved == 1
+		}
+		common.SysError(fmt.Sprintf("failed to reserve key usage: channel_id=%d, key_index=%d, error=%v", channel.Id, index, err))
+	}
+	return updateLocalKeyUsage(channel.Id, index, func(usage *KeyUsage) bool {
+		if isKeyUsageOverLimit(*usage, rpmLimit, tpmLimit) {
+			return false
+		}
+		usage.Requests++
+		usage.LastUsedAt = now
+		return true
+	})
+}
+
+// RecordKeyTokens 记录 key 本次请求消耗的 token 数
+func RecordKeyTokens(channelId int, keyIndex int, tokens int) {
+	if tokens <= 0 {
+		return
+	}
+	updateLocalKeyUsage(channelId, keyIndex, func(usage *KeyUsage) bool {
+		usage.Tokens += int64(tokens)
+		return true
+	})
+	if !common.RedisEnabled {
+		return
+	}
+	gopool.Go(func() {
+		ctx := context.Background()
+		usageKey := keyUsageRedisKey(channelId, currentUsageMinute())
+		pipe := common.RDB.Pipeline()
+		pipe.HIncrBy(ctx, usageKey, "t:"+strconv.Itoa(keyIndex), int64(tokens))
+		pipe.Expire(ctx, usageKey, 2*time.Minute)
+		if _, err := pipe.Exec(ctx); err != nil {
+			common.SysError(fmt.Sprintf("failed to record key tokens: channel_id=%d, key_index=%d, error=%v", channelId, keyIndex, err))
+		}
+	})
+}
+
+// GetChannelKeyUsages 获取渠道各个 key 当前一分钟内的用量，key 序号 -> 用量
+func GetChannelKeyUsages(channelId int) map[int]KeyUsage {
+	result := make(map[int]KeyUsage)
+	if common.RedisEnabled {
+		usages, err := getRedisKeyUsages(channelId)
+		if err == nil {
+			return usages
+		}
+		common.SysError(fmt.Sprintf("failed to get key usages: channel_id=%d, error=%v", channelId, err))
+	}
+	minute := currentUsageMinute()
+	keyUsagesLock.Lock()
+	defer keyUsagesLock.Unlock()
+	for keyIndex, counter := range keyUsages[channelId] {
+		usage := KeyUsage{LastUsedAt: counter.LastUsedAt}
+		if counter.minute == minute {
+			usage.Requests = counter.Requests
+			usage.Tokens = counter.Tokens
+		}
+		result[keyIndex] = usage
+	}
+	return result
+}
+
+func getRedisKeyUsages(channelId int) (map[int]KeyUsage, error) {
+	ctx := context.Background()
+	pipe := common.RDB.Pipeline()
+	countsCmd := pipe.HGetAll(ctx, keyUsageRedisKey(channelId, currentUsageMinute()))
+	lastUsedCmd := pipe.HGetAll(ctx, keyLastUsedRedisKey(channelId))
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+	result := make(map[int]KeyUsage)
+	for field, value := range countsCmd.Val() {
+		kind, indexStr, found := strings.Cut(field, ":")
+		keyIndex, err := strconv.Atoi(indexStr)
+		if !found || err != nil {
+			continue
+		}
+		count, _ := strconv.ParseInt(value, 10, 64)
+		usage := result[keyIndex]
+		switch kind {
+		case "r":
+			usage.Requests = count
+		case "t":
+			usage.Tokens = count
+		}
+		result[keyIndex] = usage
+	}
+	for field, value := range lastUsedCmd.Val() {
+		keyIndex, err := strconv.Atoi(field)
+		if err != nil {
+			continue
+		}
+		usage := result[keyIndex]
+		usage.LastUsedAt, _ = strconv.ParseInt(value, 10, 64)
+		result[keyIndex] = usage
+	}
+	return result, nil
+}
+
+// 选择 key 时按用量排序所用的 Redis 用量缓存，避免每次选择都读取 Redis，
+// 上限由 tryUseKey 原子检查，排序使用稍旧的用量不影响上限
+const keyUsagesCacheTTL = time.Second
+
+type cachedKeyUsages struct {
+	expireAt time.Time
+	usages   map[int]KeyUsage
+}
+
+// 渠道 id -> 缓存的用量，由 keyUsagesLock 保护
+var keyUsagesCache = make(map[int]*cachedKeyUsages)
+
+// getSelectionKeyUsages 获取选择 key 时用于排序的用量，启用 Redis 时每个渠道最多每秒读取一次
+func getSelectionKeyUsages(channelId int) map[int]KeyUsage {
+	if !common.RedisEnabled {
+		return GetChannelKeyUsages(channelId)
+	}
+	keyUsagesLock.Lock()
+	cached, ok := keyUsagesCache[channelId]
+	keyUsagesLock.Unlock()
+	if ok && time.Now().Before(cached.expireAt) {
+		return cached.usages
+	}
+	usages := GetChannelKeyUsages(channelId)
+	keyUsagesLock.Lock()
+	keyUsagesCache[channelId] = &cachedKeyUsages{expireAt: time.Now().Add(keyUsagesCacheTTL), usages: usages}
+	keyUsagesLock.Unlock()
+	return usages
+}
+
+// recordCachedKeyRequest 将当前节点选中的 key 计入缓存的用量，使缓存有效期内的选择也能轮换 key
+func recordCachedKeyRequest(channelId int, keyIndex int, now int64) {
+	keyUsagesLock.Lock()
+	defer keyUsagesLock.Unlock()
+	cached, ok := keyUsagesCache[channelId]
+	if !ok {
+		return
+	}
+	usages := make(map[int]KeyUsage, len(cached.usages)+1)
+	for idx, usage := range cached.usages {
+		usages[idx] = usage
+	}
+	usage := usages[keyIndex]
+	usage.Requests++
+	usage.LastUsedAt = now
+	usages[keyIndex] = usage
+	cached.usages = usages
+}
+
+// sortKeysByUsage 按 key 的用量排序：least_recently_used 按最久未使用排序，
+// least_tokens_this_minute 按本分钟消耗 token 从少到多排序，相同时请求数更少、更久未使用的 key 在前
+func sortKeysByUsage(mode constant.MultiKeyMode, candidates []int, usages map[int]KeyUsage) {
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := usages[candidates[i]], usages[candidates[j]]
+		if mode == constant.MultiKeyModeLeastTokensThisMinute && a.Tokens != b.Tokens {
+			return a.Tokens < b.Tokens
+		}
+		if mode == constant.MultiKeyModeLeastTokensThisMinute && a.Requests != b.Requests {
+			return a.Requests < b.Requests
+		}
+		return a.LastUsedAt < b.LastUsedAt
+	})
+}
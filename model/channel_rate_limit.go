@@ -0,0 +1,91 @@
+package model
+
+import (
+	"sync"
+	"time"
+)
+
+// KeyRateLimit 上游返回的某个 key 的限流额度，-1 表示上游未返回该项
+type KeyRateLimit struct {
+	LimitRequests     int64 `json:"limit_requests"`
+	RemainingRequests int64 `json:"remaining_requests"`
+	ResetRequestsAt   int64 `json:"reset_requests_at,omitempty"`
+	LimitTokens       int64 `json:"limit_tokens"`
+	RemainingTokens   int64 `json:"remaining_tokens"`
+	ResetTokensAt     int64 `json:"reset_tokens_at,omitempty"`
+	// 在该时间之前不再选择这个 key
+	CooldownUntil int64 `json:"cooldown_until,omitempty"`
+	UpdatedAt     int64 `json:"updated_at"`
+}
+
+// 渠道 id -> key 序号 -> 限流额度
+var keyRateLimits = make(map[int]map[int]*KeyRateLimit)
+var keyRateLimitsLock sync.RWMutex
+
+// 所有 key 中最晚的冷却结束时间，用于在没有冷却时跳过逐个 key 的检查
+var keyCooldownUntilMax int64
+
+// UpdateKeyRateLimit 记录上游返回的限流额度，CooldownUntil 大于 0 时设置冷却时间
+func UpdateKeyRateLimit(channelId int, keyIndex int, limit KeyRateLimit) {
+	keyRateLimitsLock.Lock()
+	defer keyRateLimitsLock.Unlock()
+	limits, ok := keyRateLimits[channelId]
+	if !ok {
+		limits = make(map[int]*KeyRateLimit)
+		keyRateLimits[channelId] = limits
+	}
+	if prev, ok := limits[keyIndex]; ok && prev.CooldownUntil > limit.CooldownUntil {
+		limit.CooldownUntil = prev.CooldownUntil
+	}
+	limits[keyIndex] = &limit
+	keyCooldownUntilMax = max(keyCooldownUntilMax, limit.CooldownUntil)
+}
+
+// IsKeyCoolingDown 判断 key 是否因上游限流处于冷却中
+func IsKeyCoolingDown(channelId int, keyIndex int) bool {
+	now := time.Now().Unix()
+	keyRateLimitsLock.RLock()
+	defer keyRateLimitsLock.RUnlock()
+	if keyCooldownUntilMax <= now {
+		return false
+	}
+	limit, ok := keyRateLimits[channelId][keyIndex]
+	return ok && limit.CooldownUntil > now
+}
+
+func hasKeyCooldowns() bool {
+	keyRateLimitsLock.RLock()
+	defer keyRateLimitsLock.RUnlock()
+	return keyCooldownUntilMax > time.Now().Unix()
+}
+
+// GetChannelKeyRateLimits 获取渠道各个 key 的限流额度，key 序号 -> 额度
+func GetChannelKeyRateLimits(channelId int) map[int]KeyRateLimit {
+	keyRateLimitsLock.RLock()
+	defer keyRateLimitsLock.RUnlock()
+	result := make(map[int]KeyRateLimit, len(keyRateLimits[channelId]))
+	for keyIndex, limit := range keyRateLimits[channelId] {
+		result[keyIndex] = *limit
+	}
+	return result
+}
+
+// IsChannelSelectable 判断渠道未达到最大并发数，且在某个模型上至少有一个 key 既未熔断也未处于限流冷却
+func IsChannelSelectable(channel *Channel, modelName string) bool {
+	if IsChannelSaturated(channel) {
+		return false
+	}
+	if channel == nil || !hasKeyCooldowns() {
+		return IsChannelBreakerAllowed(channel, modelName)
+	}
+	keySize := 1
+	if channel.ChannelInfo.IsMultiKey {
+		keySize = max(channel.ChannelInfo.MultiKeySize, len(channel.GetKeys()))
+	}
+	for i := 0; i < keySize; i++ {
+		if !IsKeyCoolingDown(channel.Id, i) && IsKeyBreakerAllowed(channel.Id, modelName, i) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,142 @@
+package model
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ctrlc-ctrlv-limited/cvai/common"
+	"github.com/ctrlc-ctrlv-limited/cvai/setting/operation_setting"
+)
+
+// 解析后的 cron 表达式，表达式 -> 结果，解析失败时为 nil
+var scheduleCrons = make(map[string]*common.CronExpression)
+var scheduleCronsLock sync.Mutex
+
+// 加载后的时区，时区名 -> 结果，加载失败时为 nil
+var scheduleLocations = make(map[string]*time.Location)
+
+// 当前缓存中各渠道生效的规则，用于判断是否需要刷新渠道缓存
+var channelScheduleSignature string
+
+func getScheduleCron(expr string) *common.CronExpression {
+	scheduleCronsLock.Lock()
+	defer scheduleCronsLock.Unlock()
+	if cron, ok := scheduleCrons[expr]; ok {
+		return cron
+	}
+	cron, err := common.ParseCronExpression(expr)
+	if err != nil {
+		common.SysError(fmt.Sprintf("invalid channel schedule cron: %s", err.Error()))
+	}
+	scheduleCrons[expr] = cron
+	return cron
+}
+
+func getScheduleLocation(name string) *time.Location {
+	scheduleCronsLock.Lock()
+	defer scheduleCronsLock.Unlock()
+	if location, ok := scheduleLocations[name]; ok {
+		return location
+	}
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		common.SysError(fmt.Sprintf("invalid channel schedule timezone: %s", err.Error()))
+		location = nil
+	}
+	scheduleLocations[name] = location
+	return location
+}
+
+// matchScheduleRules 返回第一条生效的规则的序号，都不生效时返回 -1
+func matchScheduleRules(rules []operation_setting.ChannelScheduleRule, now time.Time) int {
+	for i, rule := range rules {
+		cron := getScheduleCron(rule.Cron)
+		if cron == nil {
+			continue
+		}
+		t := now
+		if rule.Timezone != "" {
+			location := getScheduleLocation(rule.Timezone)
+			if location == nil {
+				continue
+			}
+			t = now.In(location)
+		}
+		if cron.Match(t) {
+			return i
+		}
+	}
+	return -1
+}
+
+// getActiveScheduleRule 获取渠道当前生效的定时规则，渠道自身的规则优先于标签的规则；
+// 第二个返回值标识生效的规则，用于判断规则是否变化
+func getActiveScheduleRule(channel *Channel, now time.Time) (*operation_setting.ChannelScheduleRule, string) {
+	setting := operation_setting.GetChannelScheduleSetting()
+	if !setting.Enabled {
+		return nil, ""
+	}
+	if rules, ok := setting.Channels[strconv.Itoa(channel.Id)]; ok {
+		if i := matchScheduleRules(rules, now); i >= 0 {
+			return &rules[i], fmt.Sprintf("c%d", i)
+		}
+	}
+	if tag := channel.GetTag(); tag != "" {
+		if rules, ok := setting.Tags[tag]; ok {
+			if i := matchScheduleRules(rules, now); i >= 0 {
+				return &rules[i], fmt.Sprintf("t%d", i)
+			}
+		}
+	}
+	return nil, ""
+}
+
+// applyChannelSchedules 将当前生效的定时规则应用到缓存的渠道上，返回规则生效期间暂停使用的渠道与规则标识
+func applyChannelSchedules(channels []*Channel, now time.Time) (map[int]bool, string) {
+	paused := make(map[int]bool)
+	var ruleKeys []string
+	for _, channel := range channels {
+		rule, ruleKey := getActiveScheduleRule(channel, now)
+		if rule == nil {
+			continue
+		}
+		ruleKeys = append(ruleKeys, fmt.Sprintf("%d:%s", channel.Id, ruleKey))
+		channel.ScheduledPriority = rule.Priority
+		channel.ScheduledWeight = rule.Weight
+		if rule.Disabled {
+			paused[channel.Id] = true
+		}
+	}
+	return paused, scheduleSignature(ruleKeys)
+}
+
+func scheduleSignature(ruleKeys []string) string {
+	slices.Sort(ruleKeys)
+	return strings.Join(ruleKeys, ";")
+}
+
+// SyncChannelSchedules 每分钟检查渠道生效的定时规则，变化时刷新渠道缓存
+func SyncChannelSchedules() {
+	for {
+		now := time.Now()
+		time.Sleep(now.Truncate(time.Minute).Add(time.Minute).Sub(now) + time.Second)
+		now = time.Now()
+		var ruleKeys []string
+		channelSyncLock.RLock()
+		for _, channel := range channelsIDM {
+			if _, ruleKey := getActiveScheduleRule(channel, now); ruleKey != "" {
+				ruleKeys = append(ruleKeys, fmt.Sprintf("%d:%s", channel.Id, ruleKey))
+			}
+		}
+		changed := scheduleSignature(ruleKeys) != channelScheduleSignature
+		channelSyncLock.RUnlock()
+		if changed {
+			common.SysLog("channel schedules changed, syncing channels from database")
+			InitChannelCache()
+		}
+	}
+}
@@ -0,0 +1,280 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ctrlc-ctrlv-limited/cvai/common"
+	"github.com/ctrlc-ctrlv-limited/cvai/setting/operation_setting"
+
+	"github.com/bytedance/gopkg/util/gopool"
+	"github.com/go-redis/redis/v8"
+)
+
+// ChannelScore 渠道在某个模型上的滚动统计（EWMA）
+type ChannelScore struct {
+	TTFT      float64 `json:"ttft"`       // 首字耗时，毫秒
+	Latency   float64 `json:"latency"`    // 总耗时，毫秒
+	ErrorRate float64 `json:"error_rate"` // 错误率，0-1
+	Samples   int64   `json:"samples"`
+	UpdatedAt int64   `json:"updated_at"`
+}
+
+// 没有耗时数据时使用的默认耗时（毫秒），避免只失败的渠道因耗时为 0 而被优先选择
+const adaptiveDefaultLatencyMs = 1000
+
+const channelScoreKeyPrefix = "channel_score:"
+
+var channelScores = make(map[string]*ChannelScore)
+var channelScoresLock sync.RWMutex
+
+// ewma(key, field, value): value < 0 表示本次没有该项数据
+var channelScoreScript = redis.NewScript(`
+local key = KEYS[1]
+local alpha = tonumber(ARGV[1])
+local function ewma(field, value)
+	if value < 0 then
+		return
+	end
+	local prev = redis.call('HGET', key, field)
+	if not prev then
+		redis.call('HSET', key, field, value)
+	else
+		redis.call('HSET', key, field, alpha * value + (1 - alpha) * tonumber(prev))
+	end
+end
+ewma('error_rate', tonumber(ARGV[2]))
+ewma('ttft', tonumber(ARGV[3]))
+ewma('latency', tonumber(ARGV[4]))
+redis.call('HINCRBY', key, 'samples', 1)
+redis.call('HSET', key, 'updated_at', ARGV[5])
+redis.call('EXPIRE', key, tonumber(ARGV[6]))
+return 1
+`)
+
+func channelScoreKey(channelId int, modelName string) string {
+	return fmt.Sprintf("%d:%s", channelId, modelName)
+}
+
+func ewma(prev float64, value float64, alpha float64, first bool) float64 {
+	if first {
+		return value
+	}
+	return alpha*value + (1-alpha)*prev
+}
+
+// cost 计算渠道的有效耗时，越小越好；统计过期或没有数据时返回 0，以便重新探测
+func (s *ChannelScore) cost(now int64) float64 {
+	setting := operation_setting.GetChannelSelectSetting()
+	if s == nil || s.Samples == 0 {
+		return 0
+	}
+	if setting.ScoreTTLSeconds > 0 && now-s.UpdatedAt > int64(setting.ScoreTTLSeconds) {
+		return 0
+	}
+	latency := s.TTFT
+	if latency <= 0 {
+		latency = s.Latency
+	}
+	if latency <= 0 {
+		latency = adaptiveDefaultLatencyMs
+	}
+	return latency * (1 + setting.ErrorPenalty*s.ErrorRate)
+}
+
+// RecordChannelScore 记录一次请求结果，ttft/latency 小于 0 表示没有该项数据
+func RecordChannelScore(channelId int, modelName string, success bool, ttft time.Duration, latency time.Duration) {
+	setting := operation_setting.GetChannelSelectSetting()
+	alpha := setting.EwmaAlpha
+	if alpha <= 0 || alpha > 1 {
+		alpha = 0.2
+	}
+	errValue := 0.0
+	if !success {
+		errValue = 1
+	}
+	ttftMs := float64(ttft.Milliseconds())
+	if ttft < 0 {
+		ttftMs = -1
+	}
+	latencyMs := float64(latency.Milliseconds())
+	if latency < 0 {
+		latencyMs = -1
+	}
+	now := common.GetTimestamp()
+
+	key := channelScoreKey(channelId, modelName)
+	channelScoresLock.Lock()
+	score, ok := channelScores[key]
+	if !ok {
+		score = &ChannelScore{}
+		channelScores[key] = score
+	}
+	first := score.Samples == 0
+	score.ErrorRate = ewma(score.ErrorRate, errValue, alpha, first)
+	if ttftMs >= 0 {
+		score.TTFT = ewma(score.TTFT, ttftMs, alpha, score.TTFT == 0)
+	}
+	if latencyMs >= 0 {
+		score.Latency = ewma(score.Latency, latencyMs, alpha, score.Latency == 0)
+	}
+	score.Samples++
+	score.UpdatedAt = now
+	channelScoresLock.Unlock()
+
+	if common.RedisEnabled {
+		ttl := setting.ScoreTTLSeconds * 2
+		if ttl <= 0 {
+			ttl = 3600
+		}
+		gopool.Go(func() {
+			err := channelScoreScript.Run(context.Background(), common.RDB, []string{channelScoreKeyPrefix + key},
+				alpha, errValue, ttftMs, latencyMs, now, ttl).Err()
+			if err != nil {
+				common.SysError(fmt.Sprintf("failed to record channel score: channel_id=%d, model=%s, error=%v", channelId, modelName, err))
+			}
+		})
+	}
+}
+
+// GetChannelScore 获取渠道在某个模型上的统计
+func GetChannelScore(channelId int, modelName string) (ChannelScore, bool) {
+	channelScoresLock.RLock()
+	defer channelScoresLock.RUnlock()
+	score, ok := channelScores[channelScoreKey(channelId, modelName)]
+	if !ok {
+		return ChannelScore{}, false
+	}
+	return *score, true
+}
+
+// GetChannelScoresByChannel 获取所有渠道的统计，channel id -> model -> score
+func GetChannelScoresByChannel() map[int]map[string]ChannelScore {
+	channelScoresLock.RLock()
+	defer channelScoresLock.RUnlock()
+	result := make(map[int]map[string]ChannelScore)
+	for key, score := range channelScores {
+		idStr, modelName, found := strings.Cut(key, ":")
+		if !found {
+			continue
+		}
+		channelId, err := strconv.Atoi(idStr)
+		if err != nil {
+			continue
+		}
+		if _, ok := result[channelId]; !ok {
+			result[channelId] = make(map[string]ChannelScore)
+		}
+		result[channelId][modelName] = *score
+	}
+	return result
+}
+
+// pickAdaptiveChannel 使用 power-of-two-choices：按权重随机抽取两个渠道，选择有效耗时更低的一个
+func pickAdaptiveChannel(channels []*Channel, modelName string) *Channel {
+	if len(channels) == 0 {
+		return nil
+	}
+	first := pickWeightedChannel(channels)
+	if len(channels) == 1 || first == nil {
+		return first
+	}
+	rest := make([]*Channel, 0, len(channels)-1)
+	for _, channel := range channels {
+		if channel.Id != first.Id {
+			rest = append(rest, channel)
+		}
+	}
+	second := pickWeightedChannel(rest)
+	if second == nil {
+		return first
+	}
+
+	now := common.GetTimestamp()
+	channelScoresLock.RLock()
+	firstCost := channelScores[channelScoreKey(first.Id, modelName)].cost(now)
+	secondCost := channelScores[channelScoreKey(second.Id, modelName)].cost(now)
+	channelScoresLock.RUnlock()
+
+	if firstCost == secondCost {
+		if rand.Intn(2) == 0 {
+			return first
+		}
+		return second
+	}
+	if secondCost < firstCost {
+		return second
+	}
+	return first
+}
+
+// SyncChannelScores 多节点部署下定期从 Redis 拉取各节点共同维护的统计
+func SyncChannelScores() {
+	for {
+		interval := operation_setting.GetChannelSelectSetting().SyncIntervalSeconds
+		if interval <= 0 {
+			interval = 10
+		}
+		time.Sleep(time.Duration(interval) * time.Second)
+		if !common.RedisEnabled || !operation_setting.IsAdaptiveSelectUsed() {
+			continue
+		}
+		if err := loadChannelScoresFromRedis(); err != nil {
+			common.SysError("failed to sync channel scores: " + err.Error())
+		}
+	}
+}
+
+func loadChannelScoresFromRedis() error {
+	ctx := context.Background()
+	var keys []string
+	var cursor uint64
+	for {
+		batch, next, err := common.RDB.Scan(ctx, cursor, channelScoreKeyPrefix+"*", 500).Result()
+		if err != nil {
+			return err
+		}
+		keys = append(keys, batch...)
+		cursor = next
+		if cursor == 0 {
+			break
+		}
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+
+	pipe := common.RDB.Pipeline()
+	cmds := make([]*redis.StringStringMapCmd, len(keys))
+	for i, key := range keys {
+		cmds[i] = pipe.HGetAll(ctx, key)
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return err
+	}
+
+	newScores := make(map[string]*ChannelScore, len(keys))
+	for i, key := range keys {
+		fields, err := cmds[i].Result()
+		if err != nil || len(fields) == 0 {
+			continue
+		}
+		score := &ChannelScore{}
+		score.TTFT, _ = strconv.ParseFloat(fields["ttft"], 64)
+		score.Latency, _ = strconv.ParseFloat(fields["latency"], 64)
+		score.ErrorRate, _ = strconv.ParseFloat(fields["error_rate"], 64)
+		score.Samples, _ = strconv.ParseInt(fields["samples"], 10, 64)
+		score.UpdatedAt, _ = strconv.ParseInt(fields["updated_at"], 10, 64)
+		newScores[strings.TrimPrefix(key, channelScoreKeyPrefix)] = score
+	}
+
+	channelScoresLock.Lock()
+	channelScores = newScores
+	channelScoresLock.Unlock()
+	return nil
+}
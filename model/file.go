@@ -0,0 +1,184 @@
+package model
+
+import (
+	"errors"
+
+	"github.com/ctrlc-ctrlv-limited/cvai/common"
+
+	"gorm.io/gorm"
+)
+
+const (
+	FileStatusUploaded  = "uploaded"
+	FileStatusProcessed = "processed"
+)
+
+// File 用户通过 Files API 上传的文件，内容保存在本地磁盘或 S3 兼容存储中
+type File struct {
+	Id          int    `json:"-"`
+	FileId      string `json:"id" gorm:"type:varchar(64);uniqueIndex"`
+	UserId      int    `json:"user_id" gorm:"index"`
+	TokenId     int    `json:"token_id" gorm:"index"`
+	Filename    string `json:"filename" gorm:"type:varchar(255)"`
+	Purpose     string `json:"purpose" gorm:"type:varchar(64);index"`
+	Bytes       int64  `json:"bytes" gorm:"bigint"`
+	ContentType string `json:"content_type" gorm:"type:varchar(128)"`
+	Status      string `json:"status" gorm:"type:varchar(32)"`
+	// 存储类型（local / s3）与存储中的路径
+	StorageType string `json:"storage_type" gorm:"type:varchar(16)"`
+	StoragePath string `json:"-" gorm:"type:varchar(512)"`
+	// 上传时扣除的存储额度
+	Quota     int   `json:"quota" gorm:"default:0"`
+	CreatedAt int64 `json:"created_at" gorm:"bigint;index"`
+	// 过期时间，0 表示不过期
+	ExpiresAt int64 `json:"expires_at" gorm:"bigint;index"`
+}
+
+// FileUpstream 文件转发到上游渠道后，上游返回的文件 id，按渠道与 key 区分
+type FileUpstream struct {
+	Id             int    `json:"id"`
+	FileId         string `json:"file_id" gorm:"type:varchar(64);index:idx_file_upstream,unique"`
+	ChannelId      int    `json:"channel_id" gorm:"index:idx_file_upstream,unique"`
+	KeyIndex       int    `json:"key_index" gorm:"index:idx_file_upstream,unique"`
+	UpstreamFileId string `json:"upstream_file_id" gorm:"type:varchar(128)"`
+	CreatedAt      int64  `json:"created_at" gorm:"bigint"`
+}
+
+func (file *File) Insert() error {
+	file.CreatedAt = common.GetTimestamp()
+	return DB.Create(file).Error
+}
+
+func (file *File) Update() error {
+	return DB.Save(file).Error
+}
+
+// Delete 删除文件记录及其上游文件映射，存储中的内容由调用方删除
+func (file *File) Delete() error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("file_id = ?", file.FileId).Delete(&FileUpstream{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(file).Error
+	})
+}
+
+func GetFileByFileId(fileId string) (*File, error) {
+	if fileId == "" {
+		return nil, errors.New("file id 为空")
+	}
+	file := &File{}
+	err := DB.Where("file_id = ?", fileId).First(file).Error
+	return file, err
+}
+
+// GetUserFileByFileId 获取用户自己的文件，已过期的文件视为不存在
+func GetUserFileByFileId(userId int, fileId string) (*File, error) {
+	file, err := GetFileByFileId(fileId)
+	if err != nil {
+		return nil, err
+	}
+	if file.UserId != userId || file.IsExpired() {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return file, nil
+}
+
+func (file *File) IsExpired() bool {
+	return file.ExpiresAt > 0 && file.ExpiresAt <= common.GetTimestamp()
+}
+
+// GetUserFiles 按 OpenAI 的游标分页方式获取用户的文件，after 为上一页最后一个文件的 id
+func GetUserFiles(userId int, purpose string, after string, limit int, ascending bool) (files []*File, hasMore bool, err error) {
+	query := DB.Where("user_id = ? and (expires_at = 0 or expires_at > ?)", userId, common.GetTimestamp())
+	if purpose != "" {
+		query = query.Where("purpose = ?", purpose)
+	}
+	order := "id desc"
+	if ascending {
+		order = "id asc"
+	}
+	if after != "" {
+		afterFile, err := GetUserFileByFileId(userId, after)
+		if err != nil {
+			return nil, false, err
+		}
+		if ascending {
+			query = query.Where("id > ?", afterFile.Id)
+		} else {
+			query = query.Where("id < ?", afterFile.Id)
+		}
+	}
+	err = query.Order(order).Limit(limit + 1).Find(&files).Error
+	if err != nil {
+		return nil, false, err
+	}
+	if len(files) > limit {
+		return files[:limit], true, nil
+	}
+	return files, false, nil
+}
+
+// GetAllFiles 管理员分页查看所有文件
+func GetAllFiles(userId int, purpose string, startIdx int, num int) (files []*File, total int64, err error) {
+	query := DB.Model(&File{})
+	if userId != 0 {
+		query = query.Where("user_id = ?", userId)
+	}
+	if purpose != "" {
+		query = query.Where("purpose = ?", purpose)
+	}
+	if err = query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err = query.Order("id desc").Limit(num).Offset(startIdx).Find(&files).Error
+	return files, total, err
+}
+
+// GetUserFileBytes 获取用户未过期文件占用的总字节数
+func GetUserFileBytes(userId int) (int64, error) {
+	var total int64
+	err := DB.Model(&File{}).Where("user_id = ? and (expires_at = 0 or expires_at > ?)", userId, common.GetTimestamp()).
+		Select("COALESCE(SUM(bytes), 0)").Scan(&total).Error
+	return total, err
+}
+
+// GetExpiredFiles 获取已过期的文件
+func GetExpiredFiles(limit int) (files []*File, err error) {
+	err = DB.Where("expires_at > 0 and expires_at <= ?", common.GetTimestamp()).Order("id asc").Limit(limit).Find(&files).Error
+	return files, err
+}
+
+func GetFileUpstreamId(fileId string, channelId int, keyIndex int) (string, bool) {
+	upstream := &FileUpstream{}
+	err := DB.Where("file_id = ? and channel_id = ? and key_index = ?", fileId, channelId, keyIndex).First(upstream).Error
+	if err != nil {
+		return "", false
+	}
+	return upstream.UpstreamFileId, true
+}
+
+// GetFileIdByUpstreamId 获取上游渠道与 key 上的文件 id 对应的网关文件 id
+func GetFileIdByUpstreamId(channelId int, keyIndex int, upstreamFileId string) (string, bool) {
+	upstream := &FileUpstream{}
+	err := DB.Where("channel_id = ? and key_index = ? and upstream_file_id = ?", channelId, keyIndex, upstreamFileId).First(upstream).Error
+	if err != nil {
+		return "", false
+	}
+	return upstream.FileId, true
+}
+
+func GetFileUpstreams(fileId string) (upstreams []*FileUpstream, err error) {
+	err = DB.Where("file_id = ?", fileId).Find(&upstreams).Error
+	return upstreams, err
+}
+
+func InsertFileUpstream(fileId string, channelId int, keyIndex int, upstreamFileId string) error {
+	return DB.Create(&FileUpstream{
+		FileId:         fileId,
+		ChannelId:      channelId,
+		KeyIndex:       keyIndex,
+		UpstreamFileId: upstreamFileId,
+		CreatedAt:      common.GetTimestamp(),
+	}).Error
+}
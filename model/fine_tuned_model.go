@@ -0,0 +1,32 @@
+package model
+
+import (
+	"github.com/ctrlc-ctrlv-limited/cvai/common"
+)
+
+// FineTunedModel 通过微调任务产生的 ft: 模型，只能由创建它的用户通过创建它的渠道与 key 调用
+type FineTunedModel struct {
+	Id        int    `json:"id"`
+	ModelName string `json:"model_name" gorm:"type:varchar(255);uniqueIndex"`
+	UserId    int    `json:"user_id" gorm:"index"`
+	ChannelId int    `json:"channel_id" gorm:"index"`
+	KeyIndex  int    `json:"-"`
+	TaskId    string `json:"task_id" gorm:"type:varchar(191)"`
+	BaseModel string `json:"base_model" gorm:"type:varchar(255)"`
+	CreatedAt int64  `json:"created_at" gorm:"bigint"`
+}
+
+func (m *FineTunedModel) Insert() error {
+	m.CreatedAt = common.GetTimestamp()
+	return DB.Create(m).Error
+}
+
+// GetFineTunedModel 获取微调模型，不存在时返回 nil
+func GetFineTunedModel(modelName string) (*FineTunedModel, error) {
+	var models []*FineTunedModel
+	err := DB.Where("model_name = ?", modelName).Limit(1).Find(&models).Error
+	if err != nil || len(models) == 0 {
+		return nil, err
+	}
+	return models[0], nil
+}
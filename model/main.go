@@ -268,6 +268,16 @@ func migrateDB() error {
 		&TwoFA{},
 		&TwoFABackupCode{},
 		&Checkin{},
+		&File{},
+		&FileUpstream{},
+		&Batch{},
+		&BatchResult{},
+		&FineTunedModel{},
+		&ResponseBinding{},
+		&VectorStore{},
+		&QuotaLedgerEntry{},
+		&QuotaLedgerDrift{},
+		&QuotaBudgetUsage{},
 	)
 	if err != nil {
 		return err
@@ -302,6 +312,16 @@ func migrateDBFast() error {
 		{&TwoFA{}, "TwoFA"},
 		{&TwoFABackupCode{}, "TwoFABackupCode"},
 		{&Checkin{}, "Checkin"},
+		{&File{}, "File"},
+		{&FileUpstream{}, "FileUpstream"},
+		{&Batch{}, "Batch"},
+		{&BatchResult{}, "BatchResult"},
+		{&FineTunedModel{}, "FineTunedModel"},
+		{&ResponseBinding{}, "ResponseBinding"},
+		{&VectorStore{}, "VectorStore"},
+		{&QuotaLedgerEntry{}, "QuotaLedgerEntry"},
+		{&QuotaLedgerDrift{}, "QuotaLedgerDrift"},
+		{&QuotaBudgetUsage{}, "QuotaBudgetUsage"},
 	}
 	// 动态计算migration数量，确保errChan缓冲区足够大
 	errChan := make(chan error, len(migrations))
@@ -0,0 +1,143 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/ctrlc-ctrlv-limited/cvai/common"
+	"github.com/ctrlc-ctrlv-limited/cvai/dto"
+
+	"github.com/go-redis/redis/v8"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 周期预算的计数对象
+const (
+	QuotaBudgetScopeToken = "token"
+	QuotaBudgetScopeUser  = "user"
+)
+
+// QuotaBudgetUsage 未启用 Redis 时保存周期预算在每个周期内的已用额度
+type QuotaBudgetUsage struct {
+	Id          int    `json:"id"`
+	Scope       string `json:"scope" gorm:"type:varchar(16);uniqueIndex:idx_quota_budget_usage,priority:1"`
+	ScopeId     int    `json:"scope_id" gorm:"uniqueIndex:idx_quota_budget_usage,priority:2"`
+	WindowStart int64  `json:"window_start" gorm:"bigint;uniqueIndex:idx_quota_budget_usage,priority:3"`
+	Used        int    `json:"used"`
+	ExpiresAt   int64  `json:"expires_at" gorm:"bigint;index"`
+}
+
+func quotaBudgetRedisKey(scope string, scopeId int, windowStart int64) string {
+	return fmt.Sprintf("quota_budget:%s:%d:%d", scope, scopeId, windowStart)
+}
+
+// GetQuotaBudgetUsed 返回当前预算周期内的已用额度与下次重置时间
+func GetQuotaBudgetUsed(scope string, scopeId int, budget dto.QuotaBudget) (used int, resetAt time.Time, err error) {
+	start, end := budget.Window(time.Now())
+	if common.RedisEnabled {
+		value, err := common.RDB.Get(context.Background(), quotaBudgetRedisKey(scope, scopeId, start.Unix())).Result()
+		if err == redis.Nil {
+			return 0, end, nil
+		}
+		if err != nil {
+			return 0, end, err
+		}
+		used, err = strconv.Atoi(value)
+		return used, end, err
+	}
+	err = DB.Model(&QuotaBudgetUsage{}).
+		Where("scope = ? and scope_id = ? and window_start = ?", scope, scopeId, start.Unix()).
+		Select("coalesce(sum(used), 0)").Scan(&used).Error
+	return used, end, err
+}
+
+// 预算未用尽且加上 amount 后不超过预算时增加已用额度，返回 {是否占用成功, 占用后（失败时为当前）的已用额度}
+var quotaBudgetReserveScript = redis.NewScript(`
+local used = tonumber(redis.call('GET', KEYS[1]) or '0')
+local amount = tonumber(ARGV[1])
+local quota = tonumber(ARGV[2])
+if used >= quota or used + amount > quota then
+	return {0, used}
+end
+used = redis.call('INCRBY', KEYS[1], amount)
+redis.call('EXPIREAT', KEYS[1], ARGV[3])
+return {1, used}
+`)
+
+// ReserveQuotaBudget 预算足够时原子地将 amount 计入当前预算周期的已用额度，预算不足时不修改计数，返回 false 与当前的已用额度。
+// 检查与累加在同一条语句（或 Redis 脚本）中完成，并发请求不会超出预算
+func ReserveQuotaBudget(scope string, scopeId int, budget dto.QuotaBudget, amount int) (reserved bool, used int, resetAt time.Time, err error) {
+	if amount <= 0 {
+		used, resetAt, err = GetQuotaBudgetUsed(scope, scopeId, budget)
+		return err == nil && used < budget.Quota, used, resetAt, err
+	}
+	start, end := budget.Window(time.Now())
+	if common.RedisEnabled {
+		result, err := quotaBudgetReserveScript.Run(context.Background(), common.RDB, []string{quotaBudgetRedisKey(scope, scopeId, start.Unix())},
+			amount, budget.Quota, end.Add(time.Hour).Unix()).Int64Slice()
+		if err != nil {
+			return false, 0, end, err
+		}
+		if len(result) != 2 {
+			return false, 0, end, fmt.Errorf("unexpected reserve result: %v", result)
+		}
+		return result[0] == 1, int(result[1]), end, nil
+	}
+	usage := QuotaBudgetUsage{
+		Scope:       scope,
+		ScopeId:     scopeId,
+		WindowStart: start.Unix(),
+		ExpiresAt:   end.Unix(),
+	}
+	if err = DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&usage).Error; err != nil {
+		return false, 0, end, err
+	}
+	result := DB.Model(&QuotaBudgetUsage{}).
+		Where("scope = ? and scope_id = ? and window_start = ? and used < ? and used + ? <= ?", scope, scopeId, start.Unix(), budget.Quota, amount, budget.Quota).
+		Update("used", gorm.Expr("used + ?", amount))
+	if result.Error != nil {
+		return false, 0, end, result.Error
+	}
+	if result.RowsAffected > 0 {
+		return true, used, end, nil
+	}
+	used, _, err = GetQuotaBudgetUsed(scope, scopeId, budget)
+	return false, used, end, err
+}
+
+// IncreaseQuotaBudgetUsed 累加当前预算周期内的已用额度，退款时 amount 为负数
+func IncreaseQuotaBudgetUsed(scope string, scopeId int, budget dto.QuotaBudget, amount int) error {
+	if amount == 0 {
+		return nil
+	}
+	start, end := budget.Window(time.Now())
+	if common.RedisEnabled {
+		ctx := context.Background()
+		key := quotaBudgetRedisKey(scope, scopeId, start.Unix())
+		pipe := common.RDB.TxPipeline()
+		pipe.IncrBy(ctx, key, int64(amount))
+		pipe.ExpireAt(ctx, key, end.Add(time.Hour))
+		_, err := pipe.Exec(ctx)
+		return err
+	}
+	usage := QuotaBudgetUsage{
+		Scope:       scope,
+		ScopeId:     scopeId,
+		WindowStart: start.Unix(),
+		Used:        amount,
+		ExpiresAt:   end.Unix(),
+	}
+	return DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "scope"}, {Name: "scope_id"}, {Name: "window_start"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{"used": gorm.Expr("used + ?", amount)}),
+	}).Create(&usage).Error
+}
+
+// DeleteExpiredQuotaBudgetUsages 删除已过期周期的计数
+func DeleteExpiredQuotaBudgetUsages() (int64, error) {
+	result := DB.Where("expires_at < ?", common.GetTimestamp()).Delete(&QuotaBudgetUsage{})
+	return result.RowsAffected, result.Error
+}
@@ -0,0 +1,351 @@
+package model
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/ctrlc-ctrlv-limited/cvai/common"
+	"github.com/ctrlc-ctrlv-limited/cvai/setting/operation_setting"
+
+	"gorm.io/gorm"
+)
+
+// 额度账户类型，用户与令牌账户的余额对应 User.Quota 与 Token.RemainQuota，其余为系统账户
+const (
+	QuotaLedgerAccountUser        = "user"
+	QuotaLedgerAccountToken       = "token"
+	QuotaLedgerAccountUsage       = "usage"
+	QuotaLedgerAccountTopUp       = "topup"
+	QuotaLedgerAccountRedemption  = "redemption"
+	QuotaLedgerAccountReward      = "reward"
+	QuotaLedgerAccountAffiliate   = "affiliate"
+	QuotaLedgerAccountAdjustment  = "adjustment"
+	QuotaLedgerAccountOpening     = "opening"
+	QuotaLedgerAccountTokenBudget = "token_budget"
+)
+
+// 额度变动原因
+const (
+	QuotaLedgerReasonPreConsume   = "pre_consume"
+	QuotaLedgerReasonConsume      = "consume"
+	QuotaLedgerReasonRefund       = "refund"
+	QuotaLedgerReasonTopUp        = "topup"
+	QuotaLedgerReasonRedemption   = "redemption"
+	QuotaLedgerReasonCheckin      = "checkin"
+	QuotaLedgerReasonNewUser      = "new_user"
+	QuotaLedgerReasonInviteReward = "invite_reward"
+	QuotaLedgerReasonAffTransfer  = "aff_transfer"
+	QuotaLedgerReasonAdminAdjust  = "admin_adjust"
+	QuotaLedgerReasonTokenCreate  = "token_create"
+	QuotaLedgerReasonTokenUpdate  = "token_update"
+	QuotaLedgerReasonOpening      = "opening"
+)
+
+// 用户账户各变动原因对应的系统账户，令牌账户的对方账户固定为 token_budget
+var quotaLedgerUserCounterparties = map[string]string{
+	QuotaLedgerReasonPreConsume:   QuotaLedgerAccountUsage,
+	QuotaLedgerReasonConsume:      QuotaLedgerAccountUsage,
+	QuotaLedgerReasonRefund:       QuotaLedgerAccountUsage,
+	QuotaLedgerReasonTopUp:        QuotaLedgerAccountTopUp,
+	QuotaLedgerReasonRedemption:   QuotaLedgerAccountRedemption,
+	QuotaLedgerReasonCheckin:      QuotaLedgerAccountReward,
+	QuotaLedgerReasonNewUser:      QuotaLedgerAccountReward,
+	QuotaLedgerReasonInviteReward: QuotaLedgerAccountReward,
+	QuotaLedgerReasonAffTransfer:  QuotaLedgerAccountAffiliate,
+	QuotaLedgerReasonAdminAdjust:  QuotaLedgerAccountAdjustment,
+	QuotaLedgerReasonOpening:      QuotaLedgerAccountOpening,
+}
+
+// QuotaLedgerEntry 额度流水分录，只追加不修改。每笔额度变动是一笔交易，
+// 由同一 TransactionId 下金额之和为 0 的两条分录组成：用户或令牌账户一条，系统账户一条
+type QuotaLedgerEntry struct {
+	Id            int    `json:"id"`
+	TransactionId string `json:"transaction_id" gorm:"type:varchar(64);index"`
+	AccountType   string `json:"account_type" gorm:"type:varchar(32);index:idx_quota_ledger_account,priority:1"`
+	AccountId     int    `json:"account_id" gorm:"index:idx_quota_ledger_account,priority:2"`
+	// 交易中另一条分录的账户
+	Counterparty string `json:"counterparty" gorm:"type:varchar(64)"`
+	// 正数为账户余额增加，负数为减少
+	Amount int `json:"amount"`
+	// 交易所属用户
+	UserId    int    `json:"user_id" gorm:"index"`
+	Reason    string `json:"reason" gorm:"type:varchar(32);index"`
+	RequestId string `json:"request_id" gorm:"type:varchar(64);index"`
+	// 发起变动的用户，系统任务为 0
+	ActorId   int    `json:"actor_id"`
+	Remark    string `json:"remark" gorm:"type:varchar(255)"`
+	CreatedAt int64  `json:"created_at" gorm:"bigint;index"`
+}
+
+// QuotaLedgerMeta 额度变动的来源信息，写入流水分录
+type QuotaLedgerMeta struct {
+	Reason    string
+	RequestId string
+	ActorId   int
+	Remark    string
+}
+
+func quotaLedgerAccountName(accountType string, accountId int) string {
+	if accountId == 0 {
+		return accountType
+	}
+	return fmt.Sprintf("%s:%d", accountType, accountId)
+}
+
+func quotaLedgerCounterparty(accountType string, reason string) string {
+	if reason == QuotaLedgerReasonOpening {
+		return QuotaLedgerAccountOpening
+	}
+	if accountType == QuotaLedgerAccountToken {
+		return QuotaLedgerAccountTokenBudget
+	}
+	if counterparty, ok := quotaLedgerUserCounterparties[reason]; ok {
+		return counterparty
+	}
+	return QuotaLedgerAccountAdjustment
+}
+
+func quotaLedgerEnabled() bool {
+	return operation_setting.GetQuotaLedgerSetting().Enabled
+}
+
+// newQuotaLedgerEntries 生成一笔平衡的交易：账户变动 amount，对应的系统账户变动 -amount
+func newQuotaLedgerEntries(userId int, accountType string, accountId int, amount int, meta QuotaLedgerMeta) []QuotaLedgerEntry {
+	transactionId := common.GetUUID()
+	counterparty := quotaLedgerCounterparty(accountType, meta.Reason)
+	now := common.GetTimestamp()
+	return []QuotaLedgerEntry{
+		{
+			TransactionId: transactionId,
+			AccountType:   accountType,
+			AccountId:     accountId,
+			Counterparty:  counterparty,
+			Amount:        amount,
+			UserId:        userId,
+			Reason:        meta.Reason,
+			RequestId:     meta.RequestId,
+			ActorId:       meta.ActorId,
+			Remark:        meta.Remark,
+			CreatedAt:     now,
+		},
+		{
+			TransactionId: transactionId,
+			AccountType:   counterparty,
+			Counterparty:  quotaLedgerAccountName(accountType, accountId),
+			Amount:        -amount,
+			UserId:        userId,
+			Reason:        meta.Reason,
+			RequestId:     meta.RequestId,
+			ActorId:       meta.ActorId,
+			Remark:        meta.Remark,
+			CreatedAt:     now,
+		},
+	}
+}
+
+// insertQuotaLedger 在额度变动所在的事务中写入流水，未启用流水时不写入
+func insertQuotaLedger(tx *gorm.DB, userId int, accountType string, accountId int, amount int, meta QuotaLedgerMeta) error {
+	if amount == 0 || !quotaLedgerEnabled() {
+		return nil
+	}
+	entries := newQuotaLedgerEntries(userId, accountType, accountId, amount, meta)
+	return tx.Create(&entries).Error
+}
+
+// updateQuotaWithLedger 在同一事务中执行额度变动并写入流水，未启用流水时直接执行额度变动
+func updateQuotaWithLedger(userId int, accountType string, accountId int, amount int, meta QuotaLedgerMeta, update func(tx *gorm.DB) error) error {
+	if amount == 0 || !quotaLedgerEnabled() {
+		return update(DB)
+	}
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := update(tx); err != nil {
+			return err
+		}
+		return insertQuotaLedger(tx, userId, accountType, accountId, amount, meta)
+	})
+}
+
+var (
+	quotaLedgerPendingEntries []QuotaLedgerEntry
+	quotaLedgerPendingLock    sync.Mutex
+)
+
+// addQuotaLedgerRecord 启用批量更新时暂存流水，与额度变动一起由批量更新写入
+func addQuotaLedgerRecord(userId int, accountType string, accountId int, amount int, meta QuotaLedgerMeta) {
+	if amount == 0 || !quotaLedgerEnabled() {
+		return
+	}
+	entries := newQuotaLedgerEntries(userId, accountType, accountId, amount, meta)
+	quotaLedgerPendingLock.Lock()
+	quotaLedgerPendingEntries = append(quotaLedgerPendingEntries, entries...)
+	quotaLedgerPendingLock.Unlock()
+}
+
+// flushQuotaLedgerRecords 写入暂存的流水，写入失败只记录日志，由对账任务发现差异
+func flushQuotaLedgerRecords() {
+	quotaLedgerPendingLock.Lock()
+	entries := quotaLedgerPendingEntries
+	quotaLedgerPendingEntries = nil
+	quotaLedgerPendingLock.Unlock()
+	if len(entries) == 0 {
+		return
+	}
+	if err := DB.CreateInBatches(entries, 100).Error; err != nil {
+		common.SysLog(fmt.Sprintf("failed to batch insert %d quota ledger entries: %s", len(entries), err.Error()))
+	}
+}
+
+// GetQuotaLedgerBalances 按账户汇总流水，返回各账户的流水余额
+func GetQuotaLedgerBalances(accountType string, accountIds []int) (map[int]int, error) {
+	var rows []struct {
+		AccountId int
+		Balance   int64
+	}
+	err := DB.Model(&QuotaLedgerEntry{}).Select("account_id, sum(amount) as balance").
+		Where("account_type = ? and account_id in ?", accountType, accountIds).
+		Group("account_id").Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	balances := make(map[int]int, len(rows))
+	for _, row := range rows {
+		balances[row.AccountId] = int(row.Balance)
+	}
+	return balances, nil
+}
+
+// GetQuotaLedgerBalanceBefore 返回账户在指定分录之前（不含）或指定时间之前的流水余额
+func GetQuotaLedgerBalanceBefore(accountType string, accountId int, beforeId int, beforeTimestamp int64) (int, error) {
+	var balance int64
+	query := DB.Model(&QuotaLedgerEntry{}).Where("account_type = ? and account_id = ?", accountType, accountId)
+	if beforeId > 0 {
+		query = query.Where("id < ?", beforeId)
+	}
+	if beforeTimestamp > 0 {
+		query = query.Where("created_at < ?", beforeTimestamp)
+	}
+	err := query.Select("coalesce(sum(amount), 0)").Scan(&balance).Error
+	return int(balance), err
+}
+
+// GetQuotaLedgerEntries 按时间顺序分页获取账户的流水分录
+func GetQuotaLedgerEntries(accountType string, accountId int, startTimestamp int64, endTimestamp int64, startIdx int, num int) (entries []*QuotaLedgerEntry, total int64, err error) {
+	query := DB.Model(&QuotaLedgerEntry{}).Where("account_type = ? and account_id = ?", accountType, accountId)
+	if startTimestamp != 0 {
+		query = query.Where("created_at >= ?", startTimestamp)
+	}
+	if endTimestamp != 0 {
+		query = query.Where("created_at <= ?", endTimestamp)
+	}
+	if err = query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err = query.Order("id asc").Limit(num).Offset(startIdx).Find(&entries).Error
+	return entries, total, err
+}
+
+// SumQuotaLedgerEntries 汇总账户在时间范围内的收入与支出
+func SumQuotaLedgerEntries(accountType string, accountId int, startTimestamp int64, endTimestamp int64) (credit int, debit int, err error) {
+	var row struct {
+		Credit int64
+		Debit  int64
+	}
+	query := DB.Model(&QuotaLedgerEntry{}).Where("account_type = ? and account_id = ?", accountType, accountId)
+	if startTimestamp != 0 {
+		query = query.Where("created_at >= ?", startTimestamp)
+	}
+	if endTimestamp != 0 {
+		query = query.Where("created_at <= ?", endTimestamp)
+	}
+	err = query.Select("coalesce(sum(case when amount > 0 then amount else 0 end), 0) as credit, coalesce(sum(case when amount < 0 then -amount else 0 end), 0) as debit").
+		Scan(&row).Error
+	return int(row.Credit), int(row.Debit), err
+}
+
+// GetQuotaLedgerOpenedAccountIds 返回给定账户中已写入期初余额的账户
+func GetQuotaLedgerOpenedAccountIds(accountType string, accountIds []int) (map[int]bool, error) {
+	var ids []int
+	err := DB.Model(&QuotaLedgerEntry{}).Where("account_type = ? and account_id in ? and reason = ?", accountType, accountIds, QuotaLedgerReasonOpening).
+		Distinct("account_id").Pluck("account_id", &ids).Error
+	if err != nil {
+		return nil, err
+	}
+	exists := make(map[int]bool, len(ids))
+	for _, id := range ids {
+		exists[id] = true
+	}
+	return exists, nil
+}
+
+// InsertQuotaLedgerOpening 为启用流水之前已存在的账户写入期初余额，balance 为账户余额与已有流水余额之差
+func InsertQuotaLedgerOpening(userId int, accountType string, accountId int, balance int) error {
+	return insertQuotaLedger(DB, userId, accountType, accountId, balance, QuotaLedgerMeta{Reason: QuotaLedgerReasonOpening})
+}
+
+// FlushBatchUpdate 立即写入批量更新中尚未写入数据库的额度变动，对账前调用
+func FlushBatchUpdate() {
+	if common.BatchUpdateEnabled {
+		batchUpdate()
+	}
+}
+
+// QuotaLedgerDrift 对账发现的余额与流水余额不一致的账户，每次对账后替换为最新结果
+type QuotaLedgerDrift struct {
+	Id            int    `json:"id"`
+	AccountType   string `json:"account_type" gorm:"type:varchar(32);index"`
+	AccountId     int    `json:"account_id"`
+	UserId        int    `json:"user_id" gorm:"index"`
+	Balance       int    `json:"balance"`
+	LedgerBalance int    `json:"ledger_balance"`
+	// 余额减去流水余额
+	Drift     int   `json:"drift"`
+	CheckedAt int64 `json:"checked_at" gorm:"bigint"`
+}
+
+// QuotaLedgerAccountBalance 用户或令牌账户的当前余额
+type QuotaLedgerAccountBalance struct {
+	AccountId int
+	UserId    int
+	Balance   int
+}
+
+func quotaLedgerAccountQuery(accountType string) *gorm.DB {
+	if accountType == QuotaLedgerAccountToken {
+		return DB.Unscoped().Model(&Token{}).Select("id as account_id, user_id, remain_quota as balance")
+	}
+	return DB.Unscoped().Model(&User{}).Select("id as account_id, id as user_id, quota as balance")
+}
+
+// GetQuotaLedgerAccountBalancesAfter 按 id 顺序分批获取用户或令牌账户的余额，包括已删除的账户
+func GetQuotaLedgerAccountBalancesAfter(accountType string, afterId int, limit int) (balances []QuotaLedgerAccountBalance, err error) {
+	err = quotaLedgerAccountQuery(accountType).Where("id > ?", afterId).Order("id asc").Limit(limit).Scan(&balances).Error
+	return balances, err
+}
+
+// GetQuotaLedgerAccountBalancesByIds 获取指定用户或令牌账户的余额
+func GetQuotaLedgerAccountBalancesByIds(accountType string, accountIds []int) (balances []QuotaLedgerAccountBalance, err error) {
+	err = quotaLedgerAccountQuery(accountType).Where("id in ?", accountIds).Scan(&balances).Error
+	return balances, err
+}
+
+// ReplaceQuotaLedgerDrifts 用本次对账结果替换已记录的差异
+func ReplaceQuotaLedgerDrifts(drifts []*QuotaLedgerDrift) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("1 = 1").Delete(&QuotaLedgerDrift{}).Error; err != nil {
+			return err
+		}
+		if len(drifts) == 0 {
+			return nil
+		}
+		return tx.CreateInBatches(drifts, 100).Error
+	})
+}
+
+func GetQuotaLedgerDrifts(startIdx int, num int) (drifts []*QuotaLedgerDrift, total int64, err error) {
+	query := DB.Model(&QuotaLedgerDrift{})
+	if err = query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err = query.Order("id asc").Limit(num).Offset(startIdx).Find(&drifts).Error
+	return drifts, total, err
+}
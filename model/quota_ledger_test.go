@@ -0,0 +1,150 @@
+package model
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/ctrlc-ctrlv-limited/cvai/common"
+	"github.com/ctrlc-ctrlv-limited/cvai/setting/operation_setting"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+func setupQuotaLedgerTestDB(t *testing.T, enabled bool) {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(fmt.Sprintf("file:%s?mode=memory&cache=shared", t.Name())), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	if err = db.AutoMigrate(&User{}, &Token{}, &QuotaLedgerEntry{}, &QuotaLedgerDrift{}); err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+	setting := operation_setting.GetQuotaLedgerSetting()
+	oldDB, oldEnabled, oldBatch, oldRedis := DB, setting.Enabled, common.BatchUpdateEnabled, common.RedisEnabled
+	DB = db
+	setting.Enabled = enabled
+	common.RedisEnabled = false
+	t.Cleanup(func() {
+		DB = oldDB
+		setting.Enabled = oldEnabled
+		common.BatchUpdateEnabled = oldBatch
+		common.RedisEnabled = oldRedis
+	})
+}
+
+func createQuotaLedgerTestAccounts(t *testing.T) (*User, *Token) {
+	t.Helper()
+	user := &User{Username: "ledger", Quota: 1000}
+	if err := DB.Create(user).Error; err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	token := &Token{UserId: user.Id, Key: common.GetRandomString(48), RemainQuota: 500}
+	if err := DB.Create(token).Error; err != nil {
+		t.Fatalf("failed to create token: %v", err)
+	}
+	return user, token
+}
+
+func TestQuotaLedgerDoubleEntry(t *testing.T) {
+	for _, batch := range []bool{false, true} {
+		t.Run(fmt.Sprintf("batch=%v", batch), func(t *testing.T) {
+			setupQuotaLedgerTestDB(t, true)
+			common.BatchUpdateEnabled = batch
+			user, token := createQuotaLedgerTestAccounts(t)
+			meta := QuotaLedgerMeta{Reason: QuotaLedgerReasonConsume, RequestId: "req"}
+
+			if err := DecreaseUserQuota(user.Id, 300, meta); err != nil {
+				t.Fatalf("DecreaseUserQuota returned error: %v", err)
+			}
+			if err := IncreaseUserQuota(user.Id, 100, false, QuotaLedgerMeta{Reason: QuotaLedgerReasonRefund}); err != nil {
+				t.Fatalf("IncreaseUserQuota returned error: %v", err)
+			}
+			if err := DecreaseTokenQuota(token.Id, user.Id, token.Key, 200, meta); err != nil {
+				t.Fatalf("DecreaseTokenQuota returned error: %v", err)
+			}
+			if err := IncreaseTokenQuota(token.Id, user.Id, token.Key, 50, QuotaLedgerMeta{Reason: QuotaLedgerReasonRefund}); err != nil {
+				t.Fatalf("IncreaseTokenQuota returned error: %v", err)
+			}
+			if batch {
+				batchUpdate()
+			}
+
+			var transactions []struct {
+				TransactionId string
+				Entries       int
+				Total         int
+			}
+			err := DB.Model(&QuotaLedgerEntry{}).Select("transaction_id, count(*) as entries, sum(amount) as total").
+				Group("transaction_id").Scan(&transactions).Error
+			if err != nil {
+				t.Fatalf("failed to sum transactions: %v", err)
+			}
+			if len(transactions) != 4 {
+				t.Fatalf("expected 4 transactions, got %d", len(transactions))
+			}
+			for _, transaction := range transactions {
+				if transaction.Entries != 2 || transaction.Total != 0 {
+					t.Fatalf("transaction %s is unbalanced: %d entries, total %d", transaction.TransactionId, transaction.Entries, transaction.Total)
+				}
+			}
+
+			userBalances, err := GetQuotaLedgerBalances(QuotaLedgerAccountUser, []int{user.Id})
+			if err != nil {
+				t.Fatalf("GetQuotaLedgerBalances returned error: %v", err)
+			}
+			tokenBalances, err := GetQuotaLedgerBalances(QuotaLedgerAccountToken, []int{token.Id})
+			if err != nil {
+				t.Fatalf("GetQuotaLedgerBalances returned error: %v", err)
+			}
+			if userBalances[user.Id] != -200 || tokenBalances[token.Id] != -150 {
+				t.Fatalf("unexpected ledger balances: user %d, token %d", userBalances[user.Id], tokenBalances[token.Id])
+			}
+
+			var entry QuotaLedgerEntry
+			if err = DB.Where("account_type = ? and account_id = ?", QuotaLedgerAccountToken, token.Id).First(&entry).Error; err != nil {
+				t.Fatalf("failed to get token entry: %v", err)
+			}
+			if entry.UserId != user.Id || entry.Counterparty != QuotaLedgerAccountTokenBudget {
+				t.Fatalf("unexpected token entry: user %d, counterparty %s", entry.UserId, entry.Counterparty)
+			}
+
+			var quota int
+			DB.Model(&User{}).Where("id = ?", user.Id).Select("quota").Scan(&quota)
+			if quota != 800 {
+				t.Fatalf("expected user quota 800, got %d", quota)
+			}
+		})
+	}
+}
+
+func TestQuotaLedgerDisabled(t *testing.T) {
+	for _, batch := range []bool{false, true} {
+		t.Run(fmt.Sprintf("batch=%v", batch), func(t *testing.T) {
+			setupQuotaLedgerTestDB(t, false)
+			common.BatchUpdateEnabled = batch
+			user, token := createQuotaLedgerTestAccounts(t)
+
+			if err := DecreaseUserQuota(user.Id, 300, QuotaLedgerMeta{Reason: QuotaLedgerReasonConsume}); err != nil {
+				t.Fatalf("DecreaseUserQuota returned error: %v", err)
+			}
+			if err := DecreaseTokenQuota(token.Id, user.Id, token.Key, 200, QuotaLedgerMeta{Reason: QuotaLedgerReasonConsume}); err != nil {
+				t.Fatalf("DecreaseTokenQuota returned error: %v", err)
+			}
+			if batch {
+				batchUpdate()
+			}
+
+			var count int64
+			DB.Model(&QuotaLedgerEntry{}).Count(&count)
+			if count != 0 {
+				t.Fatalf("expected no ledger entries, got %d", count)
+			}
+			var quota int
+			DB.Model(&User{}).Where("id = ?", user.Id).Select("quota").Scan(&quota)
+			if quota != 700 {
+				t.Fatalf("expected user quota 700, got %d", quota)
+			}
+		})
+	}
+}
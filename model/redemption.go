@@ -141,6 +141,10 @@ func Redeem(key string, userId int) (quota int, err error) {
 		if err != nil {
 			return err
 		}
+		err = insertQuotaLedger(tx, userId, QuotaLedgerAccountUser, userId, redemption.Quota, QuotaLedgerMeta{Reason: QuotaLedgerReasonRedemption, ActorId: userId, Remark: fmt.Sprintf("redemption %d", redemption.Id)})
+		if err != nil {
+			return err
+		}
 		redemption.RedeemedTime = common.GetTimestamp()
 		redemption.Status = common.RedemptionCodeStatusUsed
 		redemption.UsedUserId = userId
@@ -0,0 +1,43 @@
+package model
+
+import (
+	"github.com/ctrlc-ctrlv-limited/cvai/common"
+)
+
+// ResponseBinding 通过 Responses API 创建的 response 与创建它的渠道、key 的绑定，查询、取消与 previous_response_id 续写都需要发送到同一个渠道与 key
+type ResponseBinding struct {
+	Id         int    `json:"id"`
+	ResponseId string `json:"response_id" gorm:"type:varchar(191);uniqueIndex"`
+	UserId     int    `json:"user_id" gorm:"index"`
+	ChannelId  int    `json:"channel_id" gorm:"index"`
+	KeyIndex   int    `json:"-"`
+	ModelName  string `json:"model_name" gorm:"type:varchar(255)"`
+	// 是否为 background 模式创建的 response，对应一个异步任务
+	Background bool  `json:"background"`
+	CreatedAt  int64 `json:"created_at" gorm:"bigint;index"`
+}
+
+func (b *ResponseBinding) Insert() error {
+	b.CreatedAt = common.GetTimestamp()
+	return DB.Create(b).Error
+}
+
+// GetUserResponseBinding 获取用户的 response 绑定，不存在时返回 nil
+func GetUserResponseBinding(userId int, responseId string) (*ResponseBinding, error) {
+	var bindings []*ResponseBinding
+	err := DB.Where("response_id = ? and user_id = ?", responseId, userId).Limit(1).Find(&bindings).Error
+	if err != nil || len(bindings) == 0 {
+		return nil, err
+	}
+	return bindings[0], nil
+}
+
+func DeleteResponseBinding(responseId string) error {
+	return DB.Where("response_id = ?", responseId).Delete(&ResponseBinding{}).Error
+}
+
+// DeleteExpiredResponseBindings 删除创建时间早于 before 的绑定，返回删除的数量
+func DeleteExpiredResponseBindings(before int64) (int64, error) {
+	result := DB.Where("created_at < ?", before).Delete(&ResponseBinding{})
+	return result.RowsAffected, result.Error
+}
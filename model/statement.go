@@ -0,0 +1,97 @@
+package model
+
+import (
+	"github.com/ctrlc-ctrlv-limited/cvai/common"
+	"github.com/ctrlc-ctrlv-limited/cvai/dto"
+
+	"gorm.io/gorm"
+)
+
+// statementUserScope 限制账单查询的用户，userId 为 0 时包含所有用户
+func statementUserScope(column string, userId int) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if userId == 0 {
+			return db
+		}
+		return db.Where(column+" = ?", userId)
+	}
+}
+
+// EachStatementUsage 按用户、模型与令牌汇总 [startTime, endTime) 内的消费日志，按用户顺序逐行交给 fn，
+// userId 为 0 时包含所有用户，fn 返回错误时停止读取
+func EachStatementUsage(userId int, startTime int64, endTime int64, fn func(usage dto.StatementUsage) error) error {
+	rows, err := LOG_DB.Model(&Log{}).
+		Select("user_id, max(username) as username, model_name, token_id, token_name, count(*) as count, coalesce(sum(prompt_tokens), 0) as prompt_tokens, coalesce(sum(completion_tokens), 0) as completion_tokens, coalesce(sum(quota), 0) as quota").
+		Where("type = ? and created_at >= ? and created_at < ?", LogTypeConsume, startTime, endTime).
+		Scopes(statementUserScope("user_id", userId)).
+		Group("user_id, model_name, token_id, token_name").
+		Order("user_id asc, model_name asc, token_id asc").
+		Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var usage dto.StatementUsage
+		if err = LOG_DB.ScanRows(rows, &usage); err != nil {
+			return err
+		}
+		usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+		if err = fn(usage); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// GetStatementQuotaDataUsages 按用户与模型汇总 [startTime, endTime) 内的数据看板数据，用于没有消费日志时生成账单，
+// 数据看板只记录总 token 数，不区分令牌
+func GetStatementQuotaDataUsages(userId int, startTime int64, endTime int64) (usages []dto.StatementUsage, err error) {
+	err = DB.Model(&QuotaData{}).
+		Select("user_id, max(username) as username, model_name, coalesce(sum(count), 0) as count, coalesce(sum(token_used), 0) as total_tokens, coalesce(sum(quota), 0) as quota").
+		Where("created_at >= ? and created_at < ?", startTime, endTime).
+		Scopes(statementUserScope("user_id", userId)).
+		Group("user_id, model_name").
+		Order("user_id asc, model_name asc").
+		Scan(&usages).Error
+	return usages, err
+}
+
+// GetStatementTopUps 获取 [startTime, endTime) 内完成的充值订单，按用户与完成时间排序
+func GetStatementTopUps(userId int, startTime int64, endTime int64) (topups []dto.StatementTopUp, err error) {
+	err = DB.Model(&TopUp{}).
+		Select("user_id, trade_no, payment_method, currency, amount, money, complete_time").
+		Where("status = ? and complete_time >= ? and complete_time < ?", common.TopUpStatusSuccess, startTime, endTime).
+		Scopes(statementUserScope("user_id", userId)).
+		Order("user_id asc, complete_time asc").
+		Scan(&topups).Error
+	for i := range topups {
+		topups[i].Currency = GetTopUpCurrency(topups[i].PaymentMethod, topups[i].Currency)
+	}
+	return topups, err
+}
+
+// GetStatementRedemptions 获取 [startTime, endTime) 内使用的兑换码，包含已删除的兑换码，按用户与使用时间排序
+func GetStatementRedemptions(userId int, startTime int64, endTime int64) (redemptions []dto.StatementRedemption, err error) {
+	err = DB.Unscoped().Model(&Redemption{}).
+		Select("used_user_id as user_id, id, name, quota, redeemed_time").
+		Where("used_user_id > 0 and redeemed_time >= ? and redeemed_time < ?", startTime, endTime).
+		Scopes(statementUserScope("used_user_id", userId)).
+		Order("used_user_id asc, redeemed_time asc").
+		Scan(&redemptions).Error
+	return redemptions, err
+}
+
+// GetStatementUsernames 获取用户名，包含已删除的用户
+func GetStatementUsernames(userIds []int) (map[int]string, error) {
+	usernames := make(map[int]string, len(userIds))
+	if len(userIds) == 0 {
+		return usernames, nil
+	}
+	var users []User
+	err := DB.Unscoped().Select("id, username").Where("id in ?", userIds).Find(&users).Error
+	for _, user := range users {
+		usernames[user.Id] = user.Username
+	}
+	return usernames, err
+}
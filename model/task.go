@@ -3,11 +3,13 @@ package model
 import (
 	"database/sql/driver"
 	"encoding/json"
+	"errors"
 	"time"
 
 	"github.com/ctrlc-ctrlv-limited/cvai/constant"
 	"github.com/ctrlc-ctrlv-limited/cvai/dto"
 	commonRelay "github.com/ctrlc-ctrlv-limited/cvai/relay/common"
+	"github.com/ctrlc-ctrlv-limited/cvai/types"
 )
 
 type TaskStatus string
@@ -96,6 +98,11 @@ func (m Properties) Value() (driver.Value, error) {
 
 type TaskPrivateData struct {
 	Key string `json:"key,omitempty"`
+	// 微调任务使用的 key 序号与令牌，用于绑定微调模型与任务完成后计费
+	KeyIndex int `json:"key_index,omitempty"`
+	TokenId  int `json:"token_id,omitempty"`
+	// background 模式的 response 提交时的价格信息，完成后按实际用量结算
+	PriceData *types.PriceData `json:"price_data,omitempty"`
 }
 
 func (p *TaskPrivateData) Scan(val interface{}) error {
@@ -290,6 +297,29 @@ func GetByTaskIds(userId int, taskIds []any) ([]*Task, error) {
 	return task, nil
 }
 
+// GetUserPlatformTasks 按 OpenAI 的游标分页方式获取用户某个平台的任务，after 为上一页最后一个任务的 task_id
+func GetUserPlatformTasks(userId int, platform constant.TaskPlatform, after string, limit int) (tasks []*Task, hasMore bool, err error) {
+	query := DB.Where("user_id = ? and platform = ?", userId, platform)
+	if after != "" {
+		afterTask, exist, err := GetByTaskId(userId, after)
+		if err != nil {
+			return nil, false, err
+		}
+		if !exist {
+			return nil, false, errors.New("task not found: " + after)
+		}
+		query = query.Where("id < ?", afterTask.ID)
+	}
+	err = query.Order("id desc").Limit(limit + 1).Find(&tasks).Error
+	if err != nil {
+		return nil, false, err
+	}
+	if len(tasks) > limit {
+		return tasks[:limit], true, nil
+	}
+	return tasks, false, nil
+}
+
 func TaskUpdateProgress(id int64, progress string) error {
 	return DB.Model(&Task{}).Where("id = ?", id).Update("progress", progress).Error
 }
@@ -306,6 +336,12 @@ func (Task *Task) Update() error {
 	return err
 }
 
+// UpdateWithStatus 仅当任务状态仍为 fromStatus 时更新任务，返回是否更新成功，防止并发更新时重复结算
+func (Task *Task) UpdateWithStatus(fromStatus TaskStatus) (bool, error) {
+	result := DB.Model(Task).Where("status = ?", fromStatus).Select("*").Updates(Task)
+	return result.RowsAffected > 0, result.Error
+}
+
 func TaskBulkUpdate(TaskIds []string, params map[string]any) error {
 	if len(TaskIds) == 0 {
 		return nil
@@ -6,8 +6,10 @@ import (
 	"strings"
 
 	"github.com/ctrlc-ctrlv-limited/cvai/common"
+	"github.com/ctrlc-ctrlv-limited/cvai/dto"
 	"github.com/bytedance/gopkg/util/gopool"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type Token struct {
@@ -27,9 +29,20 @@ type Token struct {
 	UsedQuota          int            `json:"used_quota" gorm:"default:0"` // used quota
 	Group              string         `json:"group" gorm:"default:''"`
 	CrossGroupRetry    bool           `json:"cross_group_retry"` // 跨分组重试，仅auto分组有效
+	BudgetPeriod       string         `json:"budget_period" gorm:"type:varchar(16);default:''"`
+	BudgetQuota        int            `json:"budget_quota" gorm:"default:0"`
+	BudgetTimezone     string         `json:"budget_timezone" gorm:"type:varchar(64);default:''"`
 	DeletedAt          gorm.DeletedAt `gorm:"index"`
 }
 
+func (token *Token) GetBudget() dto.QuotaBudget {
+	return dto.QuotaBudget{
+		Period:   token.BudgetPeriod,
+		Quota:    token.BudgetQuota,
+		Timezone: token.BudgetTimezone,
+	}
+}
+
 func (token *Token) Clean() {
 	token.Key = ""
 }
@@ -172,9 +185,12 @@ func GetTokenByKey(key string, fromDB bool) (token *Token, err error) {
 }
 
 func (token *Token) Insert() error {
-	var err error
-	err = DB.Create(token).Error
-	return err
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(token).Error; err != nil {
+			return err
+		}
+		return insertQuotaLedger(tx, token.UserId, QuotaLedgerAccountToken, token.Id, token.RemainQuota, QuotaLedgerMeta{Reason: QuotaLedgerReasonTokenCreate, ActorId: token.UserId})
+	})
 }
 
 // Update Make sure your token's fields is completed, because this will update non-zero values
@@ -189,8 +205,21 @@ func (token *Token) Update() (err error) {
 			})
 		}
 	}()
-	err = DB.Model(token).Select("name", "status", "expired_time", "remain_quota", "unlimited_quota",
-		"model_limits_enabled", "model_limits", "allow_ips", "group", "cross_group_retry").Updates(token).Error
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		var remainQuota int
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Model(&Token{}).Where("id = ?", token.Id).
+			Select("remain_quota").Scan(&remainQuota).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Model(token).Select("name", "status", "expired_time", "remain_quota", "unlimited_quota",
+			"model_limits_enabled", "model_limits", "allow_ips", "group", "cross_group_retry",
+			"budget_period", "budget_quota", "budget_timezone").Updates(token).Error
+		if err != nil {
+			return err
+		}
+		return insertQuotaLedger(tx, token.UserId, QuotaLedgerAccountToken, token.Id, token.RemainQuota-remainQuota, QuotaLedgerMeta{Reason: QuotaLedgerReasonTokenUpdate, ActorId: token.UserId})
+	})
 	return err
 }
 
@@ -267,7 +296,7 @@ func DeleteTokenById(id int, userId int) (err error) {
 	return token.Delete()
 }
 
-func IncreaseTokenQuota(id int, key string, quota int) (err error) {
+func IncreaseTokenQuota(id int, userId int, key string, quota int, meta QuotaLedgerMeta) (err error) {
 	if quota < 0 {
 		return errors.New("quota 不能为负数！")
 	}
@@ -281,13 +310,16 @@ func IncreaseTokenQuota(id int, key string, quota int) (err error) {
 	}
 	if common.BatchUpdateEnabled {
 		addNewRecord(BatchUpdateTypeTokenQuota, id, quota)
+		addQuotaLedgerRecord(userId, QuotaLedgerAccountToken, id, quota, meta)
 		return nil
 	}
-	return increaseTokenQuota(id, quota)
+	return updateQuotaWithLedger(userId, QuotaLedgerAccountToken, id, quota, meta, func(tx *gorm.DB) error {
+		return increaseTokenQuota(tx, id, quota)
+	})
 }
 
-func increaseTokenQuota(id int, quota int) (err error) {
-	err = DB.Model(&Token{}).Where("id = ?", id).Updates(
+func increaseTokenQuota(tx *gorm.DB, id int, quota int) (err error) {
+	err = tx.Model(&Token{}).Where("id = ?", id).Updates(
 		map[string]interface{}{
 			"remain_quota":  gorm.Expr("remain_quota + ?", quota),
 			"used_quota":    gorm.Expr("used_quota - ?", quota),
@@ -297,7 +329,7 @@ func increaseTokenQuota(id int, quota int) (err error) {
 	return err
 }
 
-func DecreaseTokenQuota(id int, key string, quota int) (err error) {
+func DecreaseTokenQuota(id int, userId int, key string, quota int, meta QuotaLedgerMeta) (err error) {
 	if quota < 0 {
 		return errors.New("quota 不能为负数！")
 	}
@@ -311,13 +343,16 @@ func DecreaseTokenQuota(id int, key string, quota int) (err error) {
 	}
 	if common.BatchUpdateEnabled {
 		addNewRecord(BatchUpdateTypeTokenQuota, id, -quota)
+		addQuotaLedgerRecord(userId, QuotaLedgerAccountToken, id, -quota, meta)
 		return nil
 	}
-	return decreaseTokenQuota(id, quota)
+	return updateQuotaWithLedger(userId, QuotaLedgerAccountToken, id, -quota, meta, func(tx *gorm.DB) error {
+		return decreaseTokenQuota(tx, id, quota)
+	})
 }
 
-func decreaseTokenQuota(id int, quota int) (err error) {
-	err = DB.Model(&Token{}).Where("id = ?", id).Updates(
+func decreaseTokenQuota(tx *gorm.DB, id int, quota int) (err error) {
+	err = tx.Model(&Token{}).Where("id = ?", id).Updates(
 		map[string]interface{}{
 			"remain_quota":  gorm.Expr("remain_quota - ?", quota),
 			"used_quota":    gorm.Expr("used_quota + ?", quota),
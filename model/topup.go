@@ -18,11 +18,34 @@ type TopUp struct {
 	Money         float64 `json:"money"`
 	TradeNo       string  `json:"trade_no" gorm:"unique;type:varchar(255);index"`
 	PaymentMethod string  `json:"payment_method" gorm:"type:varchar(50)"`
+	Currency      string  `json:"currency" gorm:"type:varchar(16)"` // 支付金额的币种
 	CreateTime    int64   `json:"create_time"`
 	CompleteTime  int64   `json:"complete_time"`
 	Status        string  `json:"status"`
 }
 
+// 支付金额的币种
+const (
+	TopUpCurrencyCNY = "CNY"
+	TopUpCurrencyUSD = "USD"
+)
+
+// GetTopUpCurrency 返回充值订单支付金额的币种，早期订单未记录币种时按支付方式推断：
+// Stripe 为美元，易支付为人民币，无法推断时返回空字符串
+func GetTopUpCurrency(paymentMethod string, currency string) string {
+	if currency != "" {
+		return currency
+	}
+	switch paymentMethod {
+	case "":
+		return ""
+	case "stripe":
+		return TopUpCurrencyUSD
+	default:
+		return TopUpCurrencyCNY
+	}
+}
+
 func (topUp *TopUp) Insert() error {
 	var err error
 	err = DB.Create(topUp).Error
@@ -90,6 +113,10 @@ func Recharge(referenceId string, customerId string) (err error) {
 		if err != nil {
 			return err
 		}
+		err = insertQuotaLedger(tx, topUp.UserId, QuotaLedgerAccountUser, topUp.UserId, int(quota), QuotaLedgerMeta{Reason: QuotaLedgerReasonTopUp, ActorId: topUp.UserId, Remark: topUp.TradeNo})
+		if err != nil {
+			return err
+		}
 
 		return nil
 	})
@@ -234,8 +261,8 @@ func SearchAllTopUps(keyword string, pageInfo *common.PageInfo) (topups []*TopUp
 	return topups, total, nil
 }
 
-// ManualCompleteTopUp 管理员手动完成订单并给用户充值
-func ManualCompleteTopUp(tradeNo string) error {
+// ManualCompleteTopUp 管理员手动完成订单并给用户充值，actorId 为操作的管理员
+func ManualCompleteTopUp(tradeNo string, actorId int) error {
 	if tradeNo == "" {
 		return errors.New("未提供订单号")
 	}
@@ -291,6 +318,9 @@ func ManualCompleteTopUp(tradeNo string) error {
 		if err := tx.Model(&User{}).Where("id = ?", topUp.UserId).Update("quota", gorm.Expr("quota + ?", quotaToAdd)).Error; err != nil {
 			return err
 		}
+		if err := insertQuotaLedger(tx, topUp.UserId, QuotaLedgerAccountUser, topUp.UserId, quotaToAdd, QuotaLedgerMeta{Reason: QuotaLedgerReasonTopUp, ActorId: actorId, Remark: topUp.TradeNo}); err != nil {
+			return err
+		}
 
 		userId = topUp.UserId
 		payMoney = topUp.Money
@@ -362,6 +392,10 @@ func RechargeCreem(referenceId string, customerEmail string, customerName string
 		if err != nil {
 			return err
 		}
+		err = insertQuotaLedger(tx, topUp.UserId, QuotaLedgerAccountUser, topUp.UserId, int(quota), QuotaLedgerMeta{Reason: QuotaLedgerReasonTopUp, ActorId: topUp.UserId, Remark: topUp.TradeNo})
+		if err != nil {
+			return err
+		}
 
 		return nil
 	})
@@ -13,6 +13,7 @@ import (
 
 	"github.com/bytedance/gopkg/util/gopool"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // User if you add sensitive fields, don't forget to clean them in setupLogin function.
@@ -47,6 +48,10 @@ type User struct {
 	Setting          string         `json:"setting" gorm:"type:text;column:setting"`
 	Remark           string         `json:"remark,omitempty" gorm:"type:varchar(255)" validate:"max=255"`
 	StripeCustomer   string         `json:"stripe_customer" gorm:"type:varchar(64);column:stripe_customer;index"`
+	BudgetPeriod     string         `json:"budget_period" gorm:"type:varchar(16);default:''"`
+	BudgetQuota      int            `json:"budget_quota" gorm:"type:int;default:0"`
+	BudgetTimezone   string         `json:"budget_timezone" gorm:"type:varchar(64);default:''"`
+	CreditLimit      int            `json:"credit_limit" gorm:"type:int;default:-1"` // 信用额度，余额可透支到 -CreditLimit，0 表示预付费，-1 表示使用分组的默认信用额度
 }
 
 func (user *User) ToBaseUser() *UserBase {
@@ -58,10 +63,23 @@ func (user *User) ToBaseUser() *UserBase {
 		Username: user.Username,
 		Setting:  user.Setting,
 		Email:    user.Email,
+
+		BudgetPeriod:   user.BudgetPeriod,
+		BudgetQuota:    user.BudgetQuota,
+		BudgetTimezone: user.BudgetTimezone,
+		CreditLimit:    user.CreditLimit,
 	}
 	return cache
 }
 
+func (user *User) GetBudget() dto.QuotaBudget {
+	return dto.QuotaBudget{
+		Period:   user.BudgetPeriod,
+		Quota:    user.BudgetQuota,
+		Timezone: user.BudgetTimezone,
+	}
+}
+
 func (user *User) GetAccessToken() string {
 	if user.AccessToken == nil {
 		return ""
@@ -368,6 +386,9 @@ func (user *User) TransferAffQuotaToQuota(quota int) error {
 	if err := tx.Save(user).Error; err != nil {
 		return err
 	}
+	if err := insertQuotaLedger(tx, user.Id, QuotaLedgerAccountUser, user.Id, quota, QuotaLedgerMeta{Reason: QuotaLedgerReasonAffTransfer, ActorId: user.Id}); err != nil {
+		return err
+	}
 
 	// 提交事务
 	return tx.Commit().Error
@@ -392,9 +413,14 @@ func (user *User) Insert(inviterId int) error {
 		user.SetSetting(defaultSetting)
 	}
 
-	result := DB.Create(user)
-	if result.Error != nil {
-		return result.Error
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(user).Error; err != nil {
+			return err
+		}
+		return insertQuotaLedger(tx, user.Id, QuotaLedgerAccountUser, user.Id, user.Quota, QuotaLedgerMeta{Reason: QuotaLedgerReasonNewUser})
+	})
+	if err != nil {
+		return err
 	}
 
 	// 用户创建成功后，根据角色初始化边栏配置
@@ -417,7 +443,7 @@ func (user *User) Insert(inviterId int) error {
 	}
 	if inviterId != 0 {
 		if common.QuotaForInvitee > 0 {
-			_ = IncreaseUserQuota(user.Id, common.QuotaForInvitee, true)
+			_ = IncreaseUserQuota(user.Id, common.QuotaForInvitee, true, QuotaLedgerMeta{Reason: QuotaLedgerReasonInviteReward})
 			RecordLog(user.Id, LogTypeSystem, fmt.Sprintf("使用邀请码赠送 %s", logger.LogQuota(common.QuotaForInvitee)))
 		}
 		if common.QuotaForInviter > 0 {
@@ -447,7 +473,8 @@ func (user *User) Update(updatePassword bool) error {
 	return updateUserCache(*user)
 }
 
-func (user *User) Edit(updatePassword bool) error {
+// Edit 更新用户信息，额度变化记为 actorId 的调整
+func (user *User) Edit(updatePassword bool, actorId int) error {
 	var err error
 	if updatePassword {
 		user.Password, err = common.Password2Hash(user.Password)
@@ -458,18 +485,31 @@ func (user *User) Edit(updatePassword bool) error {
 
 	newUser := *user
 	updates := map[string]interface{}{
-		"username":     newUser.Username,
-		"display_name": newUser.DisplayName,
-		"group":        newUser.Group,
-		"quota":        newUser.Quota,
-		"remark":       newUser.Remark,
+		"username":        newUser.Username,
+		"display_name":    newUser.DisplayName,
+		"group":           newUser.Group,
+		"quota":           newUser.Quota,
+		"remark":          newUser.Remark,
+		"budget_period":   newUser.BudgetPeriod,
+		"budget_quota":    newUser.BudgetQuota,
+		"budget_timezone": newUser.BudgetTimezone,
+		"credit_limit":    newUser.CreditLimit,
 	}
 	if updatePassword {
 		updates["password"] = newUser.Password
 	}
 
-	DB.First(&user, user.Id)
-	if err = DB.Model(user).Updates(updates).Error; err != nil {
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, user.Id).Error; err != nil {
+			return err
+		}
+		oldQuota := user.Quota
+		if err := tx.Model(user).Updates(updates).Error; err != nil {
+			return err
+		}
+		return insertQuotaLedger(tx, user.Id, QuotaLedgerAccountUser, user.Id, newUser.Quota-oldQuota, QuotaLedgerMeta{Reason: QuotaLedgerReasonAdminAdjust, ActorId: actorId})
+	})
+	if err != nil {
 		return err
 	}
 
@@ -763,7 +803,7 @@ func GetUserSetting(id int, fromDB bool) (settingMap dto.UserSetting, err error)
 	return userBase.GetSetting(), nil
 }
 
-func IncreaseUserQuota(id int, quota int, db bool) (err error) {
+func IncreaseUserQuota(id int, quota int, db bool, meta QuotaLedgerMeta) (err error) {
 	if quota < 0 {
 		return errors.New("quota 不能为负数！")
 	}
@@ -775,20 +815,23 @@ func IncreaseUserQuota(id int, quota int, db bool) (err error) {
 	})
 	if !db && common.BatchUpdateEnabled {
 		addNewRecord(BatchUpdateTypeUserQuota, id, quota)
+		addQuotaLedgerRecord(id, QuotaLedgerAccountUser, id, quota, meta)
 		return nil
 	}
-	return increaseUserQuota(id, quota)
+	return updateQuotaWithLedger(id, QuotaLedgerAccountUser, id, quota, meta, func(tx *gorm.DB) error {
+		return increaseUserQuota(tx, id, quota)
+	})
 }
 
-func increaseUserQuota(id int, quota int) (err error) {
-	err = DB.Model(&User{}).Where("id = ?", id).Update("quota", gorm.Expr("quota + ?", quota)).Error
+func increaseUserQuota(tx *gorm.DB, id int, quota int) (err error) {
+	err = tx.Model(&User{}).Where("id = ?", id).Update("quota", gorm.Expr("quota + ?", quota)).Error
 	if err != nil {
 		return err
 	}
 	return err
 }
 
-func DecreaseUserQuota(id int, quota int) (err error) {
+func DecreaseUserQuota(id int, quota int, meta QuotaLedgerMeta) (err error) {
 	if quota < 0 {
 		return errors.New("quota 不能为负数！")
 	}
@@ -800,27 +843,30 @@ func DecreaseUserQuota(id int, quota int) (err error) {
 	})
 	if common.BatchUpdateEnabled {
 		addNewRecord(BatchUpdateTypeUserQuota, id, -quota)
+		addQuotaLedgerRecord(id, QuotaLedgerAccountUser, id, -quota, meta)
 		return nil
 	}
-	return decreaseUserQuota(id, quota)
+	return updateQuotaWithLedger(id, QuotaLedgerAccountUser, id, -quota, meta, func(tx *gorm.DB) error {
+		return decreaseUserQuota(tx, id, quota)
+	})
 }
 
-func decreaseUserQuota(id int, quota int) (err error) {
-	err = DB.Model(&User{}).Where("id = ?", id).Update("quota", gorm.Expr("quota - ?", quota)).Error
+func decreaseUserQuota(tx *gorm.DB, id int, quota int) (err error) {
+	err = tx.Model(&User{}).Where("id = ?", id).Update("quota", gorm.Expr("quota - ?", quota)).Error
 	if err != nil {
 		return err
 	}
 	return err
 }
 
-func DeltaUpdateUserQuota(id int, delta int) (err error) {
+func DeltaUpdateUserQuota(id int, delta int, meta QuotaLedgerMeta) (err error) {
 	if delta == 0 {
 		return nil
 	}
 	if delta > 0 {
-		return IncreaseUserQuota(id, delta, false)
+		return IncreaseUserQuota(id, delta, false, meta)
 	} else {
-		return DecreaseUserQuota(id, -delta)
+		return DecreaseUserQuota(id, -delta, meta)
 	}
 }
 
@@ -22,6 +22,11 @@ type UserBase struct {
 	Status   int    `json:"status"`
 	Username string `json:"username"`
 	Setting  string `json:"setting"`
+
+	BudgetPeriod   string `json:"budget_period"`
+	BudgetQuota    int    `json:"budget_quota"`
+	BudgetTimezone string `json:"budget_timezone"`
+	CreditLimit    int    `json:"credit_limit"`
 }
 
 func (user *UserBase) WriteContext(c *gin.Context) {
@@ -31,6 +36,16 @@ func (user *UserBase) WriteContext(c *gin.Context) {
 	common.SetContextKey(c, constant.ContextKeyUserEmail, user.Email)
 	common.SetContextKey(c, constant.ContextKeyUserName, user.Username)
 	common.SetContextKey(c, constant.ContextKeyUserSetting, user.GetSetting())
+	common.SetContextKey(c, constant.ContextKeyUserBudget, user.GetBudget())
+	common.SetContextKey(c, constant.ContextKeyUserCreditLimit, user.CreditLimit)
+}
+
+func (user *UserBase) GetBudget() dto.QuotaBudget {
+	return dto.QuotaBudget{
+		Period:   user.BudgetPeriod,
+		Quota:    user.BudgetQuota,
+		Timezone: user.BudgetTimezone,
+	}
 }
 
 func (user *UserBase) GetSetting() dto.UserSetting {
@@ -99,15 +114,7 @@ func GetUserCache(userId int) (userCache *UserBase, err error) {
 	}
 
 	// Create cache object from user data
-	userCache = &UserBase{
-		Id:       user.Id,
-		Group:    user.Group,
-		Quota:    user.Quota,
-		Status:   user.Status,
-		Username: user.Username,
-		Setting:  user.Setting,
-		Email:    user.Email,
-	}
+	userCache = user.ToBaseUser()
 
 	return userCache, nil
 }
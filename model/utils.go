@@ -50,6 +50,8 @@ func addNewRecord(type_ int, id int, value int) {
 }
 
 func batchUpdate() {
+	// 额度变动写入后再写入对应的流水
+	defer flushQuotaLedgerRecords()
 	// check if there's any data to update
 	hasData := false
 	for i := 0; i < BatchUpdateTypeCount; i++ {
@@ -76,12 +78,12 @@ func batchUpdate() {
 		for key, value := range store {
 			switch i {
 			case BatchUpdateTypeUserQuota:
-				err := increaseUserQuota(key, value)
+				err := increaseUserQuota(DB, key, value)
 				if err != nil {
 					common.SysLog("failed to batch update user quota: " + err.Error())
 				}
 			case BatchUpdateTypeTokenQuota:
-				err := increaseTokenQuota(key, value)
+				err := increaseTokenQuota(DB, key, value)
 				if err != nil {
 					common.SysLog("failed to batch update token quota: " + err.Error())
 				}
@@ -0,0 +1,115 @@
+package model
+
+import (
+	"github.com/ctrlc-ctrlv-limited/cvai/common"
+
+	"gorm.io/gorm"
+)
+
+const (
+	VectorStoreStatusExpired = "expired"
+)
+
+// VectorStore 通过 Vector Stores API 创建的向量库与创建它的渠道、key 的绑定，文件、检索与 file_search 都需要发送到同一个渠道与 key
+type VectorStore struct {
+	Id            int    `json:"-"`
+	VectorStoreId string `json:"id" gorm:"type:varchar(191);uniqueIndex"`
+	UserId        int    `json:"user_id" gorm:"index"`
+	TokenId       int    `json:"token_id" gorm:"index"`
+	Group         string `json:"group" gorm:"type:varchar(64)"`
+	// 计算存储费用使用的模型名称
+	ModelName string `json:"model_name" gorm:"type:varchar(255)"`
+	ChannelId int    `json:"channel_id" gorm:"index"`
+	KeyIndex  int    `json:"-"`
+	Status    string `json:"status" gorm:"type:varchar(32);index"`
+	// 上游最近一次返回的向量库对象（JSON）
+	Data string `json:"-" gorm:"type:text"`
+	// 最近一次同步的存储量与同步时间
+	UsageBytes     int64 `json:"usage_bytes" gorm:"bigint"`
+	UsageUpdatedAt int64 `json:"usage_updated_at" gorm:"bigint"`
+	// 尚未结算的存储量（字节·秒）
+	ByteSeconds int64 `json:"byte_seconds" gorm:"bigint"`
+	// 已扣除的存储额度
+	Quota     int   `json:"quota" gorm:"default:0"`
+	BilledAt  int64 `json:"billed_at" gorm:"bigint;index"`
+	CreatedAt int64 `json:"created_at" gorm:"bigint;index"`
+}
+
+func (store *VectorStore) Insert() error {
+	store.CreatedAt = common.GetTimestamp()
+	store.UsageUpdatedAt = store.CreatedAt
+	store.BilledAt = store.CreatedAt
+	return DB.Create(store).Error
+}
+
+func (store *VectorStore) Update() error {
+	return DB.Save(store).Error
+}
+
+// UpdateData 只更新上游返回的向量库对象与状态，不影响后台同步的存储量
+func (store *VectorStore) UpdateData(data string, status string) error {
+	store.Data = data
+	store.Status = status
+	return DB.Model(store).Select("data", "status").Updates(store).Error
+}
+
+func (store *VectorStore) Delete() error {
+	return DB.Delete(store).Error
+}
+
+// GetVectorStore 获取向量库绑定，不存在时返回 nil
+func GetVectorStore(vectorStoreId string) (*VectorStore, error) {
+	var stores []*VectorStore
+	err := DB.Where("vector_store_id = ?", vectorStoreId).Limit(1).Find(&stores).Error
+	if err != nil || len(stores) == 0 {
+		return nil, err
+	}
+	return stores[0], nil
+}
+
+// GetUserVectorStore 获取用户的向量库绑定，不存在时返回 nil
+func GetUserVectorStore(userId int, vectorStoreId string) (*VectorStore, error) {
+	store, err := GetVectorStore(vectorStoreId)
+	if err != nil || store == nil || store.UserId != userId {
+		return nil, err
+	}
+	return store, nil
+}
+
+// GetUserVectorStores 按 OpenAI 的游标分页方式获取用户的向量库，after 为上一页最后一个向量库的 id
+func GetUserVectorStores(userId int, after string, limit int, ascending bool) (stores []*VectorStore, hasMore bool, err error) {
+	query := DB.Where("user_id = ?", userId)
+	order := "id desc"
+	if ascending {
+		order = "id asc"
+	}
+	if after != "" {
+		afterStore, err := GetUserVectorStore(userId, after)
+		if err != nil {
+			return nil, false, err
+		}
+		if afterStore == nil {
+			return nil, false, gorm.ErrRecordNotFound
+		}
+		if ascending {
+			query = query.Where("id > ?", afterStore.Id)
+		} else {
+			query = query.Where("id < ?", afterStore.Id)
+		}
+	}
+	err = query.Order(order).Limit(limit + 1).Find(&stores).Error
+	if err != nil {
+		return nil, false, err
+	}
+	if len(stores) > limit {
+		return stores[:limit], true, nil
+	}
+	return stores, false, nil
+}
+
+// GetVectorStoresToSync 获取需要同步存储量的向量库，已过期且没有未结算存储量的向量库不再处理
+func GetVectorStoresToSync(syncedBefore int64, afterId int, limit int) (stores []*VectorStore, err error) {
+	err = DB.Where("id > ? and usage_updated_at <= ? and (status <> ? or byte_seconds > 0)", afterId, syncedBefore, VectorStoreStatusExpired).
+		Order("id asc").Limit(limit).Find(&stores).Error
+	return stores, err
+}
@@ -1,6 +1,7 @@
 package channel
 
 import (
+	"errors"
 	"io"
 	"net/http"
 
@@ -55,3 +56,18 @@ type TaskAdaptor interface {
 type OpenAIVideoConverter interface {
 	ConvertToOpenAIVideo(originTask *model.Task) ([]byte, error)
 }
+
+// ErrCountTokensNotSupported 渠道不支持原生计算当前请求的 token 数量，由网关在本地估算
+var ErrCountTokensNotSupported = errors.New("count tokens not supported")
+
+// TokenCounter 支持原生计算请求输入 token 数量的渠道适配器实现此接口，
+// 用于 Claude count_tokens、Responses input_tokens 与 Gemini countTokens 接口
+type TokenCounter interface {
+	CountTokens(c *gin.Context, info *relaycommon.RelayInfo, request dto.Request) (int, error)
+}
+
+// Moderator 支持内容审核的渠道适配器实现此接口，审核结果统一转换为 OpenAI moderation 格式，
+// 用于 /v1/moderations 与聊天请求转发前的内容审核预检
+type Moderator interface {
+	Moderate(c *gin.Context, info *relaycommon.RelayInfo, request *dto.ModerationRequest) (*dto.ModerationResponse, *dto.Usage, *types.CVAIError)
+}
@@ -66,7 +66,7 @@ func (a *Adaptor) GetRequestURL(info *relaycommon.RelayInfo) (string, error) {
 			} else {
 				fullRequestURL = fmt.Sprintf("%s/api/v1/services/aigc/text2image/image-synthesis", info.ChannelBaseUrl)
 			}
-		case constant.RelayModeImagesEdits:
+		case constant.RelayModeImagesEdits, constant.RelayModeImagesVariations:
 			if isOldWanModel(info.OriginModelName) {
 				fullRequestURL = fmt.Sprintf("%s/api/v1/services/aigc/image2image/image-synthesis", info.ChannelBaseUrl)
 			} else if isWanModel(info.OriginModelName) {
@@ -100,7 +100,7 @@ func (a *Adaptor) SetupRequestHeader(c *gin.Context, req *http.Header, info *rel
 			req.Set("X-DashScope-Async", "enable")
 		}
 	}
-	if info.RelayMode == constant.RelayModeImagesEdits {
+	if constant.IsImageEditMode(info.RelayMode) {
 		if isWanModel(info.OriginModelName) {
 			req.Set("X-DashScope-Async", "enable")
 		}
@@ -142,7 +142,7 @@ func (a *Adaptor) ConvertImageRequest(c *gin.Context, info *relaycommon.RelayInf
 			return nil, fmt.Errorf("convert image request to async ali image request failed: %w", err)
 		}
 		return aliRequest, nil
-	} else if info.RelayMode == constant.RelayModeImagesEdits {
+	} else if constant.IsImageEditMode(info.RelayMode) {
 		if isOldWanModel(info.OriginModelName) {
 			return oaiFormEdit2WanxImageEdit(c, info, request)
 		}
@@ -154,20 +154,19 @@ func (a *Adaptor) ConvertImageRequest(c *gin.Context, info *relaycommon.RelayInf
 			}
 		}
 		// ali image edit https://bailian.console.aliyun.com/?tab=api#/api/?type=model&url=2976416
-		// 如果用户使用表单，则需要解析表单数据
-		if strings.Contains(c.Request.Header.Get("Content-Type"), "multipart/form-data") {
-			aliRequest, err := oaiFormEdit2AliImageEdit(c, info, request)
-			if err != nil {
-				return nil, fmt.Errorf("convert image edit form request failed: %w", err)
-			}
-			return aliRequest, nil
-		} else {
+		// 请求中直接使用阿里的 input 格式时原样转发，否则从表单或 JSON 中解析输入图片
+		if _, ok := request.Extra["input"]; ok {
 			aliRequest, err := oaiImage2AliImageRequest(info, request, a.IsSyncImageModel)
 			if err != nil {
 				return nil, fmt.Errorf("convert image request to async ali image request failed: %w", err)
 			}
 			return aliRequest, nil
 		}
+		aliRequest, err := oaiFormEdit2AliImageEdit(c, info, request)
+		if err != nil {
+			return nil, fmt.Errorf("convert image edit request failed: %w", err)
+		}
+		return aliRequest, nil
 	}
 	return nil, fmt.Errorf("unsupported image relay mode: %d", info.RelayMode)
 }
@@ -206,7 +205,7 @@ func (a *Adaptor) DoResponse(c *gin.Context, resp *http.Response, info *relaycom
 		switch info.RelayMode {
 		case constant.RelayModeImagesGenerations:
 			err, usage = aliImageHandler(a, c, resp, info)
-		case constant.RelayModeImagesEdits:
+		case constant.RelayModeImagesEdits, constant.RelayModeImagesVariations:
 			err, usage = aliImageHandler(a, c, resp, info)
 		case constant.RelayModeRerank:
 			err, usage = RerankHandler(c, resp, info)
@@ -198,6 +198,13 @@ type WanImageInput struct {
 	NegativePrompt string   `json:"negative_prompt,omitempty"` // 可选：反向提示词，描述不希望在画面中看到的内容
 }
 
+type WanxImageEditInput struct {
+	Function     string `json:"function"`                 // 必需：编辑功能，指令编辑为 description_edit，局部重绘为 description_edit_with_mask
+	Prompt       string `json:"prompt"`                   // 必需：文本提示词
+	BaseImageUrl string `json:"base_image_url"`           // 必需：输入图像，支持HTTP/HTTPS URL或Base64编码
+	MaskImageUrl string `json:"mask_image_url,omitempty"` // 可选：局部重绘的蒙版，白色区域为待编辑区域
+}
+
 type WanImageParameters struct {
 	N         int     `json:"n,omitempty"`         // 生成图片数量，取值范围1-4，默认4
 	Watermark *bool   `json:"watermark,omitempty"` // 是否添加水印标识，默认false
@@ -7,29 +7,56 @@ import (
 	"github.com/ctrlc-ctrlv-limited/cvai/common"
 	"github.com/ctrlc-ctrlv-limited/cvai/dto"
 	relaycommon "github.com/ctrlc-ctrlv-limited/cvai/relay/common"
+	"github.com/ctrlc-ctrlv-limited/cvai/service"
 
 	"github.com/gin-gonic/gin"
 )
 
 func oaiFormEdit2WanxImageEdit(c *gin.Context, info *relaycommon.RelayInfo, request dto.ImageRequest) (*AliImageRequest, error) {
-	var err error
 	var imageRequest AliImageRequest
 	imageRequest.Model = request.Model
 	imageRequest.ResponseFormat = request.ResponseFormat
-	wanInput := WanImageInput{
-		Prompt: request.Prompt,
-	}
+	prompt := service.GetImageEditPrompt(info.RelayMode, request.Prompt)
 
-	if err := common.UnmarshalBodyReusable(c, &wanInput); err != nil {
-		return nil, err
+	input, err := service.GetImageEditInput(c, request)
+	if err != nil {
+		return nil, fmt.Errorf("get image edit input failed: %w", err)
 	}
-	if wanInput.Images, err = getImageBase64sFromForm(c, "image"); err != nil {
-		return nil, fmt.Errorf("get image base64s from form failed: %w", err)
+	if isWanxImageEditModel(request.Model) {
+		// 通用图像编辑，有蒙版时为局部重绘，否则按指令编辑
+		wanxInput := WanxImageEditInput{
+			Function:     "description_edit",
+			Prompt:       prompt,
+			BaseImageUrl: service.GetImageEditFileUrl(input.Images[0]),
+		}
+		if input.Mask != nil {
+			mask, err := service.ConvertImageEditMask(c, input.Mask)
+			if err != nil {
+				return nil, err
+			}
+			wanxInput.Function = "description_edit_with_mask"
+			wanxInput.MaskImageUrl = service.GetImageEditFileUrl(mask)
+		}
+		imageRequest.Input = wanxInput
+	} else {
+		var options struct {
+			NegativePrompt string `json:"negative_prompt"`
+		}
+		if err := common.UnmarshalBodyReusable(c, &options); err != nil {
+			return nil, err
+		}
+		wanInput := WanImageInput{
+			Prompt:         prompt,
+			NegativePrompt: options.NegativePrompt,
+		}
+		for _, image := range input.Images {
+			wanInput.Images = append(wanInput.Images, service.GetImageEditFileUrl(image))
+		}
+		imageRequest.Input = wanInput
 	}
 	//wanParams := WanImageParameters{
 	//	N: int(request.N),
 	//}
-	imageRequest.Input = wanInput
 	imageRequest.Parameters = AliImageParameters{
 		N: int(request.N),
 	}
@@ -45,3 +72,8 @@ func isOldWanModel(modelName string) bool {
 func isWanModel(modelName string) bool {
 	return strings.Contains(modelName, "wan")
 }
+
+// isWanxImageEditModel 通用图像编辑模型，如 wanx2.1-imageedit
+func isWanxImageEditModel(modelName string) bool {
+	return strings.Contains(modelName, "wanx") && strings.Contains(modelName, "imageedit")
+}
@@ -1,6 +1,7 @@
 package channel
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
@@ -11,6 +12,7 @@ import (
 	"time"
 
 	common2 "github.com/ctrlc-ctrlv-limited/cvai/common"
+	constant2 "github.com/ctrlc-ctrlv-limited/cvai/constant"
 	"github.com/ctrlc-ctrlv-limited/cvai/logger"
 	"github.com/ctrlc-ctrlv-limited/cvai/relay/common"
 	"github.com/ctrlc-ctrlv-limited/cvai/relay/constant"
@@ -27,7 +29,7 @@ import (
 func SetupApiRequestHeader(info *common.RelayInfo, c *gin.Context, req *http.Header) {
 	if info.RelayMode == constant.RelayModeAudioTranscription || info.RelayMode == constant.RelayModeAudioTranslation {
 		// multipart/form-data
-	} else if info.RelayMode == constant.RelayModeRealtime {
+	} else if info.RelayMode == constant.RelayModeRealtime || info.RelayMode == constant.RelayModeGeminiLive {
 		// websocket
 	} else {
 		req.Set("Content-Type", c.Request.Header.Get("Content-Type"))
@@ -249,6 +251,49 @@ func sendPingData(c *gin.Context, mutex *sync.Mutex) error {
 	}
 }
 
+// DoCountTokensRequest 请求上游计算 token 数量的接口，返回响应体，非 2xx 响应返回错误
+func DoCountTokensRequest(a Adaptor, c *gin.Context, info *common.RelayInfo, fullRequestURL string, body any) ([]byte, error) {
+	resp, err := DoJSONRequest(a, c, info, fullRequestURL, body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read response body failed: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("count tokens failed: status %d, %s", resp.StatusCode, string(responseBody))
+	}
+	return responseBody, nil
+}
+
+// DoJSONRequest 以 JSON 请求体 POST 到指定的上游地址，用于不经过 GetRequestURL 的辅助接口，响应由调用方处理
+func DoJSONRequest(a Adaptor, c *gin.Context, info *common.RelayInfo, fullRequestURL string, body any) (*http.Response, error) {
+	jsonData, err := common2.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("marshal request body failed: %w", err)
+	}
+	req, err := http.NewRequest(http.MethodPost, fullRequestURL, bytes.NewReader(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("new request failed: %w", err)
+	}
+	headers := req.Header
+	headerOverride, err := processHeaderOverride(info)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range headerOverride {
+		headers.Set(key, value)
+	}
+	err = a.SetupRequestHeader(c, &headers, info)
+	if err != nil {
+		return nil, fmt.Errorf("setup request header failed: %w", err)
+	}
+	headers.Set("Content-Type", "application/json")
+	return doRequest(c, req, info)
+}
+
 func DoRequest(c *gin.Context, req *http.Request, info *common.RelayInfo) (*http.Response, error) {
 	return doRequest(c, req, info)
 }
@@ -284,6 +329,11 @@ func doRequest(c *gin.Context, req *http.Request, info *common.RelayInfo) (*http
 		}
 	}
 
+	if _, ok := common2.GetContextKey(c, constant2.ContextKeyHedgeState); ok {
+		// 对冲请求中另一方胜出后需要取消上游请求
+		req = req.WithContext(c.Request.Context())
+	}
+
 	resp, err := client.Do(req)
 	if err != nil {
 		logger.LogError(c, "do request failed: "+err.Error())
@@ -292,6 +342,7 @@ func doRequest(c *gin.Context, req *http.Request, info *common.RelayInfo) (*http
 	if resp == nil {
 		return nil, errors.New("resp is nil")
 	}
+	service.RecordUpstreamRateLimit(c, info, resp)
 
 	_ = req.Body.Close()
 	_ = c.Request.Body.Close()
@@ -180,3 +180,12 @@ func (a *Adaptor) GetModelList() (models []string) {
 func (a *Adaptor) GetChannelName() string {
 	return ChannelName
 }
+
+// CountTokens 转发到 Bedrock 的 CountTokens 接口，仅支持 Claude 模型
+func (a *Adaptor) CountTokens(c *gin.Context, info *relaycommon.RelayInfo, request dto.Request) (int, error) {
+	claudeRequest, ok := request.(*dto.ClaudeRequest)
+	if !ok || isNovaModel(info.UpstreamModelName) {
+		return 0, channel.ErrCountTokensNotSupported
+	}
+	return countAwsClaudeTokens(c, info, claudeRequest)
+}
@@ -1,11 +1,16 @@
 package aws
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"strings"
 	"time"
 
@@ -22,6 +27,7 @@ import (
 
 	"github.com/ctrlc-ctrlv-limited/cvai/setting/model_setting"
 	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime"
 	bedrockruntimeTypes "github.com/aws/aws-sdk-go-v2/service/bedrockruntime/types"
@@ -162,6 +168,93 @@ func doAwsClientRequest(c *gin.Context, info *relaycommon.RelayInfo, a *Adaptor,
 	}
 }
 
+// countAwsClaudeTokens 调用 Bedrock 的 CountTokens 接口，请求体为 base64 编码的 InvokeModel 请求体
+// 当前版本的 SDK 尚未提供该接口，直接签名请求
+func countAwsClaudeTokens(c *gin.Context, info *relaycommon.RelayInfo, request *dto.ClaudeRequest) (int, error) {
+	requestHeader := http.Header{}
+	claude.CommonClaudeHeadersOperation(c, &requestHeader, info)
+	requestBody, err := common.Marshal(request)
+	if err != nil {
+		return 0, err
+	}
+	awsClaudeReq, err := formatRequest(bytes.NewReader(requestBody), requestHeader)
+	if err != nil {
+		return 0, err
+	}
+	// InvokeModel 要求 max_tokens，count_tokens 请求中通常没有
+	if awsClaudeReq.MaxTokens == 0 {
+		awsClaudeReq.MaxTokens = 1
+	}
+	awsClaudeReq.Temperature = nil
+	invokeBody, err := common.Marshal(awsClaudeReq)
+	if err != nil {
+		return 0, err
+	}
+	body, err := common.Marshal(map[string]any{
+		"input": map[string]any{
+			"invokeModel": map[string]any{
+				"body": base64.StdEncoding.EncodeToString(invokeBody),
+			},
+		},
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	awsSecret := strings.Split(info.ApiKey, "|")
+	if len(awsSecret) != 2 && len(awsSecret) != 3 {
+		return 0, errors.New("invalid aws secret key")
+	}
+	region := awsSecret[len(awsSecret)-1]
+	// CountTokens 不支持跨区域推理配置，使用基础模型 id
+	fullRequestURL := fmt.Sprintf("https://bedrock-runtime.%s.amazonaws.com/model/%s/count-tokens", region, url.PathEscape(getAwsModelID(info.UpstreamModelName)))
+	ctx, cancel := newAwsInvokeContext()
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fullRequestURL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(awsSecret) == 2 {
+		req.Header.Set("Authorization", "Bearer "+awsSecret[0])
+	} else {
+		payloadHash := sha256.Sum256(body)
+		awsCredentials := aws.Credentials{AccessKeyID: awsSecret[0], SecretAccessKey: awsSecret[1]}
+		if err = v4.NewSigner().SignHTTP(ctx, awsCredentials, req, hex.EncodeToString(payloadHash[:]), "bedrock", region, time.Now()); err != nil {
+			return 0, err
+		}
+	}
+
+	var httpClient *http.Client
+	if info.ChannelSetting.Proxy != "" {
+		httpClient, err = service.NewProxyHttpClient(info.ChannelSetting.Proxy)
+		if err != nil {
+			return 0, fmt.Errorf("new proxy http client failed: %w", err)
+		}
+	} else {
+		httpClient = service.GetHttpClient()
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("count tokens failed: status %d, %s", resp.StatusCode, string(responseBody))
+	}
+	var response struct {
+		InputTokens int `json:"inputTokens"`
+	}
+	if err = common.Unmarshal(responseBody, &response); err != nil {
+		return 0, err
+	}
+	return response.InputTokens, nil
+}
+
 // buildAwsRequestBody prepares the payload for AWS requests, applying passthrough rules when enabled.
 func buildAwsRequestBody(c *gin.Context, info *relaycommon.RelayInfo, awsClaudeReq any) ([]byte, error) {
 	if model_setting.GetGlobalSettings().PassThroughRequestEnabled || info.ChannelSetting.PassThroughBodyEnabled {
@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"strings"
 
+	"github.com/ctrlc-ctrlv-limited/cvai/common"
 	"github.com/ctrlc-ctrlv-limited/cvai/dto"
 	"github.com/ctrlc-ctrlv-limited/cvai/relay/channel"
 	relaycommon "github.com/ctrlc-ctrlv-limited/cvai/relay/common"
@@ -131,3 +132,24 @@ func (a *Adaptor) GetModelList() []string {
 func (a *Adaptor) GetChannelName() string {
 	return ChannelName
 }
+
+// CountTokens 转发到 Anthropic 的 /v1/messages/count_tokens 接口
+func (a *Adaptor) CountTokens(c *gin.Context, info *relaycommon.RelayInfo, request dto.Request) (int, error) {
+	claudeRequest, ok := request.(*dto.ClaudeRequest)
+	if !ok || a.RequestMode != RequestModeMessage {
+		return 0, channel.ErrCountTokensNotSupported
+	}
+	fullRequestURL := fmt.Sprintf("%s/v1/messages/count_tokens", info.ChannelBaseUrl)
+	if info.IsClaudeBetaQuery {
+		fullRequestURL = fullRequestURL + "?beta=true"
+	}
+	responseBody, err := channel.DoCountTokensRequest(a, c, info, fullRequestURL, claudeRequest.ToCountTokensRequest(info.UpstreamModelName))
+	if err != nil {
+		return 0, err
+	}
+	var response dto.ClaudeCountTokensResponse
+	if err = common.Unmarshal(responseBody, &response); err != nil {
+		return 0, err
+	}
+	return response.InputTokens, nil
+}
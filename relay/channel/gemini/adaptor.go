@@ -1,12 +1,14 @@
 package gemini
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"strings"
 
+	"github.com/ctrlc-ctrlv-limited/cvai/common"
 	"github.com/ctrlc-ctrlv-limited/cvai/dto"
 	"github.com/ctrlc-ctrlv-limited/cvai/relay/channel"
 	"github.com/ctrlc-ctrlv-limited/cvai/relay/channel/openai"
@@ -17,6 +19,7 @@ import (
 	"github.com/ctrlc-ctrlv-limited/cvai/types"
 
 	"github.com/gin-gonic/gin"
+	"github.com/tidwall/sjson"
 )
 
 type Adaptor struct {
@@ -57,32 +60,13 @@ func (a *Adaptor) ConvertAudioRequest(c *gin.Context, info *relaycommon.RelayInf
 }
 
 func (a *Adaptor) ConvertImageRequest(c *gin.Context, info *relaycommon.RelayInfo, request dto.ImageRequest) (any, error) {
+	if isGeminiImageModel(info.UpstreamModelName) {
+		return convertImageRequest2GeminiChat(c, info, request)
+	}
 	if !strings.HasPrefix(info.UpstreamModelName, "imagen") {
 		return nil, errors.New("not supported model for image generation")
 	}
 
-	// convert size to aspect ratio but allow user to specify aspect ratio
-	aspectRatio := "1:1" // default aspect ratio
-	size := strings.TrimSpace(request.Size)
-	if size != "" {
-		if strings.Contains(size, ":") {
-			aspectRatio = size
-		} else {
-			switch size {
-			case "256x256", "512x512", "1024x1024":
-				aspectRatio = "1:1"
-			case "1536x1024":
-				aspectRatio = "3:2"
-			case "1024x1536":
-				aspectRatio = "2:3"
-			case "1024x1792":
-				aspectRatio = "9:16"
-			case "1792x1024":
-				aspectRatio = "16:9"
-			}
-		}
-	}
-
 	// build gemini imagen request
 	geminiRequest := dto.GeminiImageRequest{
 		Instances: []dto.GeminiImageInstance{
@@ -91,32 +75,25 @@ func (a *Adaptor) ConvertImageRequest(c *gin.Context, info *relaycommon.RelayInf
 			},
 		},
 		Parameters: dto.GeminiImageParameters{
-			SampleCount:      int(request.N),
-			AspectRatio:      aspectRatio,
+			SampleCount: int(request.N),
+			// convert size to aspect ratio but allow user to specify aspect ratio
+			AspectRatio:      getImageAspectRatio(request.Size),
 			PersonGeneration: "allow_adult", // default allow adult
 		},
 	}
 
 	// Set imageSize when quality parameter is specified
 	// Map quality parameter to imageSize (only supported by Standard and Ultra models)
-	// quality values: auto, high, medium, low (for gpt-image-1), hd, standard (for dall-e-3)
 	// imageSize values: 1K (default), 2K
-	// https://ai.google.dev/gemini-api/docs/imagen
 	// https://platform.openai.com/docs/api-reference/images/create
 	if request.Quality != "" {
-		imageSize := "1K" // default
-		switch request.Quality {
-		case "hd", "high":
-			imageSize = "2K"
-		case "2K":
-			imageSize = "2K"
-		case "standard", "medium", "low", "auto", "1K":
-			imageSize = "1K"
-		default:
-			// unknown quality value, default to 1K
-			imageSize = "1K"
+		geminiRequest.Parameters.ImageSize = getImageSize(request.Quality, "2K")
+	}
+
+	if constant.IsImageEditMode(info.RelayMode) {
+		if err := convertImagenEditRequest(c, info, request, &geminiRequest); err != nil {
+			return nil, err
 		}
-		geminiRequest.Parameters.ImageSize = imageSize
 	}
 
 	return geminiRequest, nil
@@ -143,6 +120,10 @@ func (a *Adaptor) GetRequestURL(info *relaycommon.RelayInfo) (string, error) {
 		}
 	}
 
+	if info.RelayMode == constant.RelayModeGeminiLive {
+		return getLiveRequestURL(info.ChannelBaseUrl, info.RequestURLPath), nil
+	}
+
 	version := model_setting.GetGeminiVersionSetting(info.UpstreamModelName)
 
 	if strings.HasPrefix(info.UpstreamModelName, "imagen") {
@@ -171,6 +152,9 @@ func (a *Adaptor) GetRequestURL(info *relaycommon.RelayInfo) (string, error) {
 
 func (a *Adaptor) SetupRequestHeader(c *gin.Context, req *http.Header, info *relaycommon.RelayInfo) error {
 	channel.SetupApiRequestHeader(info, c, req)
+	if constant.IsImageEditMode(info.RelayMode) {
+		req.Set("Content-Type", gin.MIMEJSON)
+	}
 	req.Set("x-goog-api-key", info.ApiKey)
 	return nil
 }
@@ -241,10 +225,17 @@ func (a *Adaptor) ConvertOpenAIResponsesRequest(c *gin.Context, info *relaycommo
 }
 
 func (a *Adaptor) DoRequest(c *gin.Context, info *relaycommon.RelayInfo, requestBody io.Reader) (any, error) {
+	if info.RelayMode == constant.RelayModeGeminiLive {
+		return channel.DoWssRequest(a, c, info, requestBody)
+	}
 	return channel.DoApiRequest(a, c, info, requestBody)
 }
 
 func (a *Adaptor) DoResponse(c *gin.Context, resp *http.Response, info *relaycommon.RelayInfo) (usage any, err *types.CVAIError) {
+	if info.RelayMode == constant.RelayModeGeminiLive {
+		err, usage = GeminiLiveHandler(c, info, "models/"+info.UpstreamModelName)
+		return
+	}
 	if info.RelayMode == constant.RelayModeGemini {
 		if strings.Contains(info.RequestURLPath, ":embedContent") ||
 			strings.Contains(info.RequestURLPath, ":batchEmbedContents") {
@@ -261,6 +252,10 @@ func (a *Adaptor) DoResponse(c *gin.Context, resp *http.Response, info *relaycom
 		return GeminiImageHandler(c, info, resp)
 	}
 
+	if info.RelayFormat == types.RelayFormatOpenAIImage {
+		return GeminiChatImageHandler(c, info, resp)
+	}
+
 	// check if the model is an embedding model
 	if strings.HasPrefix(info.UpstreamModelName, "text-embedding") ||
 		strings.HasPrefix(info.UpstreamModelName, "embedding") ||
@@ -283,3 +278,31 @@ func (a *Adaptor) GetModelList() []string {
 func (a *Adaptor) GetChannelName() string {
 	return ChannelName
 }
+
+// CountTokens 转发到 Gemini 的 countTokens 接口
+func (a *Adaptor) CountTokens(c *gin.Context, info *relaycommon.RelayInfo, request dto.Request) (int, error) {
+	geminiRequest, ok := request.(*dto.GeminiChatRequest)
+	if !ok {
+		return 0, channel.ErrCountTokensNotSupported
+	}
+	version := model_setting.GetGeminiVersionSetting(info.UpstreamModelName)
+	fullRequestURL := fmt.Sprintf("%s/%s/models/%s:countTokens", info.ChannelBaseUrl, version, info.UpstreamModelName)
+	// 使用 generateContentRequest 的形式，使系统提示词与工具定义也计入 token 数量
+	body, err := common.Marshal(dto.GeminiCountTokensRequest{GenerateContentRequest: geminiRequest})
+	if err != nil {
+		return 0, err
+	}
+	body, err = sjson.SetBytes(body, "generateContentRequest.model", "models/"+info.UpstreamModelName)
+	if err != nil {
+		return 0, err
+	}
+	responseBody, err := channel.DoCountTokensRequest(a, c, info, fullRequestURL, json.RawMessage(body))
+	if err != nil {
+		return 0, err
+	}
+	var response dto.GeminiCountTokensResponse
+	if err = common.Unmarshal(responseBody, &response); err != nil {
+		return 0, err
+	}
+	return response.TotalTokens, nil
+}
@@ -0,0 +1,220 @@
+package gemini
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/ctrlc-ctrlv-limited/cvai/common"
+	"github.com/ctrlc-ctrlv-limited/cvai/constant"
+	"github.com/ctrlc-ctrlv-limited/cvai/dto"
+	relaycommon "github.com/ctrlc-ctrlv-limited/cvai/relay/common"
+	relayconstant "github.com/ctrlc-ctrlv-limited/cvai/relay/constant"
+	"github.com/ctrlc-ctrlv-limited/cvai/service"
+	"github.com/ctrlc-ctrlv-limited/cvai/setting/model_setting"
+	"github.com/ctrlc-ctrlv-limited/cvai/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+const imageEditMaskInstruction = "The next image is a mask: white areas mark the region to edit, keep everything else unchanged."
+
+// isGeminiImageModel 是否为通过 generateContent 生成图片的 Gemini 模型，如 gemini-2.5-flash-image
+func isGeminiImageModel(modelName string) bool {
+	if model_setting.IsGeminiModelSupportImagine(modelName) {
+		return true
+	}
+	return strings.HasPrefix(modelName, "gemini") && strings.Contains(modelName, "image")
+}
+
+// getImageAspectRatio 将 OpenAI 的尺寸转换为宽高比，允许直接传入宽高比
+func getImageAspectRatio(size string) string {
+	size = strings.TrimSpace(size)
+	if strings.Contains(size, ":") {
+		return size
+	}
+	switch size {
+	case "1536x1024":
+		return "3:2"
+	case "1024x1536":
+		return "2:3"
+	case "1024x1792":
+		return "9:16"
+	case "1792x1024":
+		return "16:9"
+	}
+	return "1:1"
+}
+
+// getImageSize 将 quality 参数转换为 imageSize
+// quality values: auto, high, medium, low (for gpt-image-1), hd, standard (for dall-e-3), 1K, 2K, 4K
+// https://ai.google.dev/gemini-api/docs/imagen
+func getImageSize(quality string, maxSize string) string {
+	imageSize := "1K"
+	switch quality {
+	case "hd", "high", "2K":
+		imageSize = "2K"
+	case "4K":
+		imageSize = maxSize
+	}
+	return imageSize
+}
+
+// convertImageRequest2GeminiChat 将图片生成、编辑与变体请求转换为 Gemini 图片模型的 generateContent 请求
+func convertImageRequest2GeminiChat(c *gin.Context, info *relaycommon.RelayInfo, request dto.ImageRequest) (*dto.GeminiChatRequest, error) {
+	parts := make([]dto.GeminiPart, 0)
+	if relayconstant.IsImageEditMode(info.RelayMode) {
+		input, err := service.GetImageEditInput(c, request)
+		if err != nil {
+			return nil, err
+		}
+		for _, image := range input.Images {
+			mimeType, data, err := service.GetImageEditFileBase64(c, image)
+			if err != nil {
+				return nil, err
+			}
+			parts = append(parts, dto.GeminiPart{
+				InlineData: &dto.GeminiInlineData{MimeType: mimeType, Data: data},
+			})
+		}
+		if input.Mask != nil {
+			mask, err := service.ConvertImageEditMask(c, input.Mask)
+			if err != nil {
+				return nil, err
+			}
+			parts = append(parts,
+				dto.GeminiPart{Text: imageEditMaskInstruction},
+				dto.GeminiPart{InlineData: &dto.GeminiInlineData{MimeType: mask.MimeType, Data: mask.Base64Data}},
+			)
+		}
+	}
+	prompt := service.GetImageEditPrompt(info.RelayMode, request.Prompt)
+	if prompt != "" {
+		parts = append(parts, dto.GeminiPart{Text: prompt})
+	}
+
+	imageConfig := map[string]string{}
+	if request.Size != "" {
+		imageConfig["aspectRatio"] = getImageAspectRatio(request.Size)
+	}
+	if request.Quality != "" {
+		imageConfig["imageSize"] = getImageSize(request.Quality, "4K")
+	}
+	geminiRequest := &dto.GeminiChatRequest{
+		Contents: []dto.GeminiChatContent{
+			{
+				Role:  "user",
+				Parts: parts,
+			},
+		},
+	}
+	geminiRequest.GenerationConfig.ResponseModalities = []string{"TEXT", "IMAGE"}
+	if request.N > 1 {
+		geminiRequest.GenerationConfig.CandidateCount = int(request.N)
+	}
+	if len(imageConfig) > 0 {
+		imageConfigBytes, err := common.Marshal(imageConfig)
+		if err != nil {
+			return nil, err
+		}
+		geminiRequest.GenerationConfig.ImageConfig = imageConfigBytes
+	}
+	return geminiRequest, nil
+}
+
+// convertImagenEditRequest 构建 Vertex AI Imagen 编辑请求，仅 Vertex AI 的 imagen capability 模型支持
+// https://cloud.google.com/vertex-ai/generative-ai/docs/model-reference/imagen-api-edit
+func convertImagenEditRequest(c *gin.Context, info *relaycommon.RelayInfo, request dto.ImageRequest, geminiRequest *dto.GeminiImageRequest) error {
+	if info.ChannelType != constant.ChannelTypeVertexAi {
+		return errors.New("imagen image editing is only supported on Vertex AI channels")
+	}
+	input, err := service.GetImageEditInput(c, request)
+	if err != nil {
+		return err
+	}
+	if len(input.Images) > 1 {
+		return errors.New("imagen image editing supports only one input image")
+	}
+	_, data, err := service.GetImageEditFileBase64(c, input.Images[0])
+	if err != nil {
+		return err
+	}
+	instance := &geminiRequest.Instances[0]
+	instance.Prompt = service.GetImageEditPrompt(info.RelayMode, request.Prompt)
+	instance.ReferenceImages = []dto.GeminiReferenceImage{
+		{
+			ReferenceType:  "REFERENCE_TYPE_RAW",
+			ReferenceId:    1,
+			ReferenceImage: dto.GeminiReferenceImageData{BytesBase64Encoded: data},
+		},
+	}
+	if input.Mask != nil {
+		mask, err := service.ConvertImageEditMask(c, input.Mask)
+		if err != nil {
+			return err
+		}
+		instance.ReferenceImages = append(instance.ReferenceImages, dto.GeminiReferenceImage{
+			ReferenceType:   "REFERENCE_TYPE_MASK",
+			ReferenceId:     2,
+			ReferenceImage:  dto.GeminiReferenceImageData{BytesBase64Encoded: mask.Base64Data},
+			MaskImageConfig: &dto.GeminiReferenceMaskConfig{MaskMode: "MASK_MODE_USER_PROVIDED"},
+		})
+		geminiRequest.Parameters.EditMode = "EDIT_MODE_INPAINT_INSERTION"
+	}
+	// 编辑时输出尺寸与输入图片一致
+	geminiRequest.Parameters.AspectRatio = ""
+	geminiRequest.Parameters.ImageSize = ""
+	return nil
+}
+
+// GeminiChatImageHandler 将 Gemini 图片模型 generateContent 的响应转换为 OpenAI 图片响应
+func GeminiChatImageHandler(c *gin.Context, info *relaycommon.RelayInfo, resp *http.Response) (*dto.Usage, *types.CVAIError) {
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, types.NewOpenAIError(err, types.ErrorCodeBadResponseBody, http.StatusInternalServerError)
+	}
+	service.CloseResponseBodyGracefully(resp)
+
+	var geminiResponse dto.GeminiChatResponse
+	if err = common.Unmarshal(responseBody, &geminiResponse); err != nil {
+		return nil, types.NewOpenAIError(err, types.ErrorCodeBadResponseBody, http.StatusInternalServerError)
+	}
+
+	imageResponse := dto.ImageResponse{
+		Created: common.GetTimestamp(),
+		Data:    make([]dto.ImageData, 0),
+	}
+	for _, candidate := range geminiResponse.Candidates {
+		texts := make([]string, 0)
+		images := make([]dto.ImageData, 0)
+		for _, part := range candidate.Content.Parts {
+			if part.InlineData != nil && strings.HasPrefix(part.InlineData.MimeType, "image") {
+				images = append(images, dto.ImageData{B64Json: part.InlineData.Data})
+			} else if part.Text != "" && !part.Thought {
+				texts = append(texts, part.Text)
+			}
+		}
+		for i := range images {
+			images[i].RevisedPrompt = strings.Join(texts, "\n")
+		}
+		imageResponse.Data = append(imageResponse.Data, images...)
+	}
+	if len(imageResponse.Data) == 0 {
+		return nil, types.NewOpenAIError(errors.New("no images generated"), types.ErrorCodeBadResponseBody, http.StatusInternalServerError)
+	}
+	info.ImageCount = len(imageResponse.Data)
+
+	jsonResponse, err := common.Marshal(imageResponse)
+	if err != nil {
+		return nil, types.NewError(err, types.ErrorCodeBadResponseBody)
+	}
+	service.IOCopyBytesGracefully(c, resp, jsonResponse)
+
+	usage := &dto.Usage{
+		PromptTokens: geminiResponse.UsageMetadata.PromptTokenCount,
+		TotalTokens:  geminiResponse.UsageMetadata.TotalTokenCount,
+	}
+	usage.CompletionTokens = usage.TotalTokens - usage.PromptTokens
+	return usage, nil
+}
@@ -0,0 +1,170 @@
+package gemini
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ctrlc-ctrlv-limited/cvai/common"
+	"github.com/ctrlc-ctrlv-limited/cvai/dto"
+	"github.com/ctrlc-ctrlv-limited/cvai/logger"
+	relaycommon "github.com/ctrlc-ctrlv-limited/cvai/relay/common"
+	"github.com/ctrlc-ctrlv-limited/cvai/relay/helper"
+	"github.com/ctrlc-ctrlv-limited/cvai/service"
+	"github.com/ctrlc-ctrlv-limited/cvai/types"
+
+	"github.com/bytedance/gopkg/util/gopool"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/tidwall/sjson"
+)
+
+// getLiveRequestURL Gemini Live 的上游地址与客户端请求的路径一致，如 /ws/google.ai.generativelanguage.v1beta.GenerativeService.BidiGenerateContent
+func getLiveRequestURL(baseURL string, requestURLPath string) string {
+	if strings.HasPrefix(baseURL, "https://") {
+		baseURL = "wss://" + strings.TrimPrefix(baseURL, "https://")
+	} else if strings.HasPrefix(baseURL, "http://") {
+		baseURL = "ws://" + strings.TrimPrefix(baseURL, "http://")
+	}
+	// 去掉查询参数，其中可能包含令牌
+	path := strings.Split(requestURLPath, "?")[0]
+	return strings.TrimSuffix(baseURL, "/") + path
+}
+
+// GeminiLiveHandler 将 setup 消息的模型改写为 setupModel 后发送到上游，之后双向转发消息，
+// 并按上游返回的 usageMetadata 逐轮扣费
+func GeminiLiveHandler(c *gin.Context, info *relaycommon.RelayInfo, setupModel string) (*types.CVAIError, *dto.RealtimeUsage) {
+	if info == nil || info.ClientWs == nil || info.TargetWs == nil {
+		return types.NewError(fmt.Errorf("invalid websocket connection"), types.ErrorCodeBadResponse), nil
+	}
+	liveRequest, ok := info.Request.(*dto.GeminiLiveRequest)
+	if !ok {
+		return types.NewError(fmt.Errorf("invalid request type, expected dto.GeminiLiveRequest, got %T", info.Request), types.ErrorCodeInvalidRequest, types.ErrOptionWithSkipRetry()), nil
+	}
+	setup, err := sjson.SetBytes(liveRequest.Setup, "setup.model", setupModel)
+	if err != nil {
+		return types.NewError(err, types.ErrorCodeConvertRequestFailed, types.ErrOptionWithSkipRetry()), nil
+	}
+	if err = info.TargetWs.WriteMessage(websocket.TextMessage, setup); err != nil {
+		return types.NewError(err, types.ErrorCodeDoRequestFailed), nil
+	}
+
+	info.IsStream = true
+	clientConn := info.ClientWs
+	targetConn := info.TargetWs
+
+	clientClosed := make(chan struct{})
+	targetClosed := make(chan struct{})
+	errChan := make(chan error, 2)
+
+	var usageLock sync.Mutex
+	var pendingUsage *dto.RealtimeUsage
+	sumUsage := &dto.RealtimeUsage{}
+	// consumeUsage 结算一轮对话的用量
+	consumeUsage := func() error {
+		usageLock.Lock()
+		defer usageLock.Unlock()
+		if pendingUsage == nil {
+			return nil
+		}
+		usage := pendingUsage
+		pendingUsage = nil
+		sumUsage.TotalTokens += usage.TotalTokens
+		sumUsage.InputTokens += usage.InputTokens
+		sumUsage.OutputTokens += usage.OutputTokens
+		sumUsage.InputTokenDetails.CachedTokens += usage.InputTokenDetails.CachedTokens
+		sumUsage.InputTokenDetails.TextTokens += usage.InputTokenDetails.TextTokens
+		sumUsage.InputTokenDetails.AudioTokens += usage.InputTokenDetails.AudioTokens
+		sumUsage.OutputTokenDetails.TextTokens += usage.OutputTokenDetails.TextTokens
+		sumUsage.OutputTokenDetails.AudioTokens += usage.OutputTokenDetails.AudioTokens
+		return service.PreWssConsumeQuota(c, info, usage)
+	}
+
+	gopool.Go(func() {
+		defer func() {
+			if r := recover(); r != nil {
+				errChan <- fmt.Errorf("panic in client reader: %v", r)
+			}
+		}()
+		for {
+			messageType, message, err := clientConn.ReadMessage()
+			if err != nil {
+				if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+					errChan <- fmt.Errorf("error reading from client: %v", err)
+				}
+				close(clientClosed)
+				return
+			}
+			if err = targetConn.WriteMessage(messageType, message); err != nil {
+				errChan <- fmt.Errorf("error writing to target: %v", err)
+				return
+			}
+		}
+	})
+
+	gopool.Go(func() {
+		defer func() {
+			if r := recover(); r != nil {
+				errChan <- fmt.Errorf("panic in target reader: %v", r)
+			}
+		}()
+		turnComplete := false
+		for {
+			// Gemini Live 以二进制帧发送 JSON 消息，原样转发
+			messageType, message, err := targetConn.ReadMessage()
+			if err != nil {
+				if closeErr, ok := err.(*websocket.CloseError); ok && closeErr.Code != websocket.CloseNormalClosure {
+					// 将上游的错误原样返回给客户端
+					_ = clientConn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(closeErr.Code, closeErr.Text), time.Now().Add(time.Second))
+				}
+				if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+					errChan <- fmt.Errorf("error reading from target: %v", err)
+				}
+				close(targetClosed)
+				return
+			}
+			info.SetFirstResponseTime()
+
+			var serverMessage dto.GeminiLiveServerMessage
+			if err = common.Unmarshal(message, &serverMessage); err == nil {
+				if serverMessage.ServerContent != nil {
+					turnComplete = serverMessage.ServerContent.TurnComplete
+				}
+				if serverMessage.UsageMetadata != nil {
+					usageLock.Lock()
+					pendingUsage = serverMessage.UsageMetadata.ToRealtimeUsage()
+					usageLock.Unlock()
+				}
+				if turnComplete {
+					if err = consumeUsage(); err != nil {
+						helper.GeminiLiveError(clientConn, http.StatusForbidden, err.Error())
+						errChan <- fmt.Errorf("error consume usage: %v", err)
+						return
+					}
+				}
+			}
+
+			if err = clientConn.WriteMessage(messageType, message); err != nil {
+				errChan <- fmt.Errorf("error writing to client: %v", err)
+				return
+			}
+		}
+	})
+
+	select {
+	case <-clientClosed:
+	case <-targetClosed:
+	case err := <-errChan:
+		logger.LogError(c, "gemini live error: "+err.Error())
+	case <-c.Done():
+	}
+
+	// 结算未完成的一轮对话
+	_ = consumeUsage()
+	usageLock.Lock()
+	defer usageLock.Unlock()
+	usage := *sumUsage
+	return nil, &usage
+}
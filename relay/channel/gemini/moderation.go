@@ -0,0 +1,93 @@
+package gemini
+
+import (
+	"fmt"
+
+	"github.com/ctrlc-ctrlv-limited/cvai/dto"
+	"github.com/ctrlc-ctrlv-limited/cvai/relay/channel"
+	relaycommon "github.com/ctrlc-ctrlv-limited/cvai/relay/common"
+	"github.com/ctrlc-ctrlv-limited/cvai/relay/helper"
+	"github.com/ctrlc-ctrlv-limited/cvai/setting/model_setting"
+	"github.com/ctrlc-ctrlv-limited/cvai/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Gemini 安全评级分类对应的 OpenAI moderation 分类
+var moderationCategories = map[string]string{
+	"HARM_CATEGORY_HARASSMENT":        dto.ModerationCategoryHarassment,
+	"HARM_CATEGORY_HATE_SPEECH":       dto.ModerationCategoryHate,
+	"HARM_CATEGORY_SEXUALLY_EXPLICIT": dto.ModerationCategorySexual,
+	"HARM_CATEGORY_DANGEROUS_CONTENT": dto.ModerationCategoryIllicit,
+}
+
+// 安全评级的概率等级对应的得分，MEDIUM 及以上视为命中
+var moderationProbabilityScores = map[string]float64{
+	"NEGLIGIBLE": 0.05,
+	"LOW":        0.3,
+	"MEDIUM":     0.6,
+	"HIGH":       0.9,
+}
+
+// Moderate 逐项输入调用 generateContent，使用最严格的安全阈值，
+// 将 promptFeedback 与候选结果的 safetyRatings 转换为 OpenAI moderation 结果
+func (a *Adaptor) Moderate(c *gin.Context, info *relaycommon.RelayInfo, request *dto.ModerationRequest) (*dto.ModerationResponse, *dto.Usage, *types.CVAIError) {
+	version := model_setting.GetGeminiVersionSetting(info.UpstreamModelName)
+	fullRequestURL := fmt.Sprintf("%s/%s/models/%s:generateContent", info.ChannelBaseUrl, version, info.UpstreamModelName)
+	safetySettings := make([]dto.GeminiChatSafetySettings, 0, len(SafetySettingList))
+	for _, category := range SafetySettingList {
+		safetySettings = append(safetySettings, dto.GeminiChatSafetySettings{
+			Category:  category,
+			Threshold: "BLOCK_LOW_AND_ABOVE",
+		})
+	}
+	response := &dto.ModerationResponse{
+		Id:    helper.GetModerationID(c),
+		Model: info.UpstreamModelName,
+	}
+	usage := &dto.Usage{}
+	for _, text := range request.GetInputTexts() {
+		geminiRequest := &dto.GeminiChatRequest{
+			Contents: []dto.GeminiChatContent{{
+				Role:  "user",
+				Parts: []dto.GeminiPart{{Text: text}},
+			}},
+			SafetySettings: safetySettings,
+			// 只需要安全评级，不需要完整的回复
+			GenerationConfig: dto.GeminiChatGenerationConfig{MaxOutputTokens: 16},
+		}
+		var geminiResponse dto.GeminiChatResponse
+		if newAPIError := channel.DoModerationRequest(a, c, info, fullRequestURL, geminiRequest, &geminiResponse); newAPIError != nil {
+			return nil, nil, newAPIError
+		}
+		response.Results = append(response.Results, geminiModerationResult(&geminiResponse))
+		usage.PromptTokens += geminiResponse.UsageMetadata.PromptTokenCount
+		usage.CompletionTokens += geminiResponse.UsageMetadata.CandidatesTokenCount
+	}
+	usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	return response, usage, nil
+}
+
+func geminiModerationResult(response *dto.GeminiChatResponse) dto.ModerationResult {
+	result := dto.NewModerationResult()
+	ratings := make([]dto.GeminiChatSafetyRating, 0)
+	if response.PromptFeedback != nil {
+		ratings = append(ratings, response.PromptFeedback.SafetyRatings...)
+		// 输入因违禁内容（如 CSAM）被拦截时没有对应的安全评级
+		if response.PromptFeedback.BlockReason != nil && *response.PromptFeedback.BlockReason == "PROHIBITED_CONTENT" {
+			result.SetCategoryScore(dto.ModerationCategorySexualMinors, 1, true)
+		}
+	}
+	for _, candidate := range response.Candidates {
+		ratings = append(ratings, candidate.SafetyRatings...)
+	}
+	for _, rating := range ratings {
+		category, ok := moderationCategories[rating.Category]
+		if !ok {
+			continue
+		}
+		score := moderationProbabilityScores[rating.Probability]
+		result.SetCategoryScore(category, score, score >= moderationProbabilityScores["MEDIUM"])
+	}
+	return result
+}
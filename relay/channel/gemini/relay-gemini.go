@@ -1343,6 +1343,11 @@ func GeminiImageHandler(c *gin.Context, info *relaycommon.RelayInfo, resp *http.
 		})
 	}
 
+	if len(openAIResponse.Data) == 0 {
+		return nil, types.NewOpenAIError(errors.New("all generated images were filtered"), types.ErrorCodeBadResponseBody, http.StatusInternalServerError)
+	}
+	info.ImageCount = len(openAIResponse.Data)
+
 	jsonResponse, jsonErr := json.Marshal(openAIResponse)
 	if jsonErr != nil {
 		return nil, types.NewError(jsonErr, types.ErrorCodeBadResponseBody)
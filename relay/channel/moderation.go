@@ -0,0 +1,91 @@
+package channel
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+
+	common2 "github.com/ctrlc-ctrlv-limited/cvai/common"
+	"github.com/ctrlc-ctrlv-limited/cvai/dto"
+	"github.com/ctrlc-ctrlv-limited/cvai/relay/common"
+	"github.com/ctrlc-ctrlv-limited/cvai/service"
+	"github.com/ctrlc-ctrlv-limited/cvai/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Llama Guard 3/4 的违规分类（S1-S14）对应的 OpenAI moderation 分类，没有对应分类的只标记 flagged
+var llamaGuardCategories = map[string][]string{
+	"S1":  {dto.ModerationCategoryViolence, dto.ModerationCategoryIllicitViolent}, // Violent Crimes
+	"S2":  {dto.ModerationCategoryIllicit},                                        // Non-Violent Crimes
+	"S3":  {dto.ModerationCategorySexual, dto.ModerationCategoryIllicit},          // Sex-Related Crimes
+	"S4":  {dto.ModerationCategorySexualMinors},                                   // Child Sexual Exploitation
+	"S5":  {dto.ModerationCategoryHarassment},                                     // Defamation
+	"S9":  {dto.ModerationCategoryIllicitViolent},                                 // Indiscriminate Weapons
+	"S10": {dto.ModerationCategoryHate},                                           // Hate
+	"S11": {dto.ModerationCategorySelfHarm},                                       // Suicide & Self-Harm
+	"S12": {dto.ModerationCategorySexual},                                         // Sexual Content
+	"S14": {dto.ModerationCategoryIllicit},                                        // Code Interpreter Abuse
+}
+
+// Qwen3Guard 等以名称输出违规分类的安全模型
+var guardCategoryNames = map[string][]string{
+	"violent":                       {dto.ModerationCategoryViolence},
+	"non-violent illegal acts":      {dto.ModerationCategoryIllicit},
+	"sexual content or sexual acts": {dto.ModerationCategorySexual},
+	"suicide & self-harm":           {dto.ModerationCategorySelfHarm},
+}
+
+var llamaGuardCodeRegexp = regexp.MustCompile(`\bS\d{1,2}\b`)
+
+// GuardModerationResult 解析 Llama Guard、Qwen3Guard 等安全分类模型的输出（safe / unsafe 及违规分类），转换为 OpenAI moderation 结果
+func GuardModerationResult(output string) dto.ModerationResult {
+	result := dto.NewModerationResult()
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	verdict := strings.ToLower(strings.TrimSpace(lines[0]))
+	if verdict != "unsafe" && !strings.HasPrefix(verdict, "safety: unsafe") {
+		return result
+	}
+	result.Flagged = true
+	for _, line := range lines[1:] {
+		line = strings.TrimSpace(line)
+		if name, ok := strings.CutPrefix(strings.ToLower(line), "categories:"); ok {
+			for _, item := range strings.Split(name, ",") {
+				for _, category := range guardCategoryNames[strings.TrimSpace(item)] {
+					result.SetCategoryScore(category, 1, true)
+				}
+			}
+			continue
+		}
+		for _, code := range llamaGuardCodeRegexp.FindAllString(line, -1) {
+			for _, category := range llamaGuardCategories[code] {
+				result.SetCategoryScore(category, 1, true)
+			}
+		}
+	}
+	return result
+}
+
+// DoModerationRequest 请求上游的审核接口并解析 JSON 响应，非 200 响应按上游错误处理以便重试其他渠道
+func DoModerationRequest(a Adaptor, c *gin.Context, info *common.RelayInfo, fullRequestURL string, body any, response any) *types.CVAIError {
+	resp, err := DoJSONRequest(a, c, info, fullRequestURL, body)
+	if err != nil {
+		return types.NewOpenAIError(err, types.ErrorCodeDoRequestFailed, http.StatusInternalServerError)
+	}
+	if resp.StatusCode != http.StatusOK {
+		newAPIError := service.RelayErrorHandler(c.Request.Context(), resp, false)
+		service.ResetStatusCode(newAPIError, c.GetString("status_code_mapping"))
+		return newAPIError
+	}
+	defer service.CloseResponseBodyGracefully(resp)
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return types.NewOpenAIError(err, types.ErrorCodeReadResponseBodyFailed, http.StatusInternalServerError)
+	}
+	if err = common2.Unmarshal(responseBody, response); err != nil {
+		return types.NewOpenAIError(fmt.Errorf("unmarshal moderation response failed: %w", err), types.ErrorCodeBadResponseBody, http.StatusInternalServerError)
+	}
+	return nil
+}
@@ -11,6 +11,7 @@ import (
 	"github.com/ctrlc-ctrlv-limited/cvai/relay/channel/openai"
 	relaycommon "github.com/ctrlc-ctrlv-limited/cvai/relay/common"
 	relayconstant "github.com/ctrlc-ctrlv-limited/cvai/relay/constant"
+	"github.com/ctrlc-ctrlv-limited/cvai/relay/helper"
 	"github.com/ctrlc-ctrlv-limited/cvai/types"
 
 	"github.com/gin-gonic/gin"
@@ -109,3 +110,33 @@ func (a *Adaptor) GetModelList() []string {
 func (a *Adaptor) GetChannelName() string {
 	return ChannelName
 }
+
+// Moderate 逐项输入调用 /api/chat，由 llama-guard3 等安全分类模型判断，输出转换为 OpenAI moderation 结果
+func (a *Adaptor) Moderate(c *gin.Context, info *relaycommon.RelayInfo, request *dto.ModerationRequest) (*dto.ModerationResponse, *dto.Usage, *types.CVAIError) {
+	fullRequestURL := info.ChannelBaseUrl + "/api/chat"
+	response := &dto.ModerationResponse{
+		Id:    helper.GetModerationID(c),
+		Model: info.UpstreamModelName,
+	}
+	usage := &dto.Usage{}
+	for _, text := range request.GetInputTexts() {
+		// stream 默认为 true，需要显式关闭
+		chatRequest := map[string]any{
+			"model":    info.UpstreamModelName,
+			"messages": []OllamaChatMessage{{Role: "user", Content: text}},
+			"stream":   false,
+		}
+		var chatResponse ollamaChatStreamChunk
+		if newAPIError := channel.DoModerationRequest(a, c, info, fullRequestURL, chatRequest, &chatResponse); newAPIError != nil {
+			return nil, nil, newAPIError
+		}
+		if chatResponse.Message == nil {
+			return nil, nil, types.NewOpenAIError(errors.New("ollama returned no message"), types.ErrorCodeBadResponseBody, http.StatusInternalServerError)
+		}
+		response.Results = append(response.Results, channel.GuardModerationResult(chatResponse.Message.Content))
+		usage.PromptTokens += chatResponse.PromptEvalCount
+		usage.CompletionTokens += chatResponse.EvalCount
+	}
+	usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	return response, usage, nil
+}
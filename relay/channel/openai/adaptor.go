@@ -26,6 +26,7 @@ import (
 	relaycommon "github.com/ctrlc-ctrlv-limited/cvai/relay/common"
 	"github.com/ctrlc-ctrlv-limited/cvai/relay/common_handler"
 	relayconstant "github.com/ctrlc-ctrlv-limited/cvai/relay/constant"
+	"github.com/ctrlc-ctrlv-limited/cvai/relay/helper"
 	"github.com/ctrlc-ctrlv-limited/cvai/service"
 	"github.com/ctrlc-ctrlv-limited/cvai/setting/model_setting"
 	"github.com/ctrlc-ctrlv-limited/cvai/types"
@@ -411,7 +412,11 @@ func (a *Adaptor) ConvertAudioRequest(c *gin.Context, info *relaycommon.RelayInf
 
 func (a *Adaptor) ConvertImageRequest(c *gin.Context, info *relaycommon.RelayInfo, request dto.ImageRequest) (any, error) {
 	switch info.RelayMode {
-	case relayconstant.RelayModeImagesEdits:
+	case relayconstant.RelayModeImagesEdits, relayconstant.RelayModeImagesVariations:
+		// JSON 格式的图片编辑请求直接转发
+		if !strings.Contains(c.Request.Header.Get("Content-Type"), "multipart/form-data") {
+			return request, nil
+		}
 
 		var requestBody bytes.Buffer
 		writer := multipart.NewWriter(&requestBody)
@@ -576,7 +581,7 @@ func (a *Adaptor) ConvertOpenAIResponsesRequest(c *gin.Context, info *relaycommo
 func (a *Adaptor) DoRequest(c *gin.Context, info *relaycommon.RelayInfo, requestBody io.Reader) (any, error) {
 	if info.RelayMode == relayconstant.RelayModeAudioTranscription ||
 		info.RelayMode == relayconstant.RelayModeAudioTranslation ||
-		info.RelayMode == relayconstant.RelayModeImagesEdits {
+		relayconstant.IsImageEditMode(info.RelayMode) {
 		return channel.DoFormRequest(a, c, info, requestBody)
 	} else if info.RelayMode == relayconstant.RelayModeRealtime {
 		return channel.DoWssRequest(a, c, info, requestBody)
@@ -595,7 +600,7 @@ func (a *Adaptor) DoResponse(c *gin.Context, resp *http.Response, info *relaycom
 		fallthrough
 	case relayconstant.RelayModeAudioTranscription:
 		err, usage = OpenaiSTTHandler(c, resp, info, a.ResponseFormat)
-	case relayconstant.RelayModeImagesGenerations, relayconstant.RelayModeImagesEdits:
+	case relayconstant.RelayModeImagesGenerations, relayconstant.RelayModeImagesEdits, relayconstant.RelayModeImagesVariations:
 		usage, err = OpenaiHandlerWithUsage(c, info, resp)
 	case relayconstant.RelayModeRerank:
 		usage, err = common_handler.RerankHandler(c, info, resp)
@@ -648,3 +653,64 @@ func (a *Adaptor) GetChannelName() string {
 		return ChannelName
 	}
 }
+
+// CountTokens 转发到 OpenAI 的 /v1/responses/input_tokens 接口，仅 OpenAI 渠道支持
+func (a *Adaptor) CountTokens(c *gin.Context, info *relaycommon.RelayInfo, request dto.Request) (int, error) {
+	responsesRequest, ok := request.(*dto.OpenAIResponsesRequest)
+	if !ok || info.ChannelType != constant.ChannelTypeOpenAI {
+		return 0, channel.ErrCountTokensNotSupported
+	}
+	fullRequestURL := fmt.Sprintf("%s/v1/responses/input_tokens", info.ChannelBaseUrl)
+	responseBody, err := channel.DoCountTokensRequest(a, c, info, fullRequestURL, responsesRequest.ToInputTokensRequest(info.UpstreamModelName))
+	if err != nil {
+		return 0, err
+	}
+	var response dto.OpenAIResponsesInputTokensResponse
+	if err = common.Unmarshal(responseBody, &response); err != nil {
+		return 0, err
+	}
+	return response.InputTokens, nil
+}
+
+// Moderate omni-moderation 与 text-moderation 模型转发到 /v1/moderations，
+// 其他模型视为部署在兼容渠道（如 Xinference）上的 Llama Guard 等安全分类模型，逐项输入调用 chat/completions 并解析输出
+func (a *Adaptor) Moderate(c *gin.Context, info *relaycommon.RelayInfo, request *dto.ModerationRequest) (*dto.ModerationResponse, *dto.Usage, *types.CVAIError) {
+	if info.ChannelType == constant.ChannelTypeAzure {
+		return nil, nil, types.NewError(errors.New("moderation is not supported by azure channel"), types.ErrorCodeInvalidApiType)
+	}
+	if strings.Contains(info.UpstreamModelName, "moderation") {
+		fullRequestURL := relaycommon.GetFullRequestURL(info.ChannelBaseUrl, "/v1/moderations", info.ChannelType)
+		var response dto.ModerationResponse
+		if newAPIError := channel.DoModerationRequest(a, c, info, fullRequestURL, request, &response); newAPIError != nil {
+			return nil, nil, newAPIError
+		}
+		// moderations 接口不返回用量，按估算的输入 token 计费
+		promptTokens := info.GetEstimatePromptTokens()
+		return &response, &dto.Usage{PromptTokens: promptTokens, TotalTokens: promptTokens}, nil
+	}
+
+	fullRequestURL := relaycommon.GetFullRequestURL(info.ChannelBaseUrl, "/v1/chat/completions", info.ChannelType)
+	response := &dto.ModerationResponse{
+		Id:    helper.GetModerationID(c),
+		Model: info.UpstreamModelName,
+	}
+	usage := &dto.Usage{}
+	for _, text := range request.GetInputTexts() {
+		chatRequest := &dto.GeneralOpenAIRequest{
+			Model:    info.UpstreamModelName,
+			Messages: []dto.Message{{Role: "user", Content: text}},
+		}
+		var chatResponse dto.OpenAITextResponse
+		if newAPIError := channel.DoModerationRequest(a, c, info, fullRequestURL, chatRequest, &chatResponse); newAPIError != nil {
+			return nil, nil, newAPIError
+		}
+		if len(chatResponse.Choices) == 0 {
+			return nil, nil, types.NewOpenAIError(errors.New("guard model returned no choices"), types.ErrorCodeBadResponseBody, http.StatusInternalServerError)
+		}
+		response.Results = append(response.Results, channel.GuardModerationResult(chatResponse.Choices[0].Message.StringContent()))
+		usage.PromptTokens += chatResponse.Usage.PromptTokens
+		usage.CompletionTokens += chatResponse.Usage.CompletionTokens
+	}
+	usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	return response, usage, nil
+}
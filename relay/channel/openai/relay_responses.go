@@ -34,6 +34,11 @@ func OaiResponsesHandler(c *gin.Context, info *relaycommon.RelayInfo, resp *http
 		return nil, types.WithOpenAIError(*oaiError, resp.StatusCode)
 	}
 
+	if info != nil && info.ResponsesUsageInfo != nil {
+		info.ResponseId = responsesResponse.ID
+		info.ResponseStatus = responsesResponse.Status
+	}
+
 	if responsesResponse.HasImageGenerationCall() {
 		c.Set("image_generation_call", true)
 		c.Set("image_generation_call_quality", responsesResponse.GetQuality())
@@ -58,13 +63,21 @@ func OaiResponsesHandler(c *gin.Context, info *relaycommon.RelayInfo, resp *http
 	}
 	// 解析 Tools 用量
 	for _, tool := range responsesResponse.Tools {
-		buildToolinfo, ok := info.ResponsesUsageInfo.BuiltInTools[common.Interface2String(tool["type"])]
+		toolType := common.Interface2String(tool["type"])
+		if toolType == dto.BuildInToolFileSearch {
+			// file_search 按实际调用次数计费
+			continue
+		}
+		buildToolinfo, ok := info.ResponsesUsageInfo.BuiltInTools[toolType]
 		if !ok || buildToolinfo == nil {
 			logger.LogError(c, fmt.Sprintf("BuiltInTools not found for tool type: %v", tool["type"]))
 			continue
 		}
 		buildToolinfo.CallCount++
 	}
+	if fileSearchTool, exists := info.ResponsesUsageInfo.BuiltInTools[dto.BuildInToolFileSearch]; exists && fileSearchTool != nil {
+		fileSearchTool.CallCount += responsesResponse.CountOutputType(dto.BuildInCallFileSearchCall)
+	}
 	return &usage, nil
 }
 
@@ -85,6 +98,10 @@ func OaiResponsesStreamHandler(c *gin.Context, info *relaycommon.RelayInfo, resp
 		var streamResponse dto.ResponsesStreamResponse
 		if err := common.UnmarshalJsonStr(data, &streamResponse); err == nil {
 			sendResponsesStreamData(c, streamResponse, data)
+			if streamResponse.Response != nil && streamResponse.Response.ID != "" && info != nil && info.ResponsesUsageInfo != nil {
+				info.ResponseId = streamResponse.Response.ID
+				info.ResponseStatus = streamResponse.Response.Status
+			}
 			switch streamResponse.Type {
 			case "response.completed":
 				if streamResponse.Response != nil {
@@ -121,6 +138,12 @@ func OaiResponsesStreamHandler(c *gin.Context, info *relaycommon.RelayInfo, resp
 								webSearchTool.CallCount++
 							}
 						}
+					case dto.BuildInCallFileSearchCall:
+						if info != nil && info.ResponsesUsageInfo != nil && info.ResponsesUsageInfo.BuiltInTools != nil {
+							if fileSearchTool, exists := info.ResponsesUsageInfo.BuiltInTools[dto.BuildInToolFileSearch]; exists && fileSearchTool != nil {
+								fileSearchTool.CallCount++
+							}
+						}
 					}
 				}
 			}
@@ -2,10 +2,12 @@ package replicate
 
 import (
 	"bytes"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"mime"
 	"mime/multipart"
 	"net/http"
 	"net/textproto"
@@ -54,7 +56,7 @@ func (a *Adaptor) SetupRequestHeader(c *gin.Context, req *http.Header, info *rel
 	channel.SetupApiRequestHeader(info, c, req)
 	req.Set("Authorization", "Bearer "+info.ApiKey)
 	req.Set("Prefer", "wait")
-	if req.Get("Content-Type") == "" {
+	if req.Get("Content-Type") == "" || relayconstant.IsImageEditMode(info.RelayMode) {
 		req.Set("Content-Type", "application/json")
 	}
 	if req.Get("Accept") == "" {
@@ -72,6 +74,7 @@ func (a *Adaptor) ConvertImageRequest(c *gin.Context, info *relaycommon.RelayInf
 			request.Prompt = v
 		}
 	}
+	request.Prompt = service.GetImageEditPrompt(info.RelayMode, request.Prompt)
 	if strings.TrimSpace(request.Prompt) == "" {
 		return nil, errors.New("replicate adaptor: prompt is required")
 	}
@@ -123,15 +126,10 @@ func (a *Adaptor) ConvertImageRequest(c *gin.Context, info *relaycommon.RelayInf
 		inputPayload["prompt_upsampling"] = true
 	}
 
-	if info.RelayMode == relayconstant.RelayModeImagesEdits {
-		imageURL, err := uploadFileFromForm(c, info, "image", "image[]", "image_prompt")
-		if err != nil {
+	if relayconstant.IsImageEditMode(info.RelayMode) {
+		if err := setImageEditInput(c, info, request, inputPayload); err != nil {
 			return nil, err
 		}
-		if imageURL == "" {
-			return nil, errors.New("replicate adaptor: image file is required for edits")
-		}
-		inputPayload["image_prompt"] = imageURL
 	}
 
 	if len(request.ExtraFields) > 0 {
@@ -276,6 +274,9 @@ func (a *Adaptor) DoResponse(c *gin.Context, resp *http.Response, info *relaycom
 	if len(imageResponse.Data) == 0 {
 		return nil, types.NewError(errors.New("replicate adaptor: no usable image data"), types.ErrorCodeBadResponse)
 	}
+	if info != nil {
+		info.ImageCount = len(imageResponse.Data)
+	}
 
 	responseBytes, err := common.Marshal(imageResponse)
 	if err != nil {
@@ -396,57 +397,69 @@ func normalizeFluxDimension(value int) int {
 	return value
 }
 
-func uploadFileFromForm(c *gin.Context, info *relaycommon.RelayInfo, fieldCandidates ...string) (string, error) {
-	if info == nil {
-		return "", errors.New("replicate adaptor: relay info is nil")
-	}
-
-	mf := c.Request.MultipartForm
-	if mf == nil {
-		if _, err := c.MultipartForm(); err != nil {
-			return "", fmt.Errorf("replicate adaptor: parse multipart form failed: %w", err)
-		}
-		mf = c.Request.MultipartForm
-	}
-	if mf == nil || len(mf.File) == 0 {
-		return "", nil
-	}
-
-	if len(fieldCandidates) == 0 {
-		fieldCandidates = []string{"image", "image[]", "image_prompt"}
+// setImageEditInput 按模型设置编辑与变体的输入图片，kontext 模型使用 input_image，fill 模型使用 image 与 mask，其余模型使用 image_prompt
+func setImageEditInput(c *gin.Context, info *relaycommon.RelayInfo, request dto.ImageRequest, inputPayload map[string]any) error {
+	input, err := service.GetImageEditInput(c, request)
+	if err != nil {
+		return err
 	}
-
-	var fileHeader *multipart.FileHeader
-	for _, key := range fieldCandidates {
-		if files := mf.File[key]; len(files) > 0 {
-			fileHeader = files[0]
-			break
-		}
+	imageURL, err := getImageEditFileURL(info, input.Images[0])
+	if err != nil {
+		return err
 	}
-	if fileHeader == nil {
-		for _, files := range mf.File {
-			if len(files) > 0 {
-				fileHeader = files[0]
-				break
+	modelName := strings.ToLower(info.UpstreamModelName)
+	switch {
+	case strings.Contains(modelName, "kontext"):
+		inputPayload["input_image"] = imageURL
+	case strings.Contains(modelName, "fill"):
+		inputPayload["image"] = imageURL
+		if input.Mask != nil {
+			mask, err := service.ConvertImageEditMask(c, input.Mask)
+			if err != nil {
+				return err
+			}
+			maskURL, err := getImageEditFileURL(info, mask)
+			if err != nil {
+				return err
 			}
+			inputPayload["mask"] = maskURL
 		}
+		return nil
+	default:
+		inputPayload["image_prompt"] = imageURL
 	}
-	if fileHeader == nil {
-		return "", nil
+	if input.Mask != nil {
+		return fmt.Errorf("replicate adaptor: model %s does not support mask", info.UpstreamModelName)
 	}
+	return nil
+}
 
-	file, err := fileHeader.Open()
+// getImageEditFileURL URL 图片直接提交，上传的图片先上传到 Replicate 文件接口
+func getImageEditFileURL(info *relaycommon.RelayInfo, file *types.LocalFileData) (string, error) {
+	if file.Url != "" {
+		return file.Url, nil
+	}
+	data, err := base64.StdEncoding.DecodeString(file.Base64Data)
 	if err != nil {
-		return "", fmt.Errorf("replicate adaptor: failed to open image file: %w", err)
+		return "", fmt.Errorf("replicate adaptor: failed to decode image: %w", err)
+	}
+	return uploadFile(info, data, file.MimeType)
+}
+
+func uploadFile(info *relaycommon.RelayInfo, data []byte, contentType string) (string, error) {
+	if info == nil {
+		return "", errors.New("replicate adaptor: relay info is nil")
 	}
-	defer file.Close()
 
 	var body bytes.Buffer
 	writer := multipart.NewWriter(&body)
 
+	filename := "image"
+	if exts, _ := mime.ExtensionsByType(contentType); len(exts) > 0 {
+		filename += exts[0]
+	}
 	hdr := make(textproto.MIMEHeader)
-	hdr.Set("Content-Disposition", fmt.Sprintf("form-data; name=\"content\"; filename=\"%s\"", fileHeader.Filename))
-	contentType := fileHeader.Header.Get("Content-Type")
+	hdr.Set("Content-Disposition", fmt.Sprintf("form-data; name=\"content\"; filename=\"%s\"", filename))
 	if contentType == "" {
 		contentType = "application/octet-stream"
 	}
@@ -457,7 +470,7 @@ func uploadFileFromForm(c *gin.Context, info *relaycommon.RelayInfo, fieldCandid
 		writer.Close()
 		return "", fmt.Errorf("replicate adaptor: create upload form failed: %w", err)
 	}
-	if _, err := io.Copy(part, file); err != nil {
+	if _, err := part.Write(data); err != nil {
 		writer.Close()
 		return "", fmt.Errorf("replicate adaptor: copy image content failed: %w", err)
 	}
@@ -204,6 +204,9 @@ func (a *Adaptor) getRequestUrl(info *relaycommon.RelayInfo, modelName, suffix s
 }
 
 func (a *Adaptor) GetRequestURL(info *relaycommon.RelayInfo) (string, error) {
+	if info.RelayMode == constant.RelayModeGeminiLive {
+		return a.getLiveRequestURL(info)
+	}
 	suffix := ""
 	if a.RequestMode == RequestModeGemini {
 		if model_setting.GetGeminiSettings().ThinkingAdapterEnabled &&
@@ -250,6 +253,9 @@ func (a *Adaptor) GetRequestURL(info *relaycommon.RelayInfo) (string, error) {
 
 func (a *Adaptor) SetupRequestHeader(c *gin.Context, req *http.Header, info *relaycommon.RelayInfo) error {
 	channel.SetupApiRequestHeader(info, c, req)
+	if constant.IsImageEditMode(info.RelayMode) {
+		req.Set("Content-Type", gin.MIMEJSON)
+	}
 	if info.ChannelOtherSettings.VertexKeyType != dto.VertexKeyTypeAPIKey {
 		accessToken, err := getAccessToken(a, info)
 		if err != nil {
@@ -361,10 +367,17 @@ func (a *Adaptor) ConvertOpenAIResponsesRequest(c *gin.Context, info *relaycommo
 }
 
 func (a *Adaptor) DoRequest(c *gin.Context, info *relaycommon.RelayInfo, requestBody io.Reader) (any, error) {
+	if info.RelayMode == constant.RelayModeGeminiLive {
+		return channel.DoWssRequest(a, c, info, requestBody)
+	}
 	return channel.DoApiRequest(a, c, info, requestBody)
 }
 
 func (a *Adaptor) DoResponse(c *gin.Context, resp *http.Response, info *relaycommon.RelayInfo) (usage any, err *types.CVAIError) {
+	if info.RelayMode == constant.RelayModeGeminiLive {
+		err, usage = gemini.GeminiLiveHandler(c, info, a.getLiveSetupModel(info))
+		return
+	}
 	if info.IsStream {
 		switch a.RequestMode {
 		case RequestModeClaude:
@@ -389,6 +402,9 @@ func (a *Adaptor) DoResponse(c *gin.Context, resp *http.Response, info *relaycom
 				if strings.HasPrefix(info.UpstreamModelName, "imagen") {
 					return gemini.GeminiImageHandler(c, info, resp)
 				}
+				if info.RelayFormat == types.RelayFormatOpenAIImage {
+					return gemini.GeminiChatImageHandler(c, info, resp)
+				}
 				return gemini.GeminiChatHandler(c, info, resp)
 			}
 		case RequestModeLlama:
@@ -418,3 +434,53 @@ func (a *Adaptor) GetModelList() []string {
 func (a *Adaptor) GetChannelName() string {
 	return ChannelName
 }
+
+// CountTokens Claude 模型转发到 count-tokens:rawPredict，Gemini 模型转发到 countTokens
+func (a *Adaptor) CountTokens(c *gin.Context, info *relaycommon.RelayInfo, request dto.Request) (int, error) {
+	switch r := request.(type) {
+	case *dto.ClaudeRequest:
+		// API Key 方式只支持 Google 发布的模型
+		if a.RequestMode != RequestModeClaude || info.ChannelOtherSettings.VertexKeyType == dto.VertexKeyTypeAPIKey {
+			return 0, channel.ErrCountTokensNotSupported
+		}
+		model := info.UpstreamModelName
+		if v, ok := claudeModelMap[info.UpstreamModelName]; ok {
+			model = v
+		}
+		fullRequestURL, err := a.getRequestUrl(info, "count-tokens", "rawPredict")
+		if err != nil {
+			return 0, err
+		}
+		responseBody, err := channel.DoCountTokensRequest(a, c, info, fullRequestURL, r.ToCountTokensRequest(model))
+		if err != nil {
+			return 0, err
+		}
+		var response dto.ClaudeCountTokensResponse
+		if err = common.Unmarshal(responseBody, &response); err != nil {
+			return 0, err
+		}
+		return response.InputTokens, nil
+	case *dto.GeminiChatRequest:
+		if a.RequestMode != RequestModeGemini {
+			return 0, channel.ErrCountTokensNotSupported
+		}
+		fullRequestURL, err := a.getRequestUrl(info, info.UpstreamModelName, "countTokens")
+		if err != nil {
+			return 0, err
+		}
+		responseBody, err := channel.DoCountTokensRequest(a, c, info, fullRequestURL, &VertexCountTokensRequest{
+			Contents:          r.Contents,
+			SystemInstruction: r.SystemInstructions,
+			Tools:             r.Tools,
+		})
+		if err != nil {
+			return 0, err
+		}
+		var response dto.GeminiCountTokensResponse
+		if err = common.Unmarshal(responseBody, &response); err != nil {
+			return 0, err
+		}
+		return response.TotalTokens, nil
+	}
+	return 0, channel.ErrCountTokensNotSupported
+}
@@ -1,6 +1,8 @@
 package vertex
 
 import (
+	"encoding/json"
+
 	"github.com/ctrlc-ctrlv-limited/cvai/dto"
 )
 
@@ -35,3 +37,10 @@ func copyRequest(req *dto.ClaudeRequest, version string) *VertexAIClaudeRequest
 		Thinking:         req.Thinking,
 	}
 }
+
+// VertexCountTokensRequest Vertex AI countTokens 接口的请求体，不接受 safetySettings 等生成参数
+type VertexCountTokensRequest struct {
+	Contents          []dto.GeminiChatContent `json:"contents"`
+	SystemInstruction *dto.GeminiChatContent  `json:"systemInstruction,omitempty"`
+	Tools             json.RawMessage         `json:"tools,omitempty"`
+}
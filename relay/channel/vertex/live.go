@@ -0,0 +1,38 @@
+package vertex
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ctrlc-ctrlv-limited/cvai/common"
+	"github.com/ctrlc-ctrlv-limited/cvai/dto"
+	relaycommon "github.com/ctrlc-ctrlv-limited/cvai/relay/common"
+)
+
+// getLiveRequestURL Vertex AI 的 Gemini Live 地址，仅支持服务账号鉴权
+// https://cloud.google.com/vertex-ai/generative-ai/docs/live-api
+func (a *Adaptor) getLiveRequestURL(info *relaycommon.RelayInfo) (string, error) {
+	if info.ChannelOtherSettings.VertexKeyType == dto.VertexKeyTypeAPIKey {
+		return "", errors.New("gemini live on vertex ai requires service account credentials")
+	}
+	adc := &Credentials{}
+	if err := common.Unmarshal([]byte(info.ApiKey), adc); err != nil {
+		return "", fmt.Errorf("failed to decode credentials file: %w", err)
+	}
+	a.AccountCredentials = *adc
+
+	host := "aiplatform.googleapis.com"
+	if region := GetModelRegion(info.ApiVersion, info.OriginModelName); region != "global" {
+		host = region + "-" + host
+	}
+	return fmt.Sprintf("wss://%s/ws/google.cloud.aiplatform.v1beta1.LlmBidiService/BidiGenerateContent", host), nil
+}
+
+// getLiveSetupModel Vertex AI 的 setup 消息需要使用完整的模型资源名
+func (a *Adaptor) getLiveSetupModel(info *relaycommon.RelayInfo) string {
+	return fmt.Sprintf("projects/%s/locations/%s/publishers/google/models/%s",
+		a.AccountCredentials.ProjectID,
+		GetModelRegion(info.ApiVersion, info.OriginModelName),
+		info.UpstreamModelName,
+	)
+}
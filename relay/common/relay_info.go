@@ -52,6 +52,9 @@ type BuildInToolInfo struct {
 
 type ResponsesUsageInfo struct {
 	BuiltInTools map[string]*BuildInToolInfo
+	// 上游返回的 response id 与状态，用于绑定 response 与渠道
+	ResponseId     string
+	ResponseStatus string
 }
 
 type ChannelMeta struct {
@@ -86,6 +89,7 @@ type RelayInfo struct {
 	UserId            int
 	UsingGroup        string // 使用的分组，当auto跨分组重试时，会变动
 	UserGroup         string // 用户所在分组
+	RequestId         string
 	TokenUnlimited    bool
 	StartTime         time.Time
 	FirstResponseTime time.Time
@@ -109,12 +113,16 @@ type RelayInfo struct {
 	AudioUsage             bool
 	ReasoningEffort        string
 	UserSetting            dto.UserSetting
+	TokenBudget            *dto.QuotaBudget // 令牌与用户的周期预算，为 nil 时结算时从缓存读取
+	UserBudget             *dto.QuotaBudget
 	UserEmail              string
 	UserQuota              int
+	UserCreditLimit        int // 用户的信用额度，预扣费时确定，0 表示预付费
 	RelayFormat            types.RelayFormat
 	SendResponseCount      int
 	FinalPreConsumedQuota  int  // 最终预消耗的配额
 	IsClaudeBetaQuery      bool // /v1/messages?beta=true
+	ImageCount             int  // 上游实际返回的图片数量，图片按张计费时按此结算
 
 	PriceData types.PriceData
 
@@ -321,6 +329,19 @@ func GenRelayInfoEmbedding(c *gin.Context, request dto.Request) *RelayInfo {
 	return info
 }
 
+func GenRelayInfoModeration(c *gin.Context, request dto.Request) *RelayInfo {
+	info := genBaseRelayInfo(c, request)
+	info.RelayMode = relayconstant.RelayModeModerations
+	info.RelayFormat = types.RelayFormatModeration
+	return info
+}
+
+func GenRelayInfoRealtimeSession(c *gin.Context, request dto.Request) *RelayInfo {
+	info := genBaseRelayInfo(c, request)
+	info.RelayFormat = types.RelayFormatOpenAIRealtimeSession
+	return info
+}
+
 func GenRelayInfoResponses(c *gin.Context, request *dto.OpenAIResponsesRequest) *RelayInfo {
 	info := genBaseRelayInfo(c, request)
 	info.RelayMode = relayconstant.RelayModeResponses
@@ -398,6 +419,7 @@ func genBaseRelayInfo(c *gin.Context, request dto.Request) *RelayInfo {
 		Request: request,
 
 		UserId:     common.GetContextKeyInt(c, constant.ContextKeyUserId),
+		RequestId:  c.GetString(common.RequestIdKey),
 		UsingGroup: common.GetContextKeyString(c, constant.ContextKeyUsingGroup),
 		UserGroup:  common.GetContextKeyString(c, constant.ContextKeyUserGroup),
 		UserQuota:  common.GetContextKeyInt(c, constant.ContextKeyUserQuota),
@@ -441,6 +463,12 @@ func genBaseRelayInfo(c *gin.Context, request dto.Request) *RelayInfo {
 	if ok {
 		info.UserSetting = userSetting
 	}
+	if tokenBudget, ok := common.GetContextKeyType[dto.QuotaBudget](c, constant.ContextKeyTokenBudget); ok {
+		info.TokenBudget = &tokenBudget
+	}
+	if userBudget, ok := common.GetContextKeyType[dto.QuotaBudget](c, constant.ContextKeyUserBudget); ok {
+		info.UserBudget = &userBudget
+	}
 
 	return info
 }
@@ -455,6 +483,8 @@ func GenRelayInfo(c *gin.Context, relayFormat types.RelayFormat, request dto.Req
 		return GenRelayInfoImage(c, request), nil
 	case types.RelayFormatOpenAIRealtime:
 		return GenRelayInfoWs(c, ws), nil
+	case types.RelayFormatOpenAIRealtimeSession:
+		return GenRelayInfoRealtimeSession(c, request), nil
 	case types.RelayFormatClaude:
 		return GenRelayInfoClaude(c, request), nil
 	case types.RelayFormatRerank:
@@ -463,9 +493,16 @@ func GenRelayInfo(c *gin.Context, relayFormat types.RelayFormat, request dto.Req
 		}
 		return nil, errors.New("request is not a RerankRequest")
 	case types.RelayFormatGemini:
-		return GenRelayInfoGemini(c, request), nil
+		info := GenRelayInfoGemini(c, request)
+		if ws != nil {
+			// Gemini Live
+			info.ClientWs = ws
+		}
+		return info, nil
 	case types.RelayFormatEmbedding:
 		return GenRelayInfoEmbedding(c, request), nil
+	case types.RelayFormatModeration:
+		return GenRelayInfoModeration(c, request), nil
 	case types.RelayFormatOpenAIResponses:
 		if request, ok := request.(*dto.OpenAIResponsesRequest); ok {
 			return GenRelayInfoResponses(c, request), nil
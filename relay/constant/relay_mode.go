@@ -50,6 +50,13 @@ const (
 	RelayModeRealtime
 
 	RelayModeGemini
+
+	RelayModeImagesVariations
+
+	RelayModeRealtimeSessions
+	RelayModeRealtimeTranscriptionSessions
+
+	RelayModeGeminiLive
 )
 
 func Path2RelayMode(path string) int {
@@ -68,6 +75,8 @@ func Path2RelayMode(path string) int {
 		relayMode = RelayModeImagesGenerations
 	} else if strings.HasPrefix(path, "/v1/images/edits") {
 		relayMode = RelayModeImagesEdits
+	} else if strings.HasPrefix(path, "/v1/images/variations") {
+		relayMode = RelayModeImagesVariations
 	} else if strings.HasPrefix(path, "/v1/edits") {
 		relayMode = RelayModeEdits
 	} else if strings.HasPrefix(path, "/v1/responses") {
@@ -80,6 +89,12 @@ func Path2RelayMode(path string) int {
 		relayMode = RelayModeAudioTranslation
 	} else if strings.HasPrefix(path, "/v1/rerank") {
 		relayMode = RelayModeRerank
+	} else if strings.Contains(path, "BidiGenerateContent") {
+		relayMode = RelayModeGeminiLive
+	} else if strings.HasPrefix(path, "/v1/realtime/sessions") {
+		relayMode = RelayModeRealtimeSessions
+	} else if strings.HasPrefix(path, "/v1/realtime/transcription_sessions") {
+		relayMode = RelayModeRealtimeTranscriptionSessions
 	} else if strings.HasPrefix(path, "/v1/realtime") {
 		relayMode = RelayModeRealtime
 	} else if strings.HasPrefix(path, "/v1beta/models") || strings.HasPrefix(path, "/v1/models") {
@@ -144,3 +159,8 @@ func Path2RelaySuno(method, path string) int {
 	}
 	return relayMode
 }
+
+// IsImageEditMode 图片编辑与变体请求，需要上传输入图片
+func IsImageEditMode(relayMode int) bool {
+	return relayMode == RelayModeImagesEdits || relayMode == RelayModeImagesVariations
+}
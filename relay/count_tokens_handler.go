@@ -0,0 +1,41 @@
+package relay
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ctrlc-ctrlv-limited/cvai/dto"
+	"github.com/ctrlc-ctrlv-limited/cvai/logger"
+	"github.com/ctrlc-ctrlv-limited/cvai/relay/channel"
+	relaycommon "github.com/ctrlc-ctrlv-limited/cvai/relay/common"
+	"github.com/ctrlc-ctrlv-limited/cvai/relay/helper"
+	"github.com/ctrlc-ctrlv-limited/cvai/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CountTokensHelper 计算请求的输入 token 数量，渠道支持时使用上游的原生接口，否则在本地估算
+func CountTokensHelper(c *gin.Context, info *relaycommon.RelayInfo, request dto.Request) (int, error) {
+	info.InitChannelMeta(c)
+	info.IsStream = false
+
+	err := helper.ModelMappedHelper(c, info, request)
+	if err != nil {
+		return 0, err
+	}
+
+	adaptor := GetAdaptor(info.ApiType)
+	if adaptor != nil {
+		adaptor.Init(info)
+		if counter, ok := adaptor.(channel.TokenCounter); ok {
+			tokens, err := counter.CountTokens(c, info, request)
+			if err == nil {
+				return tokens, nil
+			}
+			if !errors.Is(err, channel.ErrCountTokensNotSupported) {
+				logger.LogWarn(c, fmt.Sprintf("channel #%d count tokens failed, fallback to local estimation: %s", info.ChannelId, err.Error()))
+			}
+		}
+	}
+	return service.CountRequestToken(c, request.GetTokenCountMeta(), info)
+}
@@ -4,6 +4,8 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"time"
+	"unicode/utf8"
 
 	"github.com/ctrlc-ctrlv-limited/cvai/common"
 	"github.com/ctrlc-ctrlv-limited/cvai/dto"
@@ -155,11 +157,35 @@ func WssError(c *gin.Context, ws *websocket.Conn, openaiError types.OpenAIError)
 	_ = WssObject(c, ws, errorObj)
 }
 
+// GeminiLiveError 与 Gemini Live 一致，通过关闭帧返回错误，关闭原因最长 123 字节
+func GeminiLiveError(ws *websocket.Conn, statusCode int, message string) {
+	if ws == nil {
+		return
+	}
+	closeCode := websocket.CloseInternalServerErr
+	if statusCode >= 400 && statusCode < 500 {
+		closeCode = websocket.ClosePolicyViolation
+	}
+	if len(message) > 123 {
+		n := 120
+		for n > 0 && !utf8.RuneStart(message[n]) {
+			n--
+		}
+		message = message[:n] + "..."
+	}
+	_ = ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(closeCode, message), time.Now().Add(time.Second))
+}
+
 func GetResponseID(c *gin.Context) string {
 	logID := c.GetString(common.RequestIdKey)
 	return fmt.Sprintf("chatcmpl-%s", logID)
 }
 
+func GetModerationID(c *gin.Context) string {
+	logID := c.GetString(common.RequestIdKey)
+	return fmt.Sprintf("modr-%s", logID)
+}
+
 func GetLocalRealtimeID(c *gin.Context) string {
 	logID := c.GetString(common.RequestIdKey)
 	return fmt.Sprintf("evt_%s", logID)
@@ -2,8 +2,11 @@ package helper
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/ctrlc-ctrlv-limited/cvai/common"
+	"github.com/ctrlc-ctrlv-limited/cvai/constant"
+	"github.com/ctrlc-ctrlv-limited/cvai/dto"
 	"github.com/ctrlc-ctrlv-limited/cvai/logger"
 	relaycommon "github.com/ctrlc-ctrlv-limited/cvai/relay/common"
 	"github.com/ctrlc-ctrlv-limited/cvai/setting/operation_setting"
@@ -42,6 +45,12 @@ func HandleGroupRatio(ctx *gin.Context, relayInfo *relaycommon.RelayInfo) types.
 		groupRatioInfo.GroupRatio = ratio_setting.GetGroupRatio(relayInfo.UsingGroup)
 	}
 
+	// 批处理请求按折扣计费
+	if batchId := common.GetBatchRequestId(ctx); batchId != "" {
+		groupRatioInfo.GroupRatio *= operation_setting.GetBatchDiscountRatio()
+		logger.LogDebug(ctx, "batch %s, group ratio with discount: %f", batchId, groupRatioInfo.GroupRatio)
+	}
+
 	return groupRatioInfo
 }
 
@@ -50,17 +59,13 @@ func ModelPriceHelper(c *gin.Context, info *relaycommon.RelayInfo, promptTokens
 
 	groupRatioInfo := HandleGroupRatio(c, info)
 
+	priceData := types.PriceData{
+		ModelPrice:     modelPrice,
+		UsePrice:       usePrice,
+		GroupRatioInfo: groupRatioInfo,
+	}
 	var preConsumedQuota int
-	var modelRatio float64
-	var completionRatio float64
-	var cacheRatio float64
-	var imageRatio float64
-	var cacheCreationRatio float64
-	var cacheCreationRatio5m float64
-	var cacheCreationRatio1h float64
-	var audioRatio float64
-	var audioCompletionRatio float64
-	var freeModel bool
+	var imageCount int
 	if !usePrice {
 		preConsumedTokens := common.Max(promptTokens, common.PreConsumedQuota)
 		if meta.MaxTokens != 0 {
@@ -68,7 +73,7 @@ func ModelPriceHelper(c *gin.Context, info *relaycommon.RelayInfo, promptTokens
 		}
 		var success bool
 		var matchName string
-		modelRatio, success, matchName = ratio_setting.GetModelRatio(info.OriginModelName)
+		priceData.ModelRatio, success, matchName = ratio_setting.GetModelRatio(info.OriginModelName)
 		if !success {
 			acceptUnsetRatio := false
 			if info.UserSetting.AcceptUnsetRatioModel {
@@ -78,22 +83,32 @@ func ModelPriceHelper(c *gin.Context, info *relaycommon.RelayInfo, promptTokens
 				return types.PriceData{}, fmt.Errorf("模型 %s 倍率或价格未配置，请联系管理员设置或开始自用模式；Model %s ratio or price not set, please set or start self-use mode", matchName, matchName)
 			}
 		}
-		completionRatio = ratio_setting.GetCompletionRatio(info.OriginModelName)
-		cacheRatio, _ = ratio_setting.GetCacheRatio(info.OriginModelName)
-		cacheCreationRatio, _ = ratio_setting.GetCreateCacheRatio(info.OriginModelName)
-		cacheCreationRatio5m = cacheCreationRatio
+		priceData.CompletionRatio = ratio_setting.GetCompletionRatio(info.OriginModelName)
+		priceData.CacheRatio, _ = ratio_setting.GetCacheRatio(info.OriginModelName)
+		priceData.CacheCreationRatio, _ = ratio_setting.GetCreateCacheRatio(info.OriginModelName)
+		priceData.CacheCreation5mRatio = priceData.CacheCreationRatio
 		// 固定1h和5min缓存写入价格的比例
-		cacheCreationRatio1h = cacheCreationRatio * claudeCacheCreation1hMultiplier
-		imageRatio, _ = ratio_setting.GetImageRatio(info.OriginModelName)
-		audioRatio = ratio_setting.GetAudioRatio(info.OriginModelName)
-		audioCompletionRatio = ratio_setting.GetAudioCompletionRatio(info.OriginModelName)
-		ratio := modelRatio * groupRatioInfo.GroupRatio
+		priceData.CacheCreation1hRatio = priceData.CacheCreationRatio * claudeCacheCreation1hMultiplier
+		priceData.ImageRatio, _ = ratio_setting.GetImageRatio(info.OriginModelName)
+		priceData.AudioRatio = ratio_setting.GetAudioRatio(info.OriginModelName)
+		priceData.AudioCompletionRatio = ratio_setting.GetAudioCompletionRatio(info.OriginModelName)
+		// 按估算的 prompt tokens 与请求参数匹配分档计费规则
+		ApplyPricingRule(c, info, &priceData, promptTokens)
+		ratio := priceData.ModelRatio * groupRatioInfo.GroupRatio
 		preConsumedQuota = int(float64(preConsumedTokens) * ratio)
+	} else if info.RelayFormat == types.RelayFormatOpenAIImage {
+		// 图片按张计费，结算时按上游实际返回的张数修正
+		perCallPriceData := ModelPriceHelperPerCall(c, info)
+		priceData.ModelPrice = perCallPriceData.ModelPrice
+		priceData.PricingRule = perCallPriceData.PricingRule
+		imageCount = perCallPriceData.ImageCount
+		preConsumedQuota = perCallPriceData.Quota
 	} else {
-		if meta.ImagePriceRatio != 0 {
-			modelPrice = modelPrice * meta.ImagePriceRatio
+		if rule := matchPricingRule(c, info, promptTokens); rule != nil && rule.ModelPrice != nil {
+			priceData.ModelPrice = *rule.ModelPrice
+			priceData.PricingRule = rule.Name
 		}
-		preConsumedQuota = int(modelPrice * common.QuotaPerUnit * groupRatioInfo.GroupRatio)
+		preConsumedQuota = int(priceData.ModelPrice * common.QuotaPerUnit * groupRatioInfo.GroupRatio)
 	}
 
 	// check if free model pre-consume is disabled
@@ -101,35 +116,23 @@ func ModelPriceHelper(c *gin.Context, info *relaycommon.RelayInfo, promptTokens
 		// if model price or ratio is 0, do not pre-consume quota
 		if groupRatioInfo.GroupRatio == 0 {
 			preConsumedQuota = 0
-			freeModel = true
+			priceData.FreeModel = true
 		} else if usePrice {
-			if modelPrice == 0 {
+			if priceData.ModelPrice == 0 {
 				preConsumedQuota = 0
-				freeModel = true
+				priceData.FreeModel = true
 			}
 		} else {
-			if modelRatio == 0 {
+			if priceData.ModelRatio == 0 {
 				preConsumedQuota = 0
-				freeModel = true
+				priceData.FreeModel = true
 			}
 		}
 	}
 
-	priceData := types.PriceData{
-		FreeModel:            freeModel,
-		ModelPrice:           modelPrice,
-		ModelRatio:           modelRatio,
-		CompletionRatio:      completionRatio,
-		GroupRatioInfo:       groupRatioInfo,
-		UsePrice:             usePrice,
-		CacheRatio:           cacheRatio,
-		ImageRatio:           imageRatio,
-		AudioRatio:           audioRatio,
-		AudioCompletionRatio: audioCompletionRatio,
-		CacheCreationRatio:   cacheCreationRatio,
-		CacheCreation5mRatio: cacheCreationRatio5m,
-		CacheCreation1hRatio: cacheCreationRatio1h,
-		QuotaToPreConsume:    preConsumedQuota,
+	priceData.QuotaToPreConsume = preConsumedQuota
+	if imageCount > 0 {
+		priceData.AddOtherRatio("n", float64(imageCount))
 	}
 
 	if common.DebugEnabled {
@@ -139,7 +142,42 @@ func ModelPriceHelper(c *gin.Context, info *relaycommon.RelayInfo, promptTokens
 	return priceData, nil
 }
 
-// ModelPriceHelperPerCall 按次计费的 PriceHelper (MJ、Task)
+func matchPricingRule(c *gin.Context, info *relaycommon.RelayInfo, promptTokens int) *ratio_setting.PricingRule {
+	if !ratio_setting.HasModelPricingRules(info.OriginModelName) {
+		return nil
+	}
+	requestBody, _ := common.GetRequestBody(c)
+	return ratio_setting.MatchModelPricingRule(info.OriginModelName, promptTokens, ratio_setting.PricingRuleRequestAttributes(info.OriginModelName, requestBody))
+}
+
+// ApplyPricingRule 按 prompt tokens 与请求参数匹配模型的分档计费规则并调整按量计费的倍率，
+// 预扣费时按估算值调用并提取请求参数，结算时按上游返回的实际 prompt tokens 再次调用，复用已提取的请求参数
+func ApplyPricingRule(c *gin.Context, info *relaycommon.RelayInfo, priceData *types.PriceData, promptTokens int) {
+	if priceData.UsePrice || !ratio_setting.HasModelPricingRules(info.OriginModelName) {
+		return
+	}
+	if priceData.PricingRuleRequest == nil {
+		requestBody, _ := common.GetRequestBody(c)
+		priceData.PricingRuleRequest = ratio_setting.PricingRuleRequestAttributes(info.OriginModelName, requestBody)
+	}
+	ratio_setting.ApplyModelPricingRule(priceData, info.OriginModelName, promptTokens)
+}
+
+// PricingRulePromptTokens 返回结算时匹配分档计费规则使用的 prompt tokens，包含缓存读取与写入的 tokens。
+// Claude 格式与 Anthropic 渠道的 input_tokens 不包含缓存 tokens，需要加上，OpenRouter 返回的 prompt tokens 已包含缓存 tokens
+func PricingRulePromptTokens(info *relaycommon.RelayInfo, usage *dto.Usage) int {
+	promptTokens := usage.PromptTokens
+	if info.ChannelType == constant.ChannelTypeOpenRouter {
+		return promptTokens
+	}
+	if info.RelayFormat == types.RelayFormatClaude || info.ChannelType == constant.ChannelTypeAnthropic {
+		promptTokens += usage.PromptTokensDetails.CachedTokens + usage.PromptTokensDetails.CachedCreationTokens
+	}
+	return promptTokens
+}
+
+// ModelPriceHelperPerCall 按次计费的 PriceHelper (MJ、Task、图片)
+// 图片请求按张计费，单张价格按分辨率乘以对应倍率
 func ModelPriceHelperPerCall(c *gin.Context, info *relaycommon.RelayInfo) types.PerCallPriceData {
 	groupRatioInfo := HandleGroupRatio(c, info)
 
@@ -153,15 +191,41 @@ func ModelPriceHelperPerCall(c *gin.Context, info *relaycommon.RelayInfo) types.
 			modelPrice = defaultPrice
 		}
 	}
-	quota := int(modelPrice * common.QuotaPerUnit * groupRatioInfo.GroupRatio)
+	count := 1
+	imageCount := 0
+	// 计费规则设置的价格为最终单价，如按分辨率档位配置的图片价格
+	rule := matchPricingRule(c, info, 0)
+	if rule != nil && rule.ModelPrice != nil {
+		modelPrice = *rule.ModelPrice
+	}
+	if imageRequest, ok := info.Request.(*dto.ImageRequest); ok {
+		if rule == nil || rule.ModelPrice == nil {
+			modelPrice = modelPrice * getImagePriceRatio(imageRequest)
+		}
+		imageCount = imageRequest.GetImageCount()
+		count = imageCount
+	}
+	quota := int(modelPrice * common.QuotaPerUnit * groupRatioInfo.GroupRatio * float64(count))
 	priceData := types.PerCallPriceData{
 		ModelPrice:     modelPrice,
 		Quota:          quota,
 		GroupRatioInfo: groupRatioInfo,
+		ImageCount:     imageCount,
+	}
+	if rule != nil && rule.ModelPrice != nil {
+		priceData.PricingRule = rule.Name
 	}
 	return priceData
 }
 
+// getImagePriceRatio 单张图片的价格倍率，dall-e 沿用官方按尺寸与品质的价格，其他模型按分辨率档位计算
+func getImagePriceRatio(request *dto.ImageRequest) float64 {
+	if strings.HasPrefix(request.Model, "dall-e") {
+		return request.GetDallEPriceRatio()
+	}
+	return operation_setting.GetImageResolutionRatio(request.Size, request.Quality)
+}
+
 func ContainPriceOrRatio(modelName string) bool {
 	_, ok := ratio_setting.GetModelPrice(modelName, false)
 	if ok {
@@ -8,12 +8,14 @@ import (
 	"strings"
 
 	"github.com/ctrlc-ctrlv-limited/cvai/common"
+	"github.com/ctrlc-ctrlv-limited/cvai/constant"
 	"github.com/ctrlc-ctrlv-limited/cvai/dto"
 	"github.com/ctrlc-ctrlv-limited/cvai/logger"
 	relayconstant "github.com/ctrlc-ctrlv-limited/cvai/relay/constant"
 	"github.com/ctrlc-ctrlv-limited/cvai/types"
 
 	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
 )
 
 func GetAndValidateRequest(c *gin.Context, format types.RelayFormat) (request dto.Request, err error) {
@@ -23,7 +25,9 @@ func GetAndValidateRequest(c *gin.Context, format types.RelayFormat) (request dt
 	case types.RelayFormatOpenAI:
 		request, err = GetAndValidateTextRequest(c, relayMode)
 	case types.RelayFormatGemini:
-		if strings.Contains(c.Request.URL.Path, ":embedContent") {
+		if relayMode == relayconstant.RelayModeGeminiLive {
+			request, err = GetAndValidateGeminiLiveRequest(c)
+		} else if strings.Contains(c.Request.URL.Path, ":embedContent") {
 			request, err = GetAndValidateGeminiEmbeddingRequest(c)
 		} else if strings.Contains(c.Request.URL.Path, ":batchEmbedContents") {
 			request, err = GetAndValidateGeminiBatchEmbeddingRequest(c)
@@ -41,10 +45,14 @@ func GetAndValidateRequest(c *gin.Context, format types.RelayFormat) (request dt
 		request, err = GetAndValidateEmbeddingRequest(c, relayMode)
 	case types.RelayFormatRerank:
 		request, err = GetAndValidateRerankRequest(c)
+	case types.RelayFormatModeration:
+		request, err = GetAndValidateModerationRequest(c)
 	case types.RelayFormatOpenAIAudio:
 		request, err = GetAndValidAudioRequest(c, relayMode)
 	case types.RelayFormatOpenAIRealtime:
 		request = &dto.BaseRequest{}
+	case types.RelayFormatOpenAIRealtimeSession:
+		request, err = GetAndValidateRealtimeSessionRequest(c, relayMode)
 	default:
 		return nil, fmt.Errorf("unsupported relay format: %s", format)
 	}
@@ -73,6 +81,40 @@ func GetAndValidAudioRequest(c *gin.Context, relayMode int) (*dto.AudioRequest,
 	return audioRequest, nil
 }
 
+func GetAndValidateRealtimeSessionRequest(c *gin.Context, relayMode int) (*dto.RealtimeSessionRequest, error) {
+	request := &dto.RealtimeSessionRequest{}
+	err := common.UnmarshalBodyReusable(c, request)
+	if err != nil {
+		return nil, err
+	}
+	if relayMode == relayconstant.RelayModeRealtimeTranscriptionSessions {
+		// 转写会话按转写模型计费
+		if request.InputAudioTranscription == nil {
+			request.InputAudioTranscription = &dto.InputAudioTranscription{}
+		}
+		request.InputAudioTranscription.Model = common.GetStringIfEmpty(request.InputAudioTranscription.Model, "gpt-4o-transcribe")
+		request.Model = ""
+	} else if request.Model == "" {
+		return nil, errors.New("model is required")
+	}
+	return request, nil
+}
+
+func GetAndValidateGeminiLiveRequest(c *gin.Context) (*dto.GeminiLiveRequest, error) {
+	setup, ok := common.GetContextKeyType[[]byte](c, constant.ContextKeyGeminiLiveSetup)
+	if !ok {
+		return nil, errors.New("setup message is required")
+	}
+	model := gjson.GetBytes(setup, "setup.model").String()
+	if model == "" {
+		return nil, errors.New("setup.model is required")
+	}
+	return &dto.GeminiLiveRequest{
+		Model: dto.GeminiLiveModelName(model),
+		Setup: setup,
+	}, nil
+}
+
 func GetAndValidateRerankRequest(c *gin.Context) (*dto.RerankRequest, error) {
 	var rerankRequest *dto.RerankRequest
 	err := common.UnmarshalBodyReusable(c, &rerankRequest)
@@ -90,6 +132,22 @@ func GetAndValidateRerankRequest(c *gin.Context) (*dto.RerankRequest, error) {
 	return rerankRequest, nil
 }
 
+func GetAndValidateModerationRequest(c *gin.Context) (*dto.ModerationRequest, error) {
+	request := &dto.ModerationRequest{}
+	err := common.UnmarshalBodyReusable(c, request)
+	if err != nil {
+		return nil, err
+	}
+	if len(request.GetInputTexts()) == 0 {
+		return nil, errors.New("field input is required")
+	}
+	if request.Model == "" {
+		// 与选择渠道时使用的默认模型保持一致
+		request.Model = "text-moderation-stable"
+	}
+	return request, nil
+}
+
 func GetAndValidateEmbeddingRequest(c *gin.Context, relayMode int) (*dto.EmbeddingRequest, error) {
 	var embeddingRequest *dto.EmbeddingRequest
 	err := common.UnmarshalBodyReusable(c, &embeddingRequest)
@@ -129,7 +187,7 @@ func GetAndValidOpenAIImageRequest(c *gin.Context, relayMode int) (*dto.ImageReq
 	imageRequest := &dto.ImageRequest{}
 
 	switch relayMode {
-	case relayconstant.RelayModeImagesEdits:
+	case relayconstant.RelayModeImagesEdits, relayconstant.RelayModeImagesVariations:
 		if strings.Contains(c.Request.Header.Get("Content-Type"), "multipart/form-data") {
 			_, err := c.MultipartForm()
 			if err != nil {
@@ -141,9 +199,19 @@ func GetAndValidOpenAIImageRequest(c *gin.Context, relayMode int) (*dto.ImageReq
 			imageRequest.N = uint(common.String2Int(formData.Get("n")))
 			imageRequest.Quality = formData.Get("quality")
 			imageRequest.Size = formData.Get("size")
+			imageRequest.ResponseFormat = formData.Get("response_format")
 			if imageValue := formData.Get("image"); imageValue != "" {
 				imageRequest.Image, _ = json.Marshal(imageValue)
 			}
+			if maskValue := formData.Get("mask"); maskValue != "" {
+				imageRequest.Mask, _ = json.Marshal(maskValue)
+			}
+			if len(imageRequest.Image) == 0 && !hasImageFormFile(c) {
+				return nil, errors.New("image is required")
+			}
+			if relayMode == relayconstant.RelayModeImagesVariations && imageRequest.Model == "" {
+				imageRequest.Model = "dall-e-2"
+			}
 
 			if imageRequest.Model == "gpt-image-1" {
 				if imageRequest.Quality == "" {
@@ -168,11 +236,18 @@ func GetAndValidOpenAIImageRequest(c *gin.Context, relayMode int) (*dto.ImageReq
 			return nil, err
 		}
 
+		if relayMode == relayconstant.RelayModeImagesVariations && imageRequest.Model == "" {
+			imageRequest.Model = "dall-e-2"
+		}
 		if imageRequest.Model == "" {
 			//imageRequest.Model = "dall-e-3"
 			return nil, errors.New("model is required")
 		}
 
+		if relayconstant.IsImageEditMode(relayMode) && len(imageRequest.Image) == 0 && len(imageRequest.Images) == 0 {
+			return nil, errors.New("image is required")
+		}
+
 		if strings.Contains(imageRequest.Size, "×") {
 			return nil, errors.New("size an unexpected error occurred in the parameter, please use 'x' instead of the multiplication sign '×'")
 		}
@@ -213,6 +288,19 @@ func GetAndValidOpenAIImageRequest(c *gin.Context, relayMode int) (*dto.ImageReq
 	return imageRequest, nil
 }
 
+// hasImageFormFile 表单中是否上传了输入图片，兼容 image、image[] 与 image[0] 等字段名
+func hasImageFormFile(c *gin.Context) bool {
+	if c.Request.MultipartForm == nil {
+		return false
+	}
+	for fieldName, files := range c.Request.MultipartForm.File {
+		if (fieldName == "image" || strings.HasPrefix(fieldName, "image[")) && len(files) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
 func GetAndValidateClaudeRequest(c *gin.Context) (textRequest *dto.ClaudeRequest, err error) {
 	textRequest = &dto.ClaudeRequest{}
 	err = c.ShouldBindJSON(textRequest)
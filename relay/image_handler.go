@@ -119,9 +119,18 @@ func ImageHelper(c *gin.Context, info *relaycommon.RelayInfo) (newAPIError *type
 		usage.(*dto.Usage).PromptTokens = int(request.N)
 	}
 
+	// 按张计费时以上游实际返回的图片数量结算
+	imageCount := int(request.N)
+	if info.ImageCount > 0 {
+		imageCount = info.ImageCount
+		if info.PriceData.UsePrice {
+			info.PriceData.AddOtherRatio("n", float64(imageCount))
+		}
+	}
+
 	quality := "standard"
-	if request.Quality == "hd" {
-		quality = "hd"
+	if request.Quality != "" {
+		quality = request.Quality
 	}
 
 	var logContent []string
@@ -132,8 +141,8 @@ func ImageHelper(c *gin.Context, info *relaycommon.RelayInfo) (newAPIError *type
 	if len(quality) > 0 {
 		logContent = append(logContent, fmt.Sprintf("品质 %s", quality))
 	}
-	if request.N > 0 {
-		logContent = append(logContent, fmt.Sprintf("生成数量 %d", request.N))
+	if imageCount > 0 {
+		logContent = append(logContent, fmt.Sprintf("生成数量 %d", imageCount))
 	}
 
 	postConsumeQuota(c, info, usage.(*dto.Usage), logContent...)
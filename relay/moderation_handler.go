@@ -0,0 +1,57 @@
+package relay
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/ctrlc-ctrlv-limited/cvai/common"
+	"github.com/ctrlc-ctrlv-limited/cvai/dto"
+	"github.com/ctrlc-ctrlv-limited/cvai/relay/channel"
+	relaycommon "github.com/ctrlc-ctrlv-limited/cvai/relay/common"
+	"github.com/ctrlc-ctrlv-limited/cvai/relay/helper"
+	"github.com/ctrlc-ctrlv-limited/cvai/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+func ModerationHelper(c *gin.Context, info *relaycommon.RelayInfo) *types.CVAIError {
+	moderationReq, ok := info.Request.(*dto.ModerationRequest)
+	if !ok {
+		return types.NewErrorWithStatusCode(fmt.Errorf("invalid request type, expected *dto.ModerationRequest, got %T", info.Request), types.ErrorCodeInvalidRequest, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+	}
+
+	response, usage, newAPIError := Moderate(c, info, moderationReq)
+	if newAPIError != nil {
+		return newAPIError
+	}
+	c.JSON(http.StatusOK, response)
+	postConsumeQuota(c, info, usage)
+	return nil
+}
+
+// Moderate 使用当前上下文选中的渠道审核请求内容，结果统一为 OpenAI moderation 格式，也用于聊天请求转发前的预检
+func Moderate(c *gin.Context, info *relaycommon.RelayInfo, moderationReq *dto.ModerationRequest) (*dto.ModerationResponse, *dto.Usage, *types.CVAIError) {
+	info.InitChannelMeta(c)
+
+	request, err := common.DeepCopy(moderationReq)
+	if err != nil {
+		return nil, nil, types.NewError(fmt.Errorf("failed to copy request to ModerationRequest: %w", err), types.ErrorCodeInvalidRequest, types.ErrOptionWithSkipRetry())
+	}
+
+	err = helper.ModelMappedHelper(c, info, request)
+	if err != nil {
+		return nil, nil, types.NewError(err, types.ErrorCodeChannelModelMappedError, types.ErrOptionWithSkipRetry())
+	}
+
+	adaptor := GetAdaptor(info.ApiType)
+	if adaptor == nil {
+		return nil, nil, types.NewError(fmt.Errorf("invalid api type: %d", info.ApiType), types.ErrorCodeInvalidApiType, types.ErrOptionWithSkipRetry())
+	}
+	adaptor.Init(info)
+
+	moderator, ok := adaptor.(channel.Moderator)
+	if !ok {
+		return nil, nil, types.NewError(fmt.Errorf("channel %s does not support moderation", adaptor.GetChannelName()), types.ErrorCodeInvalidApiType)
+	}
+	return moderator.Moderate(c, info, request)
+}
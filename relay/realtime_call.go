@@ -0,0 +1,122 @@
+package relay
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/ctrlc-ctrlv-limited/cvai/common"
+	"github.com/ctrlc-ctrlv-limited/cvai/constant"
+	"github.com/ctrlc-ctrlv-limited/cvai/dto"
+	"github.com/ctrlc-ctrlv-limited/cvai/logger"
+	"github.com/ctrlc-ctrlv-limited/cvai/model"
+	relaycommon "github.com/ctrlc-ctrlv-limited/cvai/relay/common"
+	"github.com/ctrlc-ctrlv-limited/cvai/service"
+	"github.com/ctrlc-ctrlv-limited/cvai/setting/operation_setting"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// MonitorRealtimeCall 通过 sideband WebSocket 接收 WebRTC 通话中的用量事件并扣费，通话结束后记录消费日志
+// 额度不足时挂断通话
+func MonitorRealtimeCall(ctx context.Context, secret *service.RealtimeClientSecret, callId string, conn *websocket.Conn) {
+	defer conn.Close()
+
+	info := &relaycommon.RelayInfo{
+		UserId:          secret.UserId,
+		UsingGroup:      secret.UsingGroup,
+		UserGroup:       secret.UserGroup,
+		OriginModelName: secret.OriginModelName,
+		PriceData:       secret.PriceData,
+		RequestURLPath:  "/v1/realtime/calls",
+		StartTime:       time.Now(),
+		IsStream:        true,
+		UsePrice:        secret.PriceData.UsePrice,
+		ChannelMeta: &relaycommon.ChannelMeta{
+			ChannelId:            secret.ChannelId,
+			ChannelIsMultiKey:    secret.IsMultiKey,
+			ChannelMultiKeyIndex: secret.KeyIndex,
+			UpstreamModelName:    secret.UpstreamModelName,
+			IsModelMapped:        secret.UpstreamModelName != secret.OriginModelName,
+		},
+	}
+	info.FirstResponseTime = info.StartTime
+	if channel, err := model.CacheGetChannel(secret.ChannelId); err == nil {
+		info.ChannelType = channel.Type
+	}
+	token, err := model.GetTokenById(secret.TokenId)
+	if err != nil {
+		logger.LogError(ctx, fmt.Sprintf("failed to get token %d of realtime call %s: %s", secret.TokenId, callId, err.Error()))
+		_ = service.HangupRealtimeCall(ctx, secret, callId)
+		return
+	}
+	info.TokenId = token.Id
+	info.TokenKey = token.Key
+	username := ""
+	if user, err := model.GetUserCache(secret.UserId); err == nil {
+		username = user.Username
+		info.UserQuota = user.Quota
+		info.UserEmail = user.Email
+		info.UserSetting = user.GetSetting()
+		info.UserCreditLimit = operation_setting.GetCreditLimit(user.CreditLimit, user.Group)
+	}
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/realtime/calls", nil)
+	c.Request.RemoteAddr = ""
+	c.Set("username", username)
+	c.Set("token_name", token.Name)
+	common.SetContextKey(c, constant.ContextKeyChannelId, secret.ChannelId)
+
+	sumUsage := &dto.RealtimeUsage{}
+	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				logger.LogError(ctx, fmt.Sprintf("realtime call %s sideband closed: %s", callId, err.Error()))
+			}
+			break
+		}
+		var event dto.RealtimeEvent
+		if err = common.Unmarshal(message, &event); err != nil {
+			continue
+		}
+		var usage *dto.RealtimeUsage
+		switch event.Type {
+		case dto.RealtimeEventTypeResponseDone:
+			if event.Response != nil {
+				usage = event.Response.Usage
+			}
+		case dto.RealtimeEventInputAudioTranscriptionCompleted:
+			usage = event.Usage
+		}
+		if usage == nil || usage.TotalTokens == 0 {
+			continue
+		}
+		sumUsage.TotalTokens += usage.TotalTokens
+		sumUsage.InputTokens += usage.InputTokens
+		sumUsage.OutputTokens += usage.OutputTokens
+		sumUsage.InputTokenDetails.CachedTokens += usage.InputTokenDetails.CachedTokens
+		sumUsage.InputTokenDetails.TextTokens += usage.InputTokenDetails.TextTokens
+		sumUsage.InputTokenDetails.AudioTokens += usage.InputTokenDetails.AudioTokens
+		sumUsage.OutputTokenDetails.TextTokens += usage.OutputTokenDetails.TextTokens
+		sumUsage.OutputTokenDetails.AudioTokens += usage.OutputTokenDetails.AudioTokens
+		if err = service.PreWssConsumeQuota(c, info, usage); err != nil {
+			logger.LogError(ctx, fmt.Sprintf("realtime call %s consume quota failed, hanging up: %s", callId, err.Error()))
+			_ = service.HangupRealtimeCall(ctx, secret, callId)
+			break
+		}
+	}
+
+	// 按次计费的模型在通话结束后扣费
+	if info.UsePrice && sumUsage.TotalTokens > 0 {
+		quota := int(info.PriceData.ModelPrice * common.QuotaPerUnit * info.PriceData.GroupRatioInfo.GroupRatio)
+		if err = service.PostConsumeQuota(info, quota, 0, true); err != nil {
+			logger.LogError(ctx, fmt.Sprintf("failed to consume quota of realtime call %s: %s", callId, err.Error()))
+		}
+	}
+	service.PostWssConsumeQuota(c, info, info.UpstreamModelName, sumUsage, fmt.Sprintf("WebRTC 通话 %s", callId))
+}
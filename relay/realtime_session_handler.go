@@ -0,0 +1,98 @@
+package relay
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/ctrlc-ctrlv-limited/cvai/common"
+	"github.com/ctrlc-ctrlv-limited/cvai/constant"
+	"github.com/ctrlc-ctrlv-limited/cvai/dto"
+	relaycommon "github.com/ctrlc-ctrlv-limited/cvai/relay/common"
+	relayconstant "github.com/ctrlc-ctrlv-limited/cvai/relay/constant"
+	"github.com/ctrlc-ctrlv-limited/cvai/relay/helper"
+	"github.com/ctrlc-ctrlv-limited/cvai/service"
+	"github.com/ctrlc-ctrlv-limited/cvai/types"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// RealtimeSessionHelper 在上游创建 WebRTC 会话，并将上游签发的临时密钥替换为网关签发的临时密钥
+// 会话本身不计费，通话中的用量由 MonitorRealtimeCall 结算
+func RealtimeSessionHelper(c *gin.Context, info *relaycommon.RelayInfo) (newAPIError *types.CVAIError) {
+	info.InitChannelMeta(c)
+
+	if info.ChannelType != constant.ChannelTypeOpenAI {
+		return types.NewError(fmt.Errorf("realtime sessions are not supported by channel type %d", info.ChannelType), types.ErrorCodeInvalidApiType, types.ErrOptionWithSkipRetry())
+	}
+	sessionReq, ok := info.Request.(*dto.RealtimeSessionRequest)
+	if !ok {
+		return types.NewErrorWithStatusCode(fmt.Errorf("invalid request type, expected dto.RealtimeSessionRequest, got %T", info.Request), types.ErrorCodeInvalidRequest, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+	}
+	request, err := common.DeepCopy(sessionReq)
+	if err != nil {
+		return types.NewError(fmt.Errorf("failed to copy request to RealtimeSessionRequest: %w", err), types.ErrorCodeInvalidRequest, types.ErrOptionWithSkipRetry())
+	}
+	err = helper.ModelMappedHelper(c, info, request)
+	if err != nil {
+		return types.NewError(err, types.ErrorCodeChannelModelMappedError, types.ErrOptionWithSkipRetry())
+	}
+
+	body, err := common.GetRequestBody(c)
+	if err != nil {
+		return types.NewErrorWithStatusCode(err, types.ErrorCodeReadRequestBodyFailed, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+	}
+	if info.IsModelMapped {
+		modelPath := "model"
+		if info.RelayMode == relayconstant.RelayModeRealtimeTranscriptionSessions {
+			modelPath = "input_audio_transcription.model"
+		}
+		body, err = sjson.SetBytes(body, modelPath, info.UpstreamModelName)
+		if err != nil {
+			return types.NewError(err, types.ErrorCodeConvertRequestFailed, types.ErrOptionWithSkipRetry())
+		}
+	}
+
+	statusCodeMappingStr := c.GetString("status_code_mapping")
+	resp, err := service.CreateRealtimeSession(c, info, c.Request.URL.Path, body)
+	if err != nil {
+		return types.NewOpenAIError(err, types.ErrorCodeDoRequestFailed, http.StatusInternalServerError)
+	}
+	if resp.StatusCode != http.StatusOK {
+		newAPIError = service.RelayErrorHandler(c, resp, false)
+		// reset status code 重置状态码
+		service.ResetStatusCode(newAPIError, statusCodeMappingStr)
+		return newAPIError
+	}
+	responseBody, err := io.ReadAll(resp.Body)
+	service.CloseResponseBodyGracefully(resp)
+	if err != nil {
+		return types.NewOpenAIError(err, types.ErrorCodeReadResponseBodyFailed, http.StatusInternalServerError)
+	}
+
+	upstreamSecret := gjson.GetBytes(responseBody, "client_secret.value").String()
+	if upstreamSecret == "" {
+		return types.NewOpenAIError(errors.New("no client secret in upstream response"), types.ErrorCodeBadResponseBody, http.StatusInternalServerError)
+	}
+	expiresAt := gjson.GetBytes(responseBody, "client_secret.expires_at").Int()
+	clientSecret, err := service.NewRealtimeClientSecret(c, info, upstreamSecret, expiresAt)
+	if err != nil {
+		return types.NewError(err, types.ErrorCodeUpdateDataError, types.ErrOptionWithSkipRetry())
+	}
+	responseBody, err = sjson.SetBytes(responseBody, "client_secret.value", clientSecret)
+	if err != nil {
+		return types.NewOpenAIError(err, types.ErrorCodeBadResponseBody, http.StatusInternalServerError)
+	}
+	if info.IsModelMapped && gjson.GetBytes(responseBody, "model").Exists() {
+		responseBody, _ = sjson.SetBytes(responseBody, "model", info.OriginModelName)
+	}
+
+	// 创建会话不计费，退还预扣的额度
+	service.ReturnPreConsumedQuota(c, info)
+	info.FinalPreConsumedQuota = 0
+	c.Data(http.StatusOK, "application/json", responseBody)
+	return nil
+}
@@ -9,6 +9,7 @@ import (
 
 	"github.com/ctrlc-ctrlv-limited/cvai/common"
 	"github.com/ctrlc-ctrlv-limited/cvai/dto"
+	"github.com/ctrlc-ctrlv-limited/cvai/logger"
 	relaycommon "github.com/ctrlc-ctrlv-limited/cvai/relay/common"
 	"github.com/ctrlc-ctrlv-limited/cvai/relay/helper"
 	"github.com/ctrlc-ctrlv-limited/cvai/service"
@@ -64,6 +65,12 @@ func ResponsesHelper(c *gin.Context, info *relaycommon.RelayInfo) (newAPIError *
 			return types.NewError(err, types.ErrorCodeConvertRequestFailed, types.ErrOptionWithSkipRetry())
 		}
 
+		// 将网关上传的文件 id 替换为上游渠道的文件 id
+		jsonData, err = service.ReplaceRequestFileIds(c, info, jsonData)
+		if err != nil {
+			return types.NewError(err, types.ErrorCodeConvertRequestFailed)
+		}
+
 		// apply param override
 		if len(info.ParamOverride) > 0 {
 			jsonData, err = relaycommon.ApplyParamOverride(jsonData, info.ParamOverride, relaycommon.BuildParamOverrideContext(info))
@@ -104,6 +111,19 @@ func ResponsesHelper(c *gin.Context, info *relaycommon.RelayInfo) (newAPIError *
 		return newAPIError
 	}
 
+	// background 模式上游立即返回，response 生成完成后再按实际用量结算
+	background := responsesReq.IsBackground() && !info.IsStream && isResponseUnfinished(info.ResponseStatus)
+	if background {
+		if err = submitBackgroundResponse(info); err != nil {
+			logger.LogError(c, fmt.Sprintf("failed to save background response %s: %s", info.ResponseId, err.Error()))
+			background = false
+		}
+	}
+	service.RecordResponseBinding(c, info, responsesReq, background)
+	if background {
+		return nil
+	}
+
 	if strings.HasPrefix(info.OriginModelName, "gpt-4o-audio") {
 		service.PostAudioConsumeQuota(c, info, usage.(*dto.Usage), "")
 	} else {
@@ -0,0 +1,203 @@
+package relay
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/ctrlc-ctrlv-limited/cvai/common"
+	"github.com/ctrlc-ctrlv-limited/cvai/constant"
+	"github.com/ctrlc-ctrlv-limited/cvai/dto"
+	"github.com/ctrlc-ctrlv-limited/cvai/logger"
+	"github.com/ctrlc-ctrlv-limited/cvai/model"
+	relaycommon "github.com/ctrlc-ctrlv-limited/cvai/relay/common"
+	"github.com/ctrlc-ctrlv-limited/cvai/service"
+	"github.com/ctrlc-ctrlv-limited/cvai/setting/operation_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+// isResponseUnfinished 上游是否仍在生成 response
+func isResponseUnfinished(status string) bool {
+	return status == "queued" || status == "in_progress"
+}
+
+// submitBackgroundResponse 将 background 模式的 response 记录为异步任务，预扣的额度在任务结束后按实际用量结算
+func submitBackgroundResponse(info *relaycommon.RelayInfo) error {
+	task := model.InitTask(constant.TaskPlatformResponses, info)
+	task.TaskID = info.ResponseId
+	task.Action = constant.TaskActionResponse
+	task.Quota = info.FinalPreConsumedQuota
+	task.Status = model.TaskStatusQueued
+	task.Progress = "20%"
+	if info.ResponseStatus == "in_progress" {
+		task.Status = model.TaskStatusInProgress
+		task.Progress = "50%"
+		task.StartTime = task.SubmitTime
+	}
+	task.PrivateData.TokenId = info.TokenId
+	if info.ChannelIsMultiKey {
+		task.PrivateData.KeyIndex = info.ChannelMultiKeyIndex
+	}
+	priceData := info.PriceData
+	task.PrivateData.PriceData = &priceData
+	task.SetData(map[string]any{
+		"id":     info.ResponseId,
+		"object": "response",
+		"status": info.ResponseStatus,
+	})
+	return task.Insert()
+}
+
+// UpdateBackgroundResponseTask 根据上游返回的 response 更新异步任务，结束时按实际用量结算预扣的额度
+func UpdateBackgroundResponseTask(ctx context.Context, task *model.Task, response []byte) error {
+	var responsesResponse dto.OpenAIResponsesResponse
+	if err := common.Unmarshal(response, &responsesResponse); err != nil {
+		return fmt.Errorf("invalid response of %s: %s", task.TaskID, string(response))
+	}
+	preStatus := task.Status
+	if preStatus == model.TaskStatusSuccess || preStatus == model.TaskStatusFailure {
+		return nil
+	}
+	task.Data = response
+	now := time.Now().Unix()
+	switch responsesResponse.Status {
+	case "queued":
+		task.Status = model.TaskStatusQueued
+		task.Progress = "20%"
+	case "in_progress":
+		task.Status = model.TaskStatusInProgress
+		task.Progress = "50%"
+		if task.StartTime == 0 {
+			task.StartTime = now
+		}
+	case "completed", "incomplete":
+		task.Status = model.TaskStatusSuccess
+		task.Progress = "100%"
+		task.FinishTime = now
+	case "failed", "cancelled":
+		task.Status = model.TaskStatusFailure
+		task.Progress = "100%"
+		task.FinishTime = now
+		if oaiError := responsesResponse.GetOpenAIError(); oaiError != nil && oaiError.Message != "" {
+			task.FailReason = oaiError.Message
+		} else {
+			task.FailReason = responsesResponse.Status
+		}
+	default:
+		return fmt.Errorf("unknown response status %s of %s", responsesResponse.Status, task.TaskID)
+	}
+
+	// 只有将任务更新为结束状态的一方结算，防止轮询与用户查询同时结算
+	updated, err := task.UpdateWithStatus(preStatus)
+	if err != nil || !updated {
+		return err
+	}
+	if task.Status == model.TaskStatusSuccess || task.Status == model.TaskStatusFailure {
+		settleBackgroundResponse(ctx, task, &responsesResponse)
+	}
+	return nil
+}
+
+// settleBackgroundResponse 按 response 的实际用量结算，没有产生用量时退还预扣的额度
+func settleBackgroundResponse(ctx context.Context, task *model.Task, response *dto.OpenAIResponsesResponse) {
+	info := &relaycommon.RelayInfo{
+		UserId:                task.UserId,
+		UsingGroup:            task.Group,
+		OriginModelName:       task.Properties.OriginModelName,
+		RequestURLPath:        "/v1/responses",
+		StartTime:             time.Unix(task.SubmitTime, 0),
+		FirstResponseTime:     time.Unix(task.SubmitTime, 0),
+		FinalPreConsumedQuota: task.Quota,
+		ResponsesUsageInfo: &relaycommon.ResponsesUsageInfo{
+			BuiltInTools: make(map[string]*relaycommon.BuildInToolInfo),
+			ResponseId:   task.TaskID,
+		},
+		ChannelMeta: &relaycommon.ChannelMeta{
+			ChannelId:         task.ChannelId,
+			UpstreamModelName: task.Properties.UpstreamModelName,
+			IsModelMapped:     task.Properties.UpstreamModelName != task.Properties.OriginModelName,
+		},
+	}
+	if channel, err := model.CacheGetChannel(task.ChannelId); err == nil {
+		info.ChannelType = channel.Type
+	}
+	if task.PrivateData.PriceData != nil {
+		info.PriceData = *task.PrivateData.PriceData
+	}
+	token, err := model.GetTokenById(task.PrivateData.TokenId)
+	if err == nil {
+		info.TokenId = token.Id
+		info.TokenKey = token.Key
+	} else {
+		// 令牌已删除时只结算用户额度
+		info.IsPlayground = true
+		token = &model.Token{}
+	}
+	username := ""
+	user, err := model.GetUserCache(task.UserId)
+	if err == nil {
+		username = user.Username
+		info.UserQuota = user.Quota
+		info.UserEmail = user.Email
+		info.UserSetting = user.GetSetting()
+		info.UserCreditLimit = operation_setting.GetCreditLimit(user.CreditLimit, user.Group)
+	}
+
+	if response.Usage == nil || response.Usage.InputTokens+response.Usage.OutputTokens == 0 {
+		if task.Quota == 0 {
+			return
+		}
+		if err = service.PostConsumeQuota(info, -task.Quota, 0, false); err != nil {
+			logger.LogError(ctx, fmt.Sprintf("failed to refund quota of background response %s: %s", task.TaskID, err.Error()))
+			return
+		}
+		model.RecordLog(task.UserId, model.LogTypeSystem, fmt.Sprintf("后台 response %s 未产生用量（%s），退还 %s", task.TaskID, response.Status, logger.LogQuota(task.Quota)))
+		task.Quota = 0
+		_ = task.Update()
+		return
+	}
+
+	usage := &dto.Usage{
+		PromptTokens:     response.Usage.InputTokens,
+		CompletionTokens: response.Usage.OutputTokens,
+		TotalTokens:      response.Usage.TotalTokens,
+	}
+	if response.Usage.InputTokensDetails != nil {
+		usage.PromptTokensDetails.CachedTokens = response.Usage.InputTokensDetails.CachedTokens
+	}
+	for _, tool := range response.Tools {
+		toolType := common.Interface2String(tool["type"])
+		searchContextSize := common.Interface2String(tool["search_context_size"])
+		if searchContextSize == "" {
+			searchContextSize = "medium"
+		}
+		callCount := 1
+		if toolType == dto.BuildInToolFileSearch {
+			// file_search 按实际调用次数计费
+			callCount = response.CountOutputType(dto.BuildInCallFileSearchCall)
+		}
+		info.BuiltInTools[toolType] = &relaycommon.BuildInToolInfo{
+			ToolName:          toolType,
+			CallCount:         callCount,
+			SearchContextSize: searchContextSize,
+		}
+	}
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/responses", nil)
+	c.Request.RemoteAddr = ""
+	c.Set("username", username)
+	c.Set("token_name", token.Name)
+	if response.HasImageGenerationCall() {
+		c.Set("image_generation_call", true)
+		c.Set("image_generation_call_quality", response.GetQuality())
+		c.Set("image_generation_call_size", response.GetSize())
+	}
+	task.Quota = postConsumeQuota(c, info, usage, fmt.Sprintf("后台 response %s", task.TaskID))
+	if err = task.Update(); err != nil {
+		logger.LogError(ctx, fmt.Sprintf("failed to update quota of background response %s: %s", task.TaskID, err.Error()))
+	}
+}
@@ -5,6 +5,7 @@ import (
 
 	"github.com/ctrlc-ctrlv-limited/cvai/dto"
 	relaycommon "github.com/ctrlc-ctrlv-limited/cvai/relay/common"
+	"github.com/ctrlc-ctrlv-limited/cvai/relay/helper"
 	"github.com/ctrlc-ctrlv-limited/cvai/service"
 	"github.com/ctrlc-ctrlv-limited/cvai/types"
 
@@ -44,3 +45,36 @@ func WssHelper(c *gin.Context, info *relaycommon.RelayInfo) (newAPIError *types.
 	service.PostWssConsumeQuota(c, info, info.UpstreamModelName, usage.(*dto.RealtimeUsage), "")
 	return nil
 }
+
+// GeminiLiveHelper 转发 Gemini Live（BidiGenerateContent）会话，客户端连接已在读取 setup 消息时升级
+func GeminiLiveHelper(c *gin.Context, info *relaycommon.RelayInfo) (newAPIError *types.CVAIError) {
+	info.InitChannelMeta(c)
+
+	err := helper.ModelMappedHelper(c, info, info.Request)
+	if err != nil {
+		return types.NewError(err, types.ErrorCodeChannelModelMappedError, types.ErrOptionWithSkipRetry())
+	}
+
+	adaptor := GetAdaptor(info.ApiType)
+	if adaptor == nil {
+		return types.NewError(fmt.Errorf("invalid api type: %d", info.ApiType), types.ErrorCodeInvalidApiType, types.ErrOptionWithSkipRetry())
+	}
+	adaptor.Init(info)
+
+	statusCodeMappingStr := c.GetString("status_code_mapping")
+	resp, err := adaptor.DoRequest(c, info, nil)
+	if err != nil {
+		return types.NewError(err, types.ErrorCodeDoRequestFailed)
+	}
+	info.TargetWs = resp.(*websocket.Conn)
+	defer info.TargetWs.Close()
+
+	usage, newAPIError := adaptor.DoResponse(c, nil, info)
+	if newAPIError != nil {
+		// reset status code 重置状态码
+		service.ResetStatusCode(newAPIError, statusCodeMappingStr)
+		return newAPIError
+	}
+	service.PostWssConsumeQuota(c, info, info.UpstreamModelName, usage.(*dto.RealtimeUsage), "Gemini Live")
+	return nil
+}
@@ -1,6 +1,8 @@
 package router
 
 import (
+	"strings"
+
 	"github.com/ctrlc-ctrlv-limited/cvai/constant"
 	"github.com/ctrlc-ctrlv-limited/cvai/controller"
 	"github.com/ctrlc-ctrlv-limited/cvai/middleware"
@@ -55,11 +57,30 @@ func SetRelayRouter(router *gin.Engine) {
 		})
 	}
 
+	// Gemini Live，与 Gemini API 的 WebSocket 地址一致，模型在连接后的 setup 消息中
+	geminiLiveRouter := router.Group("/ws")
+	geminiLiveRouter.Use(middleware.TokenAuth())
+	geminiLiveRouter.Use(middleware.ModelRequestRateLimit())
+	geminiLiveRouter.Use(middleware.GeminiLiveSetup(), middleware.Distribute())
+	{
+		for _, version := range []string{"v1alpha", "v1beta"} {
+			geminiLiveRouter.GET("/google.ai.generativelanguage."+version+".GenerativeService.BidiGenerateContent", func(c *gin.Context) {
+				controller.Relay(c, types.RelayFormatGemini)
+			})
+		}
+	}
+
 	playgroundRouter := router.Group("/pg")
 	playgroundRouter.Use(middleware.UserAuth(), middleware.Distribute())
 	{
 		playgroundRouter.POST("/chat/completions", controller.Playground)
 	}
+	// WebRTC 通话使用网关签发的临时密钥鉴权，固定使用创建会话的渠道
+	realtimeCallRouter := router.Group("/v1")
+	{
+		realtimeCallRouter.POST("/realtime", controller.RelayRealtimeCall)
+		realtimeCallRouter.POST("/realtime/calls", controller.RelayRealtimeCall)
+	}
 	relayV1Router := router.Group("/v1")
 	relayV1Router.Use(middleware.TokenAuth())
 	relayV1Router.Use(middleware.ModelRequestRateLimit())
@@ -70,6 +91,65 @@ func SetRelayRouter(router *gin.Engine) {
 		wsRouter.GET("/realtime", func(c *gin.Context) {
 			controller.Relay(c, types.RelayFormatOpenAIRealtime)
 		})
+		// WebRTC 会话，签发临时密钥
+		wsRouter.POST("/realtime/sessions", func(c *gin.Context) {
+			controller.Relay(c, types.RelayFormatOpenAIRealtimeSession)
+		})
+		wsRouter.POST("/realtime/transcription_sessions", func(c *gin.Context) {
+			controller.Relay(c, types.RelayFormatOpenAIRealtimeSession)
+		})
+	}
+	{
+		// files、batches、微调任务、向量库、response 查询不需要选择渠道
+		filesRouter := relayV1Router.Group("/files")
+		filesRouter.GET("", controller.RelayListFiles)
+		filesRouter.POST("", controller.RelayUploadFile)
+		filesRouter.GET("/:id", controller.RelayGetFile)
+		filesRouter.DELETE("/:id", controller.RelayDeleteFile)
+		filesRouter.GET("/:id/content", controller.RelayGetFileContent)
+
+		batchesRouter := relayV1Router.Group("/batches")
+		batchesRouter.GET("", controller.RelayListBatches)
+		batchesRouter.POST("", controller.RelayCreateBatch)
+		batchesRouter.GET("/:id", controller.RelayGetBatch)
+		batchesRouter.POST("/:id/cancel", controller.RelayCancelBatch)
+
+		// 微调任务只在创建时选择渠道，之后固定使用创建任务的渠道
+		for _, path := range []string{"/fine_tuning/jobs", "/fine-tunes"} {
+			fineTuningRouter := relayV1Router.Group(path)
+			fineTuningRouter.GET("", controller.RelayListFineTuningJobs)
+			fineTuningRouter.POST("", middleware.Distribute(), controller.RelayCreateFineTuningJob)
+			fineTuningRouter.GET("/:id", controller.RelayGetFineTuningJob)
+			fineTuningRouter.POST("/:id/cancel", controller.RelayCancelFineTuningJob)
+			fineTuningRouter.GET("/:id/events", controller.RelayGetFineTuningJobResource("events"))
+			fineTuningRouter.GET("/:id/checkpoints", controller.RelayGetFineTuningJobResource("checkpoints"))
+		}
+
+		// 向量库只在创建时选择渠道，之后固定使用创建向量库的渠道
+		vectorStoresRouter := relayV1Router.Group("/vector_stores")
+		vectorStoresRouter.GET("", controller.RelayListVectorStores)
+		vectorStoresRouter.POST("", middleware.Distribute(), controller.RelayCreateVectorStore)
+		vectorStoresRouter.GET("/:id", controller.RelayVectorStoreRequest)
+		vectorStoresRouter.POST("/:id", controller.RelayVectorStoreRequest)
+		vectorStoresRouter.DELETE("/:id", controller.RelayDeleteVectorStore)
+		vectorStoresRouter.POST("/:id/search", controller.RelayVectorStoreRequest)
+		vectorStoresRouter.GET("/:id/files", controller.RelayVectorStoreRequest)
+		vectorStoresRouter.POST("/:id/files", controller.RelayVectorStoreRequest)
+		vectorStoresRouter.GET("/:id/files/:file_id", controller.RelayVectorStoreRequest)
+		vectorStoresRouter.POST("/:id/files/:file_id", controller.RelayVectorStoreRequest)
+		vectorStoresRouter.DELETE("/:id/files/:file_id", controller.RelayVectorStoreRequest)
+		vectorStoresRouter.GET("/:id/files/:file_id/content", controller.RelayVectorStoreRequest)
+		vectorStoresRouter.POST("/:id/file_batches", controller.RelayVectorStoreRequest)
+		vectorStoresRouter.GET("/:id/file_batches/:batch_id", controller.RelayVectorStoreRequest)
+		vectorStoresRouter.POST("/:id/file_batches/:batch_id/cancel", controller.RelayVectorStoreRequest)
+		vectorStoresRouter.GET("/:id/file_batches/:batch_id/files", controller.RelayVectorStoreRequest)
+
+		// response 的查询、取消与删除固定发送到创建它的渠道与 key
+		responsesRouter := relayV1Router.Group("/responses")
+		responsesRouter.GET("/:id", controller.RelayGetResponse)
+		responsesRouter.DELETE("/:id", controller.RelayDeleteResponse)
+		responsesRouter.POST("/:id/cancel", controller.RelayCancelResponse)
+		responsesRouter.GET("/:id/input_items", controller.RelayListResponseInputItems)
 	}
 	{
 		//http router
@@ -80,6 +160,9 @@ func SetRelayRouter(router *gin.Engine) {
 		httpRouter.POST("/messages", func(c *gin.Context) {
 			controller.Relay(c, types.RelayFormatClaude)
 		})
+		httpRouter.POST("/messages/count_tokens", func(c *gin.Context) {
+			controller.RelayCountTokens(c, types.RelayFormatClaude)
+		})
 
 		// chat related routes
 		httpRouter.POST("/completions", func(c *gin.Context) {
@@ -93,6 +176,9 @@ func SetRelayRouter(router *gin.Engine) {
 		httpRouter.POST("/responses", func(c *gin.Context) {
 			controller.Relay(c, types.RelayFormatOpenAIResponses)
 		})
+		httpRouter.POST("/responses/input_tokens", func(c *gin.Context) {
+			controller.RelayCountTokens(c, types.RelayFormatOpenAIResponses)
+		})
 
 		// image related routes
 		httpRouter.POST("/edits", func(c *gin.Context) {
@@ -104,6 +190,9 @@ func SetRelayRouter(router *gin.Engine) {
 		httpRouter.POST("/images/edits", func(c *gin.Context) {
 			controller.Relay(c, types.RelayFormatOpenAIImage)
 		})
+		httpRouter.POST("/images/variations", func(c *gin.Context) {
+			controller.Relay(c, types.RelayFormatOpenAIImage)
+		})
 
 		// embedding related routes
 		httpRouter.POST("/embeddings", func(c *gin.Context) {
@@ -130,27 +219,14 @@ func SetRelayRouter(router *gin.Engine) {
 		httpRouter.POST("/engines/:model/embeddings", func(c *gin.Context) {
 			controller.Relay(c, types.RelayFormatGemini)
 		})
-		httpRouter.POST("/models/*path", func(c *gin.Context) {
-			controller.Relay(c, types.RelayFormatGemini)
-		})
+		httpRouter.POST("/models/*path", relayGemini)
 
 		// other relay routes
 		httpRouter.POST("/moderations", func(c *gin.Context) {
-			controller.Relay(c, types.RelayFormatOpenAI)
+			controller.Relay(c, types.RelayFormatModeration)
 		})
 
 		// not implemented
-		httpRouter.POST("/images/variations", controller.RelayNotImplemented)
-		httpRouter.GET("/files", controller.RelayNotImplemented)
-		httpRouter.POST("/files", controller.RelayNotImplemented)
-		httpRouter.DELETE("/files/:id", controller.RelayNotImplemented)
-		httpRouter.GET("/files/:id", controller.RelayNotImplemented)
-		httpRouter.GET("/files/:id/content", controller.RelayNotImplemented)
-		httpRouter.POST("/fine-tunes", controller.RelayNotImplemented)
-		httpRouter.GET("/fine-tunes", controller.RelayNotImplemented)
-		httpRouter.GET("/fine-tunes/:id", controller.RelayNotImplemented)
-		httpRouter.POST("/fine-tunes/:id/cancel", controller.RelayNotImplemented)
-		httpRouter.GET("/fine-tunes/:id/events", controller.RelayNotImplemented)
 		httpRouter.DELETE("/models/:model", controller.RelayNotImplemented)
 	}
 
@@ -175,10 +251,17 @@ func SetRelayRouter(router *gin.Engine) {
 	relayGeminiRouter.Use(middleware.Distribute())
 	{
 		// Gemini API 路径格式: /v1beta/models/{model_name}:{action}
-		relayGeminiRouter.POST("/models/*path", func(c *gin.Context) {
-			controller.Relay(c, types.RelayFormatGemini)
-		})
+		relayGeminiRouter.POST("/models/*path", relayGemini)
+	}
+}
+
+// relayGemini 处理 /models/{model_name}:{action}，countTokens 由网关单独处理
+func relayGemini(c *gin.Context) {
+	if strings.HasSuffix(c.Request.URL.Path, ":countTokens") {
+		controller.RelayCountTokens(c, types.RelayFormatGemini)
+		return
 	}
+	controller.Relay(c, types.RelayFormatGemini)
 }
 
 func registerMjRouterGroup(relayMjRouter *gin.RouterGroup) {
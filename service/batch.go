@@ -0,0 +1,937 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ctrlc-ctrlv-limited/cvai/common"
+	"github.com/ctrlc-ctrlv-limited/cvai/constant"
+	"github.com/ctrlc-ctrlv-limited/cvai/dto"
+	"github.com/ctrlc-ctrlv-limited/cvai/logger"
+	"github.com/ctrlc-ctrlv-limited/cvai/model"
+	relaycommon "github.com/ctrlc-ctrlv-limited/cvai/relay/common"
+	"github.com/ctrlc-ctrlv-limited/cvai/relay/helper"
+	"github.com/ctrlc-ctrlv-limited/cvai/setting/operation_setting"
+	"github.com/ctrlc-ctrlv-limited/cvai/setting/ratio_setting"
+	"github.com/ctrlc-ctrlv-limited/cvai/types"
+
+	"github.com/bytedance/gopkg/util/gopool"
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+const batchIdPrefix = "batch_"
+
+// 批处理输出文件与错误文件的用途
+const batchOutputPurpose = "batch_output"
+
+const batchCompletionWindow = "24h"
+
+// 汇总执行结果时每次读取的行数
+const batchResultPageSize = 500
+
+// 执行过程中检查批处理是否被取消、更新进度的间隔
+const batchStatusCheckInterval = 5 * time.Second
+
+// BatchEndpoints Batch API 支持的接口
+var BatchEndpoints = []string{"/v1/chat/completions", "/v1/completions", "/v1/embeddings", "/v1/responses", "/v1/moderations"}
+
+// 执行批处理请求的 http.Handler
+var batchHandler http.Handler
+
+// 当前节点正在执行的批处理
+var runningBatches = make(map[string]bool)
+var runningBatchesLock sync.Mutex
+
+// 当前节点正在执行的批处理请求数，所有批处理共享
+var batchInflight int
+var batchInflightLock sync.Mutex
+
+// SetBatchHandler 设置执行批处理请求的 http.Handler，每行请求都会经过完整的中转流程（鉴权、限流、选择渠道、计费）
+func SetBatchHandler(handler http.Handler) {
+	batchHandler = handler
+}
+
+func optionalTimestamp(t int64) *int64 {
+	if t == 0 {
+		return nil
+	}
+	return common.GetPointer(t)
+}
+
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return common.GetPointer(s)
+}
+
+// ToOpenAIBatch 转换为 OpenAI 格式的批处理对象
+func ToOpenAIBatch(batch *model.Batch) dto.OpenAIBatch {
+	result := dto.OpenAIBatch{
+		Id:               batch.BatchId,
+		Object:           "batch",
+		Endpoint:         batch.Endpoint,
+		InputFileId:      batch.InputFileId,
+		CompletionWindow: batch.CompletionWindow,
+		Status:           batch.Status,
+		OutputFileId:     optionalString(batch.OutputFileId),
+		ErrorFileId:      optionalString(batch.ErrorFileId),
+		CreatedAt:        batch.CreatedAt,
+		InProgressAt:     optionalTimestamp(batch.InProgressAt),
+		ExpiresAt:        optionalTimestamp(batch.ExpiresAt),
+		FinalizingAt:     optionalTimestamp(batch.FinalizingAt),
+		CompletedAt:      optionalTimestamp(batch.CompletedAt),
+		FailedAt:         optionalTimestamp(batch.FailedAt),
+		ExpiredAt:        optionalTimestamp(batch.ExpiredAt),
+		CancellingAt:     optionalTimestamp(batch.CancellingAt),
+		CancelledAt:      optionalTimestamp(batch.CancelledAt),
+		RequestCounts: dto.OpenAIBatchRequestCounts{
+			Total:     batch.RequestTotal,
+			Completed: batch.RequestCompleted,
+			Failed:    batch.RequestFailed,
+		},
+	}
+	if batch.Errors != "" {
+		var batchErrors dto.OpenAIBatchErrors
+		if err := common.UnmarshalJsonStr(batch.Errors, &batchErrors); err == nil {
+			result.Errors = &batchErrors
+		}
+	}
+	if batch.Metadata != "" {
+		_ = common.UnmarshalJsonStr(batch.Metadata, &result.Metadata)
+	}
+	return result
+}
+
+// parseBatchInput 校验批处理输入文件，返回非空的行与请求使用的模型
+func parseBatchInput(data []byte, endpoint string) ([][]byte, string, error) {
+	var lines [][]byte
+	var modelName string
+	customIds := make(map[string]bool)
+	for i, raw := range bytes.Split(data, []byte("\n")) {
+		raw = bytes.TrimSpace(raw)
+		if len(raw) == 0 {
+			continue
+		}
+		var line dto.BatchRequestLine
+		if err := common.Unmarshal(raw, &line); err != nil {
+			return nil, "", fmt.Errorf("line %d: invalid json: %s", i+1, err.Error())
+		}
+		if line.CustomId == "" {
+			return nil, "", fmt.Errorf("line %d: custom_id is required", i+1)
+		}
+		if customIds[line.CustomId] {
+			return nil, "", fmt.Errorf("line %d: duplicate custom_id %s", i+1, line.CustomId)
+		}
+		customIds[line.CustomId] = true
+		if !strings.EqualFold(line.Method, http.MethodPost) {
+			return nil, "", fmt.Errorf("line %d: only POST method is supported", i+1)
+		}
+		if line.Url != endpoint {
+			return nil, "", fmt.Errorf("line %d: url %s does not match the batch endpoint %s", i+1, line.Url, endpoint)
+		}
+		lineModel := gjson.GetBytes(line.Body, "model").String()
+		if lineModel == "" {
+			return nil, "", fmt.Errorf("line %d: body.model is required", i+1)
+		}
+		if modelName == "" {
+			modelName = lineModel
+		} else if lineModel != modelName {
+			return nil, "", fmt.Errorf("line %d: all requests in a batch must use the same model", i+1)
+		}
+		lines = append(lines, raw)
+	}
+	if len(lines) == 0 {
+		return nil, "", errors.New("input file is empty")
+	}
+	return lines, modelName, nil
+}
+
+func readFileData(ctx context.Context, file *model.File) ([]byte, error) {
+	content, err := OpenFileContent(ctx, file)
+	if err != nil {
+		return nil, err
+	}
+	defer content.Close()
+	return io.ReadAll(content)
+}
+
+// CreateBatch 校验输入文件并创建批处理，渠道支持原生 Batch API 时转发到上游，否则由网关逐行执行
+func CreateBatch(c *gin.Context, req *dto.CreateBatchRequest) (*model.Batch, error) {
+	setting := operation_setting.GetBatchSetting()
+	if !slices.Contains(BatchEndpoints, req.Endpoint) {
+		return nil, fmt.Errorf("unsupported endpoint: %s", req.Endpoint)
+	}
+	if req.CompletionWindow != batchCompletionWindow {
+		return nil, fmt.Errorf("unsupported completion_window: %s, only %s is supported", req.CompletionWindow, batchCompletionWindow)
+	}
+	userId := c.GetInt("id")
+	file, err := model.GetUserFileByFileId(userId, req.InputFileId)
+	if err != nil {
+		return nil, fmt.Errorf("input file %s not found", req.InputFileId)
+	}
+	if file.Purpose != "batch" {
+		return nil, fmt.Errorf("input file %s must be uploaded with purpose batch", req.InputFileId)
+	}
+	data, err := readFileData(c.Request.Context(), file)
+	if err != nil {
+		return nil, err
+	}
+	lines, modelName, err := parseBatchInput(data, req.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if setting.MaxRequests > 0 && len(lines) > setting.MaxRequests {
+		return nil, fmt.Errorf("too many requests in input file, max is %d", setting.MaxRequests)
+	}
+
+	batch := &model.Batch{
+		BatchId:          batchIdPrefix + common.GetRandomString(24),
+		UserId:           userId,
+		TokenId:          c.GetInt("token_id"),
+		Group:            common.GetContextKeyString(c, constant.ContextKeyUsingGroup),
+		Endpoint:         req.Endpoint,
+		Model:            modelName,
+		InputFileId:      req.InputFileId,
+		CompletionWindow: req.CompletionWindow,
+		Status:           model.BatchStatusValidating,
+		RequestTotal:     len(lines),
+		ExpiresAt:        common.GetTimestamp() + 24*3600,
+		Ip:               c.ClientIP(),
+	}
+	if len(req.Metadata) > 0 {
+		batch.Metadata = common.GetJsonString(req.Metadata)
+	}
+	if setting.NativePassthrough {
+		if err = createNativeBatch(c, batch, file, lines); err != nil {
+			logger.LogWarn(c, fmt.Sprintf("native batch is not available, execute by gateway: %s", err.Error()))
+		}
+	}
+	if err = batch.Insert(); err != nil {
+		refundRequestQuota(c, batch.PreConsumedQuota, batch.BatchId)
+		return nil, err
+	}
+	if !batch.IsNative() && common.IsMasterNode {
+		startBatch(batch)
+	}
+	return batch, nil
+}
+
+// CancelBatch 取消批处理，网关执行的批处理会在已发出的请求完成后结束
+func CancelBatch(c *gin.Context, batch *model.Batch) (*model.Batch, error) {
+	if batch.IsNative() {
+		if batch.IsFinished() {
+			return nil, fmt.Errorf("batch with status %s cannot be cancelled", batch.Status)
+		}
+		upstream, err := getBatchUpstream(batch)
+		if err != nil {
+			return nil, err
+		}
+		respBody, err := upstream.do(c.Request.Context(), http.MethodPost, "/v1/batches/"+batch.UpstreamBatchId+"/cancel", "application/json", nil)
+		if err != nil {
+			return nil, err
+		}
+		var upstreamBatch dto.OpenAIBatch
+		if err = common.Unmarshal(respBody, &upstreamBatch); err != nil {
+			return nil, err
+		}
+		applyUpstreamBatch(batch, &upstreamBatch)
+		return batch, batch.Update()
+	}
+	ok, err := model.CancelBatch(batch.Id)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("batch with status %s cannot be cancelled", batch.Status)
+	}
+	batch, err = model.GetBatchByBatchId(batch.BatchId)
+	if err != nil {
+		return nil, err
+	}
+	if common.IsMasterNode {
+		startBatch(batch)
+	}
+	return batch, nil
+}
+
+// StartBatchTask 执行待执行的批处理并同步上游批处理的状态，仅在主节点运行
+func StartBatchTask() {
+	if !common.IsMasterNode {
+		return
+	}
+	for {
+		interval := operation_setting.GetBatchSetting().PollIntervalSeconds
+		if interval < 5 {
+			interval = 5
+		}
+		time.Sleep(time.Duration(interval) * time.Second)
+		if !operation_setting.GetBatchSetting().Enabled {
+			continue
+		}
+		batches, err := model.GetUnfinishedBatches()
+		if err != nil {
+			common.SysError("failed to get unfinished batches: " + err.Error())
+			continue
+		}
+		for _, batch := range batches {
+			if batch.IsNative() {
+				if err = syncNativeBatch(batch); err != nil {
+					common.SysError(fmt.Sprintf("failed to sync batch %s: %s", batch.BatchId, err.Error()))
+				}
+				continue
+			}
+			startBatch(batch)
+		}
+	}
+}
+
+func startBatch(batch *model.Batch) {
+	runningBatchesLock.Lock()
+	defer runningBatchesLock.Unlock()
+	if runningBatches[batch.BatchId] {
+		return
+	}
+	runningBatches[batch.BatchId] = true
+	gopool.Go(func() {
+		defer func() {
+			runningBatchesLock.Lock()
+			delete(runningBatches, batch.BatchId)
+			runningBatchesLock.Unlock()
+		}()
+		runBatch(batch)
+	})
+}
+
+// acquireBatchSlot 等待执行批处理请求的名额；有普通请求在排队时让出渠道，超过 deadline 时返回 false
+func acquireBatchSlot(deadline int64) bool {
+	for {
+		batchInflightLock.Lock()
+		limit := max(operation_setting.GetBatchSetting().MaxConcurrency, 1)
+		if batchInflight < limit && !HasQueuedRequests() {
+			batchInflight++
+			batchInflightLock.Unlock()
+			return true
+		}
+		batchInflightLock.Unlock()
+		if common.GetTimestamp() >= deadline {
+			return false
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+}
+
+func releaseBatchSlot() {
+	batchInflightLock.Lock()
+	batchInflight--
+	batchInflightLock.Unlock()
+}
+
+func failBatch(batch *model.Batch, code string, message string) {
+	batch.Status = model.BatchStatusFailed
+	batch.FailedAt = common.GetTimestamp()
+	batch.Errors = common.GetJsonString(dto.OpenAIBatchErrors{
+		Object: "list",
+		Data:   []dto.OpenAIBatchError{{Code: code, Message: message}},
+	})
+	if err := batch.Update(); err != nil {
+		common.SysError(fmt.Sprintf("failed to update batch %s: %s", batch.BatchId, err.Error()))
+	}
+	_ = model.DeleteBatchResults(batch.BatchId)
+}
+
+// runBatch 由网关逐行执行批处理，已执行的行保存在 BatchResult 中，重启后从未执行的行继续
+func runBatch(batch *model.Batch) {
+	if batch.Status == model.BatchStatusValidating {
+		if _, err := model.StartBatch(batch.Id); err != nil {
+			common.SysError(fmt.Sprintf("failed to start batch %s: %s", batch.BatchId, err.Error()))
+			return
+		}
+		status, err := model.GetBatchStatus(batch.Id)
+		if err != nil {
+			return
+		}
+		batch.Status = status
+		batch.InProgressAt = common.GetTimestamp()
+	}
+	if batchHandler == nil {
+		failBatch(batch, "server_error", "batch handler is not initialized")
+		return
+	}
+	file, err := model.GetFileByFileId(batch.InputFileId)
+	if err != nil {
+		failBatch(batch, "input_file_not_found", fmt.Sprintf("input file %s not found", batch.InputFileId))
+		return
+	}
+	data, err := readFileData(context.Background(), file)
+	if err != nil {
+		failBatch(batch, "input_file_unreadable", err.Error())
+		return
+	}
+	lines, _, err := parseBatchInput(data, batch.Endpoint)
+	if err != nil {
+		failBatch(batch, "invalid_input_file", err.Error())
+		return
+	}
+	token, err := model.GetTokenById(batch.TokenId)
+	if err != nil {
+		failBatch(batch, "token_not_found", "the token used to create the batch no longer exists")
+		return
+	}
+
+	done := make(map[int]bool)
+	var completed, failed int
+	states, err := model.GetBatchResultStates(batch.BatchId)
+	if err != nil {
+		common.SysError(fmt.Sprintf("failed to get results of batch %s: %s", batch.BatchId, err.Error()))
+		return
+	}
+	for _, state := range states {
+		done[state.LineIndex] = true
+		if state.Success {
+			completed++
+		} else {
+			failed++
+		}
+	}
+
+	var wg sync.WaitGroup
+	var countsLock sync.Mutex
+	finalStatus := model.BatchStatusCompleted
+	lastCheck := time.Now()
+	var unexecuted []int
+	for i, raw := range lines {
+		if done[i] {
+			continue
+		}
+		if finalStatus == model.BatchStatusCompleted {
+			if time.Since(lastCheck) >= batchStatusCheckInterval || batch.Status == model.BatchStatusCancelling {
+				lastCheck = time.Now()
+				if status, err := model.GetBatchStatus(batch.Id); err == nil && status == model.BatchStatusCancelling {
+					finalStatus = model.BatchStatusCancelled
+				}
+				countsLock.Lock()
+				_ = model.UpdateBatchRequestCounts(batch.Id, completed, failed)
+				countsLock.Unlock()
+			}
+		}
+		if finalStatus == model.BatchStatusCompleted && !acquireBatchSlot(batch.ExpiresAt) {
+			finalStatus = model.BatchStatusExpired
+		}
+		if finalStatus != model.BatchStatusCompleted {
+			unexecuted = append(unexecuted, i)
+			continue
+		}
+		wg.Add(1)
+		lineIndex, line := i, raw
+		gopool.Go(func() {
+			defer wg.Done()
+			defer releaseBatchSlot()
+			result := executeBatchLine(batch, token, lineIndex, line)
+			if err := model.InsertBatchResult(result); err != nil {
+				common.SysError(fmt.Sprintf("failed to save result of batch %s line %d: %s", batch.BatchId, lineIndex, err.Error()))
+				return
+			}
+			countsLock.Lock()
+			if result.Success {
+				completed++
+			} else {
+				failed++
+			}
+			countsLock.Unlock()
+		})
+	}
+	wg.Wait()
+
+	// 过期时未执行的请求写入错误文件
+	var expiredLines []string
+	if finalStatus == model.BatchStatusExpired {
+		for _, i := range unexecuted {
+			expiredLines = append(expiredLines, common.GetJsonString(dto.BatchResponseLine{
+				Id:       "batch_req_" + common.GetRandomString(24),
+				CustomId: gjson.GetBytes(lines[i], "custom_id").String(),
+				Error: &dto.BatchResponseError{
+					Code:    "batch_expired",
+					Message: "This request could not be executed before the completion window expired.",
+				},
+			}))
+		}
+	}
+	finalizeBatch(batch, finalStatus, expiredLines)
+}
+
+// executeBatchLine 通过完整的中转流程执行批处理中的一行请求，使用创建批处理的令牌鉴权并按批处理折扣计费
+func executeBatchLine(batch *model.Batch, token *model.Token, lineIndex int, raw []byte) *model.BatchResult {
+	var line dto.BatchRequestLine
+	_ = common.Unmarshal(raw, &line)
+	body := []byte(line.Body)
+	// 批处理不支持流式输出
+	if gjson.GetBytes(body, "stream").Exists() {
+		body, _ = sjson.DeleteBytes(body, "stream")
+	}
+
+	ctx := context.WithValue(context.Background(), constant.ContextKeyBatchId, batch.BatchId)
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, line.Url, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer sk-"+token.Key)
+	// 沿用创建批处理的客户端 IP，未知时留空，设置了 IP 白名单的令牌会被拒绝
+	req.RemoteAddr = ""
+	if batch.Ip != "" {
+		req.RemoteAddr = net.JoinHostPort(batch.Ip, "0")
+	}
+	recorder := httptest.NewRecorder()
+	batchHandler.ServeHTTP(recorder, req)
+
+	respBody := recorder.Body.Bytes()
+	if !json.Valid(respBody) {
+		respBody, _ = common.Marshal(string(respBody))
+	}
+	responseLine := dto.BatchResponseLine{
+		Id:       "batch_req_" + common.GetRandomString(24),
+		CustomId: line.CustomId,
+		Response: &dto.BatchResponse{
+			StatusCode: recorder.Code,
+			RequestId:  recorder.Header().Get(common.RequestIdKey),
+			Body:       respBody,
+		},
+	}
+	success := recorder.Code >= 200 && recorder.Code < 300
+	if !success {
+		message := gjson.GetBytes(respBody, "error.message").String()
+		if message == "" {
+			message = http.StatusText(recorder.Code)
+		}
+		code := gjson.GetBytes(respBody, "error.code").String()
+		if code == "" {
+			code = "request_failed"
+		}
+		responseLine.Error = &dto.BatchResponseError{Code: code, Message: message}
+	}
+	return &model.BatchResult{
+		BatchId:   batch.BatchId,
+		LineIndex: lineIndex,
+		Success:   success,
+		Content:   common.GetJsonString(responseLine),
+	}
+}
+
+// finalizeBatch 将执行结果汇总为输出文件与错误文件，并结束批处理
+func finalizeBatch(batch *model.Batch, finalStatus string, extraErrorLines []string) {
+	batch.Status = model.BatchStatusFinalizing
+	batch.FinalizingAt = common.GetTimestamp()
+	if err := batch.Update(); err != nil {
+		common.SysError(fmt.Sprintf("failed to update batch %s: %s", batch.BatchId, err.Error()))
+		return
+	}
+
+	var output, errorOutput bytes.Buffer
+	completed, failed := 0, 0
+	afterLine := -1
+	for {
+		results, err := model.GetBatchResults(batch.BatchId, afterLine, batchResultPageSize)
+		if err != nil {
+			common.SysError(fmt.Sprintf("failed to get results of batch %s: %s", batch.BatchId, err.Error()))
+			return
+		}
+		for _, result := range results {
+			if result.Success {
+				output.WriteString(result.Content + "\n")
+				completed++
+			} else {
+				errorOutput.WriteString(result.Content + "\n")
+				failed++
+			}
+			afterLine = result.LineIndex
+		}
+		if len(results) < batchResultPageSize {
+			break
+		}
+	}
+	for _, line := range extraErrorLines {
+		errorOutput.WriteString(line + "\n")
+	}
+
+	ctx := context.Background()
+	if output.Len() > 0 {
+		file, err := SaveGeneratedFile(ctx, batch.UserId, batch.TokenId, batch.BatchId+"_output.jsonl", batchOutputPurpose, &output, int64(output.Len()))
+		if err != nil {
+			failBatch(batch, "output_file_failed", err.Error())
+			return
+		}
+		batch.OutputFileId = file.FileId
+	}
+	if errorOutput.Len() > 0 {
+		file, err := SaveGeneratedFile(ctx, batch.UserId, batch.TokenId, batch.BatchId+"_error.jsonl", batchOutputPurpose, &errorOutput, int64(errorOutput.Len()))
+		if err != nil {
+			failBatch(batch, "error_file_failed", err.Error())
+			return
+		}
+		batch.ErrorFileId = file.FileId
+	}
+
+	now := common.GetTimestamp()
+	batch.RequestCompleted = completed
+	batch.RequestFailed = failed
+	batch.Status = finalStatus
+	switch finalStatus {
+	case model.BatchStatusCompleted:
+		batch.CompletedAt = now
+	case model.BatchStatusCancelled:
+		batch.CancelledAt = now
+	case model.BatchStatusExpired:
+		batch.ExpiredAt = now
+	}
+	if err := batch.Update(); err != nil {
+		common.SysError(fmt.Sprintf("failed to update batch %s: %s", batch.BatchId, err.Error()))
+		return
+	}
+	if err := model.DeleteBatchResults(batch.BatchId); err != nil {
+		common.SysError(fmt.Sprintf("failed to delete results of batch %s: %s", batch.BatchId, err.Error()))
+	}
+	common.SysLog(fmt.Sprintf("batch %s %s, %d completed, %d failed", batch.BatchId, finalStatus, completed, failed))
+}
+
+// createNativeBatch 将批处理转发到支持原生 Batch API 的上游渠道，成功时填充上游的批处理信息。
+// 转发前按输入文件估算并预扣额度，转发失败时退还
+func createNativeBatch(c *gin.Context, batch *model.Batch, file *model.File, lines [][]byte) (err error) {
+	channel, selectGroup, err := CacheGetRandomSatisfiedChannel(&RetryParam{
+		Ctx:        c,
+		TokenGroup: batch.Group,
+		ModelName:  batch.Model,
+	})
+	if err != nil {
+		return err
+	}
+	if channel == nil {
+		return fmt.Errorf("no available channel for model %s", batch.Model)
+	}
+	// 创建原生批处理只调用一次上游接口，不占用渠道的并发名额
+	ReleaseChannelConcurrencyReservation(c)
+	if channel.Type != constant.ChannelTypeOpenAI {
+		return fmt.Errorf("channel #%d does not support native batch", channel.Id)
+	}
+	// 上游收到的是输入文件中的原始模型名，渠道配置了模型映射时无法转发
+	if mapping := channel.GetModelMapping(); mapping != "" && mapping != "{}" {
+		modelMapping := make(map[string]string)
+		if err = common.UnmarshalJsonStr(mapping, &modelMapping); err != nil || modelMapping[batch.Model] != "" {
+			return fmt.Errorf("channel #%d maps model %s", channel.Id, batch.Model)
+		}
+	}
+	_, keyIndex, apiErr := channel.GetNextEnabledKey()
+	if apiErr != nil {
+		return apiErr
+	}
+	quota, err := estimateNativeBatchQuota(batch, lines)
+	if err != nil {
+		return err
+	}
+	if err = chargeRequestQuota(c, quota, batch.BatchId); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			refundRequestQuota(c, quota, batch.BatchId)
+		}
+	}()
+	upstream, err := newOpenAIUpstream(channel, keyIndex)
+	if err != nil {
+		return err
+	}
+	ctx := c.Request.Context()
+	upstreamFileId, err := getUpstreamFileId(ctx, upstream, file)
+	if err != nil {
+		return err
+	}
+	reqBody := common.GetJsonString(map[string]string{
+		"input_file_id":     upstreamFileId,
+		"endpoint":          batch.Endpoint,
+		"completion_window": batch.CompletionWindow,
+	})
+	respBody, err := upstream.do(ctx, http.MethodPost, "/v1/batches", "application/json", strings.NewReader(reqBody))
+	if err != nil {
+		return err
+	}
+	var upstreamBatch dto.OpenAIBatch
+	if err = common.Unmarshal(respBody, &upstreamBatch); err != nil {
+		return err
+	}
+	if upstreamBatch.Id == "" {
+		return errors.New("empty batch id from upstream")
+	}
+	batch.Group = selectGroup
+	batch.ChannelId = channel.Id
+	batch.KeyIndex = keyIndex
+	batch.UpstreamBatchId = upstreamBatch.Id
+	batch.PreConsumedQuota = quota
+	applyUpstreamBatch(batch, &upstreamBatch)
+	logger.LogInfo(c, fmt.Sprintf("batch %s forwarded to channel #%d as %s", batch.BatchId, channel.Id, upstreamBatch.Id))
+	return nil
+}
+
+func getBatchUpstream(batch *model.Batch) (*openAIUpstream, error) {
+	channel, err := model.GetChannelById(batch.ChannelId, true)
+	if err != nil {
+		return nil, err
+	}
+	return newOpenAIUpstream(channel, batch.KeyIndex)
+}
+
+// applyUpstreamBatch 同步上游批处理的状态、进度与时间，输出文件由 syncNativeBatch 下载后单独设置
+func applyUpstreamBatch(batch *model.Batch, upstreamBatch *dto.OpenAIBatch) {
+	value := func(t *int64) int64 {
+		if t == nil {
+			return 0
+		}
+		return *t
+	}
+	batch.Status = upstreamBatch.Status
+	batch.RequestTotal = upstreamBatch.RequestCounts.Total
+	batch.RequestCompleted = upstreamBatch.RequestCounts.Completed
+	batch.RequestFailed = upstreamBatch.RequestCounts.Failed
+	batch.InProgressAt = value(upstreamBatch.InProgressAt)
+	batch.FinalizingAt = value(upstreamBatch.FinalizingAt)
+	batch.CompletedAt = value(upstreamBatch.CompletedAt)
+	batch.FailedAt = value(upstreamBatch.FailedAt)
+	batch.ExpiredAt = value(upstreamBatch.ExpiredAt)
+	batch.CancellingAt = value(upstreamBatch.CancellingAt)
+	batch.CancelledAt = value(upstreamBatch.CancelledAt)
+	if expiresAt := value(upstreamBatch.ExpiresAt); expiresAt > 0 {
+		batch.ExpiresAt = expiresAt
+	}
+	if upstreamBatch.Errors != nil {
+		batch.Errors = common.GetJsonString(upstreamBatch.Errors)
+	}
+}
+
+// syncNativeBatch 同步上游批处理的状态，结束后下载输出文件并按用量计费
+func syncNativeBatch(batch *model.Batch) error {
+	upstream, err := getBatchUpstream(batch)
+	if err != nil {
+		failBatch(batch, "channel_not_found", fmt.Sprintf("channel #%d not found", batch.ChannelId))
+		return err
+	}
+	ctx := context.Background()
+	respBody, err := upstream.do(ctx, http.MethodGet, "/v1/batches/"+batch.UpstreamBatchId, "", nil)
+	if err != nil {
+		return err
+	}
+	var upstreamBatch dto.OpenAIBatch
+	if err = common.Unmarshal(respBody, &upstreamBatch); err != nil {
+		return err
+	}
+	switch upstreamBatch.Status {
+	case model.BatchStatusCompleted, model.BatchStatusFailed, model.BatchStatusExpired, model.BatchStatusCancelled:
+	default:
+		applyUpstreamBatch(batch, &upstreamBatch)
+		return batch.Update()
+	}
+
+	var output, errorOutput []byte
+	if upstreamBatch.OutputFileId != nil {
+		if output, err = upstream.do(ctx, http.MethodGet, "/v1/files/"+*upstreamBatch.OutputFileId+"/content", "", nil); err != nil {
+			return err
+		}
+	}
+	if upstreamBatch.ErrorFileId != nil {
+		if errorOutput, err = upstream.do(ctx, http.MethodGet, "/v1/files/"+*upstreamBatch.ErrorFileId+"/content", "", nil); err != nil {
+			return err
+		}
+	}
+	if len(output) > 0 {
+		file, err := SaveGeneratedFile(ctx, batch.UserId, batch.TokenId, batch.BatchId+"_output.jsonl", batchOutputPurpose, bytes.NewReader(output), int64(len(output)))
+		if err != nil {
+			return err
+		}
+		batch.OutputFileId = file.FileId
+	}
+	if len(errorOutput) > 0 {
+		file, err := SaveGeneratedFile(ctx, batch.UserId, batch.TokenId, batch.BatchId+"_error.jsonl", batchOutputPurpose, bytes.NewReader(errorOutput), int64(len(errorOutput)))
+		if err != nil {
+			return err
+		}
+		batch.ErrorFileId = file.FileId
+	}
+	applyUpstreamBatch(batch, &upstreamBatch)
+	batch.Quota = billNativeBatch(batch, output)
+	return batch.Update()
+}
+
+// batchPriceData 按普通请求的价格计算方式获取批处理模型的价格与倍率，分组倍率包含批处理折扣
+func batchPriceData(batch *model.Batch, promptTokens int, maxTokens int) (types.PriceData, error) {
+	info := &relaycommon.RelayInfo{
+		OriginModelName: batch.Model,
+		UsingGroup:      batch.Group,
+		RelayFormat:     types.RelayFormatOpenAI,
+	}
+	if user, err := model.GetUserCache(batch.UserId); err == nil {
+		info.UserGroup = user.Group
+		info.UserSetting = user.GetSetting()
+	}
+	return helper.ModelPriceHelper(newBatchLogContext(batch), info, promptTokens, &types.TokenCountMeta{MaxTokens: maxTokens})
+}
+
+// estimateNativeBatchQuota 按输入文件中各行请求的估算输入 tokens 与最大输出 tokens 估算原生批处理的额度
+func estimateNativeBatchQuota(batch *model.Batch, lines [][]byte) (int, error) {
+	var promptTokens, maxTokens int
+	for _, line := range lines {
+		body := gjson.GetBytes(line, "body")
+		promptTokens += CountTextToken(body.Raw, batch.Model)
+		for _, field := range []string{"max_tokens", "max_completion_tokens", "max_output_tokens"} {
+			if value := body.Get(field).Int(); value > 0 {
+				maxTokens += int(value)
+				break
+			}
+		}
+	}
+	priceData, err := batchPriceData(batch, promptTokens, maxTokens)
+	if err != nil {
+		return 0, err
+	}
+	if priceData.UsePrice {
+		return priceData.QuotaToPreConsume * len(lines), nil
+	}
+	return priceData.QuotaToPreConsume, nil
+}
+
+// readBatchInputBodies 读取输入文件中各行请求的 body，custom_id -> body，用于匹配分档计费规则
+func readBatchInputBodies(batch *model.Batch) map[string][]byte {
+	bodies := make(map[string][]byte)
+	file, err := model.GetFileByFileId(batch.InputFileId)
+	if err != nil {
+		return bodies
+	}
+	data, err := readFileData(context.Background(), file)
+	if err != nil {
+		return bodies
+	}
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		if customId := gjson.GetBytes(line, "custom_id").String(); customId != "" {
+			bodies[customId] = []byte(gjson.GetBytes(line, "body").Raw)
+		}
+	}
+	return bodies
+}
+
+// billNativeBatch 按上游输出文件中每行的用量计费，缓存 tokens 与分档计费规则的处理与普通请求一致，
+// 与创建时预扣的额度多退少补，返回实际消耗的额度
+func billNativeBatch(batch *model.Batch, output []byte) int {
+	priceData, err := batchPriceData(batch, 0, 0)
+	if err != nil {
+		common.SysError(fmt.Sprintf("failed to get price of batch %s: %s", batch.BatchId, err.Error()))
+		return 0
+	}
+	bodies := readBatchInputBodies(batch)
+	var promptTokens, completionTokens, cacheTokens, requests int
+	var calculateQuota float64
+	for _, line := range bytes.Split(output, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		response := gjson.GetBytes(line, "response")
+		if response.Get("status_code").Int() != http.StatusOK {
+			continue
+		}
+		usage := response.Get("body.usage")
+		requests++
+		var linePrompt, lineCompletion, lineCache int
+		if usage.Get("input_tokens").Exists() {
+			linePrompt = int(usage.Get("input_tokens").Int())
+			lineCompletion = int(usage.Get("output_tokens").Int())
+			lineCache = int(usage.Get("input_tokens_details.cached_tokens").Int())
+		} else {
+			linePrompt = int(usage.Get("prompt_tokens").Int())
+			lineCompletion = int(usage.Get("completion_tokens").Int())
+			lineCache = int(usage.Get("prompt_tokens_details.cached_tokens").Int())
+		}
+		promptTokens += linePrompt
+		completionTokens += lineCompletion
+		cacheTokens += lineCache
+		if priceData.UsePrice {
+			calculateQuota += priceData.ModelPrice * common.QuotaPerUnit * priceData.GroupRatioInfo.GroupRatio
+			continue
+		}
+		linePrice := priceData
+		linePrice.PricingRuleRequest = ratio_setting.PricingRuleRequestAttributes(batch.Model, bodies[gjson.GetBytes(line, "custom_id").String()])
+		ratio_setting.ApplyModelPricingRule(&linePrice, batch.Model, linePrompt)
+		lineQuota := float64(linePrompt-lineCache) + float64(lineCache)*linePrice.CacheRatio + float64(lineCompletion)*linePrice.CompletionRatio
+		calculateQuota += lineQuota * linePrice.ModelRatio * linePrice.GroupRatioInfo.GroupRatio
+	}
+	quota := int(calculateQuota)
+	if requests > 0 && quota <= 0 && !priceData.UsePrice && priceData.ModelRatio != 0 && priceData.GroupRatioInfo.GroupRatio != 0 {
+		quota = 1
+	}
+
+	token, err := model.GetTokenById(batch.TokenId)
+	if err != nil {
+		common.SysError(fmt.Sprintf("failed to get token of batch %s: %s", batch.BatchId, err.Error()))
+		return 0
+	}
+	relayInfo := &relaycommon.RelayInfo{
+		UserId:         batch.UserId,
+		TokenId:        token.Id,
+		TokenKey:       token.Key,
+		TokenUnlimited: token.UnlimitedQuota,
+	}
+	if quotaDelta := quota - batch.PreConsumedQuota; quotaDelta != 0 {
+		if err = PostConsumeQuota(relayInfo, quotaDelta, batch.PreConsumedQuota, false); err != nil {
+			common.SysError(fmt.Sprintf("failed to settle quota of batch %s: %s", batch.BatchId, err.Error()))
+		}
+	}
+	if requests == 0 {
+		return 0
+	}
+	model.UpdateUserUsedQuotaAndRequestCount(batch.UserId, quota)
+	model.UpdateChannelUsedQuota(batch.ChannelId, quota)
+
+	c := newBatchLogContext(batch)
+	other := map[string]interface{}{
+		"model_ratio":          priceData.ModelRatio,
+		"group_ratio":          priceData.GroupRatioInfo.GroupRatio,
+		"completion_ratio":     priceData.CompletionRatio,
+		"cache_ratio":          priceData.CacheRatio,
+		"cache_tokens":         cacheTokens,
+		"model_price":          priceData.ModelPrice,
+		"batch_id":             batch.BatchId,
+		"batch_discount_ratio": operation_setting.GetBatchDiscountRatio(),
+		"batch_requests":       requests,
+		"pre_consumed_quota":   batch.PreConsumedQuota,
+		"request_path":         batch.Endpoint,
+	}
+	model.RecordConsumeLog(c, batch.UserId, model.RecordConsumeLogParams{
+		ChannelId:        batch.ChannelId,
+		PromptTokens:     promptTokens,
+		CompletionTokens: completionTokens,
+		ModelName:        batch.Model,
+		TokenName:        token.Name,
+		Quota:            quota,
+		Content:          fmt.Sprintf("批处理 %s，%d 个请求", batch.BatchId, requests),
+		TokenId:          token.Id,
+		Group:            batch.Group,
+		Other:            other,
+	})
+	return quota
+}
+
+// newBatchLogContext 创建后台同步批处理时记录日志使用的上下文
+func newBatchLogContext(batch *model.Batch) *gin.Context {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	ctx := context.WithValue(context.Background(), constant.ContextKeyBatchId, batch.BatchId)
+	c.Request = httptest.NewRequestWithContext(ctx, http.MethodPost, batch.Endpoint, nil)
+	c.Request.RemoteAddr = ""
+	username, _ := model.GetUsernameById(batch.UserId, false)
+	c.Set("username", username)
+	return c
+}
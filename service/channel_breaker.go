@@ -0,0 +1,95 @@
+package service
+
+import (
+	"fmt"
+
+	"github.com/ctrlc-ctrlv-limited/cvai/common"
+	"github.com/ctrlc-ctrlv-limited/cvai/constant"
+	"github.com/ctrlc-ctrlv-limited/cvai/logger"
+	"github.com/ctrlc-ctrlv-limited/cvai/model"
+	"github.com/ctrlc-ctrlv-limited/cvai/setting/operation_setting"
+	"github.com/ctrlc-ctrlv-limited/cvai/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// channelBreakerProbe 转发请求时占用的半开探测名额，只由占用它的上下文记录结果或归还，
+// 复制的上下文中带有同一名额时不做处理
+type channelBreakerProbe struct {
+	channelId int
+	modelName string
+	keyIndex  int
+	owner     *gin.Context
+}
+
+func getChannelBreakerKeyIndex(c *gin.Context) int {
+	if common.GetContextKeyBool(c, constant.ContextKeyChannelIsMultiKey) {
+		return common.GetContextKeyInt(c, constant.ContextKeyChannelMultiKeyIndex)
+	}
+	return 0
+}
+
+func getChannelBreakerProbe(c *gin.Context) *channelBreakerProbe {
+	probe, ok := common.GetContextKeyType[*channelBreakerProbe](c, constant.ContextKeyChannelBreakerProbe)
+	if !ok || probe == nil || probe.owner != c {
+		return nil
+	}
+	return probe
+}
+
+// AcquireChannelBreaker 向选中的渠道与 key 转发请求前调用，返回 false 表示 key 在该模型上已熔断或半开探测名额已满。
+// 只有会通过 RecordChannelBreaker 记录结果的转发才调用，占用的探测名额在记录结果时归还，未记录结果时由 ReleaseChannelBreaker 归还
+func AcquireChannelBreaker(c *gin.Context, channel *model.Channel, modelName string) bool {
+	ReleaseChannelBreaker(c)
+	if channel == nil {
+		return true
+	}
+	keyIndex := getChannelBreakerKeyIndex(c)
+	allowed, probe := model.AcquireKeyBreaker(channel.Id, modelName, keyIndex)
+	if probe {
+		common.SetContextKey(c, constant.ContextKeyChannelBreakerProbe, &channelBreakerProbe{channelId: channel.Id, modelName: modelName, keyIndex: keyIndex, owner: c})
+	}
+	return allowed
+}
+
+// ReleaseChannelBreaker 归还上下文中尚未记录结果的探测名额
+func ReleaseChannelBreaker(c *gin.Context) {
+	probe := getChannelBreakerProbe(c)
+	if probe == nil {
+		return
+	}
+	common.SetContextKey(c, constant.ContextKeyChannelBreakerProbe, nil)
+	model.ReleaseKeyBreaker(probe.channelId, probe.modelName, probe.keyIndex)
+}
+
+// TransferChannelBreaker 将尚未记录结果的探测名额转交给复制的上下文
+func TransferChannelBreaker(from *gin.Context, to *gin.Context) {
+	probe := getChannelBreakerProbe(from)
+	if probe == nil {
+		return
+	}
+	common.SetContextKey(from, constant.ContextKeyChannelBreakerProbe, nil)
+	common.SetContextKey(to, constant.ContextKeyChannelBreakerProbe, &channelBreakerProbe{channelId: probe.channelId, modelName: probe.modelName, keyIndex: probe.keyIndex, owner: to})
+}
+
+// RecordChannelBreaker 记录渠道熔断结果，未启用自动禁用的渠道只记录成功，不会被熔断
+func RecordChannelBreaker(c *gin.Context, channel *model.Channel, modelName string, err *types.CVAIError) {
+	if channel == nil || !operation_setting.IsCircuitBreakerEnabled() {
+		ReleaseChannelBreaker(c)
+		return
+	}
+	keyIndex := getChannelBreakerKeyIndex(c)
+	// 非渠道原因的错误（例如请求参数错误）说明上游可用，按成功处理以释放半开探测名额
+	success := err == nil || !isChannelHealthError(err)
+	if !success && !channel.GetAutoBan() {
+		// 不记录结果，归还占用的探测名额
+		ReleaseChannelBreaker(c)
+		return
+	}
+	// 记录结果时归还探测名额
+	common.SetContextKey(c, constant.ContextKeyChannelBreakerProbe, nil)
+	state := model.RecordChannelBreaker(channel.Id, modelName, keyIndex, success)
+	if !success && state == model.BreakerStateOpen {
+		logger.LogWarn(c, fmt.Sprintf("channel #%d model %s key #%d circuit breaker opened: %s", channel.Id, modelName, keyIndex, err.Error()))
+	}
+}
@@ -0,0 +1,82 @@
+package service
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ctrlc-ctrlv-limited/cvai/model"
+	"github.com/ctrlc-ctrlv-limited/cvai/setting/operation_setting"
+	"github.com/ctrlc-ctrlv-limited/cvai/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+func newChannelBreakerTestContext() *gin.Context {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	return c
+}
+
+func TestChannelBreakerProbeReleasedWithoutRecord(t *testing.T) {
+	setting := operation_setting.GetCircuitBreakerSetting()
+	old := *setting
+	*setting = operation_setting.CircuitBreakerSetting{
+		Enabled:          true,
+		FailureThreshold: 1,
+		WindowSeconds:    60,
+		OpenSeconds:      2,
+		HalfOpenProbes:   1,
+	}
+	model.ResetChannelBreakers(0)
+	t.Cleanup(func() {
+		*setting = old
+		model.ResetChannelBreakers(0)
+	})
+
+	autoBan := 0
+	channel := &model.Channel{Id: 1, AutoBan: &autoBan}
+	model.RecordChannelBreaker(channel.Id, "test-model", 0, false)
+	// 等待熔断到期进入半开状态
+	time.Sleep(2 * time.Second)
+
+	upstreamErr := types.NewErrorWithStatusCode(errors.New("upstream error"), types.ErrorCodeBadResponseStatusCode, http.StatusInternalServerError)
+	tests := []struct {
+		name   string
+		finish func(c *gin.Context)
+	}{
+		{name: "failure not recorded without auto ban", finish: func(c *gin.Context) {
+			RecordChannelBreaker(c, channel, "test-model", upstreamErr)
+		}},
+		{name: "released without record", finish: ReleaseChannelBreaker},
+		{name: "transferred and released by copy", finish: func(c *gin.Context) {
+			cp := c.Copy()
+			TransferChannelBreaker(c, cp)
+			// 原上下文不再持有名额
+			ReleaseChannelBreaker(c)
+			if AcquireChannelBreaker(newChannelBreakerTestContext(), channel, "test-model") {
+				t.Fatalf("expected probe to stay with the copied context")
+			}
+			ReleaseChannelBreaker(cp)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newChannelBreakerTestContext()
+			if !AcquireChannelBreaker(c, channel, "test-model") {
+				t.Fatalf("expected half-open key to allow a probe")
+			}
+			if AcquireChannelBreaker(newChannelBreakerTestContext(), channel, "test-model") {
+				t.Fatalf("expected probe limit to reject a concurrent request")
+			}
+			tt.finish(c)
+			other := newChannelBreakerTestContext()
+			if !AcquireChannelBreaker(other, channel, "test-model") {
+				t.Fatalf("expected probe to be released")
+			}
+			ReleaseChannelBreaker(other)
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package service
+
+import (
+	"github.com/ctrlc-ctrlv-limited/cvai/common"
+	"github.com/ctrlc-ctrlv-limited/cvai/constant"
+	"github.com/ctrlc-ctrlv-limited/cvai/dto"
+	"github.com/ctrlc-ctrlv-limited/cvai/model"
+
+	"github.com/gin-gonic/gin"
+)
+
+// channelConcurrencyReservation 选择渠道时占用的并发名额，只由占用它的上下文接管或释放，
+// 复制的上下文中带有同一名额时不做处理
+type channelConcurrencyReservation struct {
+	channelId int
+	owner     *gin.Context
+}
+
+func getChannelConcurrencyReservation(c *gin.Context) *channelConcurrencyReservation {
+	reservation, ok := common.GetContextKeyType[*channelConcurrencyReservation](c, constant.ContextKeyChannelConcurrencyReservation)
+	if !ok || reservation == nil || reservation.owner != c {
+		return nil
+	}
+	return reservation
+}
+
+// ReserveChannelConcurrency 选中设置了最大并发数的渠道时为请求占用一个并发名额，返回 false 表示渠道并发已满。
+// 名额由 AcquireChannelConcurrency 在请求上游时接管，未接管的名额在重新选择渠道或请求结束时释放
+func ReserveChannelConcurrency(c *gin.Context, channel *model.Channel) bool {
+	ReleaseChannelConcurrencyReservation(c)
+	maxConcurrency := channel.GetSetting().MaxConcurrency
+	if maxConcurrency <= 0 {
+		return true
+	}
+	if !model.TryAcquireChannelConcurrency(channel.Id, maxConcurrency) {
+		return false
+	}
+	common.SetContextKey(c, constant.ContextKeyChannelConcurrencyReservation, &channelConcurrencyReservation{channelId: channel.Id, owner: c})
+	return true
+}
+
+// ReleaseChannelConcurrencyReservation 释放上下文中尚未被接管的并发名额
+func ReleaseChannelConcurrencyReservation(c *gin.Context) {
+	reservation := getChannelConcurrencyReservation(c)
+	if reservation == nil {
+		return
+	}
+	common.SetContextKey(c, constant.ContextKeyChannelConcurrencyReservation, nil)
+	model.ReleaseChannelConcurrency(reservation.channelId)
+}
+
+// TransferChannelConcurrencyReservation 将尚未被接管的并发名额转交给复制的上下文
+func TransferChannelConcurrencyReservation(from *gin.Context, to *gin.Context) {
+	reservation := getChannelConcurrencyReservation(from)
+	if reservation == nil {
+		return
+	}
+	common.SetContextKey(from, constant.ContextKeyChannelConcurrencyReservation, nil)
+	common.SetContextKey(to, constant.ContextKeyChannelConcurrencyReservation, &channelConcurrencyReservation{channelId: reservation.channelId, owner: to})
+}
+
+// AcquireChannelConcurrency 当前选中的渠道设置了最大并发数时记录一个进行中的请求，返回的函数在请求结束后调用以释放。
+// 选择渠道时已占用名额的直接接管，指定渠道的请求不经过选择，不检查最大并发数
+func AcquireChannelConcurrency(c *gin.Context, channelId int) func() {
+	if reservation := getChannelConcurrencyReservation(c); reservation != nil && reservation.channelId == channelId {
+		common.SetContextKey(c, constant.ContextKeyChannelConcurrencyReservation, nil)
+		return func() {
+			model.ReleaseChannelConcurrency(channelId)
+		}
+	}
+	setting, ok := common.GetContextKeyType[dto.ChannelSettings](c, constant.ContextKeyChannelSetting)
+	if !ok || setting.MaxConcurrency <= 0 {
+		return func() {}
+	}
+	model.AcquireChannelConcurrency(channelId)
+	return func() {
+		model.ReleaseChannelConcurrency(channelId)
+	}
+}
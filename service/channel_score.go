@@ -0,0 +1,45 @@
+package service
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/ctrlc-ctrlv-limited/cvai/model"
+	relaycommon "github.com/ctrlc-ctrlv-limited/cvai/relay/common"
+	"github.com/ctrlc-ctrlv-limited/cvai/setting/operation_setting"
+	"github.com/ctrlc-ctrlv-limited/cvai/types"
+)
+
+// isChannelHealthError 判断错误是否反映了渠道本身的健康状况，用户请求错误不计入渠道错误率
+func isChannelHealthError(err *types.CVAIError) bool {
+	if err == nil {
+		return false
+	}
+	if types.IsChannelError(err) {
+		return true
+	}
+	switch err.StatusCode {
+	case http.StatusTooManyRequests, http.StatusRequestTimeout, http.StatusUnauthorized, http.StatusForbidden:
+		return true
+	}
+	return err.StatusCode/100 == 5
+}
+
+// RecordChannelScore 记录一次渠道请求的耗时与结果，用于自适应渠道选择
+func RecordChannelScore(info *relaycommon.RelayInfo, channelId int, attemptStart time.Time, err *types.CVAIError) {
+	if info == nil || channelId == 0 || !operation_setting.IsAdaptiveSelectUsed() {
+		return
+	}
+	if err != nil && !isChannelHealthError(err) {
+		return
+	}
+	if err != nil {
+		model.RecordChannelScore(channelId, info.OriginModelName, false, -1, -1)
+		return
+	}
+	ttft := time.Duration(-1)
+	if info.HasSendResponse() && info.FirstResponseTime.After(attemptStart) {
+		ttft = info.FirstResponseTime.Sub(attemptStart)
+	}
+	model.RecordChannelScore(channelId, info.OriginModelName, true, ttft, time.Since(attemptStart))
+}
@@ -17,6 +17,8 @@ type RetryParam struct {
 	ModelName    string
 	Retry        *int
 	resetNextTry bool
+	// 本次请求中因没有可用 key 等原因跳过的渠道，后续选择时排除
+	excludedChannelIds []int
 }
 
 func (p *RetryParam) GetRetry() int {
@@ -45,7 +47,12 @@ func (p *RetryParam) ResetRetryNextTry() {
 	p.resetNextTry = true
 }
 
-// CacheGetRandomSatisfiedChannel tries to get a random channel that satisfies the requirements.
+// ExcludeChannel 在后续选择中排除渠道，不消耗重试次数
+func (p *RetryParam) ExcludeChannel(channelId int) {
+	p.excludedChannelIds = append(p.excludedChannelIds, channelId)
+}
+
+// selectRandomSatisfiedChannel tries to get a random channel that satisfies the requirements.
 // 尝试获取一个满足要求的随机渠道。
 //
 // For "auto" tokenGroup with cross-group Retry enabled:
@@ -80,7 +87,7 @@ func (p *RetryParam) ResetRetryNextTry() {
 //
 //	Retry=3: GroupB, priority1 (startRetryIndex=2, priorityRetry=1)
 //	         分组B, 优先级1
-func CacheGetRandomSatisfiedChannel(param *RetryParam) (*model.Channel, string, error) {
+func selectRandomSatisfiedChannel(param *RetryParam) (*model.Channel, string, error) {
 	var channel *model.Channel
 	var err error
 	selectGroup := param.TokenGroup
@@ -115,7 +122,7 @@ func CacheGetRandomSatisfiedChannel(param *RetryParam) (*model.Channel, string,
 			}
 			logger.LogDebug(param.Ctx, "Auto selecting group: %s, priorityRetry: %d", autoGroup, priorityRetry)
 
-			channel, _ = model.GetRandomSatisfiedChannel(autoGroup, param.ModelName, priorityRetry)
+			channel, _ = model.GetRandomSatisfiedChannel(autoGroup, param.ModelName, priorityRetry, param.excludedChannelIds)
 			if channel == nil {
 				// Current group has no available channel for this model, try next group
 				// 当前分组没有该模型的可用渠道，尝试下一个分组
@@ -153,10 +160,29 @@ func CacheGetRandomSatisfiedChannel(param *RetryParam) (*model.Channel, string,
 			break
 		}
 	} else {
-		channel, err = model.GetRandomSatisfiedChannel(param.TokenGroup, param.ModelName, param.GetRetry())
+		channel, err = model.GetRandomSatisfiedChannel(param.TokenGroup, param.ModelName, param.GetRetry(), param.excludedChannelIds)
 		if err != nil {
 			return nil, param.TokenGroup, err
 		}
 	}
 	return channel, selectGroup, nil
 }
+
+// 渠道的并发名额被其他请求抢先占用时重新选择渠道的次数
+const channelConcurrencyReserveAttempts = 3
+
+// CacheGetRandomSatisfiedChannel 选择一个可用渠道并为请求占用该渠道的并发名额，
+// 占用失败的渠道视为并发已满，在后续选择中被跳过
+func CacheGetRandomSatisfiedChannel(param *RetryParam) (*model.Channel, string, error) {
+	for i := 0; i < channelConcurrencyReserveAttempts; i++ {
+		channel, selectGroup, err := selectRandomSatisfiedChannel(param)
+		if err != nil || channel == nil {
+			return channel, selectGroup, err
+		}
+		if ReserveChannelConcurrency(param.Ctx, channel) {
+			return channel, selectGroup, nil
+		}
+		logger.LogDebug(param.Ctx, "channel #%d is saturated, selecting another channel", channel.Id)
+	}
+	return nil, param.TokenGroup, nil
+}
@@ -0,0 +1,131 @@
+package service
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ctrlc-ctrlv-limited/cvai/common"
+	"github.com/ctrlc-ctrlv-limited/cvai/constant"
+	"github.com/ctrlc-ctrlv-limited/cvai/dto"
+	"github.com/ctrlc-ctrlv-limited/cvai/logger"
+	"github.com/ctrlc-ctrlv-limited/cvai/model"
+	relaycommon "github.com/ctrlc-ctrlv-limited/cvai/relay/common"
+	"github.com/ctrlc-ctrlv-limited/cvai/setting/operation_setting"
+
+	"github.com/bytedance/gopkg/util/gopool"
+	"github.com/gin-gonic/gin"
+)
+
+// 信用额度用尽（账户暂停）时的通知级别
+const creditAlertLevelSuspended = 100
+
+const creditAlertLevelExpiration = 30 * 24 * time.Hour
+
+// 未启用 Redis 时在内存中记录每个用户最近一次通知的级别
+var creditAlertLevels sync.Map
+
+// GetUserCreditLimit 返回当前请求用户的信用额度，0 表示预付费
+func GetUserCreditLimit(c *gin.Context) int {
+	return operation_setting.GetCreditLimit(common.GetContextKeyInt(c, constant.ContextKeyUserCreditLimit), common.GetContextKeyString(c, constant.ContextKeyUserGroup))
+}
+
+// GetUserAvailableQuota 返回用户的余额与可用额度（余额加信用额度），预付费用户两者相同
+func GetUserAvailableQuota(c *gin.Context, userId int) (userQuota int, availableQuota int, err error) {
+	userQuota, err = model.GetUserQuota(userId, false)
+	if err != nil {
+		return 0, 0, err
+	}
+	return userQuota, userQuota + GetUserCreditLimit(c), nil
+}
+
+func creditAlertLevelKey(userId int) string {
+	return fmt.Sprintf("credit_alert:%d", userId)
+}
+
+func getCreditAlertLevel(userId int) int {
+	if common.RedisEnabled {
+		value, err := common.RedisGet(creditAlertLevelKey(userId))
+		if err != nil {
+			return 0
+		}
+		level, _ := strconv.Atoi(value)
+		return level
+	}
+	if level, ok := creditAlertLevels.Load(userId); ok {
+		return level.(int)
+	}
+	return 0
+}
+
+func setCreditAlertLevel(userId int, level int) {
+	if common.RedisEnabled {
+		if err := common.RedisSet(creditAlertLevelKey(userId), strconv.Itoa(level), creditAlertLevelExpiration); err != nil {
+			common.SysError(fmt.Sprintf("failed to set credit alert level of user %d: %s", userId, err.Error()))
+		}
+		return
+	}
+	if level == 0 {
+		creditAlertLevels.Delete(userId)
+		return
+	}
+	creditAlertLevels.Store(userId, level)
+}
+
+// creditAlertLevel 返回已用信用额度所处的通知级别，即达到的最高通知百分比，用尽时为 creditAlertLevelSuspended
+func creditAlertLevel(usedCredit int, creditLimit int) int {
+	if usedCredit >= creditLimit {
+		return creditAlertLevelSuspended
+	}
+	percent := usedCredit * 100 / creditLimit
+	level := 0
+	for _, alertPercent := range operation_setting.GetCreditSetting().AlertPercentages {
+		if alertPercent > level && alertPercent <= percent && alertPercent < creditAlertLevelSuspended {
+			level = alertPercent
+		}
+	}
+	return level
+}
+
+// checkAndSendCreditNotify 结算后检查信用额度的使用比例，达到新的通知级别时通知用户，用尽时同时通知管理员。
+// 余额恢复后通知级别随之降低，再次透支时重新通知
+func checkAndSendCreditNotify(relayInfo *relaycommon.RelayInfo) {
+	creditLimit := relayInfo.UserCreditLimit
+	gopool.Go(func() {
+		userQuota, err := model.GetUserQuota(relayInfo.UserId, false)
+		if err != nil {
+			common.SysError(fmt.Sprintf("failed to get quota of user %d: %s", relayInfo.UserId, err.Error()))
+			return
+		}
+		level := 0
+		if userQuota < 0 {
+			level = creditAlertLevel(-userQuota, creditLimit)
+		}
+		previousLevel := getCreditAlertLevel(relayInfo.UserId)
+		if level == previousLevel {
+			return
+		}
+		setCreditAlertLevel(relayInfo.UserId, level)
+		if level < previousLevel {
+			return
+		}
+
+		used := logger.FormatQuota(-userQuota)
+		limit := logger.FormatQuota(creditLimit)
+		var notify dto.Notify
+		if level == creditAlertLevelSuspended {
+			notify = dto.NewNotify(dto.NotifyTypeCreditLimit, "信用额度已用尽，账户已暂停使用",
+				"您的信用额度 {{value}} 已用尽，当前欠款 {{value}}，账户已暂停使用，结清欠款后将自动恢复。", []interface{}{limit, used})
+			model.RecordLog(relayInfo.UserId, model.LogTypeSystem, fmt.Sprintf("信用额度 %s 已用尽，当前欠款 %s，账户已暂停使用", limit, used))
+			NotifyRootUser(dto.NotifyTypeCreditLimit, "用户信用额度已用尽",
+				fmt.Sprintf("用户 %d 的信用额度 %s 已用尽，当前欠款 %s，账户已暂停使用", relayInfo.UserId, limit, used))
+		} else {
+			notify = dto.NewNotify(dto.NotifyTypeCreditLimit, "信用额度使用提醒",
+				"您已使用信用额度 {{value}} 的 {{value}}%，当前欠款 {{value}}，用尽后账户将暂停使用，请及时结清。", []interface{}{limit, level, used})
+		}
+		if err := NotifyUser(relayInfo.UserId, relayInfo.UserEmail, relayInfo.UserSetting, notify); err != nil {
+			common.SysError(fmt.Sprintf("failed to send credit notify to user %d: %s", relayInfo.UserId, err.Error()))
+		}
+	})
+}
@@ -0,0 +1,141 @@
+package service
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ctrlc-ctrlv-limited/cvai/common"
+	"github.com/ctrlc-ctrlv-limited/cvai/constant"
+	"github.com/ctrlc-ctrlv-limited/cvai/dto"
+	"github.com/ctrlc-ctrlv-limited/cvai/model"
+	relaycommon "github.com/ctrlc-ctrlv-limited/cvai/relay/common"
+	"github.com/ctrlc-ctrlv-limited/cvai/setting/operation_setting"
+	"github.com/ctrlc-ctrlv-limited/cvai/types"
+
+	"github.com/gin-gonic/gin"
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+func setupCreditTest(t *testing.T) {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	if err = db.AutoMigrate(&model.User{}, &model.QuotaLedgerEntry{}); err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+	setting := operation_setting.GetCreditSetting()
+	oldDB, oldLimits, oldBatch, oldRedis := model.DB, setting.GroupCreditLimits, common.BatchUpdateEnabled, common.RedisEnabled
+	model.DB = db
+	setting.GroupCreditLimits = map[string]int{"vip": 1000}
+	common.BatchUpdateEnabled = false
+	common.RedisEnabled = false
+	t.Cleanup(func() {
+		model.DB = oldDB
+		setting.GroupCreditLimits = oldLimits
+		common.BatchUpdateEnabled = oldBatch
+		common.RedisEnabled = oldRedis
+	})
+}
+
+func newCreditTestContext(user *model.User) *gin.Context {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("POST", "/v1/chat/completions", nil)
+	common.SetContextKey(c, constant.ContextKeyUserGroup, user.Group)
+	common.SetContextKey(c, constant.ContextKeyUserCreditLimit, user.CreditLimit)
+	return c
+}
+
+func TestGetCreditLimit(t *testing.T) {
+	setupCreditTest(t)
+	tests := []struct {
+		name            string
+		userCreditLimit int
+		group           string
+		want            int
+	}{
+		{name: "inherit group limit", userCreditLimit: operation_setting.UserCreditLimitInherit, group: "vip", want: 1000},
+		{name: "inherit without group limit", userCreditLimit: operation_setting.UserCreditLimitInherit, group: "default", want: 0},
+		{name: "prepaid overrides group limit", userCreditLimit: 0, group: "vip", want: 0},
+		{name: "user limit overrides group limit", userCreditLimit: 500, group: "vip", want: 500},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := operation_setting.GetCreditLimit(tt.userCreditLimit, tt.group); got != tt.want {
+				t.Fatalf("GetCreditLimit(%d, %q) = %d, want %d", tt.userCreditLimit, tt.group, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewUserInheritsGroupCreditLimit(t *testing.T) {
+	setupCreditTest(t)
+	user := &model.User{Username: "credit", Group: "vip"}
+	if err := model.DB.Create(user).Error; err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	created, err := model.GetUserById(user.Id, false)
+	if err != nil {
+		t.Fatalf("GetUserById returned error: %v", err)
+	}
+	if created.CreditLimit != operation_setting.UserCreditLimitInherit {
+		t.Fatalf("expected new user credit limit %d, got %d", operation_setting.UserCreditLimitInherit, created.CreditLimit)
+	}
+	if got := GetUserCreditLimit(newCreditTestContext(created)); got != 1000 {
+		t.Fatalf("expected group credit limit 1000, got %d", got)
+	}
+}
+
+func TestPreConsumeQuotaWithCreditLimit(t *testing.T) {
+	setupCreditTest(t)
+	trustQuota := common.GetTrustQuota()
+	tests := []struct {
+		name          string
+		quota         int
+		creditLimit   int
+		preConsume    int
+		wantErrorCode types.ErrorCode
+		wantConsumed  int
+	}{
+		{name: "trusted positive balance", quota: trustQuota * 2, creditLimit: 0, preConsume: 100, wantConsumed: 0},
+		{name: "negative balance is not trusted", quota: -100, creditLimit: trustQuota * 2, preConsume: 100, wantConsumed: 100},
+		{name: "credit limit exhausted", quota: -trustQuota, creditLimit: trustQuota, preConsume: 100, wantErrorCode: types.ErrorCodeCreditLimitExceeded},
+		{name: "prepaid without balance", quota: 0, creditLimit: 0, preConsume: 100, wantErrorCode: types.ErrorCodeInsufficientUserQuota},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := &model.User{Username: tt.name, AffCode: tt.name, Quota: tt.quota, CreditLimit: tt.creditLimit}
+			if err := model.DB.Create(user).Error; err != nil {
+				t.Fatalf("failed to create user: %v", err)
+			}
+			// 创建时 0 会被数据库默认值 -1 取代
+			model.DB.Model(user).Update("credit_limit", tt.creditLimit)
+			relayInfo := &relaycommon.RelayInfo{
+				UserId:         user.Id,
+				IsPlayground:   true,
+				TokenUnlimited: true,
+				TokenBudget:    &dto.QuotaBudget{},
+				UserBudget:     &dto.QuotaBudget{},
+			}
+			apiErr := PreConsumeQuota(newCreditTestContext(user), tt.preConsume, relayInfo)
+			if tt.wantErrorCode != "" {
+				if apiErr == nil || apiErr.GetErrorCode() != tt.wantErrorCode {
+					t.Fatalf("expected error %s, got %v", tt.wantErrorCode, apiErr)
+				}
+				return
+			}
+			if apiErr != nil {
+				t.Fatalf("PreConsumeQuota returned error: %v", apiErr)
+			}
+			if relayInfo.FinalPreConsumedQuota != tt.wantConsumed {
+				t.Fatalf("expected pre-consumed quota %d, got %d", tt.wantConsumed, relayInfo.FinalPreConsumedQuota)
+			}
+			quota, _ := model.GetUserQuota(user.Id, true)
+			if quota != tt.quota-tt.wantConsumed {
+				t.Fatalf("expected user quota %d, got %d", tt.quota-tt.wantConsumed, quota)
+			}
+		})
+	}
+}
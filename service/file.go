@@ -0,0 +1,362 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"regexp"
+	"slices"
+	"time"
+
+	"github.com/ctrlc-ctrlv-limited/cvai/common"
+	"github.com/ctrlc-ctrlv-limited/cvai/constant"
+	"github.com/ctrlc-ctrlv-limited/cvai/dto"
+	"github.com/ctrlc-ctrlv-limited/cvai/logger"
+	"github.com/ctrlc-ctrlv-limited/cvai/model"
+	relaycommon "github.com/ctrlc-ctrlv-limited/cvai/relay/common"
+	"github.com/ctrlc-ctrlv-limited/cvai/setting/operation_setting"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+const fileIdPrefix = "file-"
+
+// 每次清理过期文件时处理的数量
+const fileCleanupBatchSize = 100
+
+// FilePurposes OpenAI Files API 支持的用途
+var FilePurposes = []string{"assistants", "batch", "fine-tune", "vision", "user_data", "evals"}
+
+// 请求体中可能引用网关文件的 id
+var requestFileIdPattern = regexp.MustCompile(`"(file-[A-Za-z0-9]{24,})"`)
+
+// ToOpenAIFile 转换为 OpenAI 格式的文件对象
+func ToOpenAIFile(file *model.File) dto.OpenAIFile {
+	result := dto.OpenAIFile{
+		Id:        file.FileId,
+		Object:    "file",
+		Bytes:     file.Bytes,
+		CreatedAt: file.CreatedAt,
+		Filename:  file.Filename,
+		Purpose:   file.Purpose,
+		Status:    file.Status,
+	}
+	if file.ExpiresAt > 0 {
+		result.ExpiresAt = common.GetPointer(file.ExpiresAt)
+	}
+	return result
+}
+
+func fileStoragePath(file *model.File) string {
+	return fmt.Sprintf("%d/%s", file.UserId, file.FileId)
+}
+
+// fileStorageQuota 按文件大小计算上传时扣除的额度，不足 1MB 按 1MB 计算
+func fileStorageQuota(size int64) int {
+	perMB := operation_setting.GetFileSetting().QuotaPerMB
+	if perMB <= 0 {
+		return 0
+	}
+	mb := (size + 1<<20 - 1) >> 20
+	return int(mb) * perMB
+}
+
+// UploadFile 保存用户上传的文件并扣除存储额度，expiresAfter 为用户指定的有效期（秒），0 表示使用默认有效期
+func UploadFile(c *gin.Context, header *multipart.FileHeader, purpose string, expiresAfter int64) (*model.File, error) {
+	setting := operation_setting.GetFileSetting()
+	if !slices.Contains(FilePurposes, purpose) {
+		return nil, fmt.Errorf("invalid purpose: %s", purpose)
+	}
+	if setting.MaxFileSizeMB > 0 && header.Size > int64(setting.MaxFileSizeMB)<<20 {
+		return nil, fmt.Errorf("file is too large, max size is %d MB", setting.MaxFileSizeMB)
+	}
+	userId := c.GetInt("id")
+	if setting.MaxUserStorageMB > 0 {
+		used, err := model.GetUserFileBytes(userId)
+		if err != nil {
+			return nil, err
+		}
+		if used+header.Size > int64(setting.MaxUserStorageMB)<<20 {
+			return nil, fmt.Errorf("file storage limit exceeded, max storage is %d MB", setting.MaxUserStorageMB)
+		}
+	}
+	storage, err := GetFileStorage()
+	if err != nil {
+		return nil, err
+	}
+	src, err := header.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	file := &model.File{
+		FileId:      fileIdPrefix + common.GetRandomString(24),
+		UserId:      userId,
+		TokenId:     c.GetInt("token_id"),
+		Filename:    header.Filename,
+		Purpose:     purpose,
+		Bytes:       header.Size,
+		ContentType: header.Header.Get("Content-Type"),
+		Status:      model.FileStatusProcessed,
+		StorageType: storage.Type(),
+		Quota:       fileStorageQuota(header.Size),
+	}
+	if expiresAfter > 0 {
+		file.ExpiresAt = common.GetTimestamp() + expiresAfter
+	} else if setting.DefaultExpireDays > 0 {
+		file.ExpiresAt = common.GetTimestamp() + int64(setting.DefaultExpireDays)*24*3600
+	}
+	file.StoragePath = fileStoragePath(file)
+
+	if err = chargeRequestQuota(c, file.Quota, "file storage"); err != nil {
+		return nil, err
+	}
+	if err = storage.Put(c.Request.Context(), file.StoragePath, src, file.Bytes, file.ContentType); err != nil {
+		refundRequestQuota(c, file.Quota, "file storage")
+		return nil, err
+	}
+	if err = file.Insert(); err != nil {
+		_ = storage.Delete(context.Background(), file.StoragePath)
+		refundRequestQuota(c, file.Quota, "file storage")
+		return nil, err
+	}
+	if file.Quota > 0 {
+		model.RecordConsumeLog(c, userId, model.RecordConsumeLogParams{
+			ModelName: "files",
+			TokenName: c.GetString("token_name"),
+			Quota:     file.Quota,
+			Content:   fmt.Sprintf("文件存储 %s，%d 字节", file.FileId, file.Bytes),
+			TokenId:   file.TokenId,
+			Group:     common.GetContextKeyString(c, constant.ContextKeyUsingGroup),
+			Other: map[string]interface{}{
+				"request_path": c.Request.URL.Path,
+				"file_id":      file.FileId,
+				"purpose":      file.Purpose,
+			},
+		})
+		model.UpdateUserUsedQuotaAndRequestCount(userId, file.Quota)
+	}
+	return file, nil
+}
+
+// SaveGeneratedFile 保存网关生成的文件（如批处理的输出文件），不扣除存储额度
+func SaveGeneratedFile(ctx context.Context, userId int, tokenId int, filename string, purpose string, data io.Reader, size int64) (*model.File, error) {
+	storage, err := GetFileStorage()
+	if err != nil {
+		return nil, err
+	}
+	file := &model.File{
+		FileId:      fileIdPrefix + common.GetRandomString(24),
+		UserId:      userId,
+		TokenId:     tokenId,
+		Filename:    filename,
+		Purpose:     purpose,
+		Bytes:       size,
+		ContentType: "application/jsonl",
+		Status:      model.FileStatusProcessed,
+		StorageType: storage.Type(),
+	}
+	if days := operation_setting.GetFileSetting().DefaultExpireDays; days > 0 {
+		file.ExpiresAt = common.GetTimestamp() + int64(days)*24*3600
+	}
+	file.StoragePath = fileStoragePath(file)
+	if err = storage.Put(ctx, file.StoragePath, data, file.Bytes, file.ContentType); err != nil {
+		return nil, err
+	}
+	if err = file.Insert(); err != nil {
+		_ = storage.Delete(context.Background(), file.StoragePath)
+		return nil, err
+	}
+	return file, nil
+}
+
+// OpenFileContent 读取文件内容
+func OpenFileContent(ctx context.Context, file *model.File) (io.ReadCloser, error) {
+	storage, err := getFileStorageByType(file.StorageType)
+	if err != nil {
+		return nil, err
+	}
+	return storage.Get(ctx, file.StoragePath)
+}
+
+// DeleteFile 删除文件内容、记录以及转发到上游的副本
+func DeleteFile(ctx context.Context, file *model.File) error {
+	storage, err := getFileStorageByType(file.StorageType)
+	if err != nil {
+		return err
+	}
+	if err = storage.Delete(ctx, file.StoragePath); err != nil {
+		return err
+	}
+	deleteUpstreamFiles(ctx, file)
+	return file.Delete()
+}
+
+// CleanupExpiredFiles 删除所有已过期的文件，返回删除的数量
+func CleanupExpiredFiles() (int, error) {
+	deleted := 0
+	for {
+		files, err := model.GetExpiredFiles(fileCleanupBatchSize)
+		if err != nil {
+			return deleted, err
+		}
+		failed := 0
+		for _, file := range files {
+			if err = DeleteFile(context.Background(), file); err != nil {
+				common.SysError(fmt.Sprintf("failed to delete expired file %s: %s", file.FileId, err.Error()))
+				failed++
+				continue
+			}
+			deleted++
+		}
+		// 全部删除失败时停止，避免反复处理同一批文件
+		if len(files) < fileCleanupBatchSize || failed == len(files) {
+			return deleted, nil
+		}
+	}
+}
+
+// StartFileCleanupTask 定期清理过期文件，仅在主节点运行
+func StartFileCleanupTask() {
+	if !common.IsMasterNode {
+		return
+	}
+	for {
+		interval := operation_setting.GetFileSetting().CleanupIntervalMinutes
+		if interval <= 0 {
+			interval = 60
+		}
+		time.Sleep(time.Duration(interval) * time.Minute)
+		if !operation_setting.GetFileSetting().Enabled {
+			continue
+		}
+		deleted, err := CleanupExpiredFiles()
+		if err != nil {
+			common.SysError("failed to cleanup expired files: " + err.Error())
+		}
+		if deleted > 0 {
+			common.SysLog(fmt.Sprintf("cleaned up %d expired files", deleted))
+		}
+	}
+}
+
+// uploadUpstreamFile 将文件上传到 OpenAI 兼容的上游渠道，返回上游的文件 id
+func uploadUpstreamFile(ctx context.Context, upstream *openAIUpstream, file *model.File) (string, error) {
+	content, err := OpenFileContent(ctx, file)
+	if err != nil {
+		return "", err
+	}
+	defer content.Close()
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	if err = writer.WriteField("purpose", file.Purpose); err != nil {
+		return "", err
+	}
+	part, err := writer.CreateFormFile("file", file.Filename)
+	if err != nil {
+		return "", err
+	}
+	if _, err = io.Copy(part, content); err != nil {
+		return "", err
+	}
+	if err = writer.Close(); err != nil {
+		return "", err
+	}
+
+	respBody, err := upstream.do(ctx, http.MethodPost, "/v1/files", writer.FormDataContentType(), body)
+	if err != nil {
+		return "", fmt.Errorf("upload file to upstream failed: %w", err)
+	}
+	upstreamFileId := gjson.GetBytes(respBody, "id").String()
+	if upstreamFileId == "" {
+		return "", errors.New("upload file to upstream failed: empty file id")
+	}
+	return upstreamFileId, nil
+}
+
+// getUpstreamFileId 获取网关文件在上游渠道与 key 上对应的文件 id，尚未转发时先上传到上游
+func getUpstreamFileId(ctx context.Context, upstream *openAIUpstream, file *model.File) (string, error) {
+	if upstreamFileId, ok := model.GetFileUpstreamId(file.FileId, upstream.ChannelId, upstream.KeyIndex); ok {
+		return upstreamFileId, nil
+	}
+	upstreamFileId, err := uploadUpstreamFile(ctx, upstream, file)
+	if err != nil {
+		return "", err
+	}
+	if err = model.InsertFileUpstream(file.FileId, upstream.ChannelId, upstream.KeyIndex, upstreamFileId); err != nil {
+		logger.LogError(ctx, fmt.Sprintf("failed to save upstream file id of %s: %s", file.FileId, err.Error()))
+	}
+	logger.LogInfo(ctx, fmt.Sprintf("file %s forwarded to channel #%d as %s", file.FileId, upstream.ChannelId, upstreamFileId))
+	return upstreamFileId, nil
+}
+
+// GetUpstreamFileId 获取网关文件在当前渠道与 key 上对应的上游文件 id
+func GetUpstreamFileId(c *gin.Context, info *relaycommon.RelayInfo, file *model.File) (string, error) {
+	return getUpstreamFileId(c.Request.Context(), newOpenAIUpstreamFromInfo(info), file)
+}
+
+// ReplaceRequestFileIds 将请求体中引用的网关文件 id 替换为上游渠道的文件 id，仅适用于 OpenAI 兼容的渠道
+func ReplaceRequestFileIds(c *gin.Context, info *relaycommon.RelayInfo, body []byte) ([]byte, error) {
+	if info.ApiType != constant.APITypeOpenAI {
+		return body, nil
+	}
+	return replaceUpstreamFileIds(c.Request.Context(), newOpenAIUpstreamFromInfo(info), info.UserId, body)
+}
+
+// replaceUpstreamFileIds 将请求体中引用的用户文件 id 替换为上游渠道与 key 上的文件 id，尚未转发的文件先上传到上游
+func replaceUpstreamFileIds(ctx context.Context, upstream *openAIUpstream, userId int, body []byte) ([]byte, error) {
+	if !operation_setting.GetFileSetting().Enabled {
+		return body, nil
+	}
+	matches := requestFileIdPattern.FindAllSubmatch(body, -1)
+	if len(matches) == 0 {
+		return body, nil
+	}
+	replaced := make(map[string]bool)
+	for _, match := range matches {
+		fileId := string(match[1])
+		if replaced[fileId] {
+			continue
+		}
+		replaced[fileId] = true
+		file, err := model.GetUserFileByFileId(userId, fileId)
+		if err != nil {
+			// 不是网关上传的文件，原样转发
+			continue
+		}
+		upstreamFileId, err := getUpstreamFileId(ctx, upstream, file)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.ReplaceAll(body, []byte(`"`+fileId+`"`), []byte(`"`+upstreamFileId+`"`))
+	}
+	return body, nil
+}
+
+// deleteUpstreamFiles 尽力删除转发到上游渠道的文件副本
+func deleteUpstreamFiles(ctx context.Context, file *model.File) {
+	upstreams, err := model.GetFileUpstreams(file.FileId)
+	if err != nil {
+		return
+	}
+	for _, fileUpstream := range upstreams {
+		channel, err := model.GetChannelById(fileUpstream.ChannelId, true)
+		if err != nil {
+			continue
+		}
+		upstream, err := newOpenAIUpstream(channel, fileUpstream.KeyIndex)
+		if err != nil {
+			continue
+		}
+		if _, err = upstream.do(ctx, http.MethodDelete, "/v1/files/"+fileUpstream.UpstreamFileId, "", nil); err != nil {
+			common.SysError(fmt.Sprintf("failed to delete upstream file %s: %s", fileUpstream.UpstreamFileId, err.Error()))
+		}
+	}
+}
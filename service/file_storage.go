@@ -0,0 +1,228 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ctrlc-ctrlv-limited/cvai/constant"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+)
+
+const (
+	FileStorageTypeLocal = "local"
+	FileStorageTypeS3    = "s3"
+)
+
+// FileStorage Files API 的文件存储
+type FileStorage interface {
+	Type() string
+	// Put 流式写入 size 字节的内容，实际读取的字节数与 size 不一致时返回错误
+	Put(ctx context.Context, path string, data io.Reader, size int64, contentType string) error
+	Get(ctx context.Context, path string) (io.ReadCloser, error)
+	Delete(ctx context.Context, path string) error
+}
+
+// GetFileStorage 根据 FILE_STORAGE_TYPE 获取文件存储
+func GetFileStorage() (FileStorage, error) {
+	return getFileStorageByType(constant.FileStorageType)
+}
+
+func getFileStorageByType(storageType string) (FileStorage, error) {
+	switch storageType {
+	case FileStorageTypeS3:
+		if constant.S3Endpoint == "" || constant.S3Bucket == "" {
+			return nil, errors.New("S3_ENDPOINT and S3_BUCKET are required for s3 file storage")
+		}
+		return &s3FileStorage{}, nil
+	case FileStorageTypeLocal, "":
+		return &localFileStorage{root: constant.FileStoragePath}, nil
+	default:
+		return nil, fmt.Errorf("unsupported file storage type: %s", storageType)
+	}
+}
+
+// 空内容的 SHA256，用于不带请求体的 S3 请求签名
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// copyFileContent 从 src 复制 size 字节到 dst，内容长度与 size 不一致时返回错误
+func copyFileContent(dst io.Writer, src io.Reader, size int64) error {
+	n, err := io.Copy(dst, io.LimitReader(src, size+1))
+	if err != nil {
+		return err
+	}
+	if n != size {
+		return fmt.Errorf("file size mismatch: expected %d bytes, got %d", size, n)
+	}
+	return nil
+}
+
+type localFileStorage struct {
+	root string
+}
+
+func (s *localFileStorage) Type() string {
+	return FileStorageTypeLocal
+}
+
+func (s *localFileStorage) fullPath(path string) (string, error) {
+	fullPath := filepath.Join(s.root, filepath.FromSlash(path))
+	if !strings.HasPrefix(fullPath, filepath.Clean(s.root)+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid file path: %s", path)
+	}
+	return fullPath, nil
+}
+
+func (s *localFileStorage) Put(_ context.Context, path string, data io.Reader, size int64, _ string) error {
+	fullPath, err := s.fullPath(path)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+		return err
+	}
+	// 先写入同目录的临时文件，写完后再重命名，避免读到写了一半的文件
+	tmp, err := os.CreateTemp(filepath.Dir(fullPath), filepath.Base(fullPath)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err = copyFileContent(tmp, data, size); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), fullPath)
+}
+
+func (s *localFileStorage) Get(_ context.Context, path string) (io.ReadCloser, error) {
+	fullPath, err := s.fullPath(path)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(fullPath)
+}
+
+func (s *localFileStorage) Delete(_ context.Context, path string) error {
+	fullPath, err := s.fullPath(path)
+	if err != nil {
+		return err
+	}
+	if err = os.Remove(fullPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// s3FileStorage S3 兼容存储（AWS S3、MinIO、R2 等），使用 SigV4 签名的 REST 请求
+type s3FileStorage struct{}
+
+func (s *s3FileStorage) Type() string {
+	return FileStorageTypeS3
+}
+
+func (s *s3FileStorage) objectURL(path string) (string, error) {
+	endpoint, err := url.Parse(strings.TrimSuffix(constant.S3Endpoint, "/"))
+	if err != nil {
+		return "", err
+	}
+	key := strings.TrimPrefix(constant.S3Prefix+path, "/")
+	escapedKey := (&url.URL{Path: key}).EscapedPath()
+	if constant.S3ForcePathStyle {
+		endpoint.Path = endpoint.Path + "/" + constant.S3Bucket + "/" + escapedKey
+	} else {
+		endpoint.Host = constant.S3Bucket + "." + endpoint.Host
+		endpoint.Path = endpoint.Path + "/" + escapedKey
+	}
+	endpoint.RawPath = endpoint.Path
+	return endpoint.String(), nil
+}
+
+func (s *s3FileStorage) do(ctx context.Context, method string, path string, body io.Reader, size int64, payloadHash string, contentType string) (*http.Response, error) {
+	objectURL, err := s.objectURL(path)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, objectURL, body)
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = size
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	credentials := aws.Credentials{
+		AccessKeyID:     constant.S3AccessKeyId,
+		SecretAccessKey: constant.S3SecretAccessKey,
+	}
+	if err = v4.NewSigner().SignHTTP(ctx, credentials, req, payloadHash, "s3", constant.S3Region, time.Now()); err != nil {
+		return nil, err
+	}
+	resp, err := GetHttpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 && !(method == http.MethodDelete && resp.StatusCode == http.StatusNotFound) {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("s3 %s %s failed: status %d, %s", method, path, resp.StatusCode, string(body))
+	}
+	return resp, nil
+}
+
+func (s *s3FileStorage) Put(ctx context.Context, path string, data io.Reader, size int64, contentType string) error {
+	// 签名需要内容的 SHA256，边写入临时文件边计算，再从临时文件上传，避免将整个文件读入内存
+	tmp, err := os.CreateTemp("", "cvai-s3-upload-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+	}()
+	hash := sha256.New()
+	if err = copyFileContent(io.MultiWriter(tmp, hash), data, size); err != nil {
+		return err
+	}
+	if _, err = tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	resp, err := s.do(ctx, http.MethodPut, path, tmp, size, hex.EncodeToString(hash.Sum(nil)), contentType)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func (s *s3FileStorage) Get(ctx context.Context, path string) (io.ReadCloser, error) {
+	resp, err := s.do(ctx, http.MethodGet, path, nil, 0, emptyPayloadHash, "")
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *s3FileStorage) Delete(ctx context.Context, path string) error {
+	resp, err := s.do(ctx, http.MethodDelete, path, nil, 0, emptyPayloadHash, "")
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
@@ -0,0 +1,362 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"time"
+
+	"github.com/ctrlc-ctrlv-limited/cvai/common"
+	"github.com/ctrlc-ctrlv-limited/cvai/constant"
+	"github.com/ctrlc-ctrlv-limited/cvai/logger"
+	"github.com/ctrlc-ctrlv-limited/cvai/model"
+	relaycommon "github.com/ctrlc-ctrlv-limited/cvai/relay/common"
+	"github.com/ctrlc-ctrlv-limited/cvai/setting/operation_setting"
+	"github.com/ctrlc-ctrlv-limited/cvai/setting/ratio_setting"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// IsFineTuningChannel 渠道是否支持 OpenAI 格式的微调接口
+func IsFineTuningChannel(channelType int) bool {
+	return channelType == constant.ChannelTypeOpenAI || channelType == constant.ChannelTypeAzure
+}
+
+// ToFineTuningJob 将任务转换为返回给用户的微调任务，训练文件还原为网关的文件 id
+func ToFineTuningJob(task *model.Task) []byte {
+	job := []byte(task.Data)
+	for _, field := range []string{"training_file", "validation_file"} {
+		if fileId := gjson.Get(task.Properties.Input, field); fileId.Exists() && gjson.GetBytes(job, field).Exists() {
+			job, _ = sjson.SetBytes(job, field, fileId.Value())
+		}
+	}
+	return job
+}
+
+// fineTuningTaskUpstream 使用创建任务时的渠道与 key 创建上游信息
+func fineTuningTaskUpstream(task *model.Task) (*openAIUpstream, error) {
+	channel, err := model.CacheGetChannel(task.ChannelId)
+	if err != nil {
+		return nil, err
+	}
+	upstream, err := newOpenAIUpstream(channel, 0)
+	if err != nil {
+		return nil, err
+	}
+	if task.PrivateData.Key != "" {
+		upstream.Key = task.PrivateData.Key
+		upstream.KeyIndex = task.PrivateData.KeyIndex
+	}
+	return upstream, nil
+}
+
+// fineTuningDefaultEpochs 未指定训练轮数时估算训练 token 使用的轮数
+const fineTuningDefaultEpochs = 3
+
+// CreateFineTuningJob 将微调任务提交到分发中间件选中的渠道，并记录为异步任务，提交前按训练文件预扣额度
+func CreateFineTuningJob(c *gin.Context) (task *model.Task, err error) {
+	if !IsFineTuningChannel(common.GetContextKeyInt(c, constant.ContextKeyChannelType)) {
+		return nil, errors.New("the selected channel does not support fine-tuning")
+	}
+	input, err := common.GetRequestBody(c)
+	if err != nil {
+		return nil, err
+	}
+	if !gjson.ValidBytes(input) {
+		return nil, errors.New("invalid request body")
+	}
+	baseModel := gjson.GetBytes(input, "model").String()
+	if gjson.GetBytes(input, "training_file").String() == "" {
+		return nil, errors.New("training_file is required")
+	}
+	userId := c.GetInt("id")
+	_, userQuota, err := GetUserAvailableQuota(c, userId)
+	if err != nil {
+		return nil, err
+	}
+	if userQuota <= 0 {
+		return nil, errors.New("user quota is not enough")
+	}
+	group := common.GetContextKeyString(c, constant.ContextKeyUsingGroup)
+	// 按训练文件的 token 数预扣额度，任务结束后按实际训练的 token 数结算
+	preConsumedQuota, _, _ := fineTuningTrainingQuota(userId, group, baseModel, estimateFineTuningTokens(c.Request.Context(), userId, input))
+	if err = chargeRequestQuota(c, preConsumedQuota, "fine-tuning"); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			refundRequestQuota(c, preConsumedQuota, "fine-tuning")
+		}
+	}()
+
+	upstream := openAIUpstreamFromContext(c)
+	body := input
+	upstreamModel := baseModel
+	if modelMapping := common.GetContextKeyString(c, constant.ContextKeyChannelModelMapping); modelMapping != "" && modelMapping != "{}" {
+		mapping := make(map[string]string)
+		if err = common.UnmarshalJsonStr(modelMapping, &mapping); err == nil && mapping[baseModel] != "" {
+			upstreamModel = mapping[baseModel]
+			body, _ = sjson.SetBytes(body, "model", upstreamModel)
+		}
+	}
+	// 训练文件为网关上传的文件时，替换为上游渠道的文件 id
+	for _, field := range []string{"training_file", "validation_file"} {
+		fileId := gjson.GetBytes(body, field).String()
+		if fileId == "" || !operation_setting.GetFileSetting().Enabled {
+			continue
+		}
+		file, fileErr := model.GetUserFileByFileId(userId, fileId)
+		if fileErr != nil {
+			continue
+		}
+		var upstreamFileId string
+		upstreamFileId, err = getUpstreamFileId(c.Request.Context(), upstream, file)
+		if err != nil {
+			return nil, err
+		}
+		body, _ = sjson.SetBytes(body, field, upstreamFileId)
+	}
+
+	job, err := upstream.do(c.Request.Context(), http.MethodPost, "/v1/fine_tuning/jobs", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	jobId := gjson.GetBytes(job, "id").String()
+	if jobId == "" {
+		err = fmt.Errorf("invalid fine-tuning job response: %s", string(job))
+		return nil, err
+	}
+
+	task = &model.Task{
+		TaskID:     jobId,
+		Platform:   constant.TaskPlatformFineTuning,
+		UserId:     userId,
+		Group:      group,
+		ChannelId:  upstream.ChannelId,
+		Quota:      preConsumedQuota,
+		Action:     constant.TaskActionFineTune,
+		Status:     model.TaskStatusSubmitted,
+		SubmitTime: time.Now().Unix(),
+		Progress:   "10%",
+		Properties: model.Properties{
+			Input:             string(input),
+			UpstreamModelName: upstreamModel,
+			OriginModelName:   baseModel,
+		},
+		PrivateData: model.TaskPrivateData{
+			TokenId: c.GetInt("token_id"),
+		},
+		Data: job,
+	}
+	if common.GetContextKeyBool(c, constant.ContextKeyChannelIsMultiKey) {
+		task.PrivateData.Key = upstream.Key
+		task.PrivateData.KeyIndex = upstream.KeyIndex
+	}
+	if err = task.Insert(); err != nil {
+		return nil, err
+	}
+	logger.LogInfo(c, fmt.Sprintf("fine-tuning job %s created on channel #%d", jobId, upstream.ChannelId))
+	return task, nil
+}
+
+// CancelFineTuningJob 取消上游的微调任务并更新任务状态
+func CancelFineTuningJob(ctx context.Context, task *model.Task) error {
+	upstream, err := fineTuningTaskUpstream(task)
+	if err != nil {
+		return err
+	}
+	job, err := upstream.do(ctx, http.MethodPost, "/v1/fine_tuning/jobs/"+url.PathEscape(task.TaskID)+"/cancel", "", nil)
+	if err != nil {
+		return err
+	}
+	return UpdateFineTuningTask(ctx, task, job)
+}
+
+// GetFineTuningJobResource 透传获取微调任务的 events、checkpoints 等子资源
+func GetFineTuningJobResource(ctx context.Context, task *model.Task, resource string, query url.Values) ([]byte, error) {
+	upstream, err := fineTuningTaskUpstream(task)
+	if err != nil {
+		return nil, err
+	}
+	path := "/v1/fine_tuning/jobs/" + url.PathEscape(task.TaskID) + "/" + resource
+	if encoded := query.Encode(); encoded != "" {
+		path += "?" + encoded
+	}
+	return upstream.do(ctx, http.MethodGet, path, "", nil)
+}
+
+// FetchFineTuningJob 从上游获取微调任务的最新状态
+func FetchFineTuningJob(ctx context.Context, task *model.Task) ([]byte, error) {
+	upstream, err := fineTuningTaskUpstream(task)
+	if err != nil {
+		return nil, err
+	}
+	return upstream.do(ctx, http.MethodGet, "/v1/fine_tuning/jobs/"+url.PathEscape(task.TaskID), "", nil)
+}
+
+// UpdateFineTuningTask 根据上游返回的微调任务更新任务状态，任务结束时按训练 token 结算并登记微调模型
+func UpdateFineTuningTask(ctx context.Context, task *model.Task, job []byte) error {
+	if !gjson.ValidBytes(job) {
+		return fmt.Errorf("invalid fine-tuning job response: %s", string(job))
+	}
+	preStatus := task.Status
+	task.Data = job
+	now := time.Now().Unix()
+	switch status := gjson.GetBytes(job, "status").String(); status {
+	case "validating_files", "queued":
+		task.Status = model.TaskStatusQueued
+		task.Progress = "20%"
+	case "running":
+		task.Status = model.TaskStatusInProgress
+		task.Progress = "50%"
+		if task.StartTime == 0 {
+			task.StartTime = now
+		}
+	case "succeeded":
+		task.Status = model.TaskStatusSuccess
+		task.Progress = "100%"
+		if task.FinishTime == 0 {
+			task.FinishTime = now
+		}
+	case "failed", "cancelled":
+		task.Status = model.TaskStatusFailure
+		task.Progress = "100%"
+		if task.FinishTime == 0 {
+			task.FinishTime = now
+		}
+		task.FailReason = gjson.GetBytes(job, "error.message").String()
+		if task.FailReason == "" {
+			task.FailReason = status
+		}
+	default:
+		return fmt.Errorf("unknown fine-tuning job status %s of %s", status, task.TaskID)
+	}
+
+	// 任务结束时结算，失败与取消的任务同样按已训练的 token 数计费，防止重复计费
+	if isFineTuningTaskFinished(task.Status) && !isFineTuningTaskFinished(preStatus) {
+		settleFineTuningTask(ctx, task, job)
+	}
+	return task.Update()
+}
+
+func isFineTuningTaskFinished(status model.TaskStatus) bool {
+	return status == model.TaskStatusSuccess || status == model.TaskStatusFailure
+}
+
+// estimateFineTuningTokens 估算微调任务的训练 token 数：网关上传的训练文件的 token 数乘以训练轮数，
+// 未指定轮数时按默认轮数估算，训练文件不是网关上传的文件时无法估算，返回 0
+func estimateFineTuningTokens(ctx context.Context, userId int, input []byte) int {
+	if !operation_setting.GetFileSetting().Enabled {
+		return 0
+	}
+	file, err := model.GetUserFileByFileId(userId, gjson.GetBytes(input, "training_file").String())
+	if err != nil {
+		return 0
+	}
+	data, err := readFileData(ctx, file)
+	if err != nil {
+		return 0
+	}
+	epochs := fineTuningDefaultEpochs
+	for _, path := range []string{"hyperparameters.n_epochs", "method.supervised.hyperparameters.n_epochs", "method.dpo.hyperparameters.n_epochs"} {
+		if n := gjson.GetBytes(input, path); n.Type == gjson.Number && n.Int() > 0 {
+			epochs = int(n.Int())
+			break
+		}
+	}
+	return CountTextToken(string(data), gjson.GetBytes(input, "model").String()) * epochs
+}
+
+// fineTuningTrainingQuota 按训练价格与分组倍率计算训练 token 的额度
+func fineTuningTrainingQuota(userId int, group string, baseModel string, tokens int) (int, float64, float64) {
+	groupRatio := ratio_setting.GetGroupRatio(group)
+	if userGroup, err := model.GetUserGroup(userId, false); err == nil {
+		if ratio, ok := ratio_setting.GetGroupGroupRatio(userGroup, group); ok {
+			groupRatio = ratio
+		}
+	}
+	trainingPrice := operation_setting.GetFineTuningTrainingPrice(baseModel)
+	if tokens <= 0 {
+		return 0, trainingPrice, groupRatio
+	}
+	return int(float64(tokens) / 1000000 * trainingPrice * common.QuotaPerUnit * groupRatio), trainingPrice, groupRatio
+}
+
+// settleFineTuningTask 按实际训练的 token 数结算预扣的额度，并将产生的微调模型绑定到创建它的用户与渠道
+func settleFineTuningTask(ctx context.Context, task *model.Task, job []byte) {
+	baseModel := task.Properties.OriginModelName
+	if fineTunedModel := gjson.GetBytes(job, "fine_tuned_model").String(); fineTunedModel != "" {
+		err := (&model.FineTunedModel{
+			ModelName: fineTunedModel,
+			UserId:    task.UserId,
+			ChannelId: task.ChannelId,
+			KeyIndex:  task.PrivateData.KeyIndex,
+			TaskId:    task.TaskID,
+			BaseModel: baseModel,
+		}).Insert()
+		if err != nil {
+			logger.LogError(ctx, fmt.Sprintf("failed to register fine-tuned model %s: %s", fineTunedModel, err.Error()))
+		}
+	}
+
+	trainedTokens := int(gjson.GetBytes(job, "trained_tokens").Int())
+	quota, trainingPrice, groupRatio := fineTuningTrainingQuota(task.UserId, task.Group, baseModel, trainedTokens)
+	preConsumedQuota := task.Quota
+	if quota <= 0 && preConsumedQuota <= 0 {
+		return
+	}
+
+	relayInfo := &relaycommon.RelayInfo{UserId: task.UserId}
+	token, err := model.GetTokenById(task.PrivateData.TokenId)
+	if err == nil {
+		relayInfo.TokenId = token.Id
+		relayInfo.TokenKey = token.Key
+	} else {
+		// 令牌已删除时只扣除用户额度
+		relayInfo.IsPlayground = true
+		token = &model.Token{}
+	}
+	if quota != preConsumedQuota {
+		if err = PostConsumeQuota(relayInfo, quota-preConsumedQuota, preConsumedQuota, false); err != nil {
+			logger.LogError(ctx, fmt.Sprintf("failed to settle quota of fine-tuning job %s: %s", task.TaskID, err.Error()))
+		}
+	}
+	task.Quota = quota
+	if quota <= 0 {
+		return
+	}
+	model.UpdateUserUsedQuotaAndRequestCount(task.UserId, quota)
+	model.UpdateChannelUsedQuota(task.ChannelId, quota)
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/fine_tuning/jobs", nil)
+	c.Request.RemoteAddr = ""
+	username, _ := model.GetUsernameById(task.UserId, false)
+	c.Set("username", username)
+	model.RecordConsumeLog(c, task.UserId, model.RecordConsumeLogParams{
+		ChannelId:    task.ChannelId,
+		PromptTokens: trainedTokens,
+		ModelName:    baseModel,
+		TokenName:    token.Name,
+		Quota:        quota,
+		Content:      fmt.Sprintf("微调任务 %s，训练 %d tokens，训练价格 $%.2f/1M tokens，分组倍率 %.2f", task.TaskID, trainedTokens, trainingPrice, groupRatio),
+		TokenId:      token.Id,
+		Group:        task.Group,
+		Other: map[string]interface{}{
+			"group_ratio":        groupRatio,
+			"training_price":     trainingPrice,
+			"trained_tokens":     trainedTokens,
+			"pre_consumed_quota": preConsumedQuota,
+			"job_status":         gjson.GetBytes(job, "status").String(),
+			"fine_tuning_job":    task.TaskID,
+			"fine_tuned_model":   gjson.GetBytes(job, "fine_tuned_model").String(),
+			"request_path":       "/v1/fine_tuning/jobs",
+		},
+	})
+}
@@ -0,0 +1,224 @@
+package service
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+
+	"github.com/ctrlc-ctrlv-limited/cvai/common"
+	"github.com/ctrlc-ctrlv-limited/cvai/constant"
+	"github.com/ctrlc-ctrlv-limited/cvai/dto"
+
+	"github.com/gin-gonic/gin"
+)
+
+var ErrHedgeLost = errors.New("hedge request lost to another channel")
+
+const (
+	HedgeAttemptPrimary = 0
+	HedgeAttemptBackup  = 1
+)
+
+// HedgeState 一次对冲请求中两个并发请求共享的状态，先向客户端写出数据的一方胜出
+type HedgeState struct {
+	mutex      sync.Mutex
+	winner     int
+	claimed    chan struct{}
+	channelIds [2]int
+	loserUsage *dto.Usage
+	// 估算的 prompt tokens，落败方被取消时没有实际用量，按此估算其用量
+	estimatePromptTokens int
+}
+
+func NewHedgeState() *HedgeState {
+	return &HedgeState{
+		winner:  -1,
+		claimed: make(chan struct{}),
+	}
+}
+
+func (s *HedgeState) claim(attempt int) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.winner == -1 {
+		s.winner = attempt
+		close(s.claimed)
+	}
+	return s.winner == attempt
+}
+
+// Winner 返回胜出的请求序号，尚未决出时返回 -1
+func (s *HedgeState) Winner() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.winner
+}
+
+// Claimed 在某一方胜出时关闭
+func (s *HedgeState) Claimed() <-chan struct{} {
+	return s.claimed
+}
+
+func (s *HedgeState) SetChannel(attempt int, channelId int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.channelIds[attempt] = channelId
+}
+
+// SetEstimatePromptTokens 记录请求估算的 prompt tokens，用于估算被取消的落败方的用量
+func (s *HedgeState) SetEstimatePromptTokens(tokens int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.estimatePromptTokens = tokens
+}
+
+// logInfo 生成写入胜出方日志 Other 字段的对冲信息，发起了对冲请求时记录落败方的用量，
+// 落败方在胜出方计费前已返回用量时使用实际用量，否则（通常已被取消）按估算的 prompt tokens 记录，输出按 0 计
+func (s *HedgeState) logInfo() map[string]interface{} {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	info := map[string]interface{}{
+		"winner": s.winner,
+	}
+	if s.channelIds[HedgeAttemptBackup] != 0 {
+		info["primary_channel_id"] = s.channelIds[HedgeAttemptPrimary]
+		info["backup_channel_id"] = s.channelIds[HedgeAttemptBackup]
+	}
+	if s.winner == -1 || s.channelIds[HedgeAttemptBackup] == 0 {
+		return info
+	}
+	if s.loserUsage != nil {
+		info["loser_usage"] = s.loserUsage
+	} else {
+		info["loser_usage"] = &dto.Usage{
+			PromptTokens: s.estimatePromptTokens,
+			TotalTokens:  s.estimatePromptTokens,
+		}
+		info["loser_usage_estimated"] = true
+	}
+	return info
+}
+
+// IsHedgeLoser 对冲请求中落败的一方不计费，只记录其用量用于审计。
+// 返回 true 表示当前请求是落败方，调用方应跳过计费。
+func IsHedgeLoser(ctx *gin.Context, usage *dto.Usage) bool {
+	state, ok := common.GetContextKeyType[*HedgeState](ctx, constant.ContextKeyHedgeState)
+	if !ok || state == nil {
+		return false
+	}
+	attempt := common.GetContextKeyInt(ctx, constant.ContextKeyHedgeAttempt)
+	// 尚未有任何一方写出数据时，先完成计费的一方即为胜出方
+	if state.claim(attempt) {
+		return false
+	}
+	state.mutex.Lock()
+	state.loserUsage = usage
+	state.mutex.Unlock()
+	return true
+}
+
+func appendHedgeInfo(ctx *gin.Context, other map[string]interface{}) {
+	state, ok := common.GetContextKeyType[*HedgeState](ctx, constant.ContextKeyHedgeState)
+	if !ok || state == nil || other == nil {
+		return
+	}
+	other["hedge"] = state.logInfo()
+}
+
+// HedgeWriter 在决出胜负前缓存响应头，首次写出数据时尝试胜出，落败的一方写入会返回 ErrHedgeLost
+type HedgeWriter struct {
+	gin.ResponseWriter
+	state     *HedgeState
+	attempt   int
+	header    http.Header
+	status    int
+	committed bool
+}
+
+func NewHedgeWriter(w gin.ResponseWriter, state *HedgeState, attempt int) *HedgeWriter {
+	return &HedgeWriter{
+		ResponseWriter: w,
+		state:          state,
+		attempt:        attempt,
+		header:         make(http.Header),
+	}
+}
+
+func (w *HedgeWriter) commit() bool {
+	if w.committed {
+		return true
+	}
+	if !w.state.claim(w.attempt) {
+		return false
+	}
+	for key, values := range w.header {
+		w.ResponseWriter.Header()[key] = values
+	}
+	if w.status != 0 {
+		w.ResponseWriter.WriteHeader(w.status)
+	}
+	w.committed = true
+	return true
+}
+
+func (w *HedgeWriter) Header() http.Header {
+	if w.committed {
+		return w.ResponseWriter.Header()
+	}
+	return w.header
+}
+
+func (w *HedgeWriter) WriteHeader(code int) {
+	if w.committed {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	w.status = code
+}
+
+func (w *HedgeWriter) WriteHeaderNow() {
+	if w.committed {
+		w.ResponseWriter.WriteHeaderNow()
+	}
+}
+
+func (w *HedgeWriter) Write(data []byte) (int, error) {
+	if !w.commit() {
+		return 0, ErrHedgeLost
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *HedgeWriter) WriteString(s string) (int, error) {
+	if !w.commit() {
+		return 0, ErrHedgeLost
+	}
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *HedgeWriter) Flush() {
+	if w.committed {
+		w.ResponseWriter.Flush()
+	}
+}
+
+func (w *HedgeWriter) Status() int {
+	if w.committed {
+		return w.ResponseWriter.Status()
+	}
+	if w.status != 0 {
+		return w.status
+	}
+	return http.StatusOK
+}
+
+func (w *HedgeWriter) Size() int {
+	if w.committed {
+		return w.ResponseWriter.Size()
+	}
+	return -1
+}
+
+func (w *HedgeWriter) Written() bool {
+	return w.committed && w.ResponseWriter.Written()
+}
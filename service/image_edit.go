@@ -0,0 +1,240 @@
+package service
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/jpeg"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/ctrlc-ctrlv-limited/cvai/dto"
+	relayconstant "github.com/ctrlc-ctrlv-limited/cvai/relay/constant"
+	"github.com/ctrlc-ctrlv-limited/cvai/types"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+	_ "golang.org/x/image/webp"
+)
+
+const imageEditInputContextKey = "image_edit_input"
+
+// imageVariationPrompt 上游没有变体接口时，用于生成变体的默认提示词
+const imageVariationPrompt = "Create a variation of this image that keeps its subject, composition and style."
+
+// ImageEditInput 图片编辑与变体请求中的输入图片与蒙版
+type ImageEditInput struct {
+	Images []*types.LocalFileData
+	Mask   *types.LocalFileData
+}
+
+// GetImageEditInput 解析图片编辑与变体请求中的输入图片与蒙版，支持表单上传的文件以及 JSON 中的 URL、base64，结果缓存在上下文中供重试使用
+func GetImageEditInput(c *gin.Context, request dto.ImageRequest) (*ImageEditInput, error) {
+	if cached, ok := c.Get(imageEditInputContextKey); ok {
+		return cached.(*ImageEditInput), nil
+	}
+	input := &ImageEditInput{}
+	if strings.Contains(c.Request.Header.Get("Content-Type"), "multipart/form-data") {
+		if err := getImageEditInputFromForm(c, input); err != nil {
+			return nil, err
+		}
+	}
+	if len(input.Images) == 0 {
+		for _, raw := range []json.RawMessage{request.Image, request.Images} {
+			images, err := parseImageEditSources(raw)
+			if err != nil {
+				return nil, err
+			}
+			input.Images = append(input.Images, images...)
+		}
+	}
+	if input.Mask == nil && len(request.Mask) > 0 {
+		masks, err := parseImageEditSources(request.Mask)
+		if err != nil {
+			return nil, err
+		}
+		if len(masks) > 0 {
+			input.Mask = masks[0]
+		}
+	}
+	if len(input.Images) == 0 {
+		return nil, errors.New("image is required")
+	}
+	c.Set(imageEditInputContextKey, input)
+	return input, nil
+}
+
+func getImageEditInputFromForm(c *gin.Context, input *ImageEditInput) error {
+	mf := c.Request.MultipartForm
+	if mf == nil {
+		if _, err := c.MultipartForm(); err != nil {
+			return fmt.Errorf("failed to parse image edit form request: %w", err)
+		}
+		mf = c.Request.MultipartForm
+	}
+	// 兼容 image、image[] 与 image[0]、image[1] 等字段名
+	fieldNames := make([]string, 0, len(mf.File))
+	for fieldName := range mf.File {
+		if fieldName == "image" || strings.HasPrefix(fieldName, "image[") {
+			fieldNames = append(fieldNames, fieldName)
+		}
+	}
+	sort.Strings(fieldNames)
+	for _, fieldName := range fieldNames {
+		for _, fileHeader := range mf.File[fieldName] {
+			file, err := readImageEditFile(fileHeader)
+			if err != nil {
+				return err
+			}
+			input.Images = append(input.Images, file)
+		}
+	}
+	if maskFiles := mf.File["mask"]; len(maskFiles) > 0 {
+		mask, err := readImageEditFile(maskFiles[0])
+		if err != nil {
+			return err
+		}
+		input.Mask = mask
+	}
+	return nil
+}
+
+func readImageEditFile(fileHeader *multipart.FileHeader) (*types.LocalFileData, error) {
+	file, err := fileHeader.Open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open image file %s: %w", fileHeader.Filename, err)
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image file %s: %w", fileHeader.Filename, err)
+	}
+	return &types.LocalFileData{
+		MimeType:   http.DetectContentType(data),
+		Base64Data: base64.StdEncoding.EncodeToString(data),
+		Size:       int64(len(data)),
+	}, nil
+}
+
+// parseImageEditSources 解析 JSON 中的图片，支持字符串、字符串数组以及 {"image_url": ...} 对象（数组）
+func parseImageEditSources(raw json.RawMessage) ([]*types.LocalFileData, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	result := gjson.ParseBytes(raw)
+	items := []gjson.Result{result}
+	if result.IsArray() {
+		items = result.Array()
+	}
+	files := make([]*types.LocalFileData, 0, len(items))
+	for _, item := range items {
+		source := item.String()
+		if item.IsObject() {
+			if item.Get("file_id").Exists() {
+				return nil, errors.New("image file_id is not supported, please use image_url instead")
+			}
+			source = item.Get("image_url").String()
+			if item.Get("image_url.url").Exists() {
+				source = item.Get("image_url.url").String()
+			} else if source == "" {
+				source = item.Get("url").String()
+			}
+		}
+		source = strings.TrimSpace(source)
+		if source == "" {
+			continue
+		}
+		if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+			files = append(files, &types.LocalFileData{Url: source})
+			continue
+		}
+		mimeType, data, err := DecodeBase64FileData(source)
+		if err != nil {
+			return nil, fmt.Errorf("invalid image data: %w", err)
+		}
+		if data == "" {
+			return nil, errors.New("invalid image data: empty image")
+		}
+		files = append(files, &types.LocalFileData{
+			MimeType:   mimeType,
+			Base64Data: data,
+		})
+	}
+	return files, nil
+}
+
+// GetImageEditFileBase64 获取输入图片的 MIME 类型与 base64 数据，URL 图片会先下载
+func GetImageEditFileBase64(c *gin.Context, file *types.LocalFileData) (string, string, error) {
+	if file.Base64Data != "" {
+		return file.MimeType, file.Base64Data, nil
+	}
+	fileData, err := GetFileBase64FromUrl(c, file.Url, "image edit")
+	if err != nil {
+		return "", "", fmt.Errorf("failed to download image %s: %w", file.Url, err)
+	}
+	return fileData.MimeType, fileData.Base64Data, nil
+}
+
+// GetImageEditFileUrl 获取可直接提交给上游的图片地址，上传的文件以 data URL 形式提交
+func GetImageEditFileUrl(file *types.LocalFileData) string {
+	if file.Url != "" {
+		return file.Url
+	}
+	return fmt.Sprintf("data:%s;base64,%s", file.MimeType, file.Base64Data)
+}
+
+// ConvertImageEditMask 将 OpenAI 格式的蒙版（透明区域为待编辑区域）转换为黑白蒙版（白色区域为待编辑区域），不含透明像素的蒙版视为已是黑白蒙版
+func ConvertImageEditMask(c *gin.Context, mask *types.LocalFileData) (*types.LocalFileData, error) {
+	mimeType, data, err := GetImageEditFileBase64(c, mask)
+	if err != nil {
+		return nil, err
+	}
+	decoded, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode mask: %w", err)
+	}
+	img, _, err := image.Decode(bytes.NewReader(decoded))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode mask: %w", err)
+	}
+	bounds := img.Bounds()
+	binary := image.NewGray(bounds)
+	transparent := false
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			_, _, _, alpha := img.At(x, y).RGBA()
+			if alpha < 0x8000 {
+				transparent = true
+				binary.SetGray(x, y, color.Gray{Y: 0xff})
+			}
+		}
+	}
+	if !transparent {
+		return &types.LocalFileData{MimeType: mimeType, Base64Data: data, Size: int64(len(decoded))}, nil
+	}
+	var buf bytes.Buffer
+	if err = png.Encode(&buf, binary); err != nil {
+		return nil, fmt.Errorf("failed to encode mask: %w", err)
+	}
+	return &types.LocalFileData{
+		MimeType:   "image/png",
+		Base64Data: base64.StdEncoding.EncodeToString(buf.Bytes()),
+		Size:       int64(buf.Len()),
+	}, nil
+}
+
+// GetImageEditPrompt 获取图片编辑的提示词，变体请求未提供提示词时使用默认提示词
+func GetImageEditPrompt(relayMode int, prompt string) string {
+	if relayMode == relayconstant.RelayModeImagesVariations && strings.TrimSpace(prompt) == "" {
+		return imageVariationPrompt
+	}
+	return prompt
+}
@@ -0,0 +1,152 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/ctrlc-ctrlv-limited/cvai/common"
+	"github.com/ctrlc-ctrlv-limited/cvai/constant"
+	"github.com/ctrlc-ctrlv-limited/cvai/dto"
+	"github.com/ctrlc-ctrlv-limited/cvai/model"
+	relaycommon "github.com/ctrlc-ctrlv-limited/cvai/relay/common"
+
+	"github.com/gin-gonic/gin"
+)
+
+// openAIUpstream 直接调用 OpenAI 兼容上游的 Files、Batches、微调等接口时使用的渠道信息
+type openAIUpstream struct {
+	ChannelId   int
+	ChannelType int
+	BaseURL     string
+	Key         string
+	KeyIndex    int
+	Proxy       string
+	// Azure 渠道使用的 api-version
+	ApiVersion string
+}
+
+func newOpenAIUpstreamFromInfo(info *relaycommon.RelayInfo) *openAIUpstream {
+	return &openAIUpstream{
+		ChannelId:   info.ChannelId,
+		ChannelType: info.ChannelType,
+		BaseURL:     info.ChannelBaseUrl,
+		Key:         info.ApiKey,
+		KeyIndex:    info.ChannelMultiKeyIndex,
+		Proxy:       info.ChannelSetting.Proxy,
+		ApiVersion:  info.ApiVersion,
+	}
+}
+
+// openAIUpstreamFromContext 使用分发中间件选中的渠道与 key 创建上游信息
+func openAIUpstreamFromContext(c *gin.Context) *openAIUpstream {
+	upstream := &openAIUpstream{
+		ChannelId:   common.GetContextKeyInt(c, constant.ContextKeyChannelId),
+		ChannelType: common.GetContextKeyInt(c, constant.ContextKeyChannelType),
+		BaseURL:     common.GetContextKeyString(c, constant.ContextKeyChannelBaseUrl),
+		Key:         common.GetContextKeyString(c, constant.ContextKeyChannelKey),
+		KeyIndex:    common.GetContextKeyInt(c, constant.ContextKeyChannelMultiKeyIndex),
+		ApiVersion:  c.GetString("api_version"),
+	}
+	if channelSetting, ok := common.GetContextKeyType[dto.ChannelSettings](c, constant.ContextKeyChannelSetting); ok {
+		upstream.Proxy = channelSetting.Proxy
+	}
+	return upstream
+}
+
+// newOpenAIUpstream 使用渠道的指定 key 创建上游信息
+func newOpenAIUpstream(channel *model.Channel, keyIndex int) (*openAIUpstream, error) {
+	key := channel.Key
+	if channel.ChannelInfo.IsMultiKey {
+		keys := channel.GetKeys()
+		if keyIndex < 0 || keyIndex >= len(keys) {
+			return nil, fmt.Errorf("key index %d of channel #%d not found", keyIndex, channel.Id)
+		}
+		key = keys[keyIndex]
+	}
+	upstream := &openAIUpstream{
+		ChannelId:   channel.Id,
+		ChannelType: channel.Type,
+		BaseURL:     channel.GetBaseURL(),
+		Key:         key,
+		KeyIndex:    keyIndex,
+		Proxy:       channel.GetSetting().Proxy,
+	}
+	if channel.Type == constant.ChannelTypeAzure {
+		upstream.ApiVersion = channel.Other
+	}
+	return upstream, nil
+}
+
+// getChannelUpstream 使用渠道 id 与 key 序号获取上游信息
+func getChannelUpstream(channelId int, keyIndex int) (*openAIUpstream, error) {
+	channel, err := model.CacheGetChannel(channelId)
+	if err != nil {
+		return nil, err
+	}
+	return newOpenAIUpstream(channel, keyIndex)
+}
+
+func (u *openAIUpstream) url(path string) string {
+	baseURL := u.BaseURL
+	if baseURL == "" {
+		baseURL = constant.ChannelBaseURLs[u.ChannelType]
+	}
+	if u.ChannelType == constant.ChannelTypeAzure {
+		// Azure 的 Files、微调等接口位于 /openai 下，并且需要 api-version 参数
+		apiVersion := u.ApiVersion
+		if apiVersion == "" {
+			apiVersion = constant.AzureDefaultAPIVersion
+		}
+		separator := "?"
+		if strings.Contains(path, "?") {
+			separator = "&"
+		}
+		path = "/openai/" + strings.TrimPrefix(path, "/v1/") + separator + "api-version=" + apiVersion
+	}
+	return strings.TrimSuffix(baseURL, "/") + path
+}
+
+// request 请求上游接口，返回原始响应，由调用方关闭响应体
+func (u *openAIUpstream) request(ctx context.Context, method string, path string, contentType string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u.url(path), body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if u.ChannelType == constant.ChannelTypeAzure {
+		req.Header.Set("api-key", u.Key)
+	} else {
+		req.Header.Set("Authorization", "Bearer "+u.Key)
+	}
+	client, err := GetHttpClientWithProxy(u.Proxy)
+	if err != nil {
+		return nil, err
+	}
+	return client.Do(req)
+}
+
+// do 请求上游接口，返回响应体，非 2xx 响应返回错误
+func (u *openAIUpstream) do(ctx context.Context, method string, path string, contentType string, body io.Reader) ([]byte, error) {
+	resp, err := u.request(ctx, method, path, contentType, body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("%s %s failed: status %d, %s", method, path, resp.StatusCode, string(respBody))
+	}
+	if len(respBody) == 0 && method != http.MethodDelete {
+		return nil, errors.New("empty response from upstream")
+	}
+	return respBody, nil
+}
@@ -14,6 +14,7 @@ import (
 	"github.com/ctrlc-ctrlv-limited/cvai/logger"
 	"github.com/ctrlc-ctrlv-limited/cvai/model"
 	relaycommon "github.com/ctrlc-ctrlv-limited/cvai/relay/common"
+	"github.com/ctrlc-ctrlv-limited/cvai/relay/helper"
 	"github.com/ctrlc-ctrlv-limited/cvai/setting/ratio_setting"
 	"github.com/ctrlc-ctrlv-limited/cvai/setting/system_setting"
 	"github.com/ctrlc-ctrlv-limited/cvai/types"
@@ -90,7 +91,7 @@ func PreWssConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, usag
 	if relayInfo.UsePrice {
 		return nil
 	}
-	userQuota, err := model.GetUserQuota(relayInfo.UserId, false)
+	_, userQuota, err := GetUserAvailableQuota(ctx, relayInfo.UserId)
 	if err != nil {
 		return err
 	}
@@ -192,6 +193,7 @@ func PostWssConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, mod
 	quota := calculateAudioQuota(quotaInfo)
 
 	totalTokens := usage.TotalTokens
+	RecordKeyTokenUsage(ctx, relayInfo, totalTokens)
 	var logContent string
 	if !usePrice {
 		logContent = fmt.Sprintf("模型倍率 %.2f，补全倍率 %.2f，音频倍率 %.2f，音频补全倍率 %.2f，分组倍率 %.2f",
@@ -236,12 +238,18 @@ func PostWssConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, mod
 }
 
 func PostClaudeConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, usage *dto.Usage) {
+	if IsHedgeLoser(ctx, usage) {
+		return
+	}
 
 	useTimeSeconds := time.Now().Unix() - relayInfo.StartTime.Unix()
 	promptTokens := usage.PromptTokens
 	completionTokens := usage.CompletionTokens
 	modelName := relayInfo.OriginModelName
 
+	// 按实际的 prompt tokens 重新匹配分档计费规则
+	helper.ApplyPricingRule(ctx, relayInfo, &relayInfo.PriceData, helper.PricingRulePromptTokens(relayInfo, usage))
+
 	tokenName := ctx.GetString("token_name")
 	completionRatio := relayInfo.PriceData.CompletionRatio
 	modelRatio := relayInfo.PriceData.ModelRatio
@@ -292,6 +300,7 @@ func PostClaudeConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo,
 	quota := int(calculateQuota)
 
 	totalTokens := promptTokens + completionTokens
+	RecordKeyTokenUsage(ctx, relayInfo, totalTokens)
 
 	var logContent string
 	// record all the consume log even if quota is 0
@@ -375,6 +384,9 @@ func CalcOpenRouterCacheCreateTokens(usage dto.Usage, priceData types.PriceData)
 }
 
 func PostAudioConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, usage *dto.Usage, extraContent string) {
+	if IsHedgeLoser(ctx, usage) {
+		return
+	}
 
 	useTimeSeconds := time.Now().Unix() - relayInfo.StartTime.Unix()
 	textInputTokens := usage.PromptTokensDetails.TextTokens
@@ -411,6 +423,7 @@ func PostAudioConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, u
 	quota := calculateAudioQuota(quotaInfo)
 
 	totalTokens := usage.TotalTokens
+	RecordKeyTokenUsage(ctx, relayInfo, totalTokens)
 	var logContent string
 	if !usePrice {
 		logContent = fmt.Sprintf("模型倍率 %.2f，补全倍率 %.2f，音频倍率 %.2f，音频补全倍率 %.2f，分组倍率 %.2f",
@@ -477,6 +490,74 @@ func PostAudioConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, u
 	})
 }
 
+// relayQuotaLedgerMeta 请求产生的额度变动流水信息
+func relayQuotaLedgerMeta(relayInfo *relaycommon.RelayInfo, reason string) model.QuotaLedgerMeta {
+	return model.QuotaLedgerMeta{
+		Reason:    reason,
+		RequestId: relayInfo.RequestId,
+		ActorId:   relayInfo.UserId,
+		Remark:    relayInfo.OriginModelName,
+	}
+}
+
+// chargeRequestQuota 检查并从当前请求的用户与令牌额度中直接扣除额度，用于不经过中转流程计费的接口，remark 记入额度流水
+func chargeRequestQuota(c *gin.Context, quota int, remark string) error {
+	if quota <= 0 {
+		return nil
+	}
+	userId := c.GetInt("id")
+	_, userQuota, err := GetUserAvailableQuota(c, userId)
+	if err != nil {
+		return err
+	}
+	if userQuota < quota {
+		return fmt.Errorf("用户额度不足，剩余额度: %s，需要额度: %s", logger.FormatQuota(userQuota), logger.FormatQuota(quota))
+	}
+	tokenId := c.GetInt("token_id")
+	tokenKey := c.GetString("token_key")
+	if !c.GetBool("token_unlimited_quota") {
+		token, err := model.GetTokenByKey(tokenKey, false)
+		if err != nil {
+			return err
+		}
+		if token.RemainQuota < quota {
+			return fmt.Errorf("令牌额度不足，剩余额度: %s，需要额度: %s", logger.FormatQuota(token.RemainQuota), logger.FormatQuota(quota))
+		}
+	}
+	meta := requestQuotaLedgerMeta(c, model.QuotaLedgerReasonConsume, remark)
+	if err = model.DecreaseTokenQuota(tokenId, userId, tokenKey, quota, meta); err != nil {
+		return err
+	}
+	if err = model.DecreaseUserQuota(userId, quota, meta); err != nil {
+		_ = model.IncreaseTokenQuota(tokenId, userId, tokenKey, quota, requestQuotaLedgerMeta(c, model.QuotaLedgerReasonRefund, remark))
+		return err
+	}
+	return nil
+}
+
+func requestQuotaLedgerMeta(c *gin.Context, reason string, remark string) model.QuotaLedgerMeta {
+	return model.QuotaLedgerMeta{
+		Reason:    reason,
+		RequestId: c.GetString(common.RequestIdKey),
+		ActorId:   c.GetInt("id"),
+		Remark:    remark,
+	}
+}
+
+// refundRequestQuota 退还 chargeRequestQuota 扣除的额度
+func refundRequestQuota(c *gin.Context, quota int, remark string) {
+	if quota <= 0 {
+		return
+	}
+	meta := requestQuotaLedgerMeta(c, model.QuotaLedgerReasonRefund, remark)
+	if err := model.IncreaseTokenQuota(c.GetInt("token_id"), c.GetInt("id"), c.GetString("token_key"), quota, meta); err != nil {
+		logger.LogError(c, "failed to refund token quota: "+err.Error())
+	}
+	if err := model.IncreaseUserQuota(c.GetInt("id"), quota, false, meta); err != nil {
+		logger.LogError(c, "failed to refund user quota: "+err.Error())
+	}
+}
+
 func PreConsumeTokenQuota(relayInfo *relaycommon.RelayInfo, quota int) error {
 	if quota < 0 {
 		return errors.New("quota 不能为负数！")
@@ -494,7 +575,7 @@ func PreConsumeTokenQuota(relayInfo *relaycommon.RelayInfo, quota int) error {
 	if !relayInfo.TokenUnlimited && token.RemainQuota < quota {
 		return fmt.Errorf("token quota is not enough, token remain quota: %s, need quota: %s", logger.FormatQuota(token.RemainQuota), logger.FormatQuota(quota))
 	}
-	err = model.DecreaseTokenQuota(relayInfo.TokenId, relayInfo.TokenKey, quota)
+	err = model.DecreaseTokenQuota(relayInfo.TokenId, relayInfo.UserId, relayInfo.TokenKey, quota, relayQuotaLedgerMeta(relayInfo, model.QuotaLedgerReasonPreConsume))
 	if err != nil {
 		return err
 	}
@@ -503,10 +584,14 @@ func PreConsumeTokenQuota(relayInfo *relaycommon.RelayInfo, quota int) error {
 
 func PostConsumeQuota(relayInfo *relaycommon.RelayInfo, quota int, preConsumedQuota int, sendEmail bool) (err error) {
 
+	meta := relayQuotaLedgerMeta(relayInfo, model.QuotaLedgerReasonConsume)
+	if quota < 0 {
+		meta.Reason = model.QuotaLedgerReasonRefund
+	}
 	if quota > 0 {
-		err = model.DecreaseUserQuota(relayInfo.UserId, quota)
+		err = model.DecreaseUserQuota(relayInfo.UserId, quota, meta)
 	} else {
-		err = model.IncreaseUserQuota(relayInfo.UserId, -quota, false)
+		err = model.IncreaseUserQuota(relayInfo.UserId, -quota, false, meta)
 	}
 	if err != nil {
 		return err
@@ -514,18 +599,23 @@ func PostConsumeQuota(relayInfo *relaycommon.RelayInfo, quota int, preConsumedQu
 
 	if !relayInfo.IsPlayground {
 		if quota > 0 {
-			err = model.DecreaseTokenQuota(relayInfo.TokenId, relayInfo.TokenKey, quota)
+			err = model.DecreaseTokenQuota(relayInfo.TokenId, relayInfo.UserId, relayInfo.TokenKey, quota, meta)
 		} else {
-			err = model.IncreaseTokenQuota(relayInfo.TokenId, relayInfo.TokenKey, -quota)
+			err = model.IncreaseTokenQuota(relayInfo.TokenId, relayInfo.UserId, relayInfo.TokenKey, -quota, meta)
 		}
 		if err != nil {
 			return err
 		}
 	}
+	recordQuotaBudgetUsage(relayInfo, quota)
 
 	if sendEmail {
 		if (quota + preConsumedQuota) != 0 {
-			checkAndSendQuotaNotify(relayInfo, quota, preConsumedQuota)
+			if relayInfo.UserCreditLimit > 0 {
+				checkAndSendCreditNotify(relayInfo)
+			} else {
+				checkAndSendQuotaNotify(relayInfo, quota, preConsumedQuota)
+			}
 		}
 	}
 
@@ -579,3 +669,11 @@ func checkAndSendQuotaNotify(relayInfo *relaycommon.RelayInfo, quota int, preCon
 		}
 	})
 }
+
+// RecordKeyTokenUsage 记录多 key 渠道中本次使用的 key 消耗的 token 数，用于按用量选择 key 以及 TPM 上限
+func RecordKeyTokenUsage(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, tokens int) {
+	if relayInfo.ChannelMeta == nil || !common.GetContextKeyBool(ctx, constant.ContextKeyChannelTrackKeyUsage) {
+		return
+	}
+	model.RecordKeyTokens(relayInfo.ChannelId, relayInfo.ChannelMultiKeyIndex, tokens)
+}
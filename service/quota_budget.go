@@ -0,0 +1,126 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ctrlc-ctrlv-limited/cvai/common"
+	"github.com/ctrlc-ctrlv-limited/cvai/dto"
+	"github.com/ctrlc-ctrlv-limited/cvai/logger"
+	"github.com/ctrlc-ctrlv-limited/cvai/model"
+	relaycommon "github.com/ctrlc-ctrlv-limited/cvai/relay/common"
+	"github.com/ctrlc-ctrlv-limited/cvai/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+var quotaBudgetPeriodNames = map[string]string{
+	dto.QuotaBudgetPeriodDaily:   "每日",
+	dto.QuotaBudgetPeriodWeekly:  "每周",
+	dto.QuotaBudgetPeriodMonthly: "每月",
+}
+
+// loadQuotaBudgets 请求上下文中没有周期预算时（如异步任务结算）从缓存读取令牌与用户的预算
+func loadQuotaBudgets(relayInfo *relaycommon.RelayInfo) {
+	if relayInfo.TokenBudget == nil {
+		budget := dto.QuotaBudget{}
+		if relayInfo.TokenKey != "" {
+			if token, err := model.GetTokenByKey(relayInfo.TokenKey, false); err == nil {
+				budget = token.GetBudget()
+			}
+		}
+		relayInfo.TokenBudget = &budget
+	}
+	if relayInfo.UserBudget == nil {
+		budget := dto.QuotaBudget{}
+		if user, err := model.GetUserCache(relayInfo.UserId); err == nil {
+			budget = user.GetBudget()
+		}
+		relayInfo.UserBudget = &budget
+	}
+}
+
+// quotaBudgetEnabled 返回本次请求是否受令牌或用户周期预算限制
+func quotaBudgetEnabled(relayInfo *relaycommon.RelayInfo) bool {
+	loadQuotaBudgets(relayInfo)
+	return (relayInfo.TokenBudget.Enabled() && !relayInfo.IsPlayground) || relayInfo.UserBudget.Enabled()
+}
+
+// ReserveQuotaBudget 检查令牌与用户的周期预算并原子地占用本次预扣的额度，预算用尽时返回包含重置时间的错误。
+// 占用的额度在请求结束后随预扣费一起结算差额，请求失败时随预扣费一起退还
+func ReserveQuotaBudget(c *gin.Context, relayInfo *relaycommon.RelayInfo, quota int) *types.CVAIError {
+	loadQuotaBudgets(relayInfo)
+	checks := []struct {
+		scope     string
+		scopeId   int
+		budget    dto.QuotaBudget
+		name      string
+		errorCode types.ErrorCode
+	}{
+		{model.QuotaBudgetScopeToken, relayInfo.TokenId, *relayInfo.TokenBudget, "令牌", types.ErrorCodeTokenBudgetExceeded},
+		{model.QuotaBudgetScopeUser, relayInfo.UserId, *relayInfo.UserBudget, "用户", types.ErrorCodeUserBudgetExceeded},
+	}
+	for i, check := range checks {
+		if !check.budget.Enabled() || (check.scope == model.QuotaBudgetScopeToken && relayInfo.IsPlayground) {
+			continue
+		}
+		reserved, used, resetAt, err := model.ReserveQuotaBudget(check.scope, check.scopeId, check.budget, quota)
+		if err != nil {
+			logger.LogError(c, fmt.Sprintf("failed to reserve %s budget: %s", check.scope, err.Error()))
+			// 未能占用时按原方式计数，结算与退还时不会少计
+			if err := model.IncreaseQuotaBudgetUsed(check.scope, check.scopeId, check.budget, quota); err != nil {
+				logger.LogError(c, fmt.Sprintf("failed to record %s budget usage: %s", check.scope, err.Error()))
+			}
+			continue
+		}
+		if reserved {
+			continue
+		}
+		// 退还已经占用的预算
+		for _, prev := range checks[:i] {
+			if prev.budget.Enabled() && (prev.scope != model.QuotaBudgetScopeToken || !relayInfo.IsPlayground) {
+				if err := model.IncreaseQuotaBudgetUsed(prev.scope, prev.scopeId, prev.budget, -quota); err != nil {
+					logger.LogError(c, fmt.Sprintf("failed to release %s budget: %s", prev.scope, err.Error()))
+				}
+			}
+		}
+		c.Header("Retry-After", strconv.Itoa(int(time.Until(resetAt).Seconds())+1))
+		return types.NewErrorWithStatusCode(fmt.Errorf("%s%s预算不足，本周期已用 %s，预算 %s，需要 %s，将于 %s 重置",
+			check.name, quotaBudgetPeriodNames[check.budget.Period], logger.FormatQuota(used), logger.FormatQuota(check.budget.Quota), logger.FormatQuota(quota), resetAt.Format(time.RFC3339)),
+			check.errorCode, http.StatusTooManyRequests, types.ErrOptionWithSkipRetry(), types.ErrOptionWithNoRecordErrorLog())
+	}
+	return nil
+}
+
+// recordQuotaBudgetUsage 将扣除（或退还）的额度计入令牌与用户当前周期的预算用量
+func recordQuotaBudgetUsage(relayInfo *relaycommon.RelayInfo, quota int) {
+	if quota == 0 {
+		return
+	}
+	loadQuotaBudgets(relayInfo)
+	if relayInfo.TokenBudget.Enabled() && !relayInfo.IsPlayground {
+		if err := model.IncreaseQuotaBudgetUsed(model.QuotaBudgetScopeToken, relayInfo.TokenId, *relayInfo.TokenBudget, quota); err != nil {
+			common.SysError(fmt.Sprintf("failed to record budget usage of token %d: %s", relayInfo.TokenId, err.Error()))
+		}
+	}
+	if relayInfo.UserBudget.Enabled() {
+		if err := model.IncreaseQuotaBudgetUsed(model.QuotaBudgetScopeUser, relayInfo.UserId, *relayInfo.UserBudget, quota); err != nil {
+			common.SysError(fmt.Sprintf("failed to record budget usage of user %d: %s", relayInfo.UserId, err.Error()))
+		}
+	}
+}
+
+// StartQuotaBudgetCleanupTask 定期删除过期周期的预算计数，启用 Redis 时计数自动过期
+func StartQuotaBudgetCleanupTask() {
+	if !common.IsMasterNode || common.RedisEnabled {
+		return
+	}
+	for {
+		time.Sleep(time.Hour)
+		if _, err := model.DeleteExpiredQuotaBudgetUsages(); err != nil {
+			common.SysError("failed to delete expired budget usages: " + err.Error())
+		}
+	}
+}
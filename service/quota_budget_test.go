@@ -0,0 +1,112 @@
+package service
+
+import (
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/ctrlc-ctrlv-limited/cvai/common"
+	"github.com/ctrlc-ctrlv-limited/cvai/dto"
+	"github.com/ctrlc-ctrlv-limited/cvai/model"
+	relaycommon "github.com/ctrlc-ctrlv-limited/cvai/relay/common"
+
+	"github.com/gin-gonic/gin"
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+func setupQuotaBudgetTest(t *testing.T) {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	if err = db.AutoMigrate(&model.QuotaBudgetUsage{}); err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+	// 内存数据库不支持并发写入，并发请求在连接上排队执行
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("failed to get database: %v", err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	oldDB, oldRedis := model.DB, common.RedisEnabled
+	model.DB = db
+	common.RedisEnabled = false
+	t.Cleanup(func() {
+		model.DB = oldDB
+		common.RedisEnabled = oldRedis
+		_ = sqlDB.Close()
+	})
+}
+
+func newQuotaBudgetTestRelayInfo(tokenBudget dto.QuotaBudget, userBudget dto.QuotaBudget) *relaycommon.RelayInfo {
+	return &relaycommon.RelayInfo{UserId: 1, TokenId: 1, TokenBudget: &tokenBudget, UserBudget: &userBudget}
+}
+
+func getQuotaBudgetUsedForTest(t *testing.T, scope string, budget dto.QuotaBudget) int {
+	t.Helper()
+	used, _, err := model.GetQuotaBudgetUsed(scope, 1, budget)
+	if err != nil {
+		t.Fatalf("failed to get %s budget usage: %v", scope, err)
+	}
+	return used
+}
+
+func TestReserveQuotaBudgetConcurrent(t *testing.T) {
+	setupQuotaBudgetTest(t)
+	budget := dto.QuotaBudget{Period: dto.QuotaBudgetPeriodDaily, Quota: 100, Timezone: "UTC"}
+
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	reserved := 0
+	for i := 0; i < 30; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest("POST", "/v1/chat/completions", nil)
+			if ReserveQuotaBudget(c, newQuotaBudgetTestRelayInfo(dto.QuotaBudget{}, budget), 10) == nil {
+				lock.Lock()
+				reserved++
+				lock.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if reserved != 10 {
+		t.Fatalf("expected 10 requests to reserve the budget, got %d", reserved)
+	}
+	if used := getQuotaBudgetUsedForTest(t, model.QuotaBudgetScopeUser, budget); used != 100 {
+		t.Fatalf("expected budget usage to stop at 100, got %d", used)
+	}
+}
+
+func TestReserveQuotaBudgetReleasesOnRejection(t *testing.T) {
+	setupQuotaBudgetTest(t)
+	tokenBudget := dto.QuotaBudget{Period: dto.QuotaBudgetPeriodDaily, Quota: 100, Timezone: "UTC"}
+	userBudget := dto.QuotaBudget{Period: dto.QuotaBudgetPeriodWeekly, Quota: 5, Timezone: "UTC"}
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("POST", "/v1/chat/completions", nil)
+
+	if newAPIError := ReserveQuotaBudget(c, newQuotaBudgetTestRelayInfo(tokenBudget, userBudget), 10); newAPIError == nil {
+		t.Fatalf("expected user budget to reject the request")
+	}
+	if used := getQuotaBudgetUsedForTest(t, model.QuotaBudgetScopeToken, tokenBudget); used != 0 {
+		t.Fatalf("expected token reservation to be released, got usage %d", used)
+	}
+	if c.Writer.Header().Get("Retry-After") == "" {
+		t.Fatalf("expected Retry-After header")
+	}
+
+	// 结算时只计入实际额度与占用额度的差额
+	relayInfo := newQuotaBudgetTestRelayInfo(tokenBudget, dto.QuotaBudget{})
+	if newAPIError := ReserveQuotaBudget(c, relayInfo, 10); newAPIError != nil {
+		t.Fatalf("expected token budget to be reserved, got %v", newAPIError)
+	}
+	recordQuotaBudgetUsage(relayInfo, -4)
+	if used := getQuotaBudgetUsedForTest(t, model.QuotaBudgetScopeToken, tokenBudget); used != 6 {
+		t.Fatalf("expected settled usage 6, got %d", used)
+	}
+}
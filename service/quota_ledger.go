@@ -0,0 +1,216 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/ctrlc-ctrlv-limited/cvai/common"
+	"github.com/ctrlc-ctrlv-limited/cvai/model"
+	"github.com/ctrlc-ctrlv-limited/cvai/setting/operation_setting"
+)
+
+const quotaLedgerReconcileBatchSize = 500
+
+var quotaLedgerAccountTypes = []string{model.QuotaLedgerAccountUser, model.QuotaLedgerAccountToken}
+
+var quotaLedgerReconciling atomic.Bool
+
+var errQuotaLedgerReconciling = errors.New("对账任务正在执行")
+
+var errQuotaLedgerDisabled = errors.New("额度流水未启用")
+
+// InitQuotaLedger 为启用流水之前已存在的账户写入期初余额，主节点在提供服务前调用
+func InitQuotaLedger() {
+	if !common.IsMasterNode || !operation_setting.GetQuotaLedgerSetting().Enabled {
+		return
+	}
+	for _, accountType := range quotaLedgerAccountTypes {
+		lastId := 0
+		for {
+			balances, err := model.GetQuotaLedgerAccountBalancesAfter(accountType, lastId, quotaLedgerReconcileBatchSize)
+			if err != nil {
+				common.SysError(fmt.Sprintf("failed to get %s balances for quota ledger: %s", accountType, err.Error()))
+				break
+			}
+			if len(balances) == 0 {
+				break
+			}
+			if _, err = compareQuotaLedgerBalances(accountType, balances, true); err != nil {
+				common.SysError(fmt.Sprintf("failed to open quota ledger for %s accounts: %s", accountType, err.Error()))
+			}
+			lastId = balances[len(balances)-1].AccountId
+			if len(balances) < quotaLedgerReconcileBatchSize {
+				break
+			}
+		}
+	}
+}
+
+// compareQuotaLedgerBalances 比较账户余额与流水余额，返回不一致的账户。open 为 true 时为还没有期初余额的账户
+// 写入期初余额，即账户余额与已有流水余额之差，运行中启用流水时已写入的流水不会被重复计入
+func compareQuotaLedgerBalances(accountType string, balances []model.QuotaLedgerAccountBalance, open bool) ([]*model.QuotaLedgerDrift, error) {
+	ids := make([]int, 0, len(balances))
+	for _, balance := range balances {
+		ids = append(ids, balance.AccountId)
+	}
+	ledgerBalances, err := model.GetQuotaLedgerBalances(accountType, ids)
+	if err != nil {
+		return nil, err
+	}
+	var opened map[int]bool
+	if open {
+		if opened, err = model.GetQuotaLedgerOpenedAccountIds(accountType, ids); err != nil {
+			return nil, err
+		}
+	}
+	now := common.GetTimestamp()
+	var drifts []*model.QuotaLedgerDrift
+	for _, balance := range balances {
+		ledgerBalance := ledgerBalances[balance.AccountId]
+		if open && !opened[balance.AccountId] {
+			if err = model.InsertQuotaLedgerOpening(balance.UserId, accountType, balance.AccountId, balance.Balance-ledgerBalance); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if balance.Balance == ledgerBalance {
+			continue
+		}
+		drifts = append(drifts, &model.QuotaLedgerDrift{
+			AccountType:   accountType,
+			AccountId:     balance.AccountId,
+			UserId:        balance.UserId,
+			Balance:       balance.Balance,
+			LedgerBalance: ledgerBalance,
+			Drift:         balance.Balance - ledgerBalance,
+			CheckedAt:     now,
+		})
+	}
+	return drifts, nil
+}
+
+// quotaLedgerRecheckDelay 复查前的等待时间，等待进行中的请求与批量更新完成
+var quotaLedgerRecheckDelay = func() time.Duration {
+	delay := 5 * time.Second
+	if common.BatchUpdateEnabled {
+		delay += time.Duration(common.BatchUpdateInterval) * time.Second
+	}
+	return delay
+}
+
+// recheckQuotaLedgerDrifts 复查首次发现的差异，只保留差额不变的账户，排除额度更新与流水写入之间的时间差
+func recheckQuotaLedgerDrifts(accountType string, candidates []*model.QuotaLedgerDrift) ([]*model.QuotaLedgerDrift, error) {
+	previous := make(map[int]int, len(candidates))
+	ids := make([]int, 0, len(candidates))
+	for _, drift := range candidates {
+		previous[drift.AccountId] = drift.Drift
+		ids = append(ids, drift.AccountId)
+	}
+	var drifts []*model.QuotaLedgerDrift
+	for start := 0; start < len(ids); start += quotaLedgerReconcileBatchSize {
+		end := min(start+quotaLedgerReconcileBatchSize, len(ids))
+		balances, err := model.GetQuotaLedgerAccountBalancesByIds(accountType, ids[start:end])
+		if err != nil {
+			return nil, err
+		}
+		rechecked, err := compareQuotaLedgerBalances(accountType, balances, false)
+		if err != nil {
+			return nil, err
+		}
+		for _, drift := range rechecked {
+			if previous[drift.AccountId] == drift.Drift {
+				drifts = append(drifts, drift)
+			}
+		}
+	}
+	return drifts, nil
+}
+
+func IsQuotaLedgerReconciling() bool {
+	return quotaLedgerReconciling.Load()
+}
+
+// ReconcileQuotaLedger 比较所有用户额度与令牌剩余额度和流水余额，记录并返回持续存在的差异
+func ReconcileQuotaLedger() ([]*model.QuotaLedgerDrift, error) {
+	if !operation_setting.GetQuotaLedgerSetting().Enabled {
+		return nil, errQuotaLedgerDisabled
+	}
+	if !quotaLedgerReconciling.CompareAndSwap(false, true) {
+		return nil, errQuotaLedgerReconciling
+	}
+	defer quotaLedgerReconciling.Store(false)
+
+	model.FlushBatchUpdate()
+	candidates := make(map[string][]*model.QuotaLedgerDrift)
+	checked := 0
+	for _, accountType := range quotaLedgerAccountTypes {
+		lastId := 0
+		for {
+			balances, err := model.GetQuotaLedgerAccountBalancesAfter(accountType, lastId, quotaLedgerReconcileBatchSize)
+			if err != nil {
+				return nil, err
+			}
+			if len(balances) == 0 {
+				break
+			}
+			drifts, err := compareQuotaLedgerBalances(accountType, balances, true)
+			if err != nil {
+				return nil, err
+			}
+			candidates[accountType] = append(candidates[accountType], drifts...)
+			checked += len(balances)
+			lastId = balances[len(balances)-1].AccountId
+			if len(balances) < quotaLedgerReconcileBatchSize {
+				break
+			}
+		}
+	}
+
+	var drifts []*model.QuotaLedgerDrift
+	if len(candidates) > 0 {
+		time.Sleep(quotaLedgerRecheckDelay())
+		model.FlushBatchUpdate()
+		for _, accountType := range quotaLedgerAccountTypes {
+			if len(candidates[accountType]) == 0 {
+				continue
+			}
+			rechecked, err := recheckQuotaLedgerDrifts(accountType, candidates[accountType])
+			if err != nil {
+				return nil, err
+			}
+			drifts = append(drifts, rechecked...)
+		}
+	}
+	if err := model.ReplaceQuotaLedgerDrifts(drifts); err != nil {
+		return nil, err
+	}
+	for _, drift := range drifts {
+		common.SysLog(fmt.Sprintf("quota ledger drift: %s %d (user %d) balance %d, ledger balance %d, drift %d",
+			drift.AccountType, drift.AccountId, drift.UserId, drift.Balance, drift.LedgerBalance, drift.Drift))
+	}
+	common.SysLog(fmt.Sprintf("quota ledger reconciled: %d accounts checked, %d drifted", checked, len(drifts)))
+	return drifts, nil
+}
+
+// StartQuotaLedgerReconcileTask 定期对账，只在主节点执行
+func StartQuotaLedgerReconcileTask() {
+	if !common.IsMasterNode {
+		return
+	}
+	for {
+		interval := operation_setting.GetQuotaLedgerSetting().ReconcileIntervalMinutes
+		if interval <= 0 {
+			interval = 60
+		}
+		time.Sleep(time.Duration(interval) * time.Minute)
+		setting := operation_setting.GetQuotaLedgerSetting()
+		if !setting.Enabled || !setting.ReconcileEnabled {
+			continue
+		}
+		if _, err := ReconcileQuotaLedger(); err != nil {
+			common.SysError("failed to reconcile quota ledger: " + err.Error())
+		}
+	}
+}
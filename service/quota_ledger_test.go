@@ -0,0 +1,97 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ctrlc-ctrlv-limited/cvai/common"
+	"github.com/ctrlc-ctrlv-limited/cvai/model"
+	"github.com/ctrlc-ctrlv-limited/cvai/setting/operation_setting"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+func setupQuotaLedgerReconcileTest(t *testing.T) {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	if err = db.AutoMigrate(&model.User{}, &model.Token{}, &model.QuotaLedgerEntry{}, &model.QuotaLedgerDrift{}); err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+	setting := operation_setting.GetQuotaLedgerSetting()
+	oldDB, oldEnabled, oldBatch, oldRedis, oldDelay := model.DB, setting.Enabled, common.BatchUpdateEnabled, common.RedisEnabled, quotaLedgerRecheckDelay
+	model.DB = db
+	setting.Enabled = true
+	common.BatchUpdateEnabled = false
+	common.RedisEnabled = false
+	quotaLedgerRecheckDelay = func() time.Duration { return 0 }
+	t.Cleanup(func() {
+		model.DB = oldDB
+		setting.Enabled = oldEnabled
+		common.BatchUpdateEnabled = oldBatch
+		common.RedisEnabled = oldRedis
+		quotaLedgerRecheckDelay = oldDelay
+	})
+}
+
+func TestReconcileQuotaLedger(t *testing.T) {
+	setupQuotaLedgerReconcileTest(t)
+	// 启用流水之前已存在的账户
+	user := &model.User{Username: "ledger", Quota: 1000}
+	if err := model.DB.Create(user).Error; err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	token := &model.Token{UserId: user.Id, Key: "reconcile", RemainQuota: 500}
+	if err := model.DB.Create(token).Error; err != nil {
+		t.Fatalf("failed to create token: %v", err)
+	}
+	// 期初余额写入前已记录的流水不重复计入
+	if err := model.DecreaseUserQuota(user.Id, 100, model.QuotaLedgerMeta{Reason: model.QuotaLedgerReasonConsume}); err != nil {
+		t.Fatalf("DecreaseUserQuota returned error: %v", err)
+	}
+
+	drifts, err := ReconcileQuotaLedger()
+	if err != nil {
+		t.Fatalf("ReconcileQuotaLedger returned error: %v", err)
+	}
+	if len(drifts) != 0 {
+		t.Fatalf("expected no drifts after opening, got %d", len(drifts))
+	}
+	balances, _ := model.GetQuotaLedgerBalances(model.QuotaLedgerAccountUser, []int{user.Id})
+	if balances[user.Id] != 900 {
+		t.Fatalf("expected user ledger balance 900, got %d", balances[user.Id])
+	}
+
+	if err = model.DecreaseTokenQuota(token.Id, user.Id, token.Key, 50, model.QuotaLedgerMeta{Reason: model.QuotaLedgerReasonConsume}); err != nil {
+		t.Fatalf("DecreaseTokenQuota returned error: %v", err)
+	}
+	// 绕过流水直接修改余额
+	model.DB.Model(&model.User{}).Where("id = ?", user.Id).Update("quota", 950)
+
+	drifts, err = ReconcileQuotaLedger()
+	if err != nil {
+		t.Fatalf("ReconcileQuotaLedger returned error: %v", err)
+	}
+	if len(drifts) != 1 {
+		t.Fatalf("expected 1 drift, got %d", len(drifts))
+	}
+	drift := drifts[0]
+	if drift.AccountType != model.QuotaLedgerAccountUser || drift.AccountId != user.Id || drift.Balance != 950 || drift.LedgerBalance != 900 || drift.Drift != 50 {
+		t.Fatalf("unexpected drift: %+v", *drift)
+	}
+	_, total, err := model.GetQuotaLedgerDrifts(0, 10)
+	if err != nil || total != 1 {
+		t.Fatalf("expected 1 recorded drift, got %d (%v)", total, err)
+	}
+}
+
+func TestReconcileQuotaLedgerDisabled(t *testing.T) {
+	setupQuotaLedgerReconcileTest(t)
+	operation_setting.GetQuotaLedgerSetting().Enabled = false
+	if _, err := ReconcileQuotaLedger(); err != errQuotaLedgerDisabled {
+		t.Fatalf("expected errQuotaLedgerDisabled, got %v", err)
+	}
+}
@@ -0,0 +1,194 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ctrlc-ctrlv-limited/cvai/common"
+	"github.com/ctrlc-ctrlv-limited/cvai/constant"
+	relaycommon "github.com/ctrlc-ctrlv-limited/cvai/relay/common"
+	"github.com/ctrlc-ctrlv-limited/cvai/types"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+	"github.com/gorilla/websocket"
+)
+
+const realtimeClientSecretKeyPrefix = "realtime_client_secret:"
+
+// RealtimeClientSecretPrefix 网关签发的 Realtime 临时密钥前缀
+const RealtimeClientSecretPrefix = "ek_cvai_"
+
+// RealtimeClientSecret 网关签发的 Realtime 临时密钥，记录上游签发的临时密钥以及创建会话时的令牌、渠道与价格
+type RealtimeClientSecret struct {
+	UserId            int             `json:"user_id"`
+	TokenId           int             `json:"token_id"`
+	UsingGroup        string          `json:"using_group"`
+	UserGroup         string          `json:"user_group"`
+	OriginModelName   string          `json:"origin_model_name"`
+	UpstreamModelName string          `json:"upstream_model_name"`
+	ChannelId         int             `json:"channel_id"`
+	IsMultiKey        bool            `json:"is_multi_key"`
+	KeyIndex          int             `json:"key_index"`
+	UpstreamSecret    string          `json:"upstream_secret"`
+	ExpiresAt         int64           `json:"expires_at"`
+	PriceData         types.PriceData `json:"price_data"`
+}
+
+var realtimeClientSecrets = make(map[string]*RealtimeClientSecret)
+var realtimeClientSecretsLock sync.Mutex
+
+// 原子地读取并删除临时密钥，兼容不支持 GETDEL 的 Redis 版本
+var realtimeClientSecretClaimScript = redis.NewScript(`
+local value = redis.call('GET', KEYS[1])
+if value then
+	redis.call('DEL', KEYS[1])
+end
+return value
+`)
+
+// NewRealtimeClientSecret 为创建的会话签发网关临时密钥，有效期与上游临时密钥一致
+func NewRealtimeClientSecret(c *gin.Context, info *relaycommon.RelayInfo, upstreamSecret string, expiresAt int64) (string, error) {
+	secret := &RealtimeClientSecret{
+		UserId:            info.UserId,
+		TokenId:           info.TokenId,
+		UsingGroup:        info.UsingGroup,
+		UserGroup:         info.UserGroup,
+		OriginModelName:   info.OriginModelName,
+		UpstreamModelName: info.UpstreamModelName,
+		ChannelId:         info.ChannelId,
+		IsMultiKey:        info.ChannelIsMultiKey,
+		KeyIndex:          info.ChannelMultiKeyIndex,
+		UpstreamSecret:    upstreamSecret,
+		ExpiresAt:         expiresAt,
+		PriceData:         info.PriceData,
+	}
+	if autoGroup := common.GetContextKeyString(c, constant.ContextKeyAutoGroup); autoGroup != "" {
+		secret.UsingGroup = autoGroup
+	}
+	now := time.Now().Unix()
+	if secret.ExpiresAt <= now {
+		secret.ExpiresAt = now + 60
+	}
+	key := RealtimeClientSecretPrefix + common.GetRandomString(48)
+	if err := saveRealtimeClientSecret(key, secret); err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+func saveRealtimeClientSecret(key string, secret *RealtimeClientSecret) error {
+	now := time.Now().Unix()
+	realtimeClientSecretsLock.Lock()
+	for k, s := range realtimeClientSecrets {
+		if s.ExpiresAt <= now {
+			delete(realtimeClientSecrets, k)
+		}
+	}
+	realtimeClientSecrets[key] = secret
+	realtimeClientSecretsLock.Unlock()
+
+	if common.RedisEnabled {
+		value, err := common.Marshal(secret)
+		if err != nil {
+			return err
+		}
+		return common.RedisSet(realtimeClientSecretKeyPrefix+key, string(value), time.Duration(secret.ExpiresAt-now)*time.Second)
+	}
+	return nil
+}
+
+// ClaimRealtimeClientSecret 原子地取出并删除未过期的网关临时密钥，每个临时密钥只能建立一次通话，
+// 并发使用同一个临时密钥时只有一个请求能取到；启用 Redis 时以 Redis 中的记录为准，保证多节点下同样只能使用一次
+func ClaimRealtimeClientSecret(key string) (*RealtimeClientSecret, bool) {
+	if !strings.HasPrefix(key, RealtimeClientSecretPrefix) {
+		return nil, false
+	}
+	realtimeClientSecretsLock.Lock()
+	secret, ok := realtimeClientSecrets[key]
+	delete(realtimeClientSecrets, key)
+	realtimeClientSecretsLock.Unlock()
+	if common.RedisEnabled {
+		value, err := realtimeClientSecretClaimScript.Run(context.Background(), common.RDB, []string{realtimeClientSecretKeyPrefix + key}).Text()
+		if err != nil || value == "" {
+			return nil, false
+		}
+		secret = &RealtimeClientSecret{}
+		ok = common.UnmarshalJsonStr(value, secret) == nil
+	}
+	if !ok || secret.ExpiresAt <= time.Now().Unix() {
+		return nil, false
+	}
+	return secret, true
+}
+
+// RestoreRealtimeClientSecret 通话未能建立时归还已取出的网关临时密钥，客户端可以使用同一个临时密钥重试
+func RestoreRealtimeClientSecret(key string, secret *RealtimeClientSecret) {
+	if secret.ExpiresAt <= time.Now().Unix() {
+		return
+	}
+	if err := saveRealtimeClientSecret(key, secret); err != nil {
+		common.SysError("failed to restore realtime client secret: " + err.Error())
+	}
+}
+
+// CreateRealtimeSession 在本次选择的渠道上创建 Realtime 会话，由调用方关闭响应体
+func CreateRealtimeSession(ctx context.Context, info *relaycommon.RelayInfo, path string, body []byte) (*http.Response, error) {
+	upstream := newOpenAIUpstreamFromInfo(info)
+	return upstream.request(ctx, http.MethodPost, path, "application/json", bytes.NewReader(body))
+}
+
+// CreateRealtimeCall 使用上游临时密钥将 SDP offer 发送到创建会话的渠道，由调用方关闭响应体
+func CreateRealtimeCall(ctx context.Context, secret *RealtimeClientSecret, path string, contentType string, body io.Reader) (*http.Response, error) {
+	upstream, err := getChannelUpstream(secret.ChannelId, secret.KeyIndex)
+	if err != nil {
+		return nil, err
+	}
+	upstream.Key = secret.UpstreamSecret
+	return upstream.request(ctx, http.MethodPost, path, contentType, body)
+}
+
+// DialRealtimeCall 使用渠道 key 连接 WebRTC 通话的 sideband WebSocket，用于接收通话中的用量事件
+func DialRealtimeCall(secret *RealtimeClientSecret, callId string) (*websocket.Conn, error) {
+	upstream, err := getChannelUpstream(secret.ChannelId, secret.KeyIndex)
+	if err != nil {
+		return nil, err
+	}
+	fullRequestURL := upstream.url("/v1/realtime?call_id=" + url.QueryEscape(callId))
+	if strings.HasPrefix(fullRequestURL, "https://") {
+		fullRequestURL = "wss://" + strings.TrimPrefix(fullRequestURL, "https://")
+	} else if strings.HasPrefix(fullRequestURL, "http://") {
+		fullRequestURL = "ws://" + strings.TrimPrefix(fullRequestURL, "http://")
+	}
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+upstream.Key)
+	conn, _, err := websocket.DefaultDialer.Dial(fullRequestURL, header)
+	if err != nil {
+		return nil, fmt.Errorf("dial failed to %s: %w", fullRequestURL, err)
+	}
+	return conn, nil
+}
+
+// HangupRealtimeCall 挂断 WebRTC 通话
+func HangupRealtimeCall(ctx context.Context, secret *RealtimeClientSecret, callId string) error {
+	upstream, err := getChannelUpstream(secret.ChannelId, secret.KeyIndex)
+	if err != nil {
+		return err
+	}
+	resp, err := upstream.request(ctx, http.MethodPost, "/v1/realtime/calls/"+url.PathEscape(callId)+"/hangup", "", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("hangup call %s failed: status %d", callId, resp.StatusCode)
+	}
+	return nil
+}
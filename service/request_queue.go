@@ -0,0 +1,296 @@
+package service
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ctrlc-ctrlv-limited/cvai/common"
+	"github.com/ctrlc-ctrlv-limited/cvai/constant"
+	"github.com/ctrlc-ctrlv-limited/cvai/logger"
+	"github.com/ctrlc-ctrlv-limited/cvai/model"
+	"github.com/ctrlc-ctrlv-limited/cvai/setting/operation_setting"
+
+	"github.com/bytedance/gopkg/util/gopool"
+	"github.com/gin-gonic/gin"
+)
+
+// 排队请求的响应头，值为排队等待的毫秒数
+const requestQueueWaitHeader = "X-Queue-Wait-Ms"
+
+// RequestQueueStats 某个分组下某个模型的排队统计，统计仅针对当前节点
+type RequestQueueStats struct {
+	Group string `json:"group"`
+	Model string `json:"model"`
+	// 当前排队的请求数与令牌数
+	Depth  int `json:"depth"`
+	Tokens int `json:"tokens"`
+	// 当前排队最久的请求已等待的毫秒数
+	OldestWaitMs int64 `json:"oldest_wait_ms"`
+	// 排队后获得渠道、排队超时、因队列已满被拒绝的请求数
+	Served   int64 `json:"served"`
+	TimedOut int64 `json:"timed_out"`
+	Rejected int64 `json:"rejected"`
+	// 获得渠道的请求的平均与最长等待毫秒数
+	AvgWaitMs int64 `json:"avg_wait_ms"`
+	MaxWaitMs int64 `json:"max_wait_ms"`
+}
+
+type queueWaiter struct {
+	tokenId    int
+	enqueuedAt time.Time
+	ready      chan struct{}
+	granted    bool
+	// 检查该请求是否已有可用渠道
+	check func() bool
+}
+
+// requestQueue 按令牌轮询放行的排队队列，每个令牌各自先进先出，避免单个令牌的大量请求饿死其他令牌
+type requestQueue struct {
+	group   string
+	model   string
+	waiters map[int][]*queueWaiter
+	order   []int
+	next    int
+	depth   int
+	running bool
+
+	served      int64
+	timedOut    int64
+	rejected    int64
+	totalWaitMs int64
+	maxWaitMs   int64
+}
+
+// 分组:模型 -> 队列
+var requestQueues = make(map[string]*requestQueue)
+var requestQueuesLock sync.Mutex
+
+func requestQueueKey(group string, modelName string) string {
+	return group + ":" + modelName
+}
+
+// push 加入队列，调用方需持有 requestQueuesLock
+func (q *requestQueue) push(w *queueWaiter) {
+	if len(q.waiters[w.tokenId]) == 0 {
+		q.order = append(q.order, w.tokenId)
+	}
+	q.waiters[w.tokenId] = append(q.waiters[w.tokenId], w)
+	q.depth++
+}
+
+// remove 移除超时或取消的请求，调用方需持有 requestQueuesLock
+func (q *requestQueue) remove(w *queueWaiter) {
+	list := q.waiters[w.tokenId]
+	idx := slices.Index(list, w)
+	if idx < 0 {
+		return
+	}
+	list = slices.Delete(list, idx, idx+1)
+	q.depth--
+	if len(list) > 0 {
+		q.waiters[w.tokenId] = list
+		return
+	}
+	delete(q.waiters, w.tokenId)
+	orderIdx := slices.Index(q.order, w.tokenId)
+	q.order = slices.Delete(q.order, orderIdx, orderIdx+1)
+	if orderIdx < q.next {
+		q.next--
+	}
+	if q.next >= len(q.order) {
+		q.next = 0
+	}
+}
+
+// head 按令牌轮询顺序返回下一个应放行的请求，调用方需持有 requestQueuesLock
+func (q *requestQueue) head() *queueWaiter {
+	if len(q.order) == 0 {
+		return nil
+	}
+	return q.waiters[q.order[q.next]][0]
+}
+
+// grant 放行队首请求并轮到下一个令牌，调用方需持有 requestQueuesLock
+func (q *requestQueue) grant() {
+	w := q.head()
+	q.remove(w)
+	// remove 在令牌没有剩余请求时已将轮询位置指向下一个令牌
+	if len(q.waiters[w.tokenId]) > 0 && len(q.order) > 0 {
+		q.next = (q.next + 1) % len(q.order)
+	}
+	w.granted = true
+	waitMs := time.Since(w.enqueuedAt).Milliseconds()
+	q.served++
+	q.totalWaitMs += waitMs
+	q.maxWaitMs = max(q.maxWaitMs, waitMs)
+	close(w.ready)
+}
+
+// dispatch 定期检查渠道是否恢复，恢复后按令牌轮询放行请求，队列为空时退出
+func (q *requestQueue) dispatch() {
+	for {
+		setting := operation_setting.GetRequestQueueSetting()
+		time.Sleep(time.Duration(max(setting.CheckIntervalMs, 10)) * time.Millisecond)
+
+		requestQueuesLock.Lock()
+		w := q.head()
+		if w == nil {
+			q.running = false
+			requestQueuesLock.Unlock()
+			return
+		}
+		requestQueuesLock.Unlock()
+
+		// 同一队列的请求分组、模型相同，只检查队首请求即可
+		if !w.check() {
+			continue
+		}
+
+		requestQueuesLock.Lock()
+		for released := 0; released < max(setting.MaxReleasePerCheck, 1) && q.head() != nil; released++ {
+			q.grant()
+		}
+		requestQueuesLock.Unlock()
+	}
+}
+
+// hasSelectableChannel 判断分组下该模型是否有可用渠道，auto 分组时检查用户可用的所有自动分组
+func hasSelectableChannel(group string, userGroup string, modelName string) bool {
+	if group != "auto" {
+		return model.HasSelectableChannel(group, modelName)
+	}
+	return slices.ContainsFunc(GetUserAutoGroup(userGroup), func(autoGroup string) bool {
+		return model.HasSelectableChannel(autoGroup, modelName)
+	})
+}
+
+// ShouldQueueRequest 判断请求是否需要排队：没有可用渠道、渠道全部熔断或限流冷却，或队列中已有请求在等待
+func ShouldQueueRequest(group string, modelName string, channel *model.Channel) bool {
+	if !operation_setting.IsRequestQueueEnabled() {
+		return false
+	}
+	if channel == nil || !model.IsChannelSelectable(channel, modelName) {
+		return true
+	}
+	requestQueuesLock.Lock()
+	defer requestQueuesLock.Unlock()
+	q, ok := requestQueues[requestQueueKey(group, modelName)]
+	return ok && q.depth > 0
+}
+
+// WaitInRequestQueue 排队等待分组下该模型的渠道恢复，最多等待 MaxWaitSeconds 秒；
+// 返回 nil 表示已有可用渠道，调用方应重新选择渠道
+func WaitInRequestQueue(c *gin.Context, group string, modelName string) error {
+	setting := operation_setting.GetRequestQueueSetting()
+	userGroup := common.GetContextKeyString(c, constant.ContextKeyUserGroup)
+	w := &queueWaiter{
+		tokenId:    common.GetContextKeyInt(c, constant.ContextKeyTokenId),
+		enqueuedAt: time.Now(),
+		ready:      make(chan struct{}),
+		check: func() bool {
+			return hasSelectableChannel(group, userGroup, modelName)
+		},
+	}
+
+	key := requestQueueKey(group, modelName)
+	requestQueuesLock.Lock()
+	q, ok := requestQueues[key]
+	if !ok {
+		q = &requestQueue{group: group, model: modelName, waiters: make(map[int][]*queueWaiter)}
+		requestQueues[key] = q
+	}
+	if setting.MaxQueueSize > 0 && q.depth >= setting.MaxQueueSize {
+		q.rejected++
+		requestQueuesLock.Unlock()
+		return fmt.Errorf("分组 %s 下模型 %s 的排队请求已满", group, modelName)
+	}
+	if setting.MaxPerToken > 0 && len(q.waiters[w.tokenId]) >= setting.MaxPerToken {
+		q.rejected++
+		requestQueuesLock.Unlock()
+		return fmt.Errorf("当前令牌在分组 %s 下模型 %s 的排队请求已达上限 %d", group, modelName, setting.MaxPerToken)
+	}
+	q.push(w)
+	if !q.running {
+		q.running = true
+		gopool.Go(q.dispatch)
+	}
+	requestQueuesLock.Unlock()
+	logger.LogDebug(c, "request queued, group: %s, model: %s, token: %d", group, modelName, w.tokenId)
+
+	timer := time.NewTimer(time.Duration(setting.MaxWaitSeconds) * time.Second)
+	defer timer.Stop()
+	var waitErr error
+	select {
+	case <-w.ready:
+	case <-timer.C:
+		waitErr = fmt.Errorf("分组 %s 下模型 %s 无可用渠道，排队等待 %d 秒后超时", group, modelName, setting.MaxWaitSeconds)
+	case <-c.Request.Context().Done():
+		waitErr = errors.New("client canceled while queued")
+	}
+	if waitErr != nil {
+		requestQueuesLock.Lock()
+		// 超时的同时被放行，仍视为获得渠道
+		if !w.granted {
+			q.remove(w)
+			if c.Request.Context().Err() == nil {
+				q.timedOut++
+			}
+			requestQueuesLock.Unlock()
+			return waitErr
+		}
+		requestQueuesLock.Unlock()
+	}
+	c.Header(requestQueueWaitHeader, strconv.FormatInt(time.Since(w.enqueuedAt).Milliseconds(), 10))
+	return nil
+}
+
+// HasQueuedRequests 当前节点是否有请求在排队等待渠道
+func HasQueuedRequests() bool {
+	requestQueuesLock.Lock()
+	defer requestQueuesLock.Unlock()
+	for _, q := range requestQueues {
+		if q.depth > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// GetRequestQueueStats 获取当前节点各个队列的排队统计
+func GetRequestQueueStats() []RequestQueueStats {
+	requestQueuesLock.Lock()
+	defer requestQueuesLock.Unlock()
+	now := time.Now()
+	result := make([]RequestQueueStats, 0, len(requestQueues))
+	for _, q := range requestQueues {
+		stats := RequestQueueStats{
+			Group:     q.group,
+			Model:     q.model,
+			Depth:     q.depth,
+			Tokens:    len(q.order),
+			Served:    q.served,
+			TimedOut:  q.timedOut,
+			Rejected:  q.rejected,
+			MaxWaitMs: q.maxWaitMs,
+		}
+		if q.served > 0 {
+			stats.AvgWaitMs = q.totalWaitMs / q.served
+		}
+		for _, list := range q.waiters {
+			stats.OldestWaitMs = max(stats.OldestWaitMs, now.Sub(list[0].enqueuedAt).Milliseconds())
+		}
+		result = append(result, stats)
+	}
+	slices.SortFunc(result, func(a, b RequestQueueStats) int {
+		if a.Group != b.Group {
+			return cmp.Compare(a.Group, b.Group)
+		}
+		return cmp.Compare(a.Model, b.Model)
+	})
+	return result
+}
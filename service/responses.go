@@ -0,0 +1,94 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/ctrlc-ctrlv-limited/cvai/common"
+	"github.com/ctrlc-ctrlv-limited/cvai/constant"
+	"github.com/ctrlc-ctrlv-limited/cvai/dto"
+	"github.com/ctrlc-ctrlv-limited/cvai/logger"
+	"github.com/ctrlc-ctrlv-limited/cvai/model"
+	relaycommon "github.com/ctrlc-ctrlv-limited/cvai/relay/common"
+	"github.com/ctrlc-ctrlv-limited/cvai/setting/operation_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+// IsStatefulResponsesChannel 渠道是否在上游保存 response，支持查询、取消与 previous_response_id 续写
+func IsStatefulResponsesChannel(channelType int) bool {
+	return channelType == constant.ChannelTypeOpenAI || channelType == constant.ChannelTypeAzure
+}
+
+// RecordResponseBinding 记录上游创建的 response 与本次使用的渠道、key 的绑定
+func RecordResponseBinding(c *gin.Context, info *relaycommon.RelayInfo, request *dto.OpenAIResponsesRequest, background bool) {
+	if !operation_setting.GetResponsesSetting().Enabled || info.ResponsesUsageInfo == nil || info.ResponseId == "" {
+		return
+	}
+	if !IsStatefulResponsesChannel(info.ChannelType) || request.IsStoreDisabled() {
+		return
+	}
+	binding := &model.ResponseBinding{
+		ResponseId: info.ResponseId,
+		UserId:     info.UserId,
+		ChannelId:  info.ChannelId,
+		ModelName:  info.OriginModelName,
+		Background: background,
+	}
+	if info.ChannelIsMultiKey {
+		binding.KeyIndex = info.ChannelMultiKeyIndex
+	}
+	if err := binding.Insert(); err != nil {
+		logger.LogError(c, fmt.Sprintf("failed to save binding of response %s: %s", info.ResponseId, err.Error()))
+	}
+}
+
+// ForwardResponseRequest 将 response 的查询、取消、删除等请求转发到创建它的渠道与 key，由调用方关闭响应体
+func ForwardResponseRequest(ctx context.Context, binding *model.ResponseBinding, method string, action string, rawQuery string) (*http.Response, error) {
+	upstream, err := getChannelUpstream(binding.ChannelId, binding.KeyIndex)
+	if err != nil {
+		return nil, err
+	}
+	path := "/v1/responses/" + url.PathEscape(binding.ResponseId)
+	if action != "" {
+		path += "/" + action
+	}
+	if rawQuery != "" {
+		path += "?" + rawQuery
+	}
+	return upstream.request(ctx, method, path, "", nil)
+}
+
+// FetchBackgroundResponse 从上游获取 background 模式 response 的最新状态
+func FetchBackgroundResponse(ctx context.Context, task *model.Task) ([]byte, error) {
+	upstream, err := getChannelUpstream(task.ChannelId, task.PrivateData.KeyIndex)
+	if err != nil {
+		return nil, err
+	}
+	return upstream.do(ctx, http.MethodGet, "/v1/responses/"+url.PathEscape(task.TaskID), "", nil)
+}
+
+// StartResponseBindingCleanupTask 定期删除超过保留天数的 response 绑定
+func StartResponseBindingCleanupTask() {
+	if !common.IsMasterNode {
+		return
+	}
+	for {
+		time.Sleep(time.Hour)
+		setting := operation_setting.GetResponsesSetting()
+		if !setting.Enabled || setting.RetentionDays <= 0 {
+			continue
+		}
+		before := time.Now().AddDate(0, 0, -setting.RetentionDays).Unix()
+		deleted, err := model.DeleteExpiredResponseBindings(before)
+		if err != nil {
+			common.SysError("failed to cleanup expired response bindings: " + err.Error())
+		}
+		if deleted > 0 {
+			common.SysLog(fmt.Sprintf("cleaned up %d expired response bindings", deleted))
+		}
+	}
+}
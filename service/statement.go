@@ -0,0 +1,374 @@
+package service
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/ctrlc-ctrlv-limited/cvai/common"
+	"github.com/ctrlc-ctrlv-limited/cvai/dto"
+	"github.com/ctrlc-ctrlv-limited/cvai/model"
+	"github.com/ctrlc-ctrlv-limited/cvai/setting/operation_setting"
+)
+
+const statementMonthLayout = "2006-01"
+
+const statementTimeLayout = "2006-01-02 15:04:05"
+
+// 账单格式
+const (
+	StatementFormatJSON = "json"
+	StatementFormatCSV  = "csv"
+	StatementFormatPDF  = "pdf"
+)
+
+// 账单消费的数据来源
+const (
+	StatementUsageSourceLogs      = "logs"
+	StatementUsageSourceQuotaData = "quota_data"
+)
+
+var statementCSVHeader = []string{"user_id", "username", "month", "section", "time", "model_name", "token_id", "token_name",
+	"reference", "description", "requests", "prompt_tokens", "completion_tokens", "total_tokens", "quota", "amount", "currency"}
+
+// ParseStatementMonth 解析账单月份（如 2006-01，按服务器时区），为空时返回上个月，不允许未来的月份
+func ParseStatementMonth(month string) (string, time.Time, time.Time, error) {
+	now := time.Now()
+	currentMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.Local)
+	start := currentMonth.AddDate(0, -1, 0)
+	if month != "" {
+		var err error
+		start, err = time.ParseInLocation(statementMonthLayout, month, time.Local)
+		if err != nil {
+			return "", time.Time{}, time.Time{}, fmt.Errorf("无效的账单月份: %s", month)
+		}
+		if start.After(currentMonth) {
+			return "", time.Time{}, time.Time{}, errors.New("账单月份不能晚于当前月份")
+		}
+	}
+	return start.Format(statementMonthLayout), start, start.AddDate(0, 1, 0), nil
+}
+
+// statementAmount 将额度按站点的额度展示类型换算为金额，TOKENS 展示类型下返回额度本身
+func statementAmount(quota int) float64 {
+	if !operation_setting.IsCurrencyDisplay() {
+		return float64(quota)
+	}
+	amount := float64(quota) / common.QuotaPerUnit * operation_setting.GetUsdToCurrencyRate(operation_setting.USDExchangeRate)
+	return math.Round(amount*1e6) / 1e6
+}
+
+func newUserStatement(userId int, username string, month string, start time.Time, end time.Time) *dto.UserStatement {
+	return &dto.UserStatement{
+		UserId:          userId,
+		Username:        username,
+		Month:           month,
+		StartTime:       start.Unix(),
+		EndTime:         end.Unix(),
+		Currency:        operation_setting.GetQuotaDisplayType(),
+		CurrencySymbol:  operation_setting.GetCurrencySymbol(),
+		ExchangeRate:    operation_setting.GetUsdToCurrencyRate(operation_setting.USDExchangeRate),
+		UsageSource:     StatementUsageSourceLogs,
+		Usages:          []dto.StatementUsage{},
+		TopUps:          []dto.StatementTopUp{},
+		Redemptions:     []dto.StatementRedemption{},
+		TotalTopUpMoney: map[string]float64{},
+		GeneratedAt:     common.GetTimestamp(),
+	}
+}
+
+// statementRecords 账单月份内的充值、兑换与数据看板数据，按用户分组
+type statementRecords struct {
+	topUps          map[int][]dto.StatementTopUp
+	redemptions     map[int][]dto.StatementRedemption
+	quotaDataUsages map[int][]dto.StatementUsage
+	// 有充值、兑换或数据看板数据的用户，升序排列
+	userIds []int
+}
+
+func loadStatementRecords(userId int, startTime int64, endTime int64) (*statementRecords, error) {
+	topUps, err := model.GetStatementTopUps(userId, startTime, endTime)
+	if err != nil {
+		return nil, err
+	}
+	redemptions, err := model.GetStatementRedemptions(userId, startTime, endTime)
+	if err != nil {
+		return nil, err
+	}
+	quotaDataUsages, err := model.GetStatementQuotaDataUsages(userId, startTime, endTime)
+	if err != nil {
+		return nil, err
+	}
+	records := &statementRecords{
+		topUps:          make(map[int][]dto.StatementTopUp),
+		redemptions:     make(map[int][]dto.StatementRedemption),
+		quotaDataUsages: make(map[int][]dto.StatementUsage),
+	}
+	seen := make(map[int]bool)
+	addUser := func(id int) {
+		if !seen[id] {
+			seen[id] = true
+			records.userIds = append(records.userIds, id)
+		}
+	}
+	for _, topUp := range topUps {
+		records.topUps[topUp.UserId] = append(records.topUps[topUp.UserId], topUp)
+		addUser(topUp.UserId)
+	}
+	for _, redemption := range redemptions {
+		records.redemptions[redemption.UserId] = append(records.redemptions[redemption.UserId], redemption)
+		addUser(redemption.UserId)
+	}
+	for _, usage := range quotaDataUsages {
+		records.quotaDataUsages[usage.UserId] = append(records.quotaDataUsages[usage.UserId], usage)
+		addUser(usage.UserId)
+	}
+	sort.Ints(records.userIds)
+	return records, nil
+}
+
+// complete 补充账单的充值与兑换记录并计算合计，没有消费日志时使用数据看板数据
+func (records *statementRecords) complete(statement *dto.UserStatement) {
+	if len(statement.Usages) == 0 && len(records.quotaDataUsages[statement.UserId]) > 0 {
+		statement.Usages = records.quotaDataUsages[statement.UserId]
+		statement.UsageSource = StatementUsageSourceQuotaData
+	}
+	if topUps := records.topUps[statement.UserId]; topUps != nil {
+		statement.TopUps = topUps
+	}
+	if redemptions := records.redemptions[statement.UserId]; redemptions != nil {
+		statement.Redemptions = redemptions
+	}
+	for i := range statement.Usages {
+		usage := &statement.Usages[i]
+		usage.Amount = statementAmount(usage.Quota)
+		statement.TotalRequests += usage.Count
+		statement.TotalUsageQuota += usage.Quota
+	}
+	statement.TotalUsageAmount = statementAmount(statement.TotalUsageQuota)
+	for _, topUp := range statement.TopUps {
+		statement.TotalTopUpMoney[topUp.Currency] += topUp.Money
+	}
+	for i := range statement.Redemptions {
+		redemption := &statement.Redemptions[i]
+		redemption.Amount = statementAmount(redemption.Quota)
+		statement.TotalRedemptionQuota += redemption.Quota
+	}
+}
+
+// eachStatement 按用户顺序生成账单月份内有消费、充值、兑换或数据看板记录的用户的账单并依次交给 fn，userId 为 0 时包含所有用户。
+// 消费日志只查询一次并逐行读取，每个用户的账单生成后立即交给 fn，不在内存中保留所有用户的账单
+func eachStatement(userId int, month string, start time.Time, end time.Time, fn func(statement *dto.UserStatement) error) error {
+	startTime, endTime := start.Unix(), end.Unix()
+	records, err := loadStatementRecords(userId, startTime, endTime)
+	if err != nil {
+		return err
+	}
+	var usernames map[int]string
+	if userId == 0 {
+		if usernames, err = model.GetStatementUsernames(records.userIds); err != nil {
+			return err
+		}
+	}
+	next := 0
+	emit := func(statement *dto.UserStatement) error {
+		records.complete(statement)
+		return fn(statement)
+	}
+	// 依次生成用户 id 小于 before 且没有消费日志的用户的账单
+	emitRecordsBefore := func(before int) error {
+		for ; next < len(records.userIds) && records.userIds[next] < before; next++ {
+			id := records.userIds[next]
+			if err := emit(newUserStatement(id, usernames[id], month, start, end)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	var current *dto.UserStatement
+	err = model.EachStatementUsage(userId, startTime, endTime, func(usage dto.StatementUsage) error {
+		if current != nil && current.UserId == usage.UserId {
+			current.Usages = append(current.Usages, usage)
+			return nil
+		}
+		if current != nil {
+			if err := emit(current); err != nil {
+				return err
+			}
+		}
+		if err := emitRecordsBefore(usage.UserId); err != nil {
+			return err
+		}
+		if next < len(records.userIds) && records.userIds[next] == usage.UserId {
+			next++
+		}
+		current = newUserStatement(usage.UserId, usage.Username, month, start, end)
+		current.Usages = append(current.Usages, usage)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if current != nil {
+		if err = emit(current); err != nil {
+			return err
+		}
+	}
+	return emitRecordsBefore(math.MaxInt)
+}
+
+// BuildUserStatement 生成用户指定月份的账单，包含按模型与令牌汇总的消费、完成的充值订单与使用的兑换码
+func BuildUserStatement(userId int, username string, month string) (*dto.UserStatement, error) {
+	month, start, end, err := ParseStatementMonth(month)
+	if err != nil {
+		return nil, err
+	}
+	var statement *dto.UserStatement
+	err = eachStatement(userId, month, start, end, func(s *dto.UserStatement) error {
+		statement = s
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if statement == nil {
+		statement = newUserStatement(userId, username, month, start, end)
+		(&statementRecords{}).complete(statement)
+	}
+	statement.Username = username
+	return statement, nil
+}
+
+// EachMonthlyStatement 按用户顺序生成指定月份所有有记录的用户的账单并依次交给 fn，用于流式导出
+func EachMonthlyStatement(month string, fn func(statement *dto.UserStatement) error) error {
+	month, start, end, err := ParseStatementMonth(month)
+	if err != nil {
+		return err
+	}
+	return eachStatement(0, month, start, end, fn)
+}
+
+func formatStatementTime(timestamp int64) string {
+	return time.Unix(timestamp, 0).Format(statementTimeLayout)
+}
+
+func formatStatementAmount(statement *dto.UserStatement, amount float64) string {
+	if statement.Currency == operation_setting.QuotaDisplayTypeTokens {
+		return strconv.FormatFloat(amount, 'f', 0, 64)
+	}
+	return statement.CurrencySymbol + strconv.FormatFloat(amount, 'f', 6, 64)
+}
+
+func formatStatementCSVAmount(amount float64) string {
+	return strconv.FormatFloat(amount, 'f', -1, 64)
+}
+
+// StatementCSVWriter 以 CSV 格式逐个写出账单，每个用户的消费、充值、兑换与合计各占一行，用 section 列区分，
+// 充值行的金额为实际支付金额，币种为支付币种，合计行的 description 为消费的数据来源
+type StatementCSVWriter struct {
+	writer *csv.Writer
+}
+
+// NewStatementCSVWriter 创建 CSV 账单写入器并写出表头
+func NewStatementCSVWriter(w io.Writer) (*StatementCSVWriter, error) {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(statementCSVHeader); err != nil {
+		return nil, err
+	}
+	return &StatementCSVWriter{writer: writer}, nil
+}
+
+// Write 写出一个用户的账单
+func (w *StatementCSVWriter) Write(statement *dto.UserStatement) error {
+	userId := strconv.Itoa(statement.UserId)
+	record := func(currency string, fields ...string) []string {
+		return append(append([]string{userId, statement.Username, statement.Month}, fields...), currency)
+	}
+	var records [][]string
+	for _, usage := range statement.Usages {
+		records = append(records, record(statement.Currency, "usage", "", usage.ModelName, strconv.Itoa(usage.TokenId), usage.TokenName, "", "",
+			strconv.Itoa(usage.Count), strconv.Itoa(usage.PromptTokens), strconv.Itoa(usage.CompletionTokens), strconv.Itoa(usage.TotalTokens),
+			strconv.Itoa(usage.Quota), formatStatementCSVAmount(usage.Amount)))
+	}
+	for _, topUp := range statement.TopUps {
+		records = append(records, record(topUp.Currency, "topup", formatStatementTime(topUp.CompleteTime), "", "", "", topUp.TradeNo, topUp.PaymentMethod,
+			"", "", "", "", "", formatStatementCSVAmount(topUp.Money)))
+	}
+	for _, redemption := range statement.Redemptions {
+		records = append(records, record(statement.Currency, "redemption", formatStatementTime(redemption.RedeemedTime), "", "", "", strconv.Itoa(redemption.Id), redemption.Name,
+			"", "", "", "", strconv.Itoa(redemption.Quota), formatStatementCSVAmount(redemption.Amount)))
+	}
+	records = append(records, record(statement.Currency, "total", "", "", "", "", "", statement.UsageSource,
+		strconv.Itoa(statement.TotalRequests), "", "", "", strconv.Itoa(statement.TotalUsageQuota), formatStatementCSVAmount(statement.TotalUsageAmount)))
+	return w.writer.WriteAll(records)
+}
+
+// Flush 将缓冲的内容写出
+func (w *StatementCSVWriter) Flush() error {
+	w.writer.Flush()
+	return w.writer.Error()
+}
+
+// StartStatementNotifyTask 每月初向上月有账单的用户发送账单已生成通知，只在主节点执行
+func StartStatementNotifyTask() {
+	if !common.IsMasterNode {
+		return
+	}
+	for {
+		time.Sleep(time.Hour)
+		setting := operation_setting.GetStatementSetting()
+		if !setting.NotifyEnabled {
+			continue
+		}
+		month, _, _, _ := ParseStatementMonth("")
+		if setting.LastNotifiedMonth == month {
+			continue
+		}
+		// 先记录月份再发送，避免发送过程中重启导致重复通知
+		if err := model.UpdateOption("statement_setting.last_notified_month", month); err != nil {
+			common.SysError("failed to update statement notified month: " + err.Error())
+			continue
+		}
+		notifyMonthlyStatements(month)
+	}
+}
+
+func notifyMonthlyStatements(month string) {
+	type statementSummary struct {
+		userId        int
+		totalRequests int
+		totalAmount   string
+	}
+	// 先汇总再发送通知，避免发送通知时长时间占用查询
+	var summaries []statementSummary
+	err := EachMonthlyStatement(month, func(statement *dto.UserStatement) error {
+		summaries = append(summaries, statementSummary{
+			userId:        statement.UserId,
+			totalRequests: statement.TotalRequests,
+			totalAmount:   formatStatementAmount(statement, statement.TotalUsageAmount),
+		})
+		return nil
+	})
+	if err != nil {
+		common.SysError(fmt.Sprintf("failed to build statements of %s: %s", month, err.Error()))
+		return
+	}
+	for _, summary := range summaries {
+		user, err := model.GetUserById(summary.userId, false)
+		if err != nil {
+			continue
+		}
+		content := "您 {{value}} 的月度账单已生成，共 {{value}} 次请求，消费 {{value}}，可在个人中心下载 CSV 或 JSON 格式的账单。"
+		values := []interface{}{month, summary.totalRequests, summary.totalAmount}
+		err = NotifyUser(user.Id, user.Email, user.GetSetting(), dto.NewNotify(dto.NotifyTypeStatement, fmt.Sprintf("%s 月度账单", month), content, values))
+		if err != nil {
+			common.SysLog(fmt.Sprintf("failed to notify statement to user %d: %s", user.Id, err.Error()))
+		}
+	}
+	common.SysLog(fmt.Sprintf("statements of %s notified to %d users", month, len(summaries)))
+}
@@ -0,0 +1,166 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ctrlc-ctrlv-limited/cvai/common"
+	"github.com/ctrlc-ctrlv-limited/cvai/dto"
+	"github.com/ctrlc-ctrlv-limited/cvai/setting/operation_setting"
+)
+
+// A4 页面，使用 PDF 内置的 Courier 等宽字体，不需要嵌入字体文件，仅支持 Latin-1 字符，其他字符显示为 ?
+const (
+	statementPDFPageWidth  = 595
+	statementPDFPageHeight = 842
+	statementPDFMargin     = 40
+	statementPDFFontSize   = 9
+	statementPDFLeading    = 12
+	statementPDFPageLines  = (statementPDFPageHeight - 2*statementPDFMargin) / statementPDFLeading
+)
+
+// pdfText 将字符串转换为 WinAnsiEncoding 并转义 PDF 字符串中的特殊字符
+func pdfText(s string) string {
+	var builder strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '\\' || r == '(' || r == ')':
+			builder.WriteByte('\\')
+			builder.WriteRune(r)
+		case (r >= 0x20 && r < 0x7f) || (r >= 0xa0 && r <= 0xff):
+			builder.WriteByte(byte(r))
+		default:
+			builder.WriteByte('?')
+		}
+	}
+	return builder.String()
+}
+
+// pdfColumn 将字段截断或补齐到固定宽度，right 为 true 时右对齐
+func pdfColumn(s string, width int, right bool) string {
+	runes := []rune(s)
+	if len(runes) > width {
+		return string(runes[:width-1]) + "~"
+	}
+	padding := strings.Repeat(" ", width-len(runes))
+	if right {
+		return padding + s
+	}
+	return s + padding
+}
+
+func pdfRow(columns ...string) string {
+	return strings.TrimRight(strings.Join(columns, " "), " ")
+}
+
+func statementPDFLines(statement *dto.UserStatement) []string {
+	amount := func(v float64) string {
+		return formatStatementAmount(statement, v)
+	}
+	currency := statement.Currency
+	if statement.Currency != operation_setting.QuotaDisplayTypeTokens {
+		currency += fmt.Sprintf(" (%s), 1 USD = %s", statement.CurrencySymbol, strconv.FormatFloat(statement.ExchangeRate, 'f', -1, 64))
+	}
+	lines := []string{
+		fmt.Sprintf("%s Monthly Statement %s", common.SystemName, statement.Month),
+		"",
+		fmt.Sprintf("User:      %s (ID %d)", statement.Username, statement.UserId),
+		fmt.Sprintf("Period:    %s - %s", formatStatementTime(statement.StartTime), formatStatementTime(statement.EndTime)),
+		fmt.Sprintf("Currency:  %s", currency),
+		fmt.Sprintf("Source:    %s", statement.UsageSource),
+		fmt.Sprintf("Generated: %s", time.Unix(statement.GeneratedAt, 0).Format(statementTimeLayout)),
+		"",
+		"USAGE",
+		pdfRow(pdfColumn("Model", 26, false), pdfColumn("Token", 16, false), pdfColumn("Requests", 9, true),
+			pdfColumn("Prompt", 11, true), pdfColumn("Completion", 11, true), pdfColumn("Amount", 16, true)),
+		strings.Repeat("-", 94),
+	}
+	for _, usage := range statement.Usages {
+		lines = append(lines, pdfRow(pdfColumn(usage.ModelName, 26, false), pdfColumn(usage.TokenName, 16, false), pdfColumn(strconv.Itoa(usage.Count), 9, true),
+			pdfColumn(strconv.Itoa(usage.PromptTokens), 11, true), pdfColumn(strconv.Itoa(usage.CompletionTokens), 11, true), pdfColumn(amount(usage.Amount), 16, true)))
+	}
+	if len(statement.Usages) == 0 {
+		lines = append(lines, "(none)")
+	}
+	lines = append(lines, strings.Repeat("-", 94),
+		fmt.Sprintf("Total: %d requests, %d quota, %s", statement.TotalRequests, statement.TotalUsageQuota, amount(statement.TotalUsageAmount)),
+		"",
+		"TOP-UPS",
+		pdfRow(pdfColumn("Time", 19, false), pdfColumn("Trade No", 26, false), pdfColumn("Method", 12, false),
+			pdfColumn("Amount", 10, true), pdfColumn("Paid", 16, true), pdfColumn("Currency", 8, false)),
+		strings.Repeat("-", 96),
+	)
+	for _, topUp := range statement.TopUps {
+		lines = append(lines, pdfRow(pdfColumn(formatStatementTime(topUp.CompleteTime), 19, false), pdfColumn(topUp.TradeNo, 26, false), pdfColumn(topUp.PaymentMethod, 12, false),
+			pdfColumn(strconv.FormatInt(topUp.Amount, 10), 10, true), pdfColumn(strconv.FormatFloat(topUp.Money, 'f', 2, 64), 16, true), pdfColumn(topUp.Currency, 8, false)))
+	}
+	if len(statement.TopUps) == 0 {
+		lines = append(lines, "(none)")
+	}
+	for _, currency := range slices.Sorted(maps.Keys(statement.TotalTopUpMoney)) {
+		lines = append(lines, fmt.Sprintf("Total paid: %s %s", strconv.FormatFloat(statement.TotalTopUpMoney[currency], 'f', 2, 64), currency))
+	}
+	lines = append(lines, "",
+		"REDEMPTIONS",
+		pdfRow(pdfColumn("Time", 19, false), pdfColumn("ID", 8, false), pdfColumn("Name", 30, false),
+			pdfColumn("Quota", 14, true), pdfColumn("Amount", 16, true)),
+		strings.Repeat("-", 91),
+	)
+	for _, redemption := range statement.Redemptions {
+		lines = append(lines, pdfRow(pdfColumn(formatStatementTime(redemption.RedeemedTime), 19, false), pdfColumn(strconv.Itoa(redemption.Id), 8, false), pdfColumn(redemption.Name, 30, false),
+			pdfColumn(strconv.Itoa(redemption.Quota), 14, true), pdfColumn(amount(redemption.Amount), 16, true)))
+	}
+	if len(statement.Redemptions) == 0 {
+		lines = append(lines, "(none)")
+	}
+	return lines
+}
+
+// RenderStatementPDF 将账单渲染为 PDF 文档
+func RenderStatementPDF(statement *dto.UserStatement) []byte {
+	lines := statementPDFLines(statement)
+	var pages [][]string
+	for start := 0; start < len(lines); start += statementPDFPageLines {
+		pages = append(pages, lines[start:min(start+statementPDFPageLines, len(lines))])
+	}
+
+	var buf bytes.Buffer
+	var offsets []int
+	writeObject := func(body string) {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+	buf.WriteString("%PDF-1.4\n")
+	// 对象 1 为目录，2 为页面树，3 为字体，之后每页依次为页面与内容流
+	kids := make([]string, 0, len(pages))
+	for i := range pages {
+		kids = append(kids, fmt.Sprintf("%d 0 R", 4+2*i))
+	}
+	writeObject("<< /Type /Catalog /Pages 2 0 R >>")
+	writeObject(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages)))
+	writeObject("<< /Type /Font /Subtype /Type1 /BaseFont /Courier /Encoding /WinAnsiEncoding >>")
+	for i, page := range pages {
+		writeObject(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources << /Font << /F1 3 0 R >> >> /Contents %d 0 R >>",
+			statementPDFPageWidth, statementPDFPageHeight, 5+2*i))
+		var content strings.Builder
+		fmt.Fprintf(&content, "BT\n/F1 %d Tf\n%d TL\n%d %d Td\n", statementPDFFontSize, statementPDFLeading,
+			statementPDFMargin, statementPDFPageHeight-statementPDFMargin-statementPDFFontSize)
+		for _, line := range page {
+			fmt.Fprintf(&content, "(%s) Tj T*\n", pdfText(line))
+		}
+		content.WriteString("ET")
+		writeObject(fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", content.Len(), content.String()))
+	}
+	xrefOffset := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xrefOffset)
+	return buf.Bytes()
+}
@@ -0,0 +1,93 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/ctrlc-ctrlv-limited/cvai/dto"
+)
+
+func newPDFTestStatement(usages int) *dto.UserStatement {
+	statement := &dto.UserStatement{
+		UserId:          1,
+		Username:        "用户(test)",
+		Month:           "2026-09",
+		Currency:        "USD",
+		CurrencySymbol:  "$",
+		ExchangeRate:    1,
+		UsageSource:     StatementUsageSourceLogs,
+		TotalTopUpMoney: map[string]float64{"CNY": 70, "USD": 10},
+		TopUps: []dto.StatementTopUp{
+			{TradeNo: "trade-1", PaymentMethod: "stripe", Currency: "USD", Amount: 10, Money: 10},
+			{TradeNo: "trade-2", PaymentMethod: "alipay", Currency: "CNY", Amount: 10, Money: 70},
+		},
+	}
+	for i := 0; i < usages; i++ {
+		statement.Usages = append(statement.Usages, dto.StatementUsage{ModelName: fmt.Sprintf("model-%d", i), TokenName: "token", Count: 1, Amount: 0.5})
+	}
+	return statement
+}
+
+func TestRenderStatementPDF(t *testing.T) {
+	tests := []struct {
+		name      string
+		usages    int
+		wantPages int
+	}{
+		{name: "single page", usages: 3, wantPages: 1},
+		{name: "multiple pages", usages: statementPDFPageLines * 2, wantPages: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := RenderStatementPDF(newPDFTestStatement(tt.usages))
+			if !bytes.HasPrefix(data, []byte("%PDF-1.4\n")) || !bytes.HasSuffix(data, []byte("%%EOF\n")) {
+				t.Fatalf("expected PDF header and trailer, got %q ... %q", data[:16], data[len(data)-16:])
+			}
+			if got := bytes.Count(data, []byte("/Type /Page ")); got != tt.wantPages {
+				t.Fatalf("expected %d pages, got %d", tt.wantPages, got)
+			}
+			if !bytes.Contains(data, []byte(fmt.Sprintf("/Count %d", tt.wantPages))) {
+				t.Fatalf("expected page tree to count %d pages", tt.wantPages)
+			}
+
+			// xref 中的偏移量必须指向对应的对象
+			startxref := regexp.MustCompile(`startxref\n(\d+)\n`).FindSubmatch(data)
+			if startxref == nil {
+				t.Fatalf("startxref not found")
+			}
+			xrefOffset, _ := strconv.Atoi(string(startxref[1]))
+			if !bytes.HasPrefix(data[xrefOffset:], []byte("xref\n")) {
+				t.Fatalf("startxref %d does not point to xref table", xrefOffset)
+			}
+			entries := regexp.MustCompile(`(\d{10}) 00000 n `).FindAllSubmatch(data[xrefOffset:], -1)
+			if len(entries) != 3+2*tt.wantPages {
+				t.Fatalf("expected %d objects, got %d", 3+2*tt.wantPages, len(entries))
+			}
+			for i, entry := range entries {
+				offset, _ := strconv.Atoi(string(entry[1]))
+				if want := fmt.Sprintf("%d 0 obj\n", i+1); !bytes.HasPrefix(data[offset:], []byte(want)) {
+					t.Fatalf("xref entry %d does not point to %q", i+1, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRenderStatementPDFContent(t *testing.T) {
+	data := string(RenderStatementPDF(newPDFTestStatement(1)))
+	for _, want := range []string{
+		`(User:      ??\(test\) \(ID 1\)) Tj`,
+		"trade-2",
+		"Total paid: 70.00 CNY",
+		"Total paid: 10.00 USD",
+		"model-0",
+	} {
+		if !strings.Contains(data, want) {
+			t.Fatalf("expected PDF to contain %q", want)
+		}
+	}
+}
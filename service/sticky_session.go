@@ -0,0 +1,264 @@
+package service
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ctrlc-ctrlv-limited/cvai/common"
+	"github.com/ctrlc-ctrlv-limited/cvai/constant"
+	"github.com/ctrlc-ctrlv-limited/cvai/logger"
+	"github.com/ctrlc-ctrlv-limited/cvai/model"
+	"github.com/ctrlc-ctrlv-limited/cvai/setting/operation_setting"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+const stickySessionKeyPrefix = "sticky_session:"
+
+// 本地绑定表的清理间隔（秒）
+const stickySessionSweepInterval = 60
+
+// StickyBinding 会话与渠道、key 序号的绑定关系
+type StickyBinding struct {
+	ChannelId int    `json:"channel_id"`
+	KeyIndex  int    `json:"key_index"`
+	Group     string `json:"group"`
+}
+
+type stickyEntry struct {
+	binding   StickyBinding
+	expiresAt int64
+}
+
+var stickySessions = make(map[string]stickyEntry)
+var stickySessionsLock sync.Mutex
+var stickySessionsLastSweep int64
+
+func stickySessionTTL() int {
+	ttl := operation_setting.GetStickySessionSetting().TTLSeconds
+	if ttl <= 0 {
+		ttl = 3600
+	}
+	return ttl
+}
+
+// stickySessionPrefixHash 计算系统提示词和前若干条消息的哈希，兼容 OpenAI、Claude、Gemini 与 Responses 格式
+func stickySessionPrefixHash(body []byte, prefixMessages int) string {
+	if prefixMessages <= 0 {
+		prefixMessages = 1
+	}
+	var builder strings.Builder
+	for _, path := range []string{"system", "instructions", "systemInstruction"} {
+		if value := gjson.GetBytes(body, path); value.Exists() {
+			builder.WriteString(value.Raw)
+		}
+	}
+	found := false
+	for _, path := range []string{"messages", "contents", "input"} {
+		value := gjson.GetBytes(body, path)
+		if !value.Exists() {
+			continue
+		}
+		found = true
+		if !value.IsArray() {
+			builder.WriteString(value.Raw)
+			break
+		}
+		for i, item := range value.Array() {
+			if i >= prefixMessages {
+				break
+			}
+			builder.WriteString(item.Raw)
+		}
+		break
+	}
+	if !found {
+		return ""
+	}
+	sum := sha1.Sum([]byte(builder.String()))
+	return hex.EncodeToString(sum[:])
+}
+
+// getStickySessionSource 按 请求头、user 字段、消息前缀哈希 的顺序获取会话标识
+func getStickySessionSource(c *gin.Context) string {
+	setting := operation_setting.GetStickySessionSetting()
+	if setting.HeaderName != "" {
+		if value := c.Request.Header.Get(setting.HeaderName); value != "" {
+			return "header:" + value
+		}
+	}
+	if !setting.UseUser && !setting.UsePrefixHash {
+		return ""
+	}
+	if !strings.HasPrefix(c.Request.Header.Get("Content-Type"), "application/json") {
+		return ""
+	}
+	body, err := common.GetRequestBody(c)
+	if err != nil || len(body) == 0 {
+		return ""
+	}
+	if setting.UseUser {
+		for _, path := range []string{"user", "metadata.user_id"} {
+			if value := gjson.GetBytes(body, path); value.Type == gjson.String && value.String() != "" {
+				return "user:" + value.String()
+			}
+		}
+	}
+	if setting.UsePrefixHash {
+		if hash := stickySessionPrefixHash(body, setting.PrefixMessages); hash != "" {
+			return "prefix:" + hash
+		}
+	}
+	return ""
+}
+
+// GetStickySessionKey 获取本次请求的会话亲和键，按用户和模型隔离，没有会话标识时返回空字符串
+func GetStickySessionKey(c *gin.Context, modelName string) string {
+	if !operation_setting.IsStickySessionEnabled() {
+		return ""
+	}
+	if key, ok := common.GetContextKey(c, constant.ContextKeyStickySessionKey); ok {
+		return key.(string)
+	}
+	key := ""
+	if source := getStickySessionSource(c); source != "" {
+		userId := common.GetContextKeyInt(c, constant.ContextKeyUserId)
+		sum := sha1.Sum([]byte(fmt.Sprintf("%d:%s:%s", userId, modelName, source)))
+		key = hex.EncodeToString(sum[:])
+	}
+	common.SetContextKey(c, constant.ContextKeyStickySessionKey, key)
+	return key
+}
+
+func getStickyBinding(key string) (*StickyBinding, bool) {
+	now := time.Now().Unix()
+	stickySessionsLock.Lock()
+	entry, ok := stickySessions[key]
+	if ok && entry.expiresAt <= now {
+		delete(stickySessions, key)
+		ok = false
+	}
+	stickySessionsLock.Unlock()
+	if ok {
+		return &entry.binding, true
+	}
+	if !common.RedisEnabled {
+		return nil, false
+	}
+	value, err := common.RedisGet(stickySessionKeyPrefix + key)
+	if err != nil || value == "" {
+		return nil, false
+	}
+	var binding StickyBinding
+	if err = common.UnmarshalJsonStr(value, &binding); err != nil {
+		return nil, false
+	}
+	setLocalStickyBinding(key, binding, now)
+	return &binding, true
+}
+
+func setLocalStickyBinding(key string, binding StickyBinding, now int64) {
+	stickySessionsLock.Lock()
+	defer stickySessionsLock.Unlock()
+	stickySessions[key] = stickyEntry{binding: binding, expiresAt: now + int64(stickySessionTTL())}
+	if now-stickySessionsLastSweep < stickySessionSweepInterval {
+		return
+	}
+	stickySessionsLastSweep = now
+	for k, entry := range stickySessions {
+		if entry.expiresAt <= now {
+			delete(stickySessions, k)
+		}
+	}
+}
+
+func deleteStickyBinding(key string) {
+	stickySessionsLock.Lock()
+	delete(stickySessions, key)
+	stickySessionsLock.Unlock()
+	if common.RedisEnabled {
+		_ = common.RedisDel(stickySessionKeyPrefix + key)
+	}
+}
+
+// GetStickyChannel 获取会话绑定的渠道，渠道已禁用、不再提供该模型或已熔断时解除绑定并返回 nil，由调用方正常选择渠道
+func GetStickyChannel(c *gin.Context, tokenGroup string, modelName string) *model.Channel {
+	key := GetStickySessionKey(c, modelName)
+	if key == "" {
+		return nil
+	}
+	binding, ok := getStickyBinding(key)
+	if !ok {
+		return nil
+	}
+	channel, err := model.CacheGetChannel(binding.ChannelId)
+	valid := err == nil && channel.Status == common.ChannelStatusEnabled
+	autoGroupIndex := -1
+	if valid && tokenGroup == "auto" {
+		userGroup := common.GetContextKeyString(c, constant.ContextKeyUserGroup)
+		autoGroupIndex = slices.Index(GetUserAutoGroup(userGroup), binding.Group)
+		valid = autoGroupIndex >= 0
+	} else if valid {
+		valid = binding.Group == tokenGroup
+	}
+	valid = valid && model.IsChannelSatisfied(binding.Group, modelName, channel.Id) && model.IsChannelSelectable(channel, modelName)
+	if !valid {
+		logger.LogDebug(c, "sticky session binding to channel #%d is no longer available", binding.ChannelId)
+		deleteStickyBinding(key)
+		return nil
+	}
+	if autoGroupIndex >= 0 {
+		common.SetContextKey(c, constant.ContextKeyAutoGroup, binding.Group)
+		common.SetContextKey(c, constant.ContextKeyAutoGroupIndex, autoGroupIndex)
+	}
+	common.SetContextKey(c, constant.ContextKeyStickyBinding, binding)
+	return channel
+}
+
+// BindStickySession 请求成功后将会话绑定到本次使用的渠道和 key，并刷新有效期
+func BindStickySession(c *gin.Context) {
+	key := common.GetContextKeyString(c, constant.ContextKeyStickySessionKey)
+	if key == "" || !operation_setting.IsStickySessionEnabled() {
+		return
+	}
+	binding := StickyBinding{
+		ChannelId: common.GetContextKeyInt(c, constant.ContextKeyChannelId),
+		Group:     common.GetContextKeyString(c, constant.ContextKeyUsingGroup),
+	}
+	if binding.ChannelId == 0 {
+		return
+	}
+	if autoGroup := common.GetContextKeyString(c, constant.ContextKeyAutoGroup); binding.Group == "auto" && autoGroup != "" {
+		binding.Group = autoGroup
+	}
+	if common.GetContextKeyBool(c, constant.ContextKeyChannelIsMultiKey) {
+		binding.KeyIndex = common.GetContextKeyInt(c, constant.ContextKeyChannelMultiKeyIndex)
+	}
+	setLocalStickyBinding(key, binding, time.Now().Unix())
+	if !common.RedisEnabled {
+		return
+	}
+	value, err := common.Marshal(binding)
+	if err != nil {
+		return
+	}
+	if err = common.RedisSet(stickySessionKeyPrefix+key, string(value), time.Duration(stickySessionTTL())*time.Second); err != nil {
+		logger.LogError(c, "failed to save sticky session: "+err.Error())
+	}
+}
+
+// GetStickyKeyIndex 获取会话绑定的 key 序号，只在首次为绑定的渠道选择 key 时生效一次，重试时正常选择
+func GetStickyKeyIndex(c *gin.Context, channelId int) (int, bool) {
+	binding, ok := common.GetContextKeyType[*StickyBinding](c, constant.ContextKeyStickyBinding)
+	if !ok || binding == nil || binding.ChannelId != channelId {
+		return 0, false
+	}
+	common.SetContextKey(c, constant.ContextKeyStickyBinding, nil)
+	return binding.KeyIndex, true
+}
@@ -0,0 +1,163 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ctrlc-ctrlv-limited/cvai/logger"
+	"github.com/ctrlc-ctrlv-limited/cvai/model"
+	relaycommon "github.com/ctrlc-ctrlv-limited/cvai/relay/common"
+	"github.com/ctrlc-ctrlv-limited/cvai/setting/operation_setting"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+// 读取 429 响应体查找 Gemini retryDelay 时的最大长度
+const maxRateLimitBodySize = 64 * 1024
+
+// parseRateLimitReset 解析重置时间，支持 OpenAI 的时长（如 6m0s、20ms）、秒数与 Anthropic 的 RFC3339 时间
+func parseRateLimitReset(value string, now time.Time) int64 {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.Unix()
+	}
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		return now.Add(time.Duration(seconds * float64(time.Second))).Unix()
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(d).Unix()
+	}
+	return 0
+}
+
+func parseRateLimitInt(header http.Header, names ...string) int64 {
+	for _, name := range names {
+		if value := header.Get(name); value != "" {
+			if n, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64); err == nil {
+				return n
+			}
+		}
+	}
+	return -1
+}
+
+func parseRateLimitResetHeader(header http.Header, now time.Time, names ...string) int64 {
+	for _, name := range names {
+		if reset := parseRateLimitReset(header.Get(name), now); reset > 0 {
+			return reset
+		}
+	}
+	return 0
+}
+
+// parseRateLimitHeaders 解析 OpenAI / Azure（x-ratelimit-*）与 Anthropic（anthropic-ratelimit-*）的限流额度，
+// 第二个返回值表示是否存在任何限流头
+func parseRateLimitHeaders(header http.Header, now time.Time) (model.KeyRateLimit, bool) {
+	limit := model.KeyRateLimit{
+		LimitRequests:     parseRateLimitInt(header, "x-ratelimit-limit-requests", "anthropic-ratelimit-requests-limit"),
+		RemainingRequests: parseRateLimitInt(header, "x-ratelimit-remaining-requests", "anthropic-ratelimit-requests-remaining"),
+		ResetRequestsAt:   parseRateLimitResetHeader(header, now, "x-ratelimit-reset-requests", "anthropic-ratelimit-requests-reset"),
+		LimitTokens:       parseRateLimitInt(header, "x-ratelimit-limit-tokens", "anthropic-ratelimit-tokens-limit", "anthropic-ratelimit-input-tokens-limit"),
+		RemainingTokens:   parseRateLimitInt(header, "x-ratelimit-remaining-tokens", "anthropic-ratelimit-tokens-remaining", "anthropic-ratelimit-input-tokens-remaining"),
+		ResetTokensAt:     parseRateLimitResetHeader(header, now, "x-ratelimit-reset-tokens", "anthropic-ratelimit-tokens-reset", "anthropic-ratelimit-input-tokens-reset"),
+		UpdatedAt:         now.Unix(),
+	}
+	found := limit.LimitRequests >= 0 || limit.RemainingRequests >= 0 || limit.LimitTokens >= 0 || limit.RemainingTokens >= 0
+	return limit, found
+}
+
+// parseRetryAfter 解析 Retry-After（秒数或 HTTP 时间）以及 OpenAI / Azure 的毫秒版本
+func parseRetryAfter(header http.Header, now time.Time) time.Duration {
+	for _, name := range []string{"retry-after-ms", "x-ms-retry-after-ms"} {
+		if ms, err := strconv.ParseFloat(header.Get(name), 64); err == nil && ms > 0 {
+			return time.Duration(ms * float64(time.Millisecond))
+		}
+	}
+	value := strings.TrimSpace(header.Get("Retry-After"))
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		return time.Duration(seconds * float64(time.Second))
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return t.Sub(now)
+	}
+	return 0
+}
+
+// parseGeminiRetryDelay 从 Gemini 429 响应体的 RetryInfo 中读取 retryDelay，读取后恢复响应体供后续错误处理使用
+func parseGeminiRetryDelay(resp *http.Response) time.Duration {
+	if resp.Body == nil {
+		return 0
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxRateLimitBodySize))
+	rest := resp.Body
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(body), rest), rest}
+	if err != nil {
+		return 0
+	}
+	var delay time.Duration
+	gjson.GetBytes(body, "error.details").ForEach(func(_, detail gjson.Result) bool {
+		if !strings.HasSuffix(detail.Get("@type").String(), "google.rpc.RetryInfo") {
+			return true
+		}
+		delay, _ = time.ParseDuration(detail.Get("retryDelay").String())
+		return false
+	})
+	return delay
+}
+
+// RecordUpstreamRateLimit 记录上游返回的限流额度；被限流或额度耗尽时，在重置前不再选择该渠道的这个 key
+func RecordUpstreamRateLimit(c *gin.Context, info *relaycommon.RelayInfo, resp *http.Response) {
+	setting := operation_setting.GetUpstreamRateLimitSetting()
+	if !setting.Enabled || info == nil || info.ChannelMeta == nil || resp == nil {
+		return
+	}
+	now := time.Now()
+	limit, found := parseRateLimitHeaders(resp.Header, now)
+
+	var cooldownUntil int64
+	if limit.RemainingRequests == 0 && limit.ResetRequestsAt > now.Unix() {
+		cooldownUntil = limit.ResetRequestsAt
+	}
+	if limit.RemainingTokens == 0 && limit.ResetTokensAt > now.Unix() {
+		cooldownUntil = max(cooldownUntil, limit.ResetTokensAt)
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		retryAfter := parseRetryAfter(resp.Header, now)
+		if retryAfter <= 0 {
+			retryAfter = parseGeminiRetryDelay(resp)
+		}
+		if retryAfter > 0 {
+			cooldownUntil = max(cooldownUntil, now.Add(retryAfter).Unix())
+		}
+		if cooldownUntil == 0 && setting.DefaultCooldownSeconds > 0 {
+			cooldownUntil = now.Unix() + int64(setting.DefaultCooldownSeconds)
+		}
+	}
+	if setting.MaxCooldownSeconds > 0 {
+		cooldownUntil = min(cooldownUntil, now.Unix()+int64(setting.MaxCooldownSeconds))
+	}
+	if !found && cooldownUntil == 0 {
+		return
+	}
+	limit.CooldownUntil = cooldownUntil
+	model.UpdateKeyRateLimit(info.ChannelId, info.ChannelMultiKeyIndex, limit)
+	if cooldownUntil > now.Unix() {
+		logger.LogWarn(c, fmt.Sprintf("channel #%d key #%d is rate limited by upstream, cooling down for %ds",
+			info.ChannelId, info.ChannelMultiKeyIndex, cooldownUntil-now.Unix()))
+	}
+}
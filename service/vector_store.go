@@ -0,0 +1,374 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ctrlc-ctrlv-limited/cvai/common"
+	"github.com/ctrlc-ctrlv-limited/cvai/constant"
+	"github.com/ctrlc-ctrlv-limited/cvai/logger"
+	"github.com/ctrlc-ctrlv-limited/cvai/model"
+	relaycommon "github.com/ctrlc-ctrlv-limited/cvai/relay/common"
+	"github.com/ctrlc-ctrlv-limited/cvai/setting/operation_setting"
+	"github.com/ctrlc-ctrlv-limited/cvai/setting/ratio_setting"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// 每次同步存储量时处理的向量库数量
+const vectorStoreSyncBatchSize = 100
+
+// 存储价格按 GB·天计算
+const vectorStoreByteSecondsPerGBDay = float64(1<<30) * 86400
+
+// 同步存储量与删除向量库时都会结算，避免同一节点上重复结算
+var vectorStoreUsageLock sync.Mutex
+
+// IsVectorStoreChannel 渠道是否支持 OpenAI 格式的向量库接口
+func IsVectorStoreChannel(channelType int) bool {
+	return channelType == constant.ChannelTypeOpenAI || channelType == constant.ChannelTypeAzure
+}
+
+// CreateVectorStore 在分发中间件选中的渠道上创建向量库，并记录向量库与渠道、key 的绑定
+func CreateVectorStore(c *gin.Context) (*model.VectorStore, error) {
+	if !IsVectorStoreChannel(common.GetContextKeyInt(c, constant.ContextKeyChannelType)) {
+		return nil, errors.New("the selected channel does not support vector stores")
+	}
+	modelName := operation_setting.GetVectorStoreSetting().ChannelModel
+	if _, ok := ratio_setting.GetStoragePrice(modelName); !ok && !operation_setting.SelfUseModeEnabled {
+		return nil, fmt.Errorf("storage price of %s is not set", modelName)
+	}
+	body, err := common.GetRequestBody(c)
+	if err != nil {
+		return nil, err
+	}
+	if len(body) == 0 {
+		body = []byte("{}")
+	}
+	if !gjson.ValidBytes(body) {
+		return nil, errors.New("invalid request body")
+	}
+	userId := c.GetInt("id")
+	_, userQuota, err := GetUserAvailableQuota(c, userId)
+	if err != nil {
+		return nil, err
+	}
+	if userQuota <= 0 {
+		return nil, errors.New("user quota is not enough")
+	}
+
+	upstream := openAIUpstreamFromContext(c)
+	body, err = replaceUpstreamFileIds(c.Request.Context(), upstream, userId, body)
+	if err != nil {
+		return nil, err
+	}
+	data, err := upstream.do(c.Request.Context(), http.MethodPost, "/v1/vector_stores", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	vectorStoreId := gjson.GetBytes(data, "id").String()
+	if vectorStoreId == "" {
+		return nil, fmt.Errorf("invalid vector store response: %s", string(data))
+	}
+	store := &model.VectorStore{
+		VectorStoreId: vectorStoreId,
+		UserId:        userId,
+		TokenId:       c.GetInt("token_id"),
+		Group:         common.GetContextKeyString(c, constant.ContextKeyUsingGroup),
+		ModelName:     modelName,
+		ChannelId:     upstream.ChannelId,
+		KeyIndex:      upstream.KeyIndex,
+		Status:        gjson.GetBytes(data, "status").String(),
+		Data:          string(data),
+		UsageBytes:    gjson.GetBytes(data, "usage_bytes").Int(),
+	}
+	if err = store.Insert(); err != nil {
+		return nil, err
+	}
+	logger.LogInfo(c, fmt.Sprintf("vector store %s created on channel #%d", vectorStoreId, upstream.ChannelId))
+	return store, nil
+}
+
+// ForwardVectorStoreRequest 将向量库及其文件、文件批次、检索请求转发到创建它的渠道与 key，
+// 请求中的网关文件 id 替换为上游的文件 id，响应中的上游文件 id 还原为网关的文件 id
+func ForwardVectorStoreRequest(ctx context.Context, store *model.VectorStore, method string, action string, query url.Values, body []byte) (int, []byte, error) {
+	upstream, err := getChannelUpstream(store.ChannelId, store.KeyIndex)
+	if err != nil {
+		return 0, nil, err
+	}
+	segments := strings.Split(strings.Trim(action, "/"), "/")
+	for i, segment := range segments {
+		if upstreamFileId, ok := toUpstreamFileId(upstream, store.UserId, segment); ok {
+			segments[i] = upstreamFileId
+		}
+	}
+	for _, field := range []string{"after", "before"} {
+		if upstreamFileId, ok := toUpstreamFileId(upstream, store.UserId, query.Get(field)); ok {
+			query.Set(field, upstreamFileId)
+		}
+	}
+	path := "/v1/vector_stores/" + url.PathEscape(store.VectorStoreId)
+	if action = strings.Join(segments, "/"); action != "" {
+		path += "/" + action
+	}
+	if encoded := query.Encode(); encoded != "" {
+		path += "?" + encoded
+	}
+
+	var reader io.Reader
+	contentType := ""
+	if len(body) > 0 {
+		if body, err = replaceUpstreamFileIds(ctx, upstream, store.UserId, body); err != nil {
+			return 0, nil, err
+		}
+		reader = bytes.NewReader(body)
+		contentType = "application/json"
+	}
+	resp, err := upstream.request(ctx, method, path, contentType, reader)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, err
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		respBody = restoreGatewayFileIds(upstream, respBody)
+	}
+	return resp.StatusCode, respBody, nil
+}
+
+// toUpstreamFileId 获取用户文件在上游渠道与 key 上的文件 id，文件尚未转发到上游时返回 false
+func toUpstreamFileId(upstream *openAIUpstream, userId int, fileId string) (string, bool) {
+	if !strings.HasPrefix(fileId, fileIdPrefix) || !operation_setting.GetFileSetting().Enabled {
+		return "", false
+	}
+	if _, err := model.GetUserFileByFileId(userId, fileId); err != nil {
+		return "", false
+	}
+	return model.GetFileUpstreamId(fileId, upstream.ChannelId, upstream.KeyIndex)
+}
+
+// restoreGatewayFileIds 将向量库文件对象、列表与检索结果中的上游文件 id 还原为网关的文件 id
+func restoreGatewayFileIds(upstream *openAIUpstream, body []byte) []byte {
+	if !operation_setting.GetFileSetting().Enabled {
+		return body
+	}
+	paths := []string{"id", "file_id", "first_id", "last_id"}
+	for i := range gjson.GetBytes(body, "data").Array() {
+		paths = append(paths, fmt.Sprintf("data.%d.id", i), fmt.Sprintf("data.%d.file_id", i))
+	}
+	for _, path := range paths {
+		upstreamFileId := gjson.GetBytes(body, path).String()
+		if !strings.HasPrefix(upstreamFileId, fileIdPrefix) {
+			continue
+		}
+		if fileId, ok := model.GetFileIdByUpstreamId(upstream.ChannelId, upstream.KeyIndex, upstreamFileId); ok {
+			body, _ = sjson.SetBytes(body, path, fileId)
+		}
+	}
+	return body
+}
+
+// UpdateVectorStoreData 保存上游返回的最新向量库对象
+func UpdateVectorStoreData(store *model.VectorStore, data []byte) error {
+	if gjson.GetBytes(data, "id").String() != store.VectorStoreId {
+		return nil
+	}
+	return store.UpdateData(string(data), gjson.GetBytes(data, "status").String())
+}
+
+// DeleteVectorStore 删除上游的向量库，结算存储费用后删除绑定，返回上游的响应
+func DeleteVectorStore(ctx context.Context, store *model.VectorStore) (int, []byte, error) {
+	statusCode, body, err := ForwardVectorStoreRequest(ctx, store, http.MethodDelete, "", url.Values{}, nil)
+	if err != nil {
+		return statusCode, body, err
+	}
+	if statusCode == http.StatusNotFound || (statusCode >= 200 && statusCode < 300) {
+		vectorStoreUsageLock.Lock()
+		defer vectorStoreUsageLock.Unlock()
+		if store, err = model.GetVectorStore(store.VectorStoreId); err != nil || store == nil {
+			return statusCode, body, nil
+		}
+		accumulateVectorStoreUsage(store, 0, common.GetTimestamp())
+		settleVectorStoreStorage(store, true)
+		if err = store.Delete(); err != nil {
+			logger.LogError(ctx, fmt.Sprintf("failed to delete vector store %s: %s", store.VectorStoreId, err.Error()))
+		}
+	}
+	return statusCode, body, nil
+}
+
+// accumulateVectorStoreUsage 按上次同步的存储量累计到 now 的存储量，并更新为新的存储量
+func accumulateVectorStoreUsage(store *model.VectorStore, usageBytes int64, now int64) {
+	if now > store.UsageUpdatedAt {
+		store.ByteSeconds += store.UsageBytes * (now - store.UsageUpdatedAt)
+		store.UsageUpdatedAt = now
+	}
+	store.UsageBytes = usageBytes
+}
+
+// vectorStoreGroupRatio 获取向量库计费使用的分组倍率
+func vectorStoreGroupRatio(store *model.VectorStore) float64 {
+	groupRatio := ratio_setting.GetGroupRatio(store.Group)
+	if userGroup, err := model.GetUserGroup(store.UserId, false); err == nil {
+		if ratio, ok := ratio_setting.GetGroupGroupRatio(userGroup, store.Group); ok {
+			groupRatio = ratio
+		}
+	}
+	return groupRatio
+}
+
+// settleVectorStoreStorage 按未结算的存储量扣除存储费用并记录消费日志，不足 1 点额度的存储量留到下次结算，final 为 true 时直接结算
+func settleVectorStoreStorage(store *model.VectorStore, final bool) {
+	storagePrice, _ := ratio_setting.GetStoragePrice(store.ModelName)
+	groupRatio := vectorStoreGroupRatio(store)
+	gbDays := float64(store.ByteSeconds) / vectorStoreByteSecondsPerGBDay
+	quota := int(storagePrice * gbDays * groupRatio * common.QuotaPerUnit)
+	if quota <= 0 && !final {
+		return
+	}
+	store.ByteSeconds = 0
+	store.BilledAt = common.GetTimestamp()
+	if quota <= 0 {
+		return
+	}
+
+	relayInfo := &relaycommon.RelayInfo{
+		UserId: store.UserId,
+	}
+	token, err := model.GetTokenById(store.TokenId)
+	if err == nil {
+		relayInfo.TokenId = token.Id
+		relayInfo.TokenKey = token.Key
+	} else {
+		// 令牌已删除时只扣除用户额度
+		relayInfo.IsPlayground = true
+		token = &model.Token{}
+	}
+	if err = PostConsumeQuota(relayInfo, quota, 0, false); err != nil {
+		common.SysError(fmt.Sprintf("failed to consume storage quota of vector store %s: %s", store.VectorStoreId, err.Error()))
+		return
+	}
+	store.Quota += quota
+	model.UpdateUserUsedQuotaAndRequestCount(store.UserId, quota)
+	model.UpdateChannelUsedQuota(store.ChannelId, quota)
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/vector_stores", nil)
+	c.Request.RemoteAddr = ""
+	username, _ := model.GetUsernameById(store.UserId, false)
+	c.Set("username", username)
+	other := map[string]interface{}{
+		"storage_price":   storagePrice,
+		"group_ratio":     groupRatio,
+		"storage_gb_days": gbDays,
+		"vector_store_id": store.VectorStoreId,
+		"request_path":    "/v1/vector_stores",
+	}
+	model.RecordConsumeLog(c, store.UserId, model.RecordConsumeLogParams{
+		ChannelId: store.ChannelId,
+		ModelName: store.ModelName,
+		TokenName: token.Name,
+		Quota:     quota,
+		Content:   fmt.Sprintf("向量库 %s 存储 %.4f GB·天", store.VectorStoreId, gbDays),
+		TokenId:   token.Id,
+		Group:     store.Group,
+		Other:     other,
+	})
+}
+
+// syncVectorStoreUsage 从上游同步向量库的存储量，到达结算间隔时结算存储费用，上游已删除的向量库结算后删除绑定
+func syncVectorStoreUsage(store *model.VectorStore) error {
+	ctx := context.Background()
+	now := common.GetTimestamp()
+	usageBytes := int64(0)
+	deleted := false
+	if store.Status != model.VectorStoreStatusExpired {
+		statusCode, data, err := ForwardVectorStoreRequest(ctx, store, http.MethodGet, "", url.Values{}, nil)
+		if err != nil {
+			return err
+		}
+		switch {
+		case statusCode == http.StatusNotFound:
+			deleted = true
+		case statusCode >= 200 && statusCode < 300:
+			store.Data = string(data)
+			store.Status = gjson.GetBytes(data, "status").String()
+			// 已过期的向量库不再计费
+			if store.Status != model.VectorStoreStatusExpired {
+				usageBytes = gjson.GetBytes(data, "usage_bytes").Int()
+			}
+		default:
+			return fmt.Errorf("get vector store failed: status %d, %s", statusCode, string(data))
+		}
+	}
+
+	vectorStoreUsageLock.Lock()
+	defer vectorStoreUsageLock.Unlock()
+	// 同步期间向量库可能已被删除
+	latest, err := model.GetVectorStore(store.VectorStoreId)
+	if err != nil || latest == nil {
+		return err
+	}
+	latest.Data = store.Data
+	latest.Status = store.Status
+	store = latest
+	accumulateVectorStoreUsage(store, usageBytes, now)
+	billingInterval := int64(operation_setting.GetVectorStoreSetting().BillingIntervalMinutes) * 60
+	if deleted || store.Status == model.VectorStoreStatusExpired || now-store.BilledAt >= billingInterval {
+		settleVectorStoreStorage(store, deleted || store.Status == model.VectorStoreStatusExpired)
+	}
+	if deleted {
+		logger.LogInfo(ctx, fmt.Sprintf("vector store %s not found on channel #%d, binding deleted", store.VectorStoreId, store.ChannelId))
+		return store.Delete()
+	}
+	return store.Update()
+}
+
+// StartVectorStoreBillingTask 定期同步向量库的存储量并结算存储费用，仅在主节点运行
+func StartVectorStoreBillingTask() {
+	if !common.IsMasterNode {
+		return
+	}
+	for {
+		interval := operation_setting.GetVectorStoreSetting().UsageSyncIntervalMinutes
+		if interval <= 0 {
+			interval = 60
+		}
+		time.Sleep(time.Duration(interval) * time.Minute)
+		if !operation_setting.GetVectorStoreSetting().Enabled {
+			continue
+		}
+		syncedBefore := common.GetTimestamp() - int64(interval)*60/2
+		lastId := 0
+		for {
+			stores, err := model.GetVectorStoresToSync(syncedBefore, lastId, vectorStoreSyncBatchSize)
+			if err != nil {
+				common.SysError("failed to get vector stores to sync: " + err.Error())
+				break
+			}
+			for _, store := range stores {
+				lastId = store.Id
+				if err = syncVectorStoreUsage(store); err != nil {
+					common.SysError(fmt.Sprintf("failed to sync usage of vector store %s: %s", store.VectorStoreId, err.Error()))
+				}
+			}
+			if len(stores) < vectorStoreSyncBatchSize {
+				break
+			}
+		}
+	}
+}
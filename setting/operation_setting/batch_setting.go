@@ -0,0 +1,45 @@
+package operation_setting
+
+import "github.com/ctrlc-ctrlv-limited/cvai/setting/config"
+
+// BatchSetting Batch API 配置，输入与输出文件依赖 Files API
+type BatchSetting struct {
+	Enabled bool `json:"enabled"`
+	// 批处理请求的计费折扣，作用于分组倍率，例如 0.5 表示按半价计费
+	DiscountRatio float64 `json:"discount_ratio"`
+	// 网关执行批处理时，每个节点同时执行的请求数
+	MaxConcurrency int `json:"max_concurrency"`
+	// 单个批处理最多包含的请求数
+	MaxRequests int `json:"max_requests"`
+	// 选中的渠道支持原生 Batch API 时直接转发到上游，目前支持 OpenAI 渠道
+	NativePassthrough bool `json:"native_passthrough"`
+	// 检查待执行批处理与同步上游批处理状态的间隔（秒）
+	PollIntervalSeconds int `json:"poll_interval_seconds"`
+}
+
+// 默认配置
+var batchSetting = BatchSetting{
+	Enabled:             false,
+	DiscountRatio:       0.5,
+	MaxConcurrency:      4,
+	MaxRequests:         50000,
+	NativePassthrough:   false,
+	PollIntervalSeconds: 30,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("batch_setting", &batchSetting)
+}
+
+func GetBatchSetting() *BatchSetting {
+	return &batchSetting
+}
+
+// GetBatchDiscountRatio 获取批处理计费折扣，配置无效时不打折
+func GetBatchDiscountRatio() float64 {
+	if batchSetting.DiscountRatio < 0 {
+		return 1
+	}
+	return batchSetting.DiscountRatio
+}
@@ -0,0 +1,43 @@
+package operation_setting
+
+import "github.com/ctrlc-ctrlv-limited/cvai/setting/config"
+
+// ChannelScheduleRule 渠道定时规则，当前时间所在的分钟匹配 Cron 时规则生效
+type ChannelScheduleRule struct {
+	// 五段式 cron 表达式（分 时 日 月 周），如 "* 0-6 * * *" 表示每天 0 点到 6 点 59 分
+	Cron string `json:"cron"`
+	// IANA 时区，如 Asia/Shanghai，为空时使用服务器时区
+	Timezone string `json:"timezone,omitempty"`
+	// 规则生效期间暂停使用该渠道
+	Disabled bool `json:"disabled,omitempty"`
+	// 规则生效期间使用的优先级与权重，为空时沿用渠道本身的设置
+	Priority *int64 `json:"priority,omitempty"`
+	Weight   *uint  `json:"weight,omitempty"`
+}
+
+// ChannelScheduleSetting 渠道定时窗口配置，规则按顺序匹配，第一条生效的规则决定渠道状态；
+// 渠道自身的规则优先于标签的规则，都不生效时使用渠道本身的设置。
+// 规则在刷新渠道缓存时生效，需要启用内存缓存
+type ChannelScheduleSetting struct {
+	Enabled bool `json:"enabled"`
+	// 渠道 id -> 规则
+	Channels map[string][]ChannelScheduleRule `json:"channels"`
+	// 渠道标签 -> 规则
+	Tags map[string][]ChannelScheduleRule `json:"tags"`
+}
+
+// 默认配置
+var channelScheduleSetting = ChannelScheduleSetting{
+	Enabled:  false,
+	Channels: map[string][]ChannelScheduleRule{},
+	Tags:     map[string][]ChannelScheduleRule{},
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("channel_schedule_setting", &channelScheduleSetting)
+}
+
+func GetChannelScheduleSetting() *ChannelScheduleSetting {
+	return &channelScheduleSetting
+}
@@ -0,0 +1,52 @@
+package operation_setting
+
+import (
+	"slices"
+
+	"github.com/ctrlc-ctrlv-limited/cvai/setting/config"
+)
+
+// ChannelSelectSetting 渠道选择策略配置
+type ChannelSelectSetting struct {
+	// 启用自适应选择（基于延迟与错误率）的分组，"*" 表示全部分组
+	AdaptiveGroups []string `json:"adaptive_groups"`
+	// EWMA 平滑系数，越大越偏向最近的请求
+	EwmaAlpha float64 `json:"ewma_alpha"`
+	// 错误率惩罚系数，有效耗时 = 耗时 * (1 + ErrorPenalty * 错误率)
+	ErrorPenalty float64 `json:"error_penalty"`
+	// 统计过期时间（秒），超过该时间未更新的统计视为未知，以便重新探测
+	ScoreTTLSeconds int `json:"score_ttl_seconds"`
+	// 多节点下从 Redis 同步统计的间隔（秒）
+	SyncIntervalSeconds int `json:"sync_interval_seconds"`
+}
+
+// 默认配置
+var channelSelectSetting = ChannelSelectSetting{
+	AdaptiveGroups:      []string{},
+	EwmaAlpha:           0.2,
+	ErrorPenalty:        4,
+	ScoreTTLSeconds:     600,
+	SyncIntervalSeconds: 10,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("channel_select_setting", &channelSelectSetting)
+}
+
+func GetChannelSelectSetting() *ChannelSelectSetting {
+	return &channelSelectSetting
+}
+
+// IsAdaptiveSelectEnabled 分组是否启用了自适应渠道选择
+func IsAdaptiveSelectEnabled(group string) bool {
+	if len(channelSelectSetting.AdaptiveGroups) == 0 {
+		return false
+	}
+	return slices.Contains(channelSelectSetting.AdaptiveGroups, "*") || slices.Contains(channelSelectSetting.AdaptiveGroups, group)
+}
+
+// IsAdaptiveSelectUsed 是否有任意分组启用了自适应渠道选择
+func IsAdaptiveSelectUsed() bool {
+	return len(channelSelectSetting.AdaptiveGroups) > 0
+}
@@ -0,0 +1,38 @@
+package operation_setting
+
+import "github.com/ctrlc-ctrlv-limited/cvai/setting/config"
+
+// CircuitBreakerSetting 渠道熔断配置，按 渠道 + 模型 + key 序号 熔断
+type CircuitBreakerSetting struct {
+	Enabled bool `json:"enabled"`
+	// 时间窗口内失败次数达到该值后熔断
+	FailureThreshold int `json:"failure_threshold"`
+	// 统计失败次数的时间窗口（秒）
+	WindowSeconds int `json:"window_seconds"`
+	// 熔断持续时间（秒），之后进入半开状态放行探测请求
+	OpenSeconds int `json:"open_seconds"`
+	// 半开状态下允许同时进行的探测请求数，全部成功后恢复
+	HalfOpenProbes int `json:"half_open_probes"`
+}
+
+// 默认配置
+var circuitBreakerSetting = CircuitBreakerSetting{
+	Enabled:          false,
+	FailureThreshold: 5,
+	WindowSeconds:    60,
+	OpenSeconds:      30,
+	HalfOpenProbes:   1,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("circuit_breaker_setting", &circuitBreakerSetting)
+}
+
+func GetCircuitBreakerSetting() *CircuitBreakerSetting {
+	return &circuitBreakerSetting
+}
+
+func IsCircuitBreakerEnabled() bool {
+	return circuitBreakerSetting.Enabled
+}
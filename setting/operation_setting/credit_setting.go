@@ -0,0 +1,37 @@
+package operation_setting
+
+import "github.com/ctrlc-ctrlv-limited/cvai/setting/config"
+
+// CreditSetting 信用额度（后付费）配置，有信用额度的用户余额可以透支到负的信用额度，超出后暂停使用直到结清欠款
+type CreditSetting struct {
+	// 分组的默认信用额度，用户单独设置的信用额度优先
+	GroupCreditLimits map[string]int `json:"group_credit_limits"`
+	// 已用信用额度达到这些百分比时通知用户，用尽时总会通知
+	AlertPercentages []int `json:"alert_percentages"`
+}
+
+// 默认配置
+var creditSetting = CreditSetting{
+	GroupCreditLimits: map[string]int{},
+	AlertPercentages:  []int{50, 80, 90},
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("credit_setting", &creditSetting)
+}
+
+func GetCreditSetting() *CreditSetting {
+	return &creditSetting
+}
+
+// UserCreditLimitInherit 用户未单独设置信用额度，使用分组的默认信用额度
+const UserCreditLimitInherit = -1
+
+// GetCreditLimit 返回用户的信用额度，用户单独设置的信用额度（包括 0，即预付费）优先于分组的默认信用额度
+func GetCreditLimit(userCreditLimit int, group string) int {
+	if userCreditLimit >= 0 {
+		return userCreditLimit
+	}
+	return max(creditSetting.GroupCreditLimits[group], 0)
+}
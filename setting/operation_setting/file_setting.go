@@ -0,0 +1,37 @@
+package operation_setting
+
+import "github.com/ctrlc-ctrlv-limited/cvai/setting/config"
+
+// FileSetting Files API 配置，存储位置通过环境变量 FILE_STORAGE_TYPE、FILE_STORAGE_PATH 与 S3_* 配置
+type FileSetting struct {
+	Enabled bool `json:"enabled"`
+	// 单个文件的最大大小（MB）
+	MaxFileSizeMB int `json:"max_file_size_mb"`
+	// 每个用户可保存的文件总大小（MB），0 表示不限制
+	MaxUserStorageMB int `json:"max_user_storage_mb"`
+	// 文件默认保留天数，上传时未指定 expires_after 时使用，0 表示不过期
+	DefaultExpireDays int `json:"default_expire_days"`
+	// 上传时按文件大小扣除的额度，每 MB 的额度，不足 1MB 按 1MB 计算
+	QuotaPerMB int `json:"quota_per_mb"`
+	// 清理过期文件的间隔（分钟）
+	CleanupIntervalMinutes int `json:"cleanup_interval_minutes"`
+}
+
+// 默认配置
+var fileSetting = FileSetting{
+	Enabled:                false,
+	MaxFileSizeMB:          512,
+	MaxUserStorageMB:       0,
+	DefaultExpireDays:      30,
+	QuotaPerMB:             0,
+	CleanupIntervalMinutes: 60,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("file_setting", &fileSetting)
+}
+
+func GetFileSetting() *FileSetting {
+	return &fileSetting
+}
@@ -0,0 +1,43 @@
+package operation_setting
+
+import "github.com/ctrlc-ctrlv-limited/cvai/setting/config"
+
+// FineTuningSetting 微调任务配置，训练文件依赖 Files API
+type FineTuningSetting struct {
+	Enabled bool `json:"enabled"`
+	// 各基础模型每百万训练 token 的价格（美元），任务成功后按实际训练的 token 数计费
+	TrainingPrices map[string]float64 `json:"training_prices"`
+	// 未配置训练价格的基础模型使用的价格（美元/百万 token）
+	DefaultTrainingPrice float64 `json:"default_training_price"`
+}
+
+// 默认配置
+var fineTuningSetting = FineTuningSetting{
+	Enabled: false,
+	TrainingPrices: map[string]float64{
+		"gpt-4.1":                25,
+		"gpt-4.1-mini":           5,
+		"gpt-4.1-nano":           1.5,
+		"gpt-4o-2024-08-06":      25,
+		"gpt-4o-mini-2024-07-18": 3,
+		"gpt-3.5-turbo-0125":     8,
+	},
+	DefaultTrainingPrice: 25,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("fine_tuning_setting", &fineTuningSetting)
+}
+
+func GetFineTuningSetting() *FineTuningSetting {
+	return &fineTuningSetting
+}
+
+// GetFineTuningTrainingPrice 获取基础模型每百万训练 token 的价格（美元）
+func GetFineTuningTrainingPrice(baseModel string) float64 {
+	if price, ok := fineTuningSetting.TrainingPrices[baseModel]; ok {
+		return price
+	}
+	return fineTuningSetting.DefaultTrainingPrice
+}
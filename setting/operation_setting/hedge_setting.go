@@ -0,0 +1,59 @@
+package operation_setting
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/ctrlc-ctrlv-limited/cvai/setting/config"
+)
+
+// HedgeSetting 对冲请求配置：首个渠道在延迟内没有返回首字时，向第二个渠道发起相同请求，取先返回的一方
+type HedgeSetting struct {
+	Enabled bool `json:"enabled"`
+	// 发起对冲请求前等待首字的时间（毫秒）
+	DelayMs int `json:"delay_ms"`
+	// 启用对冲的模型，支持以 * 结尾的前缀匹配，单独的 * 表示全部模型
+	Models []string `json:"models"`
+	// 是否仅对流式请求启用
+	StreamOnly bool `json:"stream_only"`
+}
+
+// 默认配置
+var hedgeSetting = HedgeSetting{
+	Enabled:    false,
+	DelayMs:    2000,
+	Models:     []string{},
+	StreamOnly: true,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("hedge_setting", &hedgeSetting)
+}
+
+func GetHedgeSetting() *HedgeSetting {
+	return &hedgeSetting
+}
+
+// ShouldHedgeModel 判断模型是否启用了对冲请求
+func ShouldHedgeModel(modelName string, isStream bool) bool {
+	if !hedgeSetting.Enabled || hedgeSetting.DelayMs <= 0 {
+		return false
+	}
+	if hedgeSetting.StreamOnly && !isStream {
+		return false
+	}
+	if slices.Contains(hedgeSetting.Models, "*") {
+		return true
+	}
+	for _, pattern := range hedgeSetting.Models {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if strings.HasPrefix(modelName, prefix) {
+				return true
+			}
+		} else if pattern == modelName {
+			return true
+		}
+	}
+	return false
+}
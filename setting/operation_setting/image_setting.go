@@ -0,0 +1,77 @@
+package operation_setting
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/ctrlc-ctrlv-limited/cvai/setting/config"
+)
+
+// ImageSetting 图片按张计费配置
+type ImageSetting struct {
+	// 按分辨率档位设置的单张价格倍率，档位为 1K（约 1024x1024）、2K（约 2048x2048）、4K，未设置的档位倍率为 1，
+	// 默认均为 1，由管理员按需开启，dall-e 沿用官方按尺寸与品质的价格
+	ResolutionRatios map[string]float64 `json:"resolution_ratios"`
+}
+
+// 默认配置
+var imageSetting = ImageSetting{
+	ResolutionRatios: map[string]float64{
+		"1K": 1,
+		"2K": 1,
+		"4K": 1,
+	},
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("image_setting", &imageSetting)
+}
+
+func GetImageSetting() *ImageSetting {
+	return &imageSetting
+}
+
+// GetImageResolutionTier 根据尺寸（如 1024x1024、2K）获取分辨率档位，无法识别时返回空字符串
+func GetImageResolutionTier(size string) string {
+	size = strings.ToUpper(strings.TrimSpace(size))
+	switch size {
+	case "1K", "2K", "4K":
+		return size
+	}
+	parts := strings.FieldsFunc(size, func(r rune) bool {
+		return r == 'X' || r == '*'
+	})
+	if len(parts) != 2 {
+		return ""
+	}
+	width, err1 := strconv.Atoi(strings.TrimSpace(parts[0]))
+	height, err2 := strconv.Atoi(strings.TrimSpace(parts[1]))
+	if err1 != nil || err2 != nil || width <= 0 || height <= 0 {
+		return ""
+	}
+	switch pixels := width * height; {
+	case pixels <= 1536*1024:
+		return "1K"
+	case pixels <= 2048*2048:
+		return "2K"
+	default:
+		return "4K"
+	}
+}
+
+// GetImageResolutionRatio 获取单张图片的分辨率倍率，品质为 1K、2K、4K 时（Gemini 的 imageSize）优先按品质判断，否则按尺寸判断，默认为 1K
+func GetImageResolutionRatio(size string, quality string) float64 {
+	tier := GetImageResolutionTier(quality)
+	if tier == "" {
+		tier = GetImageResolutionTier(size)
+	}
+	if tier == "" {
+		tier = "1K"
+	}
+	ratio, ok := imageSetting.ResolutionRatios[tier]
+	if !ok || ratio <= 0 {
+		return 1
+	}
+	return ratio
+}
@@ -0,0 +1,33 @@
+package operation_setting
+
+import "github.com/ctrlc-ctrlv-limited/cvai/setting/config"
+
+// ModelFallbackSetting 模型降级链配置：某个模型的所有渠道都失败或被限流时，依次改用链中的下一个模型
+type ModelFallbackSetting struct {
+	Enabled bool `json:"enabled"`
+	// 模型名称 -> 按顺序尝试的备用模型
+	Chains map[string][]string `json:"chains"`
+}
+
+// 默认配置
+var modelFallbackSetting = ModelFallbackSetting{
+	Enabled: false,
+	Chains:  map[string][]string{},
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("model_fallback_setting", &modelFallbackSetting)
+}
+
+func GetModelFallbackSetting() *ModelFallbackSetting {
+	return &modelFallbackSetting
+}
+
+// GetModelFallbacks 获取模型的备用模型列表，未启用或未配置时返回空
+func GetModelFallbacks(modelName string) []string {
+	if !modelFallbackSetting.Enabled {
+		return nil
+	}
+	return modelFallbackSetting.Chains[modelName]
+}
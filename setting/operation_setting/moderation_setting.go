@@ -0,0 +1,59 @@
+package operation_setting
+
+import (
+	"slices"
+
+	"github.com/ctrlc-ctrlv-limited/cvai/setting/config"
+)
+
+// ModerationSetting 内容审核预检配置，开启后聊天请求转发前先调用审核模型检查输入，预检调用不向用户计费
+type ModerationSetting struct {
+	PreflightEnabled bool `json:"preflight_enabled"`
+	// 预检使用的审核模型，按该模型在请求的分组中选择渠道（OpenAI moderation、Llama Guard 等安全模型或 Gemini）
+	PreflightModel string `json:"preflight_model"`
+	// 需要预检的分组，为空时检查所有分组
+	PreflightGroups []string `json:"preflight_groups"`
+	// 命中这些分类时拒绝请求，为空时任一分类命中即拒绝
+	BlockCategories []string `json:"block_categories"`
+	// 审核渠道不可用或审核请求失败时放行请求
+	FailOpen bool `json:"fail_open"`
+}
+
+// 默认配置
+var moderationSetting = ModerationSetting{
+	PreflightEnabled: false,
+	PreflightModel:   "omni-moderation-latest",
+	PreflightGroups:  []string{},
+	BlockCategories:  []string{},
+	FailOpen:         true,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("moderation_setting", &moderationSetting)
+}
+
+func GetModerationSetting() *ModerationSetting {
+	return &moderationSetting
+}
+
+// ShouldModerationPreflight 判断分组的请求是否需要内容审核预检
+func ShouldModerationPreflight(group string) bool {
+	if !moderationSetting.PreflightEnabled || moderationSetting.PreflightModel == "" {
+		return false
+	}
+	return len(moderationSetting.PreflightGroups) == 0 || slices.Contains(moderationSetting.PreflightGroups, group)
+}
+
+// ShouldBlockModeration 判断被标记的分类是否需要拒绝请求
+func ShouldBlockModeration(flaggedCategories []string) bool {
+	if len(moderationSetting.BlockCategories) == 0 {
+		return true
+	}
+	for _, category := range flaggedCategories {
+		if slices.Contains(moderationSetting.BlockCategories, category) {
+			return true
+		}
+	}
+	return false
+}
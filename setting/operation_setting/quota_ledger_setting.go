@@ -0,0 +1,28 @@
+package operation_setting
+
+import "github.com/ctrlc-ctrlv-limited/cvai/setting/config"
+
+// QuotaLedgerSetting 额度流水配置。启用后每次额度变动都写入一笔复式流水，
+// 对账任务比较用户与令牌余额和流水余额，记录持续存在的差异
+type QuotaLedgerSetting struct {
+	Enabled          bool `json:"enabled"`
+	ReconcileEnabled bool `json:"reconcile_enabled"`
+	// 对账间隔（分钟）
+	ReconcileIntervalMinutes int `json:"reconcile_interval_minutes"`
+}
+
+// 默认配置
+var quotaLedgerSetting = QuotaLedgerSetting{
+	Enabled:                  false,
+	ReconcileEnabled:         false,
+	ReconcileIntervalMinutes: 60,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("quota_ledger_setting", &quotaLedgerSetting)
+}
+
+func GetQuotaLedgerSetting() *QuotaLedgerSetting {
+	return &quotaLedgerSetting
+}
@@ -0,0 +1,41 @@
+package operation_setting
+
+import "github.com/ctrlc-ctrlv-limited/cvai/setting/config"
+
+// RequestQueueSetting 请求排队配置，分组下模型的渠道全部不可用时，请求排队等待渠道恢复，而不是直接返回 503
+type RequestQueueSetting struct {
+	Enabled bool `json:"enabled"`
+	// 单个请求最长排队时间（秒），超时后返回 503
+	MaxWaitSeconds int `json:"max_wait_seconds"`
+	// 每个分组下每个模型的最大排队请求数，超出时直接返回 503
+	MaxQueueSize int `json:"max_queue_size"`
+	// 每个令牌在同一队列中的最大排队请求数，避免单个令牌占满队列，0 表示不限制
+	MaxPerToken int `json:"max_per_token"`
+	// 检查渠道是否恢复的间隔（毫秒）
+	CheckIntervalMs int `json:"check_interval_ms"`
+	// 每次检查最多放行的请求数，避免渠道恢复时瞬间涌入
+	MaxReleasePerCheck int `json:"max_release_per_check"`
+}
+
+// 默认配置
+var requestQueueSetting = RequestQueueSetting{
+	Enabled:            false,
+	MaxWaitSeconds:     30,
+	MaxQueueSize:       100,
+	MaxPerToken:        10,
+	CheckIntervalMs:    200,
+	MaxReleasePerCheck: 20,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("request_queue_setting", &requestQueueSetting)
+}
+
+func GetRequestQueueSetting() *RequestQueueSetting {
+	return &requestQueueSetting
+}
+
+func IsRequestQueueEnabled() bool {
+	return requestQueueSetting.Enabled && requestQueueSetting.MaxWaitSeconds > 0
+}
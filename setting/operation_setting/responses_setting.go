@@ -0,0 +1,25 @@
+package operation_setting
+
+import "github.com/ctrlc-ctrlv-limited/cvai/setting/config"
+
+// ResponsesSetting Responses API 配置，记录 response 与渠道、key 的绑定，使后续请求发送到创建 response 的渠道
+type ResponsesSetting struct {
+	Enabled bool `json:"enabled"`
+	// 绑定关系保留的天数，与上游保存 response 的时间一致
+	RetentionDays int `json:"retention_days"`
+}
+
+// 默认配置
+var responsesSetting = ResponsesSetting{
+	Enabled:       true,
+	RetentionDays: 30,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("responses_setting", &responsesSetting)
+}
+
+func GetResponsesSetting() *ResponsesSetting {
+	return &responsesSetting
+}
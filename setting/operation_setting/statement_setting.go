@@ -0,0 +1,25 @@
+package operation_setting
+
+import "github.com/ctrlc-ctrlv-limited/cvai/setting/config"
+
+// StatementSetting 月度账单配置
+type StatementSetting struct {
+	// 每月初向上月有账单的用户发送账单已生成通知
+	NotifyEnabled bool `json:"notify_enabled"`
+	// 最近一次发送通知的账单月份，如 2006-01，避免重启后重复发送
+	LastNotifiedMonth string `json:"last_notified_month"`
+}
+
+// 默认配置
+var statementSetting = StatementSetting{
+	NotifyEnabled: false,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("statement_setting", &statementSetting)
+}
+
+func GetStatementSetting() *StatementSetting {
+	return &statementSetting
+}
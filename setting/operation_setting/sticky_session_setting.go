@@ -0,0 +1,41 @@
+package operation_setting
+
+import "github.com/ctrlc-ctrlv-limited/cvai/setting/config"
+
+// StickySessionSetting 会话亲和路由配置，将同一会话固定到同一渠道和 key，以提高上游提示词缓存命中率
+type StickySessionSetting struct {
+	Enabled bool `json:"enabled"`
+	// 会话与渠道绑定的有效期（秒），每次成功请求后刷新
+	TTLSeconds int `json:"ttl_seconds"`
+	// 从该请求头读取会话标识，为空时不使用请求头
+	HeaderName string `json:"header_name"`
+	// 使用请求体中的 user 或 metadata.user_id 作为会话标识
+	UseUser bool `json:"use_user"`
+	// 以上都没有时，使用系统提示词和前若干条消息的哈希作为会话标识
+	UsePrefixHash bool `json:"use_prefix_hash"`
+	// 计算哈希时使用的消息条数
+	PrefixMessages int `json:"prefix_messages"`
+}
+
+// 默认配置
+var stickySessionSetting = StickySessionSetting{
+	Enabled:        false,
+	TTLSeconds:     3600,
+	HeaderName:     "X-Session-Id",
+	UseUser:        true,
+	UsePrefixHash:  false,
+	PrefixMessages: 2,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("sticky_session_setting", &stickySessionSetting)
+}
+
+func GetStickySessionSetting() *StickySessionSetting {
+	return &stickySessionSetting
+}
+
+func IsStickySessionEnabled() bool {
+	return stickySessionSetting.Enabled
+}
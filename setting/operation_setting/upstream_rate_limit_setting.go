@@ -0,0 +1,29 @@
+package operation_setting
+
+import "github.com/ctrlc-ctrlv-limited/cvai/setting/config"
+
+// UpstreamRateLimitSetting 上游限流响应头处理配置
+type UpstreamRateLimitSetting struct {
+	// 根据 Retry-After 与 x-ratelimit-* 等响应头暂停选择被限流的渠道 key
+	Enabled bool `json:"enabled"`
+	// 冷却时间上限（秒），避免异常的响应头导致 key 长时间不可用
+	MaxCooldownSeconds int `json:"max_cooldown_seconds"`
+	// 上游返回 429 但没有给出重置时间时的冷却时间（秒），0 表示不冷却
+	DefaultCooldownSeconds int `json:"default_cooldown_seconds"`
+}
+
+// 默认配置
+var upstreamRateLimitSetting = UpstreamRateLimitSetting{
+	Enabled:                false,
+	MaxCooldownSeconds:     600,
+	DefaultCooldownSeconds: 0,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("upstream_rate_limit_setting", &upstreamRateLimitSetting)
+}
+
+func GetUpstreamRateLimitSetting() *UpstreamRateLimitSetting {
+	return &upstreamRateLimitSetting
+}
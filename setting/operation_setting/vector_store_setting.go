@@ -0,0 +1,31 @@
+package operation_setting
+
+import "github.com/ctrlc-ctrlv-limited/cvai/setting/config"
+
+// VectorStoreSetting 向量库（Vector Stores API）配置，向量库保存在创建它的渠道与 key 上，按存储量与天数计费
+type VectorStoreSetting struct {
+	Enabled bool `json:"enabled"`
+	// 创建向量库时用于选择渠道的模型名称，需要添加到支持向量库的渠道，存储价格也按该名称配置
+	ChannelModel string `json:"channel_model"`
+	// 从上游同步存储量的间隔（分钟）
+	UsageSyncIntervalMinutes int `json:"usage_sync_interval_minutes"`
+	// 结算存储费用的间隔（分钟）
+	BillingIntervalMinutes int `json:"billing_interval_minutes"`
+}
+
+// 默认配置
+var vectorStoreSetting = VectorStoreSetting{
+	Enabled:                  false,
+	ChannelModel:             "vector-store",
+	UsageSyncIntervalMinutes: 60,
+	BillingIntervalMinutes:   1440,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("vector_store_setting", &vectorStoreSetting)
+}
+
+func GetVectorStoreSetting() *VectorStoreSetting {
+	return &vectorStoreSetting
+}
@@ -374,6 +374,16 @@ func InitRatioSettings() {
 	audioCompletionRatioMapMutex.Lock()
 	audioCompletionRatioMap = defaultAudioCompletionRatio
 	audioCompletionRatioMapMutex.Unlock()
+
+	// initialize storagePriceMap
+	storagePriceMapMutex.Lock()
+	storagePriceMap = defaultStoragePrice
+	storagePriceMapMutex.Unlock()
+
+	// initialize modelPricingRulesMap
+	modelPricingRulesMapMutex.Lock()
+	modelPricingRulesMap = defaultModelPricingRules
+	modelPricingRulesMapMutex.Unlock()
 }
 
 func GetModelPriceMap() map[string]float64 {
@@ -413,6 +423,11 @@ func GetModelPrice(name string, printErr bool) (float64, bool) {
 
 	price, ok := modelPriceMap[name]
 	if !ok {
+		if baseModel, isFineTuned := getFineTunedBaseModel(name); isFineTuned {
+			if price, ok = modelPriceMap[FormatMatchingModelName(baseModel)]; ok {
+				return price, true
+			}
+		}
 		if printErr {
 			common.SysError("model price not found: " + name)
 		}
@@ -432,6 +447,18 @@ func UpdateModelRatioByJSONString(jsonStr string) error {
 	return err
 }
 
+// 微调模型 ft:<基础模型>:<组织>::<id> 未单独配置价格时沿用基础模型的价格
+func getFineTunedBaseModel(name string) (string, bool) {
+	if !strings.HasPrefix(name, "ft:") {
+		return "", false
+	}
+	parts := strings.Split(name, ":")
+	if parts[1] == "" {
+		return "", false
+	}
+	return parts[1], true
+}
+
 // 处理带有思考预算的模型名称，方便统一定价
 func handleThinkingBudgetModel(name, prefix, wildcard string) string {
 	if strings.HasPrefix(name, prefix) && strings.Contains(name, "-thinking-") {
@@ -448,6 +475,11 @@ func GetModelRatio(name string) (float64, bool, string) {
 
 	ratio, ok := modelRatioMap[name]
 	if !ok {
+		if baseModel, isFineTuned := getFineTunedBaseModel(name); isFineTuned {
+			if ratio, ok = modelRatioMap[FormatMatchingModelName(baseModel)]; ok {
+				return ratio, true, name
+			}
+		}
 		return 37.5, operation_setting.SelfUseModeEnabled, name
 	}
 	return ratio, true, name
@@ -514,6 +546,11 @@ func GetCompletionRatio(name string) float64 {
 	defer CompletionRatioMutex.RUnlock()
 
 	name = FormatMatchingModelName(name)
+	if baseModel, isFineTuned := getFineTunedBaseModel(name); isFineTuned {
+		if _, ok := CompletionRatio[name]; !ok {
+			name = FormatMatchingModelName(baseModel)
+		}
+	}
 
 	if strings.Contains(name, "/") {
 		if ratio, ok := CompletionRatio[name]; ok {
@@ -0,0 +1,143 @@
+package ratio_setting
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/ctrlc-ctrlv-limited/cvai/common"
+	"github.com/ctrlc-ctrlv-limited/cvai/types"
+
+	"github.com/tidwall/gjson"
+)
+
+// PricingRule 模型的分档计费规则，按顺序匹配第一条满足全部条件的规则，匹配后覆盖模型的价格与倍率。
+// prompt tokens 区间在预扣费时按估算值匹配，结算时按上游返回的实际用量重新匹配
+type PricingRule struct {
+	// 规则名称，显示在日志详情中，如 ">200k"
+	Name string `json:"name"`
+	// prompt tokens 大于该值时匹配，0 表示不限制
+	PromptTokensAbove int `json:"prompt_tokens_above,omitempty"`
+	// prompt tokens 小于等于该值时匹配，0 表示不限制
+	PromptTokensUpTo int `json:"prompt_tokens_up_to,omitempty"`
+	// 请求的 service_tier，如 flex、priority
+	ServiceTier string `json:"service_tier,omitempty"`
+	// 其他请求参数，键为请求体中的 JSON 路径，如 {"size": "4096x4096", "quality": "hd"}
+	Match map[string]string `json:"match,omitempty"`
+
+	types.PriceOverride
+}
+
+// 默认不配置计费规则，由管理员按需添加
+var defaultModelPricingRules = map[string][]PricingRule{}
+
+var (
+	modelPricingRulesMap      map[string][]PricingRule = nil
+	modelPricingRulesMapMutex                          = sync.RWMutex{}
+)
+
+func ModelPricingRules2JSONString() string {
+	modelPricingRulesMapMutex.RLock()
+	defer modelPricingRulesMapMutex.RUnlock()
+	jsonBytes, err := common.Marshal(modelPricingRulesMap)
+	if err != nil {
+		common.SysError("error marshalling model pricing rules: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateModelPricingRulesByJSONString(jsonStr string) error {
+	tmp := make(map[string][]PricingRule)
+	if err := common.Unmarshal([]byte(jsonStr), &tmp); err != nil {
+		return err
+	}
+	for model, rules := range tmp {
+		for i, rule := range rules {
+			if rule.Name == "" {
+				return fmt.Errorf("模型 %s 的第 %d 条计费规则缺少名称", model, i+1)
+			}
+			if rule.PromptTokensUpTo != 0 && rule.PromptTokensUpTo <= rule.PromptTokensAbove {
+				return fmt.Errorf("模型 %s 的计费规则 %s 的 prompt tokens 区间无效", model, rule.Name)
+			}
+		}
+	}
+	modelPricingRulesMapMutex.Lock()
+	modelPricingRulesMap = tmp
+	modelPricingRulesMapMutex.Unlock()
+	InvalidateExposedDataCache()
+	return nil
+}
+
+// HasModelPricingRules 模型是否配置了分档计费规则
+func HasModelPricingRules(name string) bool {
+	return len(getModelPricingRules(name)) > 0
+}
+
+func getModelPricingRules(name string) []PricingRule {
+	modelPricingRulesMapMutex.RLock()
+	defer modelPricingRulesMapMutex.RUnlock()
+	if rules, ok := modelPricingRulesMap[name]; ok {
+		return rules
+	}
+	return modelPricingRulesMap[FormatMatchingModelName(name)]
+}
+
+func (rule *PricingRule) matches(promptTokens int, requestAttributes map[string]string) bool {
+	if rule.PromptTokensAbove != 0 && promptTokens <= rule.PromptTokensAbove {
+		return false
+	}
+	if rule.PromptTokensUpTo != 0 && promptTokens > rule.PromptTokensUpTo {
+		return false
+	}
+	if rule.ServiceTier != "" && requestAttributes["service_tier"] != rule.ServiceTier {
+		return false
+	}
+	for path, value := range rule.Match {
+		if requestAttributes[path] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// PricingRuleRequestAttributes 从请求体中提取模型的计费规则用到的请求参数，键为 JSON 路径，
+// 预扣费时提取一次，结算时按实际用量重新匹配规则时复用
+func PricingRuleRequestAttributes(name string, requestBody []byte) map[string]string {
+	rules := getModelPricingRules(name)
+	attributes := make(map[string]string)
+	for _, rule := range rules {
+		if rule.ServiceTier != "" {
+			attributes["service_tier"] = gjson.GetBytes(requestBody, "service_tier").String()
+		}
+		for path := range rule.Match {
+			if _, ok := attributes[path]; !ok {
+				attributes[path] = gjson.GetBytes(requestBody, path).String()
+			}
+		}
+	}
+	return attributes
+}
+
+// MatchModelPricingRule 返回模型第一条匹配的计费规则，没有匹配时返回 nil
+func MatchModelPricingRule(name string, promptTokens int, requestAttributes map[string]string) *PricingRule {
+	rules := getModelPricingRules(name)
+	for i := range rules {
+		if rules[i].matches(promptTokens, requestAttributes) {
+			return &rules[i]
+		}
+	}
+	return nil
+}
+
+// ApplyModelPricingRule 按匹配的计费规则调整按量计费的 PriceData，请求参数使用 PriceData 中已提取的值，
+// 按次计费或没有配置规则时不做修改
+func ApplyModelPricingRule(priceData *types.PriceData, name string, promptTokens int) {
+	if priceData.UsePrice || !HasModelPricingRules(name) {
+		return
+	}
+	rule := MatchModelPricingRule(name, promptTokens, priceData.PricingRuleRequest)
+	if rule == nil {
+		priceData.ApplyPricingRule("", nil)
+		return
+	}
+	priceData.ApplyPricingRule(rule.Name, &rule.PriceOverride)
+}
@@ -0,0 +1,92 @@
+package ratio_setting
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/ctrlc-ctrlv-limited/cvai/types"
+)
+
+func setupPricingRules(t *testing.T, rules string) {
+	t.Helper()
+	modelPricingRulesMapMutex.RLock()
+	original := modelPricingRulesMap
+	modelPricingRulesMapMutex.RUnlock()
+	t.Cleanup(func() {
+		modelPricingRulesMapMutex.Lock()
+		modelPricingRulesMap = original
+		modelPricingRulesMapMutex.Unlock()
+	})
+	if err := UpdateModelPricingRulesByJSONString(rules); err != nil {
+		t.Fatalf("UpdateModelPricingRulesByJSONString returned error: %v", err)
+	}
+}
+
+func TestMatchModelPricingRule(t *testing.T) {
+	setupPricingRules(t, `{
+		"test-model": [
+			{"name": "flex", "service_tier": "flex", "model_ratio": 0.5},
+			{"name": "hd-4k", "match": {"size": "4096x4096", "quality": "hd"}, "model_price": 0.2},
+			{"name": "<=128k", "prompt_tokens_up_to": 128000},
+			{"name": "128k-200k", "prompt_tokens_above": 128000, "prompt_tokens_up_to": 200000, "model_ratio": 2},
+			{"name": ">200k", "prompt_tokens_above": 200000, "model_ratio": 3}
+		]
+	}`)
+
+	tests := []struct {
+		name         string
+		model        string
+		promptTokens int
+		requestBody  string
+		want         string
+	}{
+		{name: "lower bound", model: "test-model", promptTokens: 0, requestBody: `{}`, want: "<=128k"},
+		{name: "up to is inclusive", model: "test-model", promptTokens: 128000, requestBody: `{}`, want: "<=128k"},
+		{name: "above is exclusive", model: "test-model", promptTokens: 128001, requestBody: `{}`, want: "128k-200k"},
+		{name: "middle tier upper bound", model: "test-model", promptTokens: 200000, requestBody: `{}`, want: "128k-200k"},
+		{name: "open ended tier", model: "test-model", promptTokens: 1000000, requestBody: `{}`, want: ">200k"},
+		{name: "service tier first", model: "test-model", promptTokens: 300000, requestBody: `{"service_tier":"flex"}`, want: "flex"},
+		{name: "other service tier", model: "test-model", promptTokens: 100, requestBody: `{"service_tier":"priority"}`, want: "<=128k"},
+		{name: "all match params", model: "test-model", promptTokens: 100, requestBody: `{"size":"4096x4096","quality":"hd"}`, want: "hd-4k"},
+		{name: "partial match params", model: "test-model", promptTokens: 100, requestBody: `{"size":"4096x4096","quality":"standard"}`, want: "<=128k"},
+		{name: "model without rules", model: "other-model", promptTokens: 300000, requestBody: `{"service_tier":"flex"}`, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attributes := PricingRuleRequestAttributes(tt.model, []byte(tt.requestBody))
+			rule := MatchModelPricingRule(tt.model, tt.promptTokens, attributes)
+			got := ""
+			if rule != nil {
+				got = rule.Name
+			}
+			if got != tt.want {
+				t.Fatalf("MatchModelPricingRule(%q, %d, %s) = %q, want %q", tt.model, tt.promptTokens, tt.requestBody, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyModelPricingRuleAfterSerialization(t *testing.T) {
+	setupPricingRules(t, `{"test-model": [{"name": ">200k", "prompt_tokens_above": 200000, "model_ratio": 3, "completion_ratio": 6}]}`)
+
+	priceData := types.PriceData{ModelRatio: 1, CompletionRatio: 4}
+	priceData.PricingRuleRequest = PricingRuleRequestAttributes("test-model", []byte(`{}`))
+	ApplyModelPricingRule(&priceData, "test-model", 300000)
+	if priceData.PricingRule != ">200k" || priceData.ModelRatio != 3 || priceData.CompletionRatio != 6 {
+		t.Fatalf("unexpected price data after pre-consume: %s", priceData.ToSetting())
+	}
+
+	// 异步任务会序列化 PriceData，结算时仍需按规则应用前的价格重新匹配
+	data, err := json.Marshal(priceData)
+	if err != nil {
+		t.Fatalf("json.Marshal returned error: %v", err)
+	}
+	var restored types.PriceData
+	if err = json.Unmarshal(data, &restored); err != nil {
+		t.Fatalf("json.Unmarshal returned error: %v", err)
+	}
+	ApplyModelPricingRule(&restored, "test-model", 1000)
+	if restored.PricingRule != "" || restored.ModelRatio != 1 || restored.CompletionRatio != 4 {
+		t.Fatalf("unexpected price data after settlement: %s", restored.ToSetting())
+	}
+}
@@ -0,0 +1,47 @@
+package ratio_setting
+
+import (
+	"sync"
+
+	"github.com/ctrlc-ctrlv-limited/cvai/common"
+)
+
+// 存储价格（美元/GB/天），按实际存储量与存储时长计费，如向量库
+var defaultStoragePrice = map[string]float64{
+	"vector-store": 0.1,
+}
+
+var (
+	storagePriceMap      map[string]float64 = nil
+	storagePriceMapMutex                    = sync.RWMutex{}
+)
+
+func StoragePrice2JSONString() string {
+	storagePriceMapMutex.RLock()
+	defer storagePriceMapMutex.RUnlock()
+	jsonBytes, err := common.Marshal(storagePriceMap)
+	if err != nil {
+		common.SysError("error marshalling storage price: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateStoragePriceByJSONString(jsonStr string) error {
+	tmp := make(map[string]float64)
+	if err := common.Unmarshal([]byte(jsonStr), &tmp); err != nil {
+		return err
+	}
+	storagePriceMapMutex.Lock()
+	storagePriceMap = tmp
+	storagePriceMapMutex.Unlock()
+	InvalidateExposedDataCache()
+	return nil
+}
+
+// GetStoragePrice 获取每 GB 每天的存储价格（美元）
+func GetStoragePrice(name string) (float64, bool) {
+	storagePriceMapMutex.RLock()
+	defer storagePriceMapMutex.RUnlock()
+	price, ok := storagePriceMap[name]
+	return price, ok
+}
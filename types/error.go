@@ -40,6 +40,8 @@ type ErrorCode string
 const (
 	ErrorCodeInvalidRequest         ErrorCode = "invalid_request"
 	ErrorCodeSensitiveWordsDetected ErrorCode = "sensitive_words_detected"
+	ErrorCodeModerationFlagged      ErrorCode = "moderation_flagged"
+	ErrorCodeModerationFailed       ErrorCode = "moderation_failed"
 
 	// CVAI error
 	ErrorCodeCountTokenFailed   ErrorCode = "count_token_failed"
@@ -84,6 +86,9 @@ const (
 	// quota error
 	ErrorCodeInsufficientUserQuota      ErrorCode = "insufficient_user_quota"
 	ErrorCodePreConsumeTokenQuotaFailed ErrorCode = "pre_consume_token_quota_failed"
+	ErrorCodeTokenBudgetExceeded        ErrorCode = "token_budget_exceeded"
+	ErrorCodeUserBudgetExceeded         ErrorCode = "user_budget_exceeded"
+	ErrorCodeCreditLimitExceeded        ErrorCode = "credit_limit_exceeded"
 )
 
 type CVAIError struct {
@@ -24,6 +24,76 @@ type PriceData struct {
 	UsePrice             bool
 	QuotaToPreConsume    int // 预消耗额度
 	GroupRatioInfo       GroupRatioInfo
+	PricingRule          string            // 匹配的计费规则（如 prompt tokens 区间），显示在日志详情中
+	PricingRuleRequest   map[string]string // 预扣费时提取的计费规则用到的请求参数，结算时复用
+	PricingBase          *PricingBase      // 应用计费规则前的价格与倍率
+}
+
+// PricingBase 应用计费规则前的价格与倍率，结算时按实际用量重新匹配规则时以此为基础
+type PricingBase struct {
+	ModelPrice           float64
+	ModelRatio           float64
+	CompletionRatio      float64
+	CacheRatio           float64
+	CacheCreationRatio   float64
+	CacheCreation5mRatio float64
+	CacheCreation1hRatio float64
+}
+
+// PriceOverride 计费规则覆盖的价格与倍率，为空的项沿用模型的默认值
+type PriceOverride struct {
+	ModelRatio         *float64 `json:"model_ratio,omitempty"`
+	CompletionRatio    *float64 `json:"completion_ratio,omitempty"`
+	CacheRatio         *float64 `json:"cache_ratio,omitempty"`
+	CacheCreationRatio *float64 `json:"cache_creation_ratio,omitempty"`
+	ModelPrice         *float64 `json:"model_price,omitempty"`
+}
+
+// ApplyPricingRule 按计费规则覆盖价格与倍率，name 为空表示没有匹配的规则。
+// 结算时可按实际用量再次调用，总是在规则应用前的价格上计算
+func (p *PriceData) ApplyPricingRule(name string, override *PriceOverride) {
+	if p.PricingBase == nil {
+		p.PricingBase = &PricingBase{
+			ModelPrice:           p.ModelPrice,
+			ModelRatio:           p.ModelRatio,
+			CompletionRatio:      p.CompletionRatio,
+			CacheRatio:           p.CacheRatio,
+			CacheCreationRatio:   p.CacheCreationRatio,
+			CacheCreation5mRatio: p.CacheCreation5mRatio,
+			CacheCreation1hRatio: p.CacheCreation1hRatio,
+		}
+	}
+	base := p.PricingBase
+	p.ModelRatio = base.ModelRatio
+	p.CompletionRatio = base.CompletionRatio
+	p.CacheRatio = base.CacheRatio
+	p.CacheCreationRatio = base.CacheCreationRatio
+	p.CacheCreation5mRatio = base.CacheCreation5mRatio
+	p.CacheCreation1hRatio = base.CacheCreation1hRatio
+	p.ModelPrice = base.ModelPrice
+	p.PricingRule = name
+	if override == nil {
+		return
+	}
+	if override.ModelRatio != nil {
+		p.ModelRatio = *override.ModelRatio
+	}
+	if override.CompletionRatio != nil {
+		p.CompletionRatio = *override.CompletionRatio
+	}
+	if override.CacheRatio != nil {
+		p.CacheRatio = *override.CacheRatio
+	}
+	if override.CacheCreationRatio != nil {
+		p.CacheCreationRatio = *override.CacheCreationRatio
+		p.CacheCreation5mRatio = *override.CacheCreationRatio
+		if base.CacheCreationRatio != 0 {
+			p.CacheCreation1hRatio = *override.CacheCreationRatio * base.CacheCreation1hRatio / base.CacheCreationRatio
+		}
+	}
+	if override.ModelPrice != nil {
+		p.ModelPrice = *override.ModelPrice
+	}
 }
 
 func (p *PriceData) AddOtherRatio(key string, ratio float64) {
@@ -40,8 +110,10 @@ type PerCallPriceData struct {
 	ModelPrice     float64
 	Quota          int
 	GroupRatioInfo GroupRatioInfo
+	ImageCount     int    // 图片按张计费时请求的张数
+	PricingRule    string // 匹配的计费规则
 }
 
 func (p *PriceData) ToSetting() string {
-	return fmt.Sprintf("ModelPrice: %f, ModelRatio: %f, CompletionRatio: %f, CacheRatio: %f, GroupRatio: %f, UsePrice: %t, CacheCreationRatio: %f, CacheCreation5mRatio: %f, CacheCreation1hRatio: %f, QuotaToPreConsume: %d, ImageRatio: %f, AudioRatio: %f, AudioCompletionRatio: %f", p.ModelPrice, p.ModelRatio, p.CompletionRatio, p.CacheRatio, p.GroupRatioInfo.GroupRatio, p.UsePrice, p.CacheCreationRatio, p.CacheCreation5mRatio, p.CacheCreation1hRatio, p.QuotaToPreConsume, p.ImageRatio, p.AudioRatio, p.AudioCompletionRatio)
+	return fmt.Sprintf("ModelPrice: %f, ModelRatio: %f, CompletionRatio: %f, CacheRatio: %f, GroupRatio: %f, UsePrice: %t, CacheCreationRatio: %f, CacheCreation5mRatio: %f, CacheCreation1hRatio: %f, QuotaToPreConsume: %d, ImageRatio: %f, AudioRatio: %f, AudioCompletionRatio: %f, PricingRule: %s", p.ModelPrice, p.ModelRatio, p.CompletionRatio, p.CacheRatio, p.GroupRatioInfo.GroupRatio, p.UsePrice, p.CacheCreationRatio, p.CacheCreation5mRatio, p.CacheCreation1hRatio, p.QuotaToPreConsume, p.ImageRatio, p.AudioRatio, p.AudioCompletionRatio, p.PricingRule)
 }
@@ -3,15 +3,17 @@ package types
 type RelayFormat string
 
 const (
-	RelayFormatOpenAI          RelayFormat = "openai"
-	RelayFormatClaude                      = "claude"
-	RelayFormatGemini                      = "gemini"
-	RelayFormatOpenAIResponses             = "openai_responses"
-	RelayFormatOpenAIAudio                 = "openai_audio"
-	RelayFormatOpenAIImage                 = "openai_image"
-	RelayFormatOpenAIRealtime              = "openai_realtime"
-	RelayFormatRerank                      = "rerank"
-	RelayFormatEmbedding                   = "embedding"
+	RelayFormatOpenAI                RelayFormat = "openai"
+	RelayFormatClaude                            = "claude"
+	RelayFormatGemini                            = "gemini"
+	RelayFormatOpenAIResponses                   = "openai_responses"
+	RelayFormatOpenAIAudio                       = "openai_audio"
+	RelayFormatOpenAIImage                       = "openai_image"
+	RelayFormatOpenAIRealtime                    = "openai_realtime"
+	RelayFormatOpenAIRealtimeSession             = "openai_realtime_session"
+	RelayFormatRerank                            = "rerank"
+	RelayFormatEmbedding                         = "embedding"
+	RelayFormatModeration                        = "moderation"
 
 	RelayFormatTask    = "task"
 	RelayFormatMjProxy = "mj_proxy"
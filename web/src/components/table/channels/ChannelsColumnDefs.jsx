@@ -424,7 +424,29 @@ export const getChannelsColumns = ({
       key: COLUMN_KEYS.RESPONSE_TIME,
       title: t('响应时间'),
       dataIndex: 'response_time',
-      render: (text, record, index) => <div>{renderResponseTime(text, t)}</div>,
+      render: (text, record, index) => {
+        const scores = record.scores ? Object.entries(record.scores) : [];
+        if (scores.length === 0) {
+          return <div>{renderResponseTime(text, t)}</div>;
+        }
+        return (
+          <Tooltip
+            content={
+              <div>
+                {scores.map(([modelName, score]) => (
+                  <div key={modelName}>
+                    {modelName}: TTFT {Math.round(score.ttft)}ms /{' '}
+                    {t('总耗时')} {Math.round(score.latency)}ms /{' '}
+                    {t('错误率')} {(score.error_rate * 100).toFixed(1)}%
+                  </div>
+                ))}
+              </div>
+            }
+          >
+            <div>{renderResponseTime(text, t)}</div>
+          </Tooltip>
+        );
+      },
     },
     {
       key: COLUMN_KEYS.BALANCE,
@@ -153,6 +153,7 @@ const EditChannelModal = (props) => {
     pass_through_body_enabled: false,
     system_prompt: '',
     system_prompt_override: false,
+    max_concurrency: 0,
     settings: '',
     // 仅 Vertex: 密钥格式（存入 settings.vertex_key_type）
     vertex_key_type: 'json',
@@ -560,6 +561,7 @@ const EditChannelModal = (props) => {
           data.system_prompt = parsedSettings.system_prompt || '';
           data.system_prompt_override =
             parsedSettings.system_prompt_override || false;
+          data.max_concurrency = parsedSettings.max_concurrency || 0;
         } catch (error) {
           console.error('解析渠道设置失败:', error);
           data.force_format = false;
@@ -568,6 +570,7 @@ const EditChannelModal = (props) => {
           data.pass_through_body_enabled = false;
           data.system_prompt = '';
           data.system_prompt_override = false;
+          data.max_concurrency = 0;
         }
       } else {
         data.force_format = false;
@@ -576,6 +579,7 @@ const EditChannelModal = (props) => {
         data.pass_through_body_enabled = false;
         data.system_prompt = '';
         data.system_prompt_override = false;
+        data.max_concurrency = 0;
       }
 
       if (data.settings) {
@@ -644,6 +648,7 @@ const EditChannelModal = (props) => {
         pass_through_body_enabled: data.pass_through_body_enabled,
         system_prompt: data.system_prompt,
         system_prompt_override: data.system_prompt_override || false,
+        max_concurrency: data.max_concurrency || 0,
       });
       initialModelsRef.current = (data.models || [])
         .map((model) => (model || '').trim())
@@ -924,6 +929,7 @@ const EditChannelModal = (props) => {
       pass_through_body_enabled: false,
       system_prompt: '',
       system_prompt_override: false,
+      max_concurrency: 0,
     });
     // 重置密钥模式状态
     setKeyMode('append');
@@ -1210,6 +1216,7 @@ const EditChannelModal = (props) => {
       pass_through_body_enabled: localInputs.pass_through_body_enabled || false,
       system_prompt: localInputs.system_prompt || '',
       system_prompt_override: localInputs.system_prompt_override || false,
+      max_concurrency: localInputs.max_concurrency || 0,
     };
     localInputs.setting = JSON.stringify(channelExtraSettings);
 
@@ -1261,6 +1268,7 @@ const EditChannelModal = (props) => {
     delete localInputs.pass_through_body_enabled;
     delete localInputs.system_prompt;
     delete localInputs.system_prompt_override;
+    delete localInputs.max_concurrency;
     delete localInputs.is_enterprise_account;
     // 顶层的 vertex_key_type 不应发送给后端
     delete localInputs.vertex_key_type;
@@ -2108,6 +2116,14 @@ const EditChannelModal = (props) => {
                           optionList={[
                             { label: t('随机'), value: 'random' },
                             { label: t('轮询'), value: 'polling' },
+                            {
+                              label: t('最久未使用'),
+                              value: 'least_recently_used',
+                            },
+                            {
+                              label: t('本分钟用量最少'),
+                              value: 'least_tokens_this_minute',
+                            },
                           ]}
                           style={{ width: '100%' }}
                           value={inputs.multi_key_mode || 'random'}
@@ -2116,6 +2132,17 @@ const EditChannelModal = (props) => {
                             handleInputChange('multi_key_mode', value);
                           }}
                         />
+                        {(inputs.multi_key_mode === 'least_recently_used' ||
+                          inputs.multi_key_mode ===
+                            'least_tokens_this_minute') && (
+                          <Banner
+                            type='info'
+                            description={t(
+                              '按用量选择密钥时，多节点部署需启用Redis以共享各密钥的用量统计',
+                            )}
+                            className='!rounded-lg mt-2'
+                          />
+                        )}
                         {inputs.multi_key_mode === 'polling' && (
                           <Banner
                             type='warning'
@@ -3044,6 +3071,20 @@ const EditChannelModal = (props) => {
                       extraText={t('启用请求体透传功能')}
                     />
 
+                    <Form.InputNumber
+                      field='max_concurrency'
+                      label={t('最大并发数')}
+                      placeholder={t('0 表示不限制')}
+                      min={0}
+                      onNumberChange={(value) =>
+                        handleChannelSettingsChange('max_concurrency', value)
+                      }
+                      style={{ width: '100%' }}
+                      extraText={t(
+                        '渠道同时处理的请求数达到上限后暂不选择该渠道，流式与 WebSocket 请求在连接关闭前持续占用',
+                      )}
+                    />
+
                     <Form.Input
                       field='proxy'
                       label={t('代理地址')}
@@ -36,6 +36,7 @@ import {
   Badge,
   Progress,
   Card,
+  InputNumber,
 } from '@douyinfe/semi-ui';
 import {
   IllustrationNoResult,
@@ -70,6 +71,9 @@ const MultiKeyManageModal = ({ visible, onCancel, channel, onRefresh }) => {
   // Filter states
   const [statusFilter, setStatusFilter] = useState(null); // null=all, 1=enabled, 2=manual_disabled, 3=auto_disabled
 
+  // Key limit editing state: { index, rpm, tpm }
+  const [limitEditing, setLimitEditing] = useState(null);
+
   // Load key status data
   const loadKeyStatus = async (
     page = currentPage,
@@ -273,6 +277,35 @@ const MultiKeyManageModal = ({ visible, onCancel, channel, onRefresh }) => {
     }
   };
 
+  // Set RPM/TPM limit of a specific key
+  const handleSetKeyLimit = async () => {
+    if (!limitEditing) return;
+    setOperationLoading((prev) => ({ ...prev, set_limit: true }));
+
+    try {
+      const res = await API.post('/api/channel/multi_key/manage', {
+        channel_id: channel.id,
+        action: 'set_key_limit',
+        key_index: limitEditing.index,
+        rpm_limit: limitEditing.rpm || 0,
+        tpm_limit: limitEditing.tpm || 0,
+      });
+
+      if (res.data.success) {
+        showSuccess(t('密钥限额已更新'));
+        setLimitEditing(null);
+        await loadKeyStatus(currentPage, pageSize); // Reload current page
+        onRefresh && onRefresh(); // Refresh parent component
+      } else {
+        showError(res.data.message);
+      }
+    } catch (error) {
+      showError(t('设置密钥限额失败'));
+    } finally {
+      setOperationLoading((prev) => ({ ...prev, set_limit: false }));
+    }
+  };
+
   // Handle page change
   const handlePageChange = (page) => {
     setCurrentPage(page);
@@ -406,13 +439,99 @@ const MultiKeyManageModal = ({ visible, onCancel, channel, onRefresh }) => {
         );
       },
     },
+    {
+      title: t('限流额度'),
+      dataIndex: 'rate_limit',
+      render: (rateLimit) => {
+        if (!rateLimit) {
+          return <Text type='quaternary'>-</Text>;
+        }
+        const formatBudget = (remaining, limit) => {
+          if (remaining < 0) {
+            return '-';
+          }
+          return limit >= 0 ? `${remaining} / ${limit}` : `${remaining}`;
+        };
+        const coolingDown =
+          rateLimit.cooldown_until &&
+          rateLimit.cooldown_until > Date.now() / 1000;
+        return (
+          <Tooltip
+            content={
+              <div>
+                <div>
+                  {t('更新时间')}: {timestamp2string(rateLimit.updated_at)}
+                </div>
+                {coolingDown && (
+                  <div>
+                    {t('冷却至')}: {timestamp2string(rateLimit.cooldown_until)}
+                  </div>
+                )}
+              </div>
+            }
+          >
+            <Space vertical align='start' spacing={2}>
+              <Text style={{ fontSize: '12px' }}>
+                {t('请求')}:{' '}
+                {formatBudget(
+                  rateLimit.remaining_requests,
+                  rateLimit.limit_requests,
+                )}
+              </Text>
+              <Text style={{ fontSize: '12px' }}>
+                Tokens:{' '}
+                {formatBudget(rateLimit.remaining_tokens, rateLimit.limit_tokens)}
+              </Text>
+              {coolingDown && (
+                <Tag color='orange' shape='circle' size='small'>
+                  {t('限流冷却中')}
+                </Tag>
+              )}
+            </Space>
+          </Tooltip>
+        );
+      },
+    },
+    {
+      title: t('每分钟用量'),
+      key: 'usage',
+      render: (_, record) => {
+        if (!record.usage && !record.rpm_limit && !record.tpm_limit) {
+          return <Text type='quaternary'>-</Text>;
+        }
+        const formatUsage = (used, limit) =>
+          limit ? `${used || 0} / ${limit}` : `${used || 0}`;
+        return (
+          <Space vertical align='start' spacing={2}>
+            <Text style={{ fontSize: '12px' }}>
+              RPM: {formatUsage(record.usage?.requests, record.rpm_limit)}
+            </Text>
+            <Text style={{ fontSize: '12px' }}>
+              TPM: {formatUsage(record.usage?.tokens, record.tpm_limit)}
+            </Text>
+          </Space>
+        );
+      },
+    },
     {
       title: t('操作'),
       key: 'action',
       fixed: 'right',
-      width: 150,
+      width: 210,
       render: (_, record) => (
         <Space>
+          <Button
+            size='small'
+            onClick={() =>
+              setLimitEditing({
+                index: record.index,
+                rpm: record.rpm_limit || 0,
+                tpm: record.tpm_limit || 0,
+              })
+            }
+          >
+            {t('限额')}
+          </Button>
           {record.status === 1 ? (
             <Button
               type='danger'
@@ -467,9 +586,14 @@ const MultiKeyManageModal = ({ visible, onCancel, channel, onRefresh }) => {
           </Tag>
           {channel?.channel_info?.multi_key_mode && (
             <Tag size='small' shape='circle' color='white'>
-              {channel.channel_info.multi_key_mode === 'random'
-                ? t('随机模式')
-                : t('轮询模式')}
+              {
+                {
+                  random: t('随机模式'),
+                  polling: t('轮询模式'),
+                  least_recently_used: t('最久未使用模式'),
+                  least_tokens_this_minute: t('最少用量模式'),
+                }[channel.channel_info.multi_key_mode]
+              }
             </Tag>
           )}
         </Space>
@@ -735,6 +859,35 @@ const MultiKeyManageModal = ({ visible, onCancel, channel, onRefresh }) => {
           </Spin>
         </div>
       </div>
+      <Modal
+        title={`${t('密钥限额')} #${limitEditing?.index ?? ''}`}
+        visible={limitEditing !== null}
+        onCancel={() => setLimitEditing(null)}
+        onOk={handleSetKeyLimit}
+        okButtonProps={{ loading: operationLoading.set_limit }}
+        width={400}
+      >
+        <Space vertical align='start' style={{ width: '100%' }}>
+          <Text>{t('每分钟请求数上限（RPM），0 表示不限制')}</Text>
+          <InputNumber
+            min={0}
+            style={{ width: '100%' }}
+            value={limitEditing?.rpm}
+            onChange={(value) =>
+              setLimitEditing((prev) => ({ ...prev, rpm: value }))
+            }
+          />
+          <Text>{t('每分钟 token 数上限（TPM），0 表示不限制')}</Text>
+          <InputNumber
+            min={0}
+            style={{ width: '100%' }}
+            value={limitEditing?.tpm}
+            onChange={(value) =>
+              setLimitEditing((prev) => ({ ...prev, tpm: value }))
+            }
+          />
+        </Space>
+      </Modal>
     </Modal>
   );
 };